@@ -0,0 +1,139 @@
+/*
+lmc-console serves the management console over HTTP. The arguments are shell patterns of the configuration files to
+manage, each optionally led by a format name and an equal sign, for example:
+
+	lmc-console -listen 127.0.0.1:8080 -users /etc/lmc/users /etc/named.conf 'httpd=/etc/apache2/vhosts.d/*.conf'
+
+Users log in with the passwords stored in the user file, which has a line "user:hash" for each user. The hash is printed
+by "lmc-console -hash-password", which reads the password from standard input, or by "htpasswd -5" or "htpasswd -B". The
+optional policy file assigns roles to users and grants them permissions on files, see console.Policy. Applied changes
+are recorded in the audit log, whose chain of hashes is checked against the head kept in the file of the log's name
+followed by ".head", by "lmc-console -audit /var/log/lmc-audit.log -verify-audit". Every version of the files is kept in
+the snapshot directory if one is given, subject to the -keep-* retention limits. Changes are checked by the validators
+before they are written, see console.Validator for the JSON table. After that, the services of the changed files are
+reloaded and checked, see console.ServiceAction for the JSON table. With -watch, changes made by other means show up in
+the web interface right away. Change sets may be queued to be applied in a maintenance window, the queue is kept in the
+-schedule file across restarts. The files are compared periodically with the desired state if one is given, see
+console.DesiredFile for the JSON table.
+*/
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/console"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:8080", "address to listen on")
+	usersPath := flag.String("users", "", "path of the user file")
+	policyPath := flag.String("policy", "", "path of the JSON access control policy, all users may do everything without one")
+	secureCookies := flag.Bool("secure-cookies", false, "mark session cookies secure, use when serving HTTPS via a proxy")
+	hashPassword := flag.Bool("hash-password", false, "read a password from standard input and print its hash")
+	backupDir := flag.String("backup-dir", "", "directory of backups of changed files, backups are kept next to the files by default")
+	auditPath := flag.String("audit", "", "path of the audit log of applied changes")
+	verifyAudit := flag.Bool("verify-audit", false, "check the chain of hashes in the audit log and exit")
+	validatorsPath := flag.String("validators", "", "path of the JSON table of validators, or \"default\" for the checkers of named, Apache, sudo, sshd, and postfix")
+	servicesPath := flag.String("services", "", "path of the JSON table of service actions that run after files are changed")
+	watch := flag.Bool("watch", false, "watch the files, and tell the web clients about changes made outside of the console")
+	schedulePath := flag.String("schedule", "", "path of the queue of change sets scheduled to be applied later")
+	desiredPath := flag.String("desired", "", "path of the JSON table of the desired state of the files, to detect drift from")
+	driftInterval := flag.Duration("drift-interval", time.Hour, "interval of comparing the files with the desired state")
+	snapshotDir := flag.String("snapshots", "", "directory that keeps every version of the managed files")
+	var retention console.Retention
+	flag.IntVar(&retention.MaxCount, "keep-versions", 0, "number of versions kept of each file, 0 for no limit")
+	flag.DurationVar(&retention.MaxAge, "keep-age", 0, "remove versions older than this, 0 for no limit")
+	flag.Int64Var(&retention.MaxSize, "keep-size", 0, "total bytes of stored versions, 0 for no limit")
+	flag.Parse()
+	if *verifyAudit {
+		count, lastHash, err := console.VerifyAuditLog(*auditPath)
+		if err != nil {
+			log.Fatalf("after %d intact entries: %v", count, err)
+		}
+		fmt.Printf("%d entries are intact, the last hash is %s\n", count, lastHash)
+		return
+	}
+	if *hashPassword {
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && password == "" {
+			log.Fatal(err)
+		}
+		hashed, err := console.HashPassword(strings.TrimRight(password, "\r\n"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(hashed)
+		return
+	}
+	if *usersPath == "" {
+		log.Fatal("please specify the user file via -users")
+	}
+	users, err := console.NewUserFile(*usersPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	files, err := console.FindFiles(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := console.NewServer(files, users)
+	srv.Specs = flag.Args()
+	if *policyPath != "" {
+		if srv.Policy, err = console.LoadPolicy(*policyPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *auditPath != "" {
+		if srv.Audit, err = console.OpenAuditLog(*auditPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *validatorsPath == "default" {
+		srv.Validators = console.DefaultValidators
+	} else if *validatorsPath != "" {
+		if srv.Validators, err = console.LoadValidators(*validatorsPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *servicesPath != "" {
+		if srv.Services, err = console.LoadServiceActions(*servicesPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *snapshotDir != "" {
+		if srv.Snapshots, err = console.OpenSnapshotStore(*snapshotDir, retention); err != nil {
+			log.Fatal(err)
+		}
+		if err := srv.Snapshots.CaptureFiles(files); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *watch {
+		if _, err := srv.Watch(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	srv.Writer.BackupDir = *backupDir
+	srv.SecureCookies = *secureCookies
+	if *desiredPath != "" {
+		state, err := console.LoadDesiredState(*desiredPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		srv.MonitorDrift(state, *driftInterval)
+	}
+	if *schedulePath != "" {
+		if _, err := srv.OpenSchedule(*schedulePath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Printf("managing %d files, listening on %s", len(files), *listen)
+	log.Fatal(http.ListenAndServe(*listen, srv))
+}
@@ -0,0 +1,18 @@
+/*
+lmc-lsp is a language server for the configuration file formats known to the lexer, such as httpd.conf, named.conf,
+and systemd units. Editors start it as a child process and talk to it via standard input and output.
+*/
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lsp"
+)
+
+func main() {
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,280 @@
+package console
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/diff"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/navigate"
+)
+
+/*
+AuditEntry records a change applied to a managed file. Each entry carries the hash of its predecessor, so that
+altering, removing, or inserting an entry breaks the chain of hashes that follow it.
+*/
+type AuditEntry struct {
+	Sequence   int           `json:"sequence"` // counting from 1
+	Time       time.Time     `json:"time"`
+	User       string        `json:"user"`
+	Host       string        `json:"host"`   // name of the host whose file is changed
+	Client     string        `json:"client"` // address of the client that asked for the change
+	File       string        `json:"file"`
+	Changes    []diff.Change `json:"changes"`
+	Event      string        `json:"event,omitempty"` // what happened to a scheduled change, in entries that do not change the file
+	BeforeHash string        `json:"beforeHash"`      // SHA-256 of the file content before the change
+	AfterHash  string        `json:"afterHash"`       // SHA-256 of the file content after the change
+	PrevHash   string        `json:"prevHash"`        // hash of the previous entry, empty for the first entry
+	Hash       string        `json:"hash"`            // SHA-256 of the entry's JSON encoding in which this field is empty
+}
+
+// Return the hash of the entry, computed over its JSON encoding without the hash itself.
+func (entry AuditEntry) computeHash() (string, error) {
+	entry.Hash = ""
+	serialised, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	return contentHash(string(serialised)), nil
+}
+
+// Return the hexadecimal SHA-256 hash of the content.
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+AuditLog is an append-only file of audit entries, one JSON object per line. Entries are only ever appended, and each
+one is flushed to the disk before the log moves on. The head of the log is kept in a file beside it, see AuditHead.
+*/
+type AuditLog struct {
+	Path     string
+	Host     string // recorded in each entry, the host name by default
+	lock     *sync.Mutex
+	sequence int    // sequence number of the last entry
+	lastHash string // hash of the last entry
+	now      func() time.Time
+}
+
+// The head of an audit log is kept in the file of the log's path followed by this suffix.
+const AUDIT_HEAD_SUFFIX = ".head"
+
+/*
+AuditHead tells the sequence number and hash of the last entry of an audit log. It is kept outside of the log, so
+that cutting entries off the end of the log, or writing the log anew, is told apart from an intact log.
+*/
+type AuditHead struct {
+	Sequence int    `json:"sequence"`
+	Hash     string `json:"hash"`
+}
+
+// Read the head of the audit log, return found being false if the head does not yet exist.
+func readAuditHead(filePath string) (head AuditHead, found bool, err error) {
+	content, err := ioutil.ReadFile(filePath + AUDIT_HEAD_SUFFIX)
+	if os.IsNotExist(err) {
+		return head, false, nil
+	} else if err != nil {
+		return
+	}
+	if err = json.Unmarshal(content, &head); err != nil {
+		err = fmt.Errorf("the head of the audit log is malformed: %v", err)
+	}
+	return head, true, err
+}
+
+// Replace the head of the audit log.
+func writeAuditHead(filePath string, head AuditHead) error {
+	serialised, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	return writeDurably(filePath+AUDIT_HEAD_SUFFIX, serialised)
+}
+
+/*
+Open the audit log file, or create it if neither the log nor its head exists yet. The existing entries must form an
+intact chain that reaches the head.
+*/
+func OpenAuditLog(filePath string) (*AuditLog, error) {
+	host, _ := os.Hostname()
+	auditLog := &AuditLog{Path: filePath, Host: host, lock: new(sync.Mutex), now: time.Now}
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		if _, found, err := readAuditHead(filePath); err != nil || found {
+			return nil, fmt.Errorf("the audit log %s is missing while its head exists", filePath)
+		}
+		return auditLog, nil
+	}
+	count, lastHash, err := VerifyAuditLog(filePath)
+	if err != nil {
+		return nil, err
+	}
+	// The head falls behind if the last entry is written but its head is not
+	if err := writeAuditHead(filePath, AuditHead{Sequence: count, Hash: lastHash}); err != nil {
+		return nil, err
+	}
+	auditLog.sequence, auditLog.lastHash = count, lastHash
+	return auditLog, nil
+}
+
+/*
+Record a change of the file made by the user from the client address. The sequence number, time, host, and hashes are
+filled in by the log. Return the entry as it is written.
+*/
+func (auditLog *AuditLog) Record(user, client, filePath string, changes []diff.Change, before, after string) (AuditEntry, error) {
+	return auditLog.append(AuditEntry{User: user, Client: client, File: filePath, Changes: changes,
+		BeforeHash: contentHash(before), AfterHash: contentHash(after)})
+}
+
+// Record an event of the file that does not change it, such as the queueing or cancellation of a scheduled change.
+func (auditLog *AuditLog) RecordEvent(user, client, filePath, event string) (AuditEntry, error) {
+	return auditLog.append(AuditEntry{User: user, Client: client, File: filePath, Changes: []diff.Change{}, Event: event})
+}
+
+// Fill in the sequence number, time, host, and hashes of the entry, and append it to the log file.
+func (auditLog *AuditLog) append(entry AuditEntry) (AuditEntry, error) {
+	auditLog.lock.Lock()
+	defer auditLog.lock.Unlock()
+	entry.Sequence = auditLog.sequence + 1
+	entry.Time = auditLog.now().UTC()
+	entry.Host = auditLog.Host
+	entry.PrevHash = auditLog.lastHash
+	var err error
+	if entry.Hash, err = entry.computeHash(); err != nil {
+		return entry, err
+	}
+	serialised, err := json.Marshal(entry)
+	if err != nil {
+		return entry, err
+	}
+	file, err := os.OpenFile(auditLog.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return entry, err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(serialised, '\n')); err != nil {
+		return entry, err
+	}
+	if err := file.Sync(); err != nil {
+		return entry, err
+	}
+	auditLog.sequence, auditLog.lastHash = entry.Sequence, entry.Hash
+	return entry, writeAuditHead(auditLog.Path, AuditHead{Sequence: entry.Sequence, Hash: entry.Hash})
+}
+
+// Call the function with each entry of the log file in order, stop at the first error.
+func readAuditLog(filePath string, fun func(lineNum int, entry AuditEntry) error) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("audit log line %d is malformed: %v", lineNum, err)
+		}
+		if err := fun(lineNum, entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+/*
+Check the chain of entries in the audit log file, and check that the chain reaches the head of the log. Return an
+error that tells the first entry which is missing or has been tampered with. Otherwise return the number of entries
+and the hash of the last entry. The log may be one entry ahead of its head, if the head could not be written.
+*/
+func VerifyAuditLog(filePath string) (count int, lastHash string, err error) {
+	head, headFound, err := readAuditHead(filePath)
+	if err != nil {
+		return
+	}
+	err = readAuditLog(filePath, func(lineNum int, entry AuditEntry) error {
+		if entry.Sequence != count+1 {
+			return fmt.Errorf("audit log line %d: expecting entry %d, but it is entry %d", lineNum, count+1, entry.Sequence)
+		} else if entry.PrevHash != lastHash {
+			return fmt.Errorf("audit log line %d: entry %d does not follow the hash of its predecessor", lineNum, entry.Sequence)
+		}
+		if hash, err := entry.computeHash(); err != nil {
+			return err
+		} else if hash != entry.Hash {
+			return fmt.Errorf("audit log line %d: entry %d has been altered", lineNum, entry.Sequence)
+		}
+		if entry.Sequence == head.Sequence && entry.Hash != head.Hash {
+			return fmt.Errorf("audit log line %d: entry %d is not the one recorded by the head, the log has been written anew", lineNum, entry.Sequence)
+		}
+		count, lastHash = entry.Sequence, entry.Hash
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	if !headFound && count > 0 {
+		err = fmt.Errorf("the head of the audit log is missing")
+	} else if count < head.Sequence {
+		err = fmt.Errorf("the audit log ends at entry %d, but its head is entry %d, entries have been cut off", count, head.Sequence)
+	} else if count > head.Sequence+1 {
+		err = fmt.Errorf("the audit log has %d entries after its head, entry %d", count-head.Sequence, head.Sequence)
+	}
+	return
+}
+
+/*
+AuditQuery selects audit entries, the criteria that are left empty select all entries. A directive is matched against
+the path of each change, as well as against the key at the end of the path, so that "PermitRootLogin" finds the
+changes of that directive in any section.
+*/
+type AuditQuery struct {
+	File      string
+	User      string
+	Directive string
+	Since     time.Time // entries made at or after this time
+	Until     time.Time // entries made before this time
+}
+
+// Return true only if the entry satisfies the query.
+func (query AuditQuery) Match(entry AuditEntry) bool {
+	if query.File != "" && entry.File != query.File || query.User != "" && entry.User != query.User ||
+		!query.Since.IsZero() && entry.Time.Before(query.Since) || !query.Until.IsZero() && !entry.Time.Before(query.Until) {
+		return false
+	}
+	if query.Directive == "" {
+		return true
+	}
+	for _, change := range entry.Changes {
+		for _, changePath := range []string{change.Path, change.OldPath} {
+			if changePath == query.Directive {
+				return true
+			}
+			if path, err := navigate.ParsePath(changePath); err == nil && len(path) > 0 && path[len(path)-1].Key == query.Directive {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Return the entries of the log that satisfy the query, in the order they are recorded.
+func (auditLog *AuditLog) Query(query AuditQuery) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0, 8)
+	err := readAuditLog(auditLog.Path, func(_ int, entry AuditEntry) error {
+		if query.Match(entry) {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return entries, err
+}
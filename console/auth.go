@@ -0,0 +1,204 @@
+package console
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrBadCredentials = errors.New("incorrect user name or password")
+
+/*
+Authenticator verifies the credentials of a console user. It returns ErrBadCredentials if the user does not exist or
+the password is incorrect, and other errors if the verification itself cannot be carried out. Backends such as PAM or
+LDAP implement this interface to replace the local user file.
+*/
+type Authenticator interface {
+	Authenticate(user, password string) error
+}
+
+/*
+UserFile authenticates users against a local file in the style of htpasswd: each line has a user name and the
+SHA-crypt or bcrypt hash of the password (see HashPassword) separated by a colon, lines that begin with # are
+comments. The file is read again whenever it is modified.
+*/
+type UserFile struct {
+	Path    string
+	lock    *sync.Mutex
+	modTime time.Time
+	hashes  map[string]string
+}
+
+// Read the user file, return an error if the file cannot be read or is malformed.
+func NewUserFile(path string) (*UserFile, error) {
+	users := &UserFile{Path: path, lock: new(sync.Mutex)}
+	if err := users.load(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Read the user file if it has been modified since it was last read.
+func (users *UserFile) load() error {
+	info, err := os.Stat(users.Path)
+	if err != nil {
+		return err
+	} else if info.ModTime().Equal(users.modTime) && users.hashes != nil {
+		return nil
+	}
+	file, err := os.Open(users.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 1 {
+			return fmt.Errorf("%s line %d: expecting user:hash", users.Path, lineNum)
+		}
+		user, hashed := line[:colon], line[colon+1:]
+		if _, err := crypt("", hashed); err != nil {
+			return fmt.Errorf("%s line %d: %v", users.Path, lineNum, err)
+		}
+		hashes[user] = hashed
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	users.hashes = hashes
+	users.modTime = info.ModTime()
+	return nil
+}
+
+// A hash to verify passwords of unknown users against, so that they take as long to reject as known users.
+var unknownUserHash, _ = shaCrypt("", "$6$rounds="+fmt.Sprint(SHA_CRYPT_HASH_ROUNDS)+"$unknownuser")
+
+func (users *UserFile) Authenticate(user, password string) error {
+	if len(password) > PASSWORD_MAX_LENGTH {
+		return ErrBadCredentials
+	}
+	users.lock.Lock()
+	err := users.load()
+	hashed, found := users.hashes[user]
+	users.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	if !found {
+		hashed = unknownUserHash
+	}
+	if matched, err := VerifyPassword(password, hashed); err != nil {
+		return err
+	} else if !matched || !found {
+		return ErrBadCredentials
+	}
+	return nil
+}
+
+// Failed login attempts allowed before further attempts are delayed, and the longest delay.
+const (
+	LOGIN_FREE_ATTEMPTS = 3
+	LOGIN_MAX_LOCKOUT   = 15 * time.Minute
+	LOGIN_BODY_MAX      = 4096 // bytes of a login request
+)
+
+/*
+Throttle slows down guessing of passwords. After LOGIN_FREE_ATTEMPTS failures of the same user name or client
+address, further attempts are refused for a period that doubles upon each failure, up to LOGIN_MAX_LOCKOUT. A
+successful login clears the failures.
+*/
+type Throttle struct {
+	lock     *sync.Mutex
+	failures map[string]*loginFailure
+	now      func() time.Time
+}
+
+type loginFailure struct {
+	count int
+	until time.Time // attempts are refused until then
+}
+
+func NewThrottle() *Throttle {
+	return &Throttle{lock: new(sync.Mutex), failures: make(map[string]*loginFailure), now: time.Now}
+}
+
+// Return how long the caller has to wait before an attempt of any of the keys is accepted, or 0 if it is accepted.
+func (throttle *Throttle) Wait(keys ...string) time.Duration {
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+	return throttle.wait(throttle.now(), keys)
+}
+
+/*
+Reserve an attempt of all of the keys, return how long the caller has to wait if the attempt is not accepted, or 0 if
+it is accepted. An accepted attempt counts as a failure right away, so that concurrent attempts cannot all check
+passwords before any of them fails, the caller records a successful attempt to clear the failures afterwards.
+*/
+func (throttle *Throttle) Reserve(keys ...string) (wait time.Duration) {
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+	now := throttle.now()
+	if wait = throttle.wait(now, keys); wait == 0 {
+		throttle.record(now, false, keys)
+	}
+	return
+}
+
+// Record the outcome of an attempt made by all of the keys.
+func (throttle *Throttle) Record(succeeded bool, keys ...string) {
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+	throttle.record(throttle.now(), succeeded, keys)
+}
+
+func (throttle *Throttle) wait(now time.Time, keys []string) (wait time.Duration) {
+	for _, key := range keys {
+		if failure, found := throttle.failures[key]; found && failure.until.After(now) {
+			if remaining := failure.until.Sub(now); remaining > wait {
+				wait = remaining
+			}
+		}
+	}
+	return
+}
+
+func (throttle *Throttle) record(now time.Time, succeeded bool, keys []string) {
+	for key, failure := range throttle.failures {
+		// Forget about failures long in the past
+		if now.Sub(failure.until) > LOGIN_MAX_LOCKOUT {
+			delete(throttle.failures, key)
+		}
+	}
+	for _, key := range keys {
+		if succeeded {
+			delete(throttle.failures, key)
+			continue
+		}
+		failure, found := throttle.failures[key]
+		if !found {
+			failure = &loginFailure{}
+			throttle.failures[key] = failure
+		}
+		failure.count++
+		failure.until = now
+		if excess := failure.count - LOGIN_FREE_ATTEMPTS; excess > 0 {
+			lockout := LOGIN_MAX_LOCKOUT
+			if excess < 20 {
+				if delay := time.Second << uint(excess-1); delay < lockout {
+					lockout = delay
+				}
+			}
+			failure.until = now.Add(lockout)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package console
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+/*
+bcrypt hashes are written by "htpasswd -B" and look like $2y$10$saltsaltsaltsaltsaltsahashhashhashhashhashhashhashh,
+where 10 is the base-2 logarithm of the number of rounds. The $2a$, $2b$, and $2y$ variants only differ for passwords
+longer than PASSWORD_MAX_LENGTH, and they are computed alike. Only the first BCRYPT_KEY_MAX bytes of a password count.
+*/
+const (
+	BCRYPT_COST_MIN    = 4
+	BCRYPT_COST_MAX    = 31
+	BCRYPT_KEY_MAX     = 72 // in bytes, including the zero byte that terminates the password
+	BCRYPT_SETTING_LEN = 29 // the variant, cost, and salt that lead the hash, e.g. $2y$10$ followed by 22 characters
+)
+
+var bcryptEncoding = base64.NewEncoding("./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789").WithPadding(base64.NoPadding)
+
+// blowfish is the state of the Blowfish cipher, which bcrypt sets up with the password and salt.
+type blowfish struct {
+	p [18]uint32
+	s [4][256]uint32
+}
+
+func (bf *blowfish) f(x uint32) uint32 {
+	return ((bf.s[0][x>>24] + bf.s[1][x>>16&0xff]) ^ bf.s[2][x>>8&0xff]) + bf.s[3][x&0xff]
+}
+
+// Encrypt a block of 64 bits made of the two halves.
+func (bf *blowfish) encrypt(l, r uint32) (uint32, uint32) {
+	l ^= bf.p[0]
+	for i := 1; i < 16; i += 2 {
+		r ^= bf.f(l) ^ bf.p[i]
+		l ^= bf.f(r) ^ bf.p[i+1]
+	}
+	r ^= bf.p[17]
+	return r, l
+}
+
+// Return the next four bytes of the data as a word, the data repeats itself endlessly.
+func streamWord(data []byte, pos *int) (word uint32) {
+	for i := 0; i < 4; i++ {
+		word = word<<8 | uint32(data[*pos])
+		*pos = (*pos + 1) % len(data)
+	}
+	return
+}
+
+/*
+Mix the key into the subkeys, then replace the subkeys and substitution boxes with the blocks encrypted one after
+another. The salt, if there is one, is mixed into each block before it is encrypted.
+*/
+func (bf *blowfish) expandKey(key, salt []byte) {
+	keyPos, saltPos := 0, 0
+	for i := range bf.p {
+		bf.p[i] ^= streamWord(key, &keyPos)
+	}
+	var l, r uint32
+	next := func() (uint32, uint32) {
+		if salt != nil {
+			l ^= streamWord(salt, &saltPos)
+			r ^= streamWord(salt, &saltPos)
+		}
+		l, r = bf.encrypt(l, r)
+		return l, r
+	}
+	for i := 0; i < len(bf.p); i += 2 {
+		bf.p[i], bf.p[i+1] = next()
+	}
+	for box := range bf.s {
+		for i := 0; i < len(bf.s[box]); i += 2 {
+			bf.s[box][i], bf.s[box][i+1] = next()
+		}
+	}
+}
+
+// Return the hash of the password in bcrypt format, using the cost and salt given in the setting.
+func bcrypt(password, setting string) (string, error) {
+	if len(setting) < BCRYPT_SETTING_LEN || !strings.HasPrefix(setting, "$2") || strings.IndexByte("aby", setting[2]) == -1 ||
+		setting[3] != '$' || setting[6] != '$' {
+		return "", ErrUnsupportedHash
+	}
+	cost, err := strconv.Atoi(setting[4:6])
+	if err != nil || cost < BCRYPT_COST_MIN || cost > BCRYPT_COST_MAX {
+		return "", ErrUnsupportedHash
+	}
+	salt, err := bcryptEncoding.DecodeString(setting[7:BCRYPT_SETTING_LEN])
+	if err != nil {
+		return "", ErrUnsupportedHash
+	}
+	if len(password) > PASSWORD_MAX_LENGTH {
+		return "", ErrPasswordTooLong
+	}
+	key := append([]byte(password), 0)
+	if len(key) > BCRYPT_KEY_MAX {
+		key = key[:BCRYPT_KEY_MAX]
+	}
+	bf := &blowfish{p: blowfishInitialP, s: blowfishInitialS}
+	bf.expandKey(key, salt)
+	for i := uint64(0); i < 1<<uint(cost); i++ {
+		bf.expandKey(key, nil)
+		bf.expandKey(salt, nil)
+	}
+	text := []byte("OrpheanBeholderScryDoubt")
+	words := make([]uint32, len(text)/4)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint32(text[i*4:])
+	}
+	for i := 0; i < 64; i++ {
+		for j := 0; j < len(words); j += 2 {
+			words[j], words[j+1] = bf.encrypt(words[j], words[j+1])
+		}
+	}
+	sum := make([]byte, len(text))
+	for i, word := range words {
+		binary.BigEndian.PutUint32(sum[i*4:], word)
+	}
+	// The last byte is left out of the hash
+	return setting[:BCRYPT_SETTING_LEN] + bcryptEncoding.EncodeToString(sum[:len(sum)-1]), nil
+}
@@ -0,0 +1,212 @@
+package console
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ChangeSet groups edits of several files, which are applied either all together or not at all.
+type ChangeSet struct {
+	Edits []EditRequest `json:"edits"`
+}
+
+// ChangeSetResult tells the outcome of each edit of a change set, and of the change set as a whole.
+type ChangeSetResult struct {
+	Results    []EditResult    `json:"results"`   // in the order of the edits
+	Succeeded  bool            `json:"succeeded"` // all operations of all edits are either applied or satisfied
+	Rejected   bool            `json:"rejected"`  // a validator rejects the result of an edit
+	Applied    bool            `json:"applied"`   // all files have been written
+	Services   []ServiceResult `json:"services,omitempty"`
+	RolledBack bool            `json:"rolledBack"` // a write failed or a service is not healthy, hence all files are written back
+
+	// The files that fail to roll back, whereas the other files are written back
+	RollbackFailures []RollbackFailure `json:"rollbackFailures,omitempty"`
+	Error            string            `json:"error,omitempty"`
+}
+
+/*
+Return the file of the edit request, which is either a managed file, or a new file that matches the specifications
+of managed files if the request creates it.
+*/
+func (srv *Server) editedFile(req EditRequest) (file ManagedFile, err error) {
+	file, found := srv.file(req.Path)
+	if !req.Create {
+		if !found {
+			err = fmt.Errorf("file \"%s\" is not managed", req.Path)
+		}
+		return
+	}
+	if found {
+		return file, fmt.Errorf("file \"%s\" already exists", req.Path)
+	}
+	if file, found = MatchSpecs(srv.Specs, req.Path); !found {
+		return file, fmt.Errorf("file \"%s\" may not be created as it would not be managed", req.Path)
+	}
+	if _, statErr := os.Lstat(file.Path); !os.IsNotExist(statErr) {
+		return file, fmt.Errorf("file \"%s\" already exists", req.Path)
+	}
+	return
+}
+
+// denialsError is the reasons why the policy denies a change set.
+type denialsError []error
+
+func (denials denialsError) Error() string {
+	messages := make([]string, len(denials))
+	for i, denial := range denials {
+		messages[i] = denial.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+/*
+Preview or apply a change set on behalf of the user of the request. Applying a change set writes the files only if all
+operations succeed and all validators accept the results. If a write fails, or a service of the files is not healthy
+after the files are written, all of the files are written back and the files created by the change set are removed.
+Return the result and the status of the response that tells it. If the change set cannot be carried out at all, the
+error is either denialsError, *Conflict, or another error to be responded with the status.
+*/
+func (srv *Server) applyChangeSet(r *http.Request, set ChangeSet, apply bool) (result ChangeSetResult, status int, err error) {
+	if len(set.Edits) == 0 {
+		return result, http.StatusBadRequest, errors.New("the change set does not have edits")
+	}
+	files := make([]ManagedFile, len(set.Edits))
+	seen := make(map[string]bool)
+	denials := make(denialsError, 0, 0)
+	for i, req := range set.Edits {
+		if seen[req.Path] {
+			return result, http.StatusBadRequest, fmt.Errorf("file \"%s\" is edited more than once in the change set", req.Path)
+		}
+		seen[req.Path] = true
+		if files[i], err = srv.editedFile(req); err != nil {
+			return result, http.StatusNotFound, err
+		}
+		denials = append(denials, srv.checkOperations(r, files[i], req.Operations, apply)...)
+	}
+	if len(denials) > 0 {
+		return result, http.StatusForbidden, denials
+	}
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	result = ChangeSetResult{Results: make([]EditResult, len(set.Edits)), Succeeded: true}
+	for i, req := range set.Edits {
+		text := ""
+		if !req.Create {
+			content, err := ioutil.ReadFile(files[i].Path)
+			if err != nil {
+				return result, http.StatusInternalServerError, err
+			}
+			text = string(content)
+		}
+		rebased, err := req.checkBase(text)
+		if err != nil {
+			return result, http.StatusPreconditionFailed, err
+		}
+		if result.Results[i], err = files[i].EditText(text, req.Operations); err != nil {
+			return result, http.StatusInternalServerError, err
+		}
+		result.Results[i].Rebased = rebased
+		result.Succeeded = result.Succeeded && result.Results[i].Succeeded
+		denials = append(denials, srv.checkChanges(r, files[i], result.Results[i].Changes, apply)...)
+	}
+	if len(denials) > 0 {
+		return result, http.StatusForbidden, denials
+	}
+	if !result.Succeeded {
+		return result, http.StatusConflict, nil
+	} else if !apply {
+		return result, http.StatusOK, nil
+	}
+	// Validate all files before writing any of them
+	for i := range set.Edits {
+		edit := &result.Results[i]
+		if len(edit.Splices) == 0 && !set.Edits[i].Create {
+			continue
+		}
+		var valid bool
+		if edit.Validation, valid, err = srv.Validators.Validate(files[i].Path, []byte(edit.Text)); err != nil {
+			return result, http.StatusInternalServerError, err
+		}
+		edit.Rejected = !valid
+		result.Rejected = result.Rejected || !valid
+	}
+	if result.Rejected {
+		return result, http.StatusUnprocessableEntity, nil
+	}
+	written := make([]fileChange, 0, len(set.Edits))
+	// Write the files one after another, the files written so far are written back if one of them fails
+	fail := func(status int, err error) (ChangeSetResult, int, error) {
+		result.Error = err.Error()
+		if rollBackErr := srv.rollBack(r, written, result.Error); rollBackErr != nil {
+			result.Error = rollBackErr.Error()
+			result.RollbackFailures = rollBackErr.Failures
+		} else {
+			result.RolledBack = true
+		}
+		return result, status, nil
+	}
+	for i, req := range set.Edits {
+		edit := &result.Results[i]
+		if len(edit.Splices) == 0 && !req.Create {
+			continue
+		}
+		// The validators take a while, make sure the result does not overwrite a change made meanwhile
+		current, err := ioutil.ReadFile(files[i].Path)
+		if req.Create && !os.IsNotExist(err) || !req.Create && (err != nil || string(current) != edit.original) {
+			return fail(http.StatusPreconditionFailed, &Conflict{Path: files[i].Path, BaseHash: contentHash(edit.original), Hash: contentHash(string(current))})
+		}
+		if edit.Backup, err = srv.Writer.WriteFile(files[i].Path, []byte(edit.Text)); err != nil {
+			return fail(http.StatusInternalServerError, err)
+		}
+		edit.Applied = true
+		written = append(written, fileChange{file: files[i], before: edit.original, after: edit.Text, created: req.Create})
+		if err := srv.recordChange(r, files[i], edit.Changes, edit.original, edit.Text); err != nil {
+			return fail(http.StatusInternalServerError, err)
+		}
+	}
+	result.Applied = true
+	var reloadErr error
+	if result.Services, result.RolledBack, reloadErr = srv.reloadServices(r, written); reloadErr != nil {
+		result.Error = reloadErr.Error()
+		if rollBackErr, ok := reloadErr.(*RollbackError); ok {
+			result.RollbackFailures = rollBackErr.Failures
+		}
+		return result, http.StatusInternalServerError, nil
+	} else if result.RolledBack {
+		return result, http.StatusFailedDependency, nil
+	}
+	for _, change := range written {
+		if change.created {
+			srv.addFile(change.file)
+		}
+	}
+	return result, http.StatusOK, nil
+}
+
+// Preview or apply a change set, depending on the endpoint.
+func (srv *Server) handleChangeSet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var set ChangeSet
+	if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+		writeError(w, http.StatusBadRequest, "malformed change set: "+err.Error())
+		return
+	}
+	result, status, err := srv.applyChangeSet(r, set, r.URL.Path == "/api/changeset/apply")
+	if denials, ok := err.(denialsError); ok {
+		writeDenials(w, denials)
+	} else if conflict, ok := err.(*Conflict); ok {
+		writeConflict(w, conflict)
+	} else if err != nil {
+		writeError(w, status, err.Error())
+	} else {
+		writeJSON(w, status, result)
+	}
+}
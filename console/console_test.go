@@ -0,0 +1,1269 @@
+package console
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/diff"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/patch"
+)
+
+var namedConf = "options {\n\tdirectory \"/var\"; # dir\n};\n"
+
+// Create a directory with a named.conf and a file of unknown format, return the directory.
+func makeFiles(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "lmc-console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "named.conf"), []byte(namedConf), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "motd"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func request(t *testing.T, srv http.Handler, method, url string, body interface{}, status int, response interface{}) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&reqBody).Encode(body)
+	}
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, httptest.NewRequest(method, url, &reqBody))
+	if recorder.Code != status {
+		t.Fatal(method, url, recorder.Code, recorder.Body.String())
+	}
+	if response != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindFiles(t *testing.T) {
+	dir := makeFiles(t)
+	defer os.RemoveAll(dir)
+	files, err := FindFiles([]string{filepath.Join(dir, "*")})
+	if err != nil || len(files) != 1 || files[0].Format != "named" {
+		t.Fatal(files, err)
+	}
+	files, err = FindFiles([]string{filepath.Join(dir, "*"), "sysconfig=" + filepath.Join(dir, "motd")})
+	if err != nil || len(files) != 2 || files[0].Format != "sysconfig" {
+		t.Fatal(files, err)
+	}
+	if _, err := FindFiles([]string{"nonsense=/etc/*"}); err == nil {
+		t.Fatal("did not error")
+	}
+	// A path that is not clean may escape the pattern
+	if file, found := MatchSpecs([]string{"/etc/*/named.conf"}, "/etc/bind/named.conf"); !found || file.Format != "named" {
+		t.Fatal(file)
+	}
+	for _, path := range []string{"/etc/../named.conf", "/etc/bind/./named.conf", "/etc//bind/named.conf"} {
+		if file, found := MatchSpecs([]string{"/etc/*/named.conf", "named=/etc/**"}, path); found {
+			t.Fatal(file)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	dir := makeFiles(t)
+	defer os.RemoveAll(dir)
+	files, _ := FindFiles([]string{filepath.Join(dir, "*")})
+	srv := NewServer(files, nil)
+	path := filepath.Join(dir, "named.conf")
+
+	var listed []ManagedFile
+	request(t, srv, "GET", "/api/files", nil, http.StatusOK, &listed)
+	if len(listed) != 1 || listed[0].Path != path {
+		t.Fatal(listed)
+	}
+	var content FileContent
+	request(t, srv, "GET", "/api/file?path="+path, nil, http.StatusOK, &content)
+	if content.Text != namedConf || content.Tree.VerbatimText() != namedConf {
+		t.Fatal(content)
+	}
+	request(t, srv, "GET", "/api/file?path=/etc/shadow", nil, http.StatusNotFound, nil)
+	request(t, srv, "POST", "/api/files", nil, http.StatusMethodNotAllowed, nil)
+
+	edit := map[string]interface{}{"path": path, "operations": []map[string]interface{}{
+		{"op": "set", "path": "options/directory", "values": []string{"/var/named"}},
+		{"op": "set", "path": "options/notify", "values": []string{"yes"}},
+	}}
+	var result EditResult
+	request(t, srv, "POST", "/api/preview", edit, http.StatusOK, &result)
+	if !result.Succeeded || result.Applied || len(result.Changes) != 2 || len(result.Splices) != 2 ||
+		result.Text != "options {\n\tdirectory \"/var/named\"; # dir\n\tnotify yes;\n};\n" {
+		t.Fatal(result)
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) != namedConf {
+		t.Fatal("preview has written the file")
+	}
+	request(t, srv, "POST", "/api/apply", edit, http.StatusOK, &result)
+	if content, _ := ioutil.ReadFile(path); !result.Applied || string(content) != result.Text {
+		t.Fatal(result, string(content))
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0640 {
+		t.Fatal(info.Mode())
+	}
+	if backup, _ := ioutil.ReadFile(result.Backup); string(backup) != namedConf {
+		t.Fatal(result.Backup, string(backup))
+	}
+	// Backups are not managed
+	if files, _ := FindFiles([]string{filepath.Join(dir, "*")}); len(files) != 1 {
+		t.Fatal(files)
+	}
+	// A failed precondition leaves the file alone
+	edit = map[string]interface{}{"path": path, "operations": []map[string]interface{}{
+		{"op": "remove", "path": "options/directory", "expect": []string{"/nowhere"}},
+	}}
+	result = EditResult{}
+	request(t, srv, "POST", "/api/apply", edit, http.StatusConflict, &result)
+	if result.Succeeded || result.Applied {
+		t.Fatal(result)
+	}
+	request(t, srv, "POST", "/api/apply", "not an edit", http.StatusBadRequest, nil)
+}
+
+func TestOutline(t *testing.T) {
+	file := ManagedFile{Format: "httpd"}
+	text := "#Listen 80\n<VirtualHost *:80>\n  # the name\n  ServerName a # why\n  #ServerAdmin root\n</VirtualHost>\n"
+	config := file.Config()
+	root := lexer.NewLexer(text, config, &lexer.LexerDebugNoop{}).Run()
+	outline := Outline(root, file.Config())
+	if len(outline) != 2 || outline[0].Kind != OUTLINE_DISABLED || outline[0].Path != "Listen" || outline[0].Values[0] != "80" {
+		t.Fatal(outline)
+	}
+	vhost := outline[1]
+	if vhost.Kind != OUTLINE_SECTION || len(vhost.Args) != 3 || len(vhost.Children) != 2 {
+		t.Fatal(vhost)
+	}
+	if name := vhost.Children[0]; name.Path != `VirtualHost["*" ":" "80"]/ServerName` || name.Values[0] != "a" ||
+		strings.Join(name.Comments, ",") != "the name,why" {
+		t.Fatal(name)
+	}
+	if admin := vhost.Children[1]; admin.Kind != OUTLINE_DISABLED || admin.Path != `VirtualHost["*" ":" "80"]/ServerAdmin` {
+		t.Fatal(admin)
+	}
+}
+
+func TestUI(t *testing.T) {
+	srv := NewServer(nil, nil)
+	for _, path := range []string{"/", "/app.js", "/style.css"} {
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		if recorder.Code != http.StatusOK || recorder.Body.Len() == 0 {
+			t.Fatal(path, recorder.Code)
+		}
+	}
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(recorder.Body.String(), "app.js") {
+		t.Fatal(recorder.Body.String())
+	}
+}
+
+func TestPassword(t *testing.T) {
+	for _, test := range []struct{ password, setting, hashed string }{
+		{"Hello world!", "$5$saltstring", "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+		{"Hello world!", "$6$saltstring", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+		{"Hello world!", "$5$rounds=10000$saltstringsaltstring", "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA"},
+	} {
+		if hashed, err := shaCrypt(test.password, test.setting); err != nil || hashed != test.hashed {
+			t.Fatal(hashed, err)
+		}
+	}
+	hashed, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matched, err := VerifyPassword("secret", hashed); !matched || err != nil {
+		t.Fatal(hashed, err)
+	}
+	if matched, err := VerifyPassword("Secret", hashed); matched || err != nil {
+		t.Fatal(hashed, err)
+	}
+	for _, test := range []struct{ password, hashed string }{
+		{"U*U", "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"},
+		{"U*U*", "$2b$05$CCCCCCCCCCCCCCCCCCCCC.VGOzA784oUp/Z0DY336zx7pLYAy0lwK"},
+		{"", "$2y$05$CCCCCCCCCCCCCCCCCCCCC.7uG0VCzI2bS7j6ymqJi9CdcdxiRTWNy"},
+	} {
+		if matched, err := VerifyPassword(test.password, test.hashed); !matched || err != nil {
+			t.Fatal(test.hashed, err)
+		}
+		if matched, err := VerifyPassword(test.password+"x", test.hashed); matched || err != nil {
+			t.Fatal(test.hashed, err)
+		}
+	}
+	for _, malformed := range []string{"$2y$10$abcdefghijklmnopqrstu", "$2x$05$CCCCCCCCCCCCCCCCCCCCC.", "$2y$99$CCCCCCCCCCCCCCCCCCCCC.", "$1$salt$hash"} {
+		if _, err := VerifyPassword("secret", malformed); err != ErrUnsupportedHash {
+			t.Fatal(malformed, err)
+		}
+	}
+	if _, err := VerifyPassword(strings.Repeat("a", PASSWORD_MAX_LENGTH+1), hashed); err != ErrPasswordTooLong {
+		t.Fatal(err)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	throttle := NewThrottle()
+	now := time.Now()
+	throttle.now = func() time.Time { return now }
+	for i := 0; i < LOGIN_FREE_ATTEMPTS; i++ {
+		if wait := throttle.Wait("a", "b"); wait != 0 {
+			t.Fatal(i, wait)
+		}
+		throttle.Record(false, "a")
+	}
+	throttle.Record(false, "a")
+	if wait := throttle.Wait("b", "a"); wait != time.Second {
+		t.Fatal(wait)
+	}
+	throttle.Record(false, "a")
+	if wait := throttle.Wait("a"); wait != 2*time.Second {
+		t.Fatal(wait)
+	}
+	now = now.Add(2 * time.Second)
+	if wait := throttle.Wait("a"); wait != 0 {
+		t.Fatal(wait)
+	}
+	throttle.Record(true, "a")
+	throttle.Record(false, "a")
+	if wait := throttle.Wait("a"); wait != 0 {
+		t.Fatal(wait)
+	}
+	// A reserved attempt counts as a failure until it is recorded as a success
+	throttle.Record(true, "a")
+	for i := 0; i <= LOGIN_FREE_ATTEMPTS; i++ {
+		if wait := throttle.Reserve("a"); wait != 0 {
+			t.Fatal(i, wait)
+		}
+	}
+	if wait := throttle.Reserve("a"); wait != time.Second {
+		t.Fatal(wait)
+	}
+}
+
+func TestConcurrentLogin(t *testing.T) {
+	dir := makeFiles(t)
+	defer os.RemoveAll(dir)
+	hashed, _ := HashPassword("secret")
+	usersPath := filepath.Join(dir, "users")
+	if err := ioutil.WriteFile(usersPath, []byte("admin:"+hashed+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := NewUserFile(usersPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(nil, users)
+	// All attempts start before any password check finishes, yet only the free attempts and one more are checked
+	var wg sync.WaitGroup
+	codes := make(chan int, 20)
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, _ := json.Marshal(LoginRequest{"admin", "wrong"})
+			recorder := httptest.NewRecorder()
+			srv.ServeHTTP(recorder, httptest.NewRequest("POST", "/api/login", bytes.NewReader(body)))
+			codes <- recorder.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	checked := 0
+	for code := range codes {
+		if code == http.StatusUnauthorized {
+			checked++
+		} else if code != http.StatusTooManyRequests {
+			t.Fatal(code)
+		}
+	}
+	if checked != LOGIN_FREE_ATTEMPTS+1 {
+		t.Fatal(checked)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	dir := makeFiles(t)
+	defer os.RemoveAll(dir)
+	hashed, _ := HashPassword("secret")
+	usersPath := filepath.Join(dir, "users")
+	if err := ioutil.WriteFile(usersPath, []byte("# console users\nadmin:"+hashed+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := NewUserFile(usersPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Authenticate("admin", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Authenticate("admin", "wrong"); err != ErrBadCredentials {
+		t.Fatal(err)
+	}
+	if err := users.Authenticate("nobody", "secret"); err != ErrBadCredentials {
+		t.Fatal(err)
+	}
+	files, _ := FindFiles([]string{filepath.Join(dir, "*.conf")})
+	srv := NewServer(files, users)
+	now := time.Now()
+	srv.Sessions.now = func() time.Time { return now }
+
+	call := func(method, url, cookie, csrfToken string, body interface{}) *httptest.ResponseRecorder {
+		var reqBody bytes.Buffer
+		json.NewEncoder(&reqBody).Encode(body)
+		req := httptest.NewRequest(method, url, &reqBody)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: SESSION_COOKIE, Value: cookie})
+		}
+		if csrfToken != "" {
+			req.Header.Set(CSRF_HEADER, csrfToken)
+		}
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+		return recorder
+	}
+	if resp := call("GET", "/api/files", "", "", nil); resp.Code != http.StatusUnauthorized {
+		t.Fatal(resp.Code)
+	}
+	if resp := call("GET", "/", "", "", nil); resp.Code != http.StatusOK {
+		t.Fatal(resp.Code)
+	}
+	if resp := call("POST", "/api/login", "", "", LoginRequest{"admin", "wrong"}); resp.Code != http.StatusUnauthorized {
+		t.Fatal(resp.Code)
+	}
+	// Long passwords are refused before they are hashed, and so are large requests before they are decoded
+	long := strings.Repeat("a", PASSWORD_MAX_LENGTH+1)
+	if resp := call("POST", "/api/login", "", "", LoginRequest{"admin", long}); resp.Code != http.StatusUnauthorized {
+		t.Fatal(resp.Code)
+	}
+	long = strings.Repeat("a", LOGIN_BODY_MAX)
+	if resp := call("POST", "/api/login", "", "", LoginRequest{"admin", long}); resp.Code != http.StatusBadRequest {
+		t.Fatal(resp.Code)
+	}
+	resp := call("POST", "/api/login", "", "", LoginRequest{"admin", "secret"})
+	var info SessionInfo
+	json.Unmarshal(resp.Body.Bytes(), &info)
+	cookies := resp.Result().Cookies()
+	if resp.Code != http.StatusOK || info.User != "admin" || len(cookies) != 1 || !cookies[0].HttpOnly ||
+		cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatal(resp.Code, info, cookies)
+	}
+	cookie := cookies[0].Value
+	if resp := call("GET", "/api/files", cookie, "", nil); resp.Code != http.StatusOK {
+		t.Fatal(resp.Code)
+	}
+	// Mutating requests need the CSRF token
+	edit := EditRequest{Path: filepath.Join(dir, "named.conf")}
+	if resp := call("POST", "/api/preview", cookie, "", edit); resp.Code != http.StatusForbidden {
+		t.Fatal(resp.Code)
+	}
+	if resp := call("POST", "/api/preview", cookie, info.CSRFToken, edit); resp.Code != http.StatusOK {
+		t.Fatal(resp.Code, resp.Body.String())
+	}
+	// Idle sessions expire, and so do old sessions regardless of activity
+	now = now.Add(SESSION_IDLE_TIMEOUT + time.Second)
+	if resp := call("GET", "/api/session", cookie, "", nil); resp.Code != http.StatusUnauthorized {
+		t.Fatal(resp.Code)
+	}
+	cookie = call("POST", "/api/login", "", "", LoginRequest{"admin", "secret"}).Result().Cookies()[0].Value
+	for elapsed := time.Duration(0); elapsed <= SESSION_MAX_AGE; elapsed += SESSION_IDLE_TIMEOUT / 2 {
+		now = now.Add(SESSION_IDLE_TIMEOUT / 2)
+		call("GET", "/api/session", cookie, "", nil)
+	}
+	if resp := call("GET", "/api/session", cookie, "", nil); resp.Code != http.StatusUnauthorized {
+		t.Fatal(resp.Code)
+	}
+	// Logging out ends the session
+	resp = call("POST", "/api/login", "", "", LoginRequest{"admin", "secret"})
+	json.Unmarshal(resp.Body.Bytes(), &info)
+	cookie = resp.Result().Cookies()[0].Value
+	if resp := call("POST", "/api/logout", cookie, info.CSRFToken, nil); resp.Code != http.StatusOK {
+		t.Fatal(resp.Code)
+	}
+	if resp := call("GET", "/api/session", cookie, "", nil); resp.Code != http.StatusUnauthorized {
+		t.Fatal(resp.Code)
+	}
+	// Repeated failures are throttled
+	for i := 0; i <= LOGIN_FREE_ATTEMPTS; i++ {
+		call("POST", "/api/login", "", "", LoginRequest{"admin", "wrong"})
+	}
+	if resp := call("POST", "/api/login", "", "", LoginRequest{"admin", "secret"}); resp.Code != http.StatusTooManyRequests ||
+		resp.Header().Get("Retry-After") == "" {
+		t.Fatal(resp.Code)
+	}
+}
+
+var policyJSON = `{
+	"users": {"junior": ["junior"], "root": ["admin"]},
+	"roles": {
+		"admin": [{"files": "/**", "permissions": ["read", "edit", "apply"]}],
+		"junior": [
+			{"files": "/etc/hosts", "permissions": ["read", "edit", "apply"]},
+			{"files": "/var/spool/cron/**", "permissions": ["read", "edit", "apply"]},
+			{"files": "/etc/named.conf", "permissions": ["read", "edit", "apply"]},
+			{"name": "named-options", "files": "/etc/named.conf", "path": "options", "permissions": ["edit", "apply"], "deny": true},
+			{"files": "/etc/named.conf", "path": "zone", "permissions": ["apply"], "deny": true}
+		]
+	}
+}`
+
+func TestPolicy(t *testing.T) {
+	policy, err := ParsePolicy([]byte(policyJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		user, permission, file, path string
+		allowed                      bool
+		rule                         string
+	}{
+		{"root", PERMISSION_APPLY, "/etc/sudoers", "", true, ""},
+		{"junior", PERMISSION_APPLY, "/etc/hosts", "127.0.0.1", true, ""},
+		{"junior", PERMISSION_EDIT, "/var/spool/cron/tabs/root", "MAILTO", true, ""},
+		{"junior", PERMISSION_READ, "/etc/sudoers", "", false, ""},
+		{"junior", PERMISSION_EDIT, "/etc/login.defs", "PASS_MAX_DAYS", false, ""},
+		{"junior", PERMISSION_READ, "/etc/named.conf", "", true, ""},
+		{"junior", PERMISSION_EDIT, "/etc/named.conf", "options/directory", false, "named-options"},
+		{"junior", PERMISSION_EDIT, "/etc/named.conf", "options", false, "named-options"},
+		{"junior", PERMISSION_EDIT, "/etc/named.conf", `zone["a"]/type`, true, ""},
+		{"junior", PERMISSION_APPLY, "/etc/named.conf", `zone["a"]/type`, false, "junior#4"},
+		{"nobody", PERMISSION_READ, "/etc/hosts", "", false, ""},
+	} {
+		err := policy.Check(test.user, test.permission, test.file, test.path)
+		if test.allowed != (err == nil) || !test.allowed && err.(*AccessDenied).Rule != test.rule {
+			t.Fatal(test, err)
+		}
+	}
+	if _, err := ParsePolicy([]byte(`{"users": {"a": ["b"]}}`)); err == nil {
+		t.Fatal("did not error")
+	}
+	if _, err := ParsePolicy([]byte(`{"roles": {"b": [{"files": "*", "permissions": ["delete"]}]}}`)); err == nil {
+		t.Fatal("did not error")
+	}
+
+	// The server enforces the policy upon the user of the session
+	dir := makeFiles(t)
+	defer os.RemoveAll(dir)
+	files, _ := FindFiles([]string{filepath.Join(dir, "*")})
+	srv := NewServer(files, nil)
+	srv.Policy, _ = ParsePolicy([]byte(strings.Replace(policyJSON, "/etc/named.conf", filepath.Join(dir, "named.conf"), -1)))
+	session := &Session{User: "junior"}
+	call := func(url string, body interface{}) *httptest.ResponseRecorder {
+		var reqBody bytes.Buffer
+		json.NewEncoder(&reqBody).Encode(body)
+		req := httptest.NewRequest("POST", url, &reqBody)
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req.WithContext(context.WithValue(req.Context(), sessionContextKey, session)))
+		return recorder
+	}
+	edit := map[string]interface{}{"path": filepath.Join(dir, "named.conf"), "operations": []map[string]interface{}{
+		{"op": "set", "path": "options/notify", "values": []string{"yes"}},
+		{"op": "set", "path": `zone["a"]/type`, "values": []string{"master"}},
+	}}
+	resp := call("/api/preview", edit)
+	if resp.Code != http.StatusForbidden || !strings.Contains(resp.Body.String(), `as denied by rule \"named-options\"`) {
+		t.Fatal(resp.Code, resp.Body.String())
+	}
+	// Inserted text may not smuggle in a node other than the one its path names
+	smuggle := map[string]interface{}{"path": filepath.Join(dir, "named.conf"), "operations": []map[string]interface{}{
+		{"op": "insert", "path": `zone["x"]`, "text": "options { notify no; };\n"},
+	}}
+	if resp := call("/api/preview", smuggle); resp.Code != http.StatusConflict || !strings.Contains(resp.Body.String(), `"changes":[]`) {
+		t.Fatal(resp.Code, resp.Body.String())
+	}
+	if denials := srv.checkChanges(httptest.NewRequest("GET", "/", nil).WithContext(context.WithValue(context.Background(), sessionContextKey, session)),
+		ManagedFile{Path: filepath.Join(dir, "named.conf")}, []diff.Change{{Kind: diff.CHANGE_ADDED, Path: "options#1"}}, false); len(denials) != 1 {
+		t.Fatal(denials)
+	}
+	session.User = "root"
+	if resp := call("/api/preview", edit); resp.Code != http.StatusConflict {
+		t.Fatal(resp.Code, resp.Body.String())
+	}
+}
+
+func TestAudit(t *testing.T) {
+	dir := makeFiles(t)
+	defer os.RemoveAll(dir)
+	files, _ := FindFiles([]string{filepath.Join(dir, "*")})
+	srv := NewServer(files, nil)
+	auditPath := filepath.Join(dir, "audit.log")
+	var err error
+	if srv.Audit, err = OpenAuditLog(auditPath); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	srv.Audit.now = func() time.Time { return now }
+	path := filepath.Join(dir, "named.conf")
+	for _, values := range [][]string{{"/var/named"}, {"/srv/named"}} {
+		edit := map[string]interface{}{"path": path, "operations": []map[string]interface{}{
+			{"op": "set", "path": "options/directory", "values": values},
+		}}
+		request(t, srv, "POST", "/api/apply", edit, http.StatusOK, nil)
+		now = now.Add(time.Hour)
+	}
+	// Previews and edits that change nothing are not recorded
+	request(t, srv, "POST", "/api/preview", map[string]interface{}{"path": path, "operations": []map[string]interface{}{
+		{"op": "set", "path": "options/directory", "values": []string{"/a"}}}}, http.StatusOK, nil)
+	var entries []AuditEntry
+	request(t, srv, "GET", "/api/audit?directive=directory", nil, http.StatusOK, &entries)
+	if len(entries) != 2 || entries[0].Sequence != 1 || entries[1].PrevHash != entries[0].Hash ||
+		entries[0].AfterHash != entries[1].BeforeHash || entries[0].BeforeHash != contentHash(namedConf) ||
+		entries[1].Changes[0].OldValues[0] != "/var/named" || entries[1].File != path {
+		t.Fatal(entries)
+	}
+	request(t, srv, "GET", "/api/audit?since=2020-01-01T00:30:00Z", nil, http.StatusOK, &entries)
+	if len(entries) != 1 || entries[0].Sequence != 2 {
+		t.Fatal(entries)
+	}
+	request(t, srv, "GET", "/api/audit?directive=notify", nil, http.StatusOK, &entries)
+	if len(entries) != 0 {
+		t.Fatal(entries)
+	}
+	request(t, srv, "GET", "/api/audit?until=yesterday", nil, http.StatusBadRequest, nil)
+	var verification AuditVerification
+	request(t, srv, "GET", "/api/audit/verify", nil, http.StatusOK, &verification)
+	if !verification.Intact || verification.Entries != 2 || verification.LastHash != lastAuditHash(t, auditPath) {
+		t.Fatal(verification)
+	}
+	// The log continues the chain after being opened again
+	reopened, err := OpenAuditLog(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := reopened.Record("root", "", path, nil, "a", "b"); err != nil || entry.Sequence != 3 || entry.PrevHash != verification.LastHash {
+		t.Fatal(entry, err)
+	}
+	// Tampering, gaps, entries cut off the end, and a log written anew are detected
+	content, _ := ioutil.ReadFile(auditPath)
+	lines := strings.SplitAfter(string(content), "\n")
+	anewPath := filepath.Join(dir, "anew.log")
+	anew, err := OpenAuditLog(anewPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		anew.Record("root", "", path, nil, "a", "b")
+	}
+	anewContent, _ := ioutil.ReadFile(anewPath)
+	for _, tampered := range []string{
+		lines[0] + strings.Replace(lines[1], "/srv/named", "/tmp/named", 1) + lines[2],
+		lines[0] + lines[2],
+		lines[1] + lines[2],
+		lines[0] + lines[1],
+		"",
+		string(anewContent),
+	} {
+		ioutil.WriteFile(auditPath, []byte(tampered), 0600)
+		if _, _, err := VerifyAuditLog(auditPath); err == nil {
+			t.Fatal("did not detect", tampered)
+		}
+		if _, err := OpenAuditLog(auditPath); err == nil {
+			t.Fatal("opened a broken log")
+		}
+	}
+	ioutil.WriteFile(auditPath, content, 0600)
+	if _, _, err := VerifyAuditLog(auditPath); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(auditPath + AUDIT_HEAD_SUFFIX)
+	if _, _, err := VerifyAuditLog(auditPath); err == nil {
+		t.Fatal("did not detect the missing head")
+	}
+}
+
+// Return the hash of the last entry in the audit log.
+func lastAuditHash(t *testing.T, auditPath string) string {
+	entries, err := (&AuditLog{Path: auditPath}).Query(AuditQuery{})
+	if err != nil || len(entries) == 0 {
+		t.Fatal(err)
+	}
+	return entries[len(entries)-1].Hash
+}
+
+func TestSnapshots(t *testing.T) {
+	dir := makeFiles(t)
+	defer os.RemoveAll(dir)
+	sysctlPath := filepath.Join(dir, "sysctl.conf")
+	if err := ioutil.WriteFile(sysctlPath, []byte("kernel.panic = 5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := FindFiles([]string{filepath.Join(dir, "*")})
+	srv := NewServer(files, nil)
+	var err error
+	if srv.Snapshots, err = OpenSnapshotStore(filepath.Join(dir, "snapshots"), Retention{}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	srv.Snapshots.now = func() time.Time { return now }
+	if err := srv.Snapshots.CaptureFiles(files); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "named.conf")
+	for _, values := range [][]string{{"/var/named"}, {"/srv/named"}} {
+		now = now.Add(time.Hour)
+		edit := map[string]interface{}{"path": path, "operations": []map[string]interface{}{
+			{"op": "set", "path": "options/directory", "values": values},
+		}}
+		request(t, srv, "POST", "/api/apply", edit, http.StatusOK, nil)
+	}
+	var versions []Version
+	request(t, srv, "GET", "/api/versions?path="+path, nil, http.StatusOK, &versions)
+	if len(versions) != 3 || versions[0].Hash != contentHash(namedConf) || !versions[2].Time.Equal(now) {
+		t.Fatal(versions)
+	}
+	var content VersionContent
+	request(t, srv, "GET", "/api/version?path="+path+"&hash="+versions[0].Hash, nil, http.StatusOK, &content)
+	if content.Text != namedConf || len(content.Outline) != 1 {
+		t.Fatal(content)
+	}
+	request(t, srv, "GET", "/api/version?path="+path+"&hash="+contentHash("nonsense"), nil, http.StatusNotFound, nil)
+	var changes VersionDiff
+	request(t, srv, "GET", "/api/versions/diff?path="+path+"&from="+versions[0].Hash, nil, http.StatusOK, &changes)
+	if len(changes.Changes) != 1 || changes.Changes[0].OldValues[0] != "/var" || changes.Changes[0].NewValues[0] != "/srv/named" {
+		t.Fatal(changes)
+	}
+	// Restore to the point in time between the two edits, then to the first version by its hash
+	var set RestoreSetResult
+	request(t, srv, "POST", "/api/restore", RestoreRequest{Time: versions[1].Time.Add(time.Minute)}, http.StatusOK, &set)
+	if written, _ := ioutil.ReadFile(path); len(set.Results) != 2 || !set.Results[0].Restored || set.Results[1].Restored ||
+		!strings.Contains(string(written), "/var/named") {
+		t.Fatal(set, string(written))
+	}
+	set = RestoreSetResult{}
+	request(t, srv, "POST", "/api/restore", RestoreRequest{Path: path, Hash: versions[0].Hash}, http.StatusOK, &set)
+	if written, _ := ioutil.ReadFile(path); !set.Results[0].Restored || string(written) != namedConf {
+		t.Fatal(set, string(written))
+	}
+	// Restoring the content the file already has does not write the file
+	set = RestoreSetResult{}
+	request(t, srv, "POST", "/api/restore", RestoreRequest{Path: path, Hash: versions[0].Hash}, http.StatusOK, &set)
+	if set.Results[0].Restored {
+		t.Fatal(set)
+	}
+	// Files that had no version at the time are left alone
+	set = RestoreSetResult{}
+	request(t, srv, "POST", "/api/restore", RestoreRequest{Time: versions[0].Time.Add(-time.Hour)}, http.StatusOK, &set)
+	if len(set.Results) != 2 || set.Results[0].Version != nil || set.Results[0].Restored {
+		t.Fatal(set)
+	}
+	request(t, srv, "POST", "/api/restore", RestoreRequest{Hash: versions[0].Hash}, http.StatusBadRequest, nil)
+	// The store cannot be opened twice, and is intact after being opened again
+	if _, err := OpenSnapshotStore(srv.Snapshots.Dir, Retention{}); err == nil {
+		t.Fatal("opened the store twice")
+	}
+	if err := srv.Snapshots.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenSnapshotStore(srv.Snapshots.Dir, Retention{})
+	if err != nil || len(reopened.Versions(path)) != 5 {
+		t.Fatal(reopened.Versions(path), err)
+	}
+	defer reopened.Close()
+	srv.Snapshots = reopened
+	// A file that cannot be restored rolls back the files restored before it
+	if err := ioutil.WriteFile(sysctlPath, []byte("kernel.panic = 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	request(t, srv, "POST", "/api/apply", map[string]interface{}{"path": path, "operations": []map[string]interface{}{
+		{"op": "set", "path": "options/directory", "values": []string{"/tmp"}},
+	}}, http.StatusOK, nil)
+	changed, _ := ioutil.ReadFile(path)
+	srv.Validators = Validators{{Files: "**/sysctl.conf", Command: []string{"sh", "-c", "echo >> " + sysctlPath}}}
+	set = RestoreSetResult{}
+	request(t, srv, "POST", "/api/restore", RestoreRequest{Time: versions[0].Time}, http.StatusPreconditionFailed, &set)
+	if written, _ := ioutil.ReadFile(path); !set.Results[0].Restored || set.Results[1].Restored || !set.RolledBack ||
+		set.Error == "" || string(written) != string(changed) {
+		t.Fatal(set, string(written))
+	}
+	if written, _ := ioutil.ReadFile(sysctlPath); string(written) != "kernel.panic = 10\n\n" {
+		t.Fatal(string(written))
+	}
+}
+
+func TestRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lmc-snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := OpenSnapshotStore(dir, Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	// Content that no version of the store refers to is not the store's to remove
+	stray := store.objectPath(contentHash("stray"))
+	if err := ioutil.WriteFile(stray, []byte("stray"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"a", "bb", "a", "cccc"} {
+		now = now.Add(time.Hour)
+		store.Capture("/x", []byte(content))
+	}
+	store.Capture("/y", []byte("a"))
+	// Capturing the latest content again does not make a new version
+	if _, captured, err := store.Capture("/x", []byte("cccc")); captured || err != nil || len(store.Versions("")) != 5 {
+		t.Fatal(captured, err, store.Versions(""))
+	}
+	store.Retention = Retention{MaxCount: 3}
+	if err := store.Prune(); err != nil || len(store.Versions("/x")) != 3 || store.Versions("/x")[0].Hash != contentHash("bb") {
+		t.Fatal(err, store.Versions("/x"))
+	}
+	// The content shared by versions of different files counts once, and the latest versions are kept
+	store.Retention = Retention{MaxSize: 4}
+	if err := store.Prune(); err != nil || len(store.Versions("/x")) != 1 || len(store.Versions("/y")) != 1 {
+		t.Fatal(err, store.Versions(""))
+	}
+	if _, err := store.Content(contentHash("bb")); !os.IsNotExist(err) {
+		t.Fatal("did not remove the content", err)
+	}
+	if content, err := store.Content(contentHash("a")); err != nil || string(content) != "a" {
+		t.Fatal(string(content), err)
+	}
+	if _, err := os.Stat(stray); err != nil {
+		t.Fatal("removed content of no pruned version", err)
+	}
+	now = now.Add(48 * time.Hour)
+	store.Capture("/x", []byte("ddd"))
+	store.Retention = Retention{MaxAge: 24 * time.Hour}
+	if err := store.Prune(); err != nil || len(store.Versions("")) != 2 || store.Versions("/x")[0].Hash != contentHash("ddd") {
+		t.Fatal(err, store.Versions(""))
+	}
+}
+
+func TestValidators(t *testing.T) {
+	dir := makeFiles(t)
+	defer os.RemoveAll(dir)
+	files, _ := FindFiles([]string{filepath.Join(dir, "*")})
+	srv := NewServer(files, nil)
+	validatorsPath := filepath.Join(dir, "validators.json")
+	ioutil.WriteFile(validatorsPath, []byte(`[
+		{"files": "**/named.conf", "command": ["sh", "-c", "if grep -q nowhere \"$0\"; then echo no such dir; exit 1; fi", "{file}"]},
+		{"files": "**/named.conf", "command": ["sh", "-c", "test -f \"$0/named.conf\" -a -f \"$0/motd\"", "{stagedir}"]},
+		{"files": "/etc/*", "command": ["false"]}
+	]`), 0644)
+	var err error
+	if srv.Validators, err = LoadValidators(validatorsPath); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "named.conf")
+	if matched := srv.Validators.For(path); len(matched) != 2 {
+		t.Fatal(matched)
+	}
+	if results, passed, err := srv.Validators.Validate("/etc/hosts", nil); passed || err != nil || results[0].Passed {
+		t.Fatal(results, passed, err)
+	}
+	var result EditResult
+	edit := map[string]interface{}{"path": path, "operations": []map[string]interface{}{
+		{"op": "set", "path": "options/directory", "values": []string{"/nowhere"}},
+	}}
+	request(t, srv, "POST", "/api/apply", edit, http.StatusUnprocessableEntity, &result)
+	if content, _ := ioutil.ReadFile(path); result.Applied || !result.Rejected || len(result.Validation) != 2 ||
+		result.Validation[0].Output != "no such dir\n" || !result.Validation[1].Passed || string(content) != namedConf {
+		t.Fatal(result, string(content))
+	}
+	edit = map[string]interface{}{"path": path, "operations": []map[string]interface{}{
+		{"op": "set", "path": "options/directory", "values": []string{"/var/named"}},
+	}}
+	result = EditResult{}
+	request(t, srv, "POST", "/api/apply", edit, http.StatusOK, &result)
+	if !result.Applied || result.Rejected || len(result.Validation) != 2 {
+		t.Fatal(result)
+	}
+	// The staged copies are removed
+	if staged, _ := filepath.Glob(filepath.Join(dir, "*lmc-check*")); len(staged) != 0 {
+		t.Fatal(staged)
+	}
+	ioutil.WriteFile(validatorsPath, []byte(`[{"files": "*"}]`), 0644)
+	if _, err := LoadValidators(validatorsPath); err == nil {
+		t.Fatal("did not error")
+	}
+}
+
+func TestServiceActions(t *testing.T) {
+	dir := makeFiles(t)
+	defer os.RemoveAll(dir)
+	files, _ := FindFiles([]string{filepath.Join(dir, "*")})
+	srv := NewServer(files, nil)
+	path := filepath.Join(dir, "named.conf")
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	var err error
+	srv.Services, err = ParseServiceActions([]byte(`[
+		{"files": "**/named.conf", "command": ["sh", "-c", "echo reloaded"], "probe": "` + healthy.URL + `"},
+		{"files": "**/named.conf", "command": ["true"], "check": ["sh", "-c", "! grep -q nowhere ` + path + `"], "timeout": "1s"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result EditResult
+	edit := map[string]interface{}{"path": path, "operations": []map[string]interface{}{
+		{"op": "set", "path": "options/directory", "values": []string{"/var/named"}},
+	}}
+	request(t, srv, "POST", "/api/apply", edit, http.StatusOK, &result)
+	if len(result.Services) != 2 || !result.Services[0].Healthy || result.Services[0].Output != "reloaded\n" || result.RolledBack {
+		t.Fatal(result)
+	}
+	applied := result.Text
+	// The service does not come back healthy, so the change is rolled back and the services are reloaded again
+	edit = map[string]interface{}{"path": path, "operations": []map[string]interface{}{
+		{"op": "set", "path": "options/directory", "values": []string{"/nowhere"}},
+	}}
+	result = EditResult{}
+	request(t, srv, "POST", "/api/apply", edit, http.StatusFailedDependency, &result)
+	if content, _ := ioutil.ReadFile(path); !result.RolledBack || len(result.Services) != 4 || result.Services[1].Healthy ||
+		!result.Services[3].Rollback || !result.Services[3].Healthy || string(content) != applied {
+		t.Fatal(result, string(content))
+	}
+	// Probes of a closed port and commands that fail make actions fail
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	for _, serialised := range []string{
+		`[{"files": "*", "command": ["true"], "probe": "tcp://` + closed.Addr().String() + `", "timeout": "1s"}]`,
+		`[{"files": "*", "command": ["false"]}]`,
+	} {
+		actions, err := ParseServiceActions([]byte(serialised))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := actions[0].Run(); result.Healthy || result.Error == "" {
+			t.Fatal(result)
+		}
+	}
+	for _, malformed := range []string{`[{"files": "*"}]`, `[{"files": "*", "command": ["true"], "probe": "udp://a:1"}]`,
+		`[{"files": "*", "command": ["true"], "timeout": "soon"}]`} {
+		if _, err := ParseServiceActions([]byte(malformed)); err == nil {
+			t.Fatal("did not error", malformed)
+		}
+	}
+}
+
+func TestChangeSet(t *testing.T) {
+	dir := makeFiles(t)
+	defer os.RemoveAll(dir)
+	srv := NewServer(nil, nil)
+	srv.Specs = []string{filepath.Join(dir, "*")}
+	srv.Files, _ = FindFiles(srv.Specs)
+	path := filepath.Join(dir, "named.conf")
+	zonePath := filepath.Join(dir, "example.com.zone")
+	set := func(notify, ttl string) ChangeSet {
+		return ChangeSet{Edits: []EditRequest{
+			{Path: path, Operations: []patch.Operation{{Op: patch.OP_SET, Path: "options/notify", Values: []string{notify}}}},
+			{Path: zonePath, Create: true, Operations: []patch.Operation{{Op: patch.OP_SET, Path: "$TTL", Values: []string{ttl}}}},
+		}}
+	}
+	var result ChangeSetResult
+	request(t, srv, "POST", "/api/changeset/preview", set("yes", "3600"), http.StatusOK, &result)
+	if _, err := os.Stat(zonePath); !result.Succeeded || result.Applied || len(result.Results) != 2 ||
+		result.Results[1].Text != "$TTL 3600" || !os.IsNotExist(err) {
+		t.Fatal(result, err)
+	}
+	// A validator rejects the zone, so neither file is written
+	srv.Validators = Validators{{Files: "**.zone", Command: []string{"sh", "-c", "! grep -q 0000 \"$0\"", "{file}"}}}
+	result = ChangeSetResult{}
+	request(t, srv, "POST", "/api/changeset/apply", set("yes", "0000"), http.StatusUnprocessableEntity, &result)
+	if content, _ := ioutil.ReadFile(path); !result.Rejected || result.Applied || !result.Results[1].Rejected || string(content) != namedConf {
+		t.Fatal(result, string(content))
+	}
+	// The service is not healthy, so named.conf is written back and the zone is removed
+	srv.Services = ServiceActions{{Files: "**/named.conf", Command: []string{"true"},
+		Check: []string{"sh", "-c", "! grep -q 'notify no' " + path}, Timeout: "1s", timeout: time.Second}}
+	result = ChangeSetResult{}
+	request(t, srv, "POST", "/api/changeset/apply", set("no", "3600"), http.StatusFailedDependency, &result)
+	if content, _ := ioutil.ReadFile(path); !result.Applied || !result.RolledBack || string(content) != namedConf {
+		t.Fatal(result, string(content))
+	}
+	if _, err := os.Stat(zonePath); !os.IsNotExist(err) {
+		t.Fatal("zone is not removed", err)
+	}
+	// The zone is gone before it is rolled back, named.conf is still written back
+	check := srv.Services[0].Check
+	srv.Services[0].Check = []string{"sh", "-c", "rm -f " + zonePath + "; false"}
+	result = ChangeSetResult{}
+	request(t, srv, "POST", "/api/changeset/apply", set("no", "3600"), http.StatusInternalServerError, &result)
+	if content, _ := ioutil.ReadFile(path); result.RolledBack || len(result.RollbackFailures) != 1 ||
+		result.RollbackFailures[0].Path != zonePath || !strings.Contains(result.Error, "1 of 2 files") || string(content) != namedConf {
+		t.Fatal(result, string(content))
+	}
+	srv.Services[0].Check = check
+	result = ChangeSetResult{}
+	request(t, srv, "POST", "/api/changeset/apply", set("yes", "3600"), http.StatusOK, &result)
+	if content, _ := ioutil.ReadFile(zonePath); !result.Applied || result.RolledBack || string(content) != "$TTL 3600" {
+		t.Fatal(result, string(content))
+	}
+	// The new zone is managed, hence it may not be created again
+	var listed []ManagedFile
+	request(t, srv, "GET", "/api/files", nil, http.StatusOK, &listed)
+	if len(listed) != 2 || listed[0].Path != zonePath || listed[0].Format != "named-zone" {
+		t.Fatal(listed)
+	}
+	request(t, srv, "POST", "/api/changeset/apply", set("yes", "3600"), http.StatusNotFound, nil)
+	// New files must match the specifications
+	request(t, srv, "POST", "/api/changeset/apply", ChangeSet{Edits: []EditRequest{{Path: "/etc/evil.zone", Create: true}}}, http.StatusNotFound, nil)
+	request(t, srv, "POST", "/api/changeset/apply", ChangeSet{Edits: []EditRequest{{Path: path}, {Path: path}}}, http.StatusBadRequest, nil)
+}
+
+func TestConcurrentEdits(t *testing.T) {
+	dir := makeFiles(t)
+	defer os.RemoveAll(dir)
+	files, _ := FindFiles([]string{filepath.Join(dir, "*")})
+	srv := NewServer(files, nil)
+	path := filepath.Join(dir, "named.conf")
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/file?path="+path, nil))
+	var content FileContent
+	json.Unmarshal(recorder.Body.Bytes(), &content)
+	if etag := recorder.Header().Get("ETag"); etag != `"`+contentHash(namedConf)+`"` || content.Hash != contentHash(namedConf) || content.ModTime.IsZero() {
+		t.Fatal(etag, content)
+	}
+	// Someone else changes the file
+	changed := strings.Replace(namedConf, "};", "\tnotify no;\n};", 1)
+	ioutil.WriteFile(path, []byte(changed), 0640)
+	edit := EditRequest{Path: path, Operations: []patch.Operation{{Op: patch.OP_SET, Path: "options/directory", Values: []string{"/srv"}}}}
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(edit)
+	req := httptest.NewRequest("POST", "/api/apply", &body)
+	req.Header.Set("If-Match", recorder.Header().Get("ETag"))
+	recorder = httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req)
+	if written, _ := ioutil.ReadFile(path); recorder.Code != http.StatusPreconditionFailed || string(written) != changed ||
+		recorder.Header().Get("ETag") != `"`+contentHash(changed)+`"` {
+		t.Fatal(recorder.Code, recorder.Body.String(), string(written))
+	}
+	// The operations are replayed on the file's current content upon request
+	edit.BaseHash, edit.Rebase = content.Hash, true
+	var result EditResult
+	request(t, srv, "POST", "/api/apply", edit, http.StatusOK, &result)
+	if written, _ := ioutil.ReadFile(path); !result.Rebased || !result.Applied ||
+		string(written) != "options {\n\tdirectory \"/srv\"; # dir\n\tnotify no;\n};\n" || result.Hash != contentHash(string(written)) {
+		t.Fatal(result, string(written))
+	}
+	edit.Rebase = false
+	request(t, srv, "POST", "/api/preview", edit, http.StatusPreconditionFailed, nil)
+	edit.BaseHash = result.Hash
+	request(t, srv, "POST", "/api/preview", edit, http.StatusOK, &result)
+	request(t, srv, "POST", "/api/changeset/apply", ChangeSet{Edits: []EditRequest{{Path: path, BaseHash: content.Hash}}}, http.StatusPreconditionFailed, nil)
+}
+
+func TestWatcher(t *testing.T) {
+	dir := makeFiles(t)
+	defer os.RemoveAll(dir)
+	srv := NewServer(nil, nil)
+	srv.Specs = []string{filepath.Join(dir, "*")}
+	srv.Files, _ = FindFiles(srv.Specs)
+	watcher, err := srv.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	watcher.Debounce = 50 * time.Millisecond
+	httpServer := httptest.NewServer(srv)
+	defer httpServer.Close()
+	resp, err := http.Get(httpServer.URL + "/api/events")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal(resp, err)
+	}
+	defer resp.Body.Close()
+	stream := bufio.NewReader(resp.Body)
+	next := func() (event FileEvent) {
+		lines := make(chan string)
+		go func() {
+			for {
+				line, err := stream.ReadString('\n')
+				if err != nil {
+					close(lines)
+					return
+				}
+				if strings.HasPrefix(line, "data: ") {
+					lines <- strings.TrimPrefix(line, "data: ")
+					return
+				}
+			}
+		}()
+		select {
+		case line := <-lines:
+			if err := json.Unmarshal([]byte(line), &event); err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+		}
+		return
+	}
+	// An editor writes a new file and renames it over the managed file
+	path := filepath.Join(dir, "named.conf")
+	changed := strings.Replace(namedConf, "\"/var\"", "\"/srv\"", 1)
+	ioutil.WriteFile(path+".swp", []byte(changed), 0640)
+	os.Rename(path+".swp", path)
+	if event := next(); event.Path != path || event.Hash != contentHash(changed) || len(event.Changes) != 1 ||
+		event.Changes[0].NewValues[0] != "/srv" {
+		t.Fatal(event)
+	}
+	watcher.lock.Lock()
+	watched := watcher.index[path]
+	watcher.lock.Unlock()
+	if !watched.exists || watched.text != changed || watched.root.VerbatimText() != changed {
+		t.Fatal(watched)
+	}
+	// The console's own changes are not reported, the next event is about the new zone file
+	request(t, srv, "POST", "/api/apply", EditRequest{Path: path, Operations: []patch.Operation{
+		{Op: patch.OP_SET, Path: "options/notify", Values: []string{"no"}}}}, http.StatusOK, nil)
+	time.Sleep(200 * time.Millisecond)
+	zonePath := filepath.Join(dir, "db.example")
+	ioutil.WriteFile(zonePath, []byte("$TTL 60\n"), 0644)
+	if event := next(); event.Path != zonePath || !event.Created || len(event.Changes) != 1 {
+		t.Fatal(event)
+	}
+	if _, managed := srv.file(zonePath); !managed {
+		t.Fatal("new zone is not managed")
+	}
+	os.Remove(zonePath)
+	if event := next(); event.Path != zonePath || !event.Removed || event.Hash != "" {
+		t.Fatal(event)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	dir := makeFiles(t)
+	defer os.RemoveAll(dir)
+	srv := NewServer(nil, nil)
+	srv.Specs = []string{filepath.Join(dir, "*")}
+	srv.Files, _ = FindFiles(srv.Specs)
+	path, zonePath := filepath.Join(dir, "named.conf"), filepath.Join(dir, "db.example")
+	srv.Policy, _ = ParsePolicy([]byte(strings.Replace(policyJSON, "/etc/named.conf", filepath.Join(dir, "*"), -1)))
+	srv.Validators = Validators{{Files: "**/named.conf", Command: []string{"named-checkconf", "{file}"}}}
+	srv.Services = ServiceActions{{Files: "**/named.conf", Command: []string{"rndc", "reload"}, Probe: "tcp://127.0.0.1:53"}}
+	set := ChangeSet{Edits: []EditRequest{
+		{Path: path, Operations: []patch.Operation{{Op: patch.OP_SET, Path: "options/notify", Values: []string{"yes"}}}},
+		{Path: zonePath, Create: true, Operations: []patch.Operation{{Op: patch.OP_SET, Path: "$TTL", Values: []string{"60"}}}},
+	}}
+	req := httptest.NewRequest("POST", "/api/changeset/plan", nil)
+	session := &Session{User: "junior"}
+	req = req.WithContext(context.WithValue(req.Context(), sessionContextKey, session))
+	plan := srv.Plan(req, set)
+	if plan.Allowed || !plan.Succeeded || plan.User != "junior" || len(plan.Files) != 2 || len(plan.Services) != 1 {
+		t.Fatal(plan)
+	}
+	named := plan.Files[0]
+	if len(named.Checks) != 3 || !named.Checks[0].Allowed || named.Checks[1].Allowed || !named.Write || len(named.Changes) != 1 ||
+		named.Diff != "--- "+path+"\n+++ "+path+"\n@@ line 3 @@\n+\tnotify yes;\n" || len(named.Validators) != 1 {
+		t.Fatal(named)
+	}
+	if text := plan.String(); !strings.Contains(text, "DENIED  edit options/notify of "+path) || !strings.Contains(text, "+\tnotify yes;") ||
+		!strings.Contains(text, "Create "+zonePath) || !strings.Contains(text, "rndc reload, then probe tcp://127.0.0.1:53") ||
+		!strings.Contains(text, "would be denied by the policy") {
+		t.Fatal(text)
+	}
+	if _, err := os.Stat(zonePath); !os.IsNotExist(err) {
+		t.Fatal("plan has created the file")
+	}
+	// The root may apply the change set, the plan is also available in text via the API
+	session.User = "root"
+	if plan := srv.Plan(req, set); !plan.Allowed || !plan.Succeeded {
+		t.Fatal(plan)
+	}
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(set)
+	req = httptest.NewRequest("POST", "/api/changeset/plan?format=text", &body)
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, req.WithContext(context.WithValue(req.Context(), sessionContextKey, session)))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "may be applied") {
+		t.Fatal(recorder.Code, recorder.Body.String())
+	}
+	// Files that the user may not read are planned without their content
+	session.User = "nobody"
+	if plan := srv.Plan(req, set); plan.Files[0].Diff != "" || plan.Files[0].Changes != nil || plan.Files[0].Checks[0].Allowed {
+		t.Fatal(plan)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	dir := makeFiles(t)
+	defer os.RemoveAll(dir)
+	srv := NewServer(nil, nil)
+	srv.Files, _ = FindFiles([]string{filepath.Join(dir, "*")})
+	path, schedulePath := filepath.Join(dir, "named.conf"), filepath.Join(dir, "schedule.json")
+	var err error
+	if srv.Audit, err = OpenAuditLog(filepath.Join(dir, "audit.log")); err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := srv.OpenSchedule(schedulePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { srv.Schedule.Close() }()
+	set := ChangeSet{Edits: []EditRequest{{Path: path, Operations: []patch.Operation{{Op: patch.OP_SET, Path: "options/notify", Values: []string{"yes"}}}}}}
+	// Wait for the scheduled change to be carried out
+	finished := func(id int) ScheduledChange {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			for _, change := range srv.Schedule.Changes() {
+				if change.ID == id && change.Status != SCHEDULE_PENDING && change.Status != SCHEDULE_RUNNING {
+					return change
+				}
+			}
+		}
+		t.Fatal("scheduled change is not carried out", id)
+		return ScheduledChange{}
+	}
+	var change ScheduledChange
+	request(t, srv, "POST", "/api/schedule", ScheduleRequest{Time: time.Now().Add(time.Hour), ChangeSet: set}, http.StatusOK, &change)
+	if change.ID != 1 || change.Status != SCHEDULE_PENDING {
+		t.Fatal(change)
+	}
+	request(t, srv, "POST", "/api/schedule/cancel?id=1", nil, http.StatusOK, &change)
+	if change.Status != SCHEDULE_CANCELLED {
+		t.Fatal(change)
+	}
+	request(t, srv, "POST", "/api/schedule/cancel?id=1", nil, http.StatusConflict, nil)
+	request(t, srv, "POST", "/api/schedule/cancel?id=9", nil, http.StatusNotFound, nil)
+	request(t, srv, "POST", "/api/schedule", ScheduleRequest{ChangeSet: set}, http.StatusBadRequest, nil)
+	failing := ChangeSet{Edits: []EditRequest{{Path: path, Operations: []patch.Operation{{Op: patch.OP_ENABLE, Path: "nothing"}}}}}
+	request(t, srv, "POST", "/api/schedule", ScheduleRequest{Time: time.Now(), ChangeSet: failing}, http.StatusConflict, nil)
+	// The queue survives a restart, and a change is not applied once its maintenance window is over
+	request(t, srv, "POST", "/api/schedule", ScheduleRequest{Time: time.Now().Add(100 * time.Millisecond),
+		Until: time.Now().Add(200 * time.Millisecond), ChangeSet: set}, http.StatusOK, &change)
+	schedule.Close()
+	time.Sleep(300 * time.Millisecond)
+	if _, err = srv.OpenSchedule(schedulePath); err != nil {
+		t.Fatal(err)
+	}
+	if change = finished(2); change.Status != SCHEDULE_FAILED || !strings.Contains(change.Error, "maintenance window") {
+		t.Fatal(change)
+	}
+	// The change set is validated again when it is applied
+	srv.Validators = Validators{{Files: "**/named.conf", Command: []string{"false"}}}
+	request(t, srv, "POST", "/api/schedule", ScheduleRequest{Time: time.Now().Add(50 * time.Millisecond), ChangeSet: set}, http.StatusOK, nil)
+	if change = finished(3); change.Status != SCHEDULE_FAILED || change.Result == nil || !change.Result.Rejected {
+		t.Fatal(change)
+	}
+	srv.Validators = nil
+	request(t, srv, "POST", "/api/schedule", ScheduleRequest{Time: time.Now(), ChangeSet: set}, http.StatusOK, nil)
+	if change = finished(4); change.Status != SCHEDULE_APPLIED || !change.Result.Applied {
+		t.Fatal(change)
+	}
+	if content, _ := ioutil.ReadFile(path); !strings.Contains(string(content), "notify yes;") {
+		t.Fatal(string(content))
+	}
+	var changes []ScheduledChange
+	request(t, srv, "GET", "/api/schedule", nil, http.StatusOK, &changes)
+	if len(changes) != 4 || changes[0].ID != 2 || changes[3].ID != 1 {
+		t.Fatal(changes)
+	}
+	// The audit log tells the story of each scheduled change
+	var entries []AuditEntry
+	request(t, srv, "GET", "/api/audit", nil, http.StatusOK, &entries)
+	events := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Event == "" {
+			events = append(events, entry.Client+" changed "+entry.Changes[0].Path)
+		} else {
+			events = append(events, entry.Event)
+		}
+	}
+	if len(events) != 9 || !strings.HasPrefix(events[0], "scheduled change 1 is queued for") ||
+		events[1] != "scheduled change 1 is cancelled by user \"\"" || !strings.HasPrefix(events[3], "scheduled change 2 failed: ") ||
+		!strings.HasPrefix(events[5], "scheduled change 3 failed: a validator") ||
+		events[7] != "schedule 4 changed options/notify" || events[8] != "scheduled change 4 is applied" {
+		t.Fatal(strings.Join(events, "\n"))
+	}
+	// A change is not applied if the schedule cannot tell that it is running
+	notify := ChangeSet{Edits: []EditRequest{{Path: path, Operations: []patch.Operation{{Op: patch.OP_SET, Path: "options/notify", Values: []string{"no"}}}}}}
+	srv.Schedule.lock.Lock()
+	srv.Schedule.Path = filepath.Join(dir, "nonexistent", "schedule.json")
+	srv.Schedule.changes = append(srv.Schedule.changes, ScheduledChange{ID: 5, Time: time.Now(), ChangeSet: notify, Status: SCHEDULE_PENDING})
+	srv.Schedule.lock.Unlock()
+	srv.Schedule.runDue()
+	if change = finished(5); change.Status != SCHEDULE_FAILED || !strings.Contains(change.Error, "schedule cannot be saved") {
+		t.Fatal(change)
+	}
+	if content, _ := ioutil.ReadFile(path); !strings.Contains(string(content), "notify yes;") {
+		t.Fatal(string(content))
+	}
+}
+
+func TestDrift(t *testing.T) {
+	dir := makeFiles(t)
+	defer os.RemoveAll(dir)
+	sysctlPath, hostsPath, sshdPath := filepath.Join(dir, "sysctl.conf"), filepath.Join(dir, "hosts"), filepath.Join(dir, "sshd_config")
+	ioutil.WriteFile(sysctlPath, []byte("# tuning\nnet.ipv4.ip_forward = 0\nkernel.sysrq=1\nvm.swappiness = 60\n"), 0644)
+	ioutil.WriteFile(hostsPath, []byte("127.0.0.1 localhost\n10.0.0.9 evil\n"), 0644)
+	ioutil.WriteFile(sshdPath, []byte("PermitRootLogin yes\n"), 0600)
+	srv := NewServer(nil, nil)
+	srv.Specs = []string{sysctlPath, hostsPath, "login-defs=" + sshdPath, filepath.Join(dir, "*.conf")}
+	srv.Files, _ = FindFiles(srv.Specs)
+	state, err := ParseDesiredState([]byte(`[
+		{"file": "` + sysctlPath + `", "exclusive": true, "values": {"net.ipv4.ip_forward": ["1"], "vm.swappiness": ["60"], "net.ipv4.tcp_syncookies": ["1"]}},
+		{"file": "` + hostsPath + `", "values": {"127.0.0.1": ["localhost"], "::1": ["localhost"]}, "absent": ["10.0.0.9"]},
+		{"file": "` + sshdPath + `", "values": {"PermitRootLogin": ["no"]}},
+		{"file": "` + filepath.Join(dir, "limits.conf") + `", "values": {"x": ["y"]}},
+		{"file": "/etc/passwd", "values": {"root": ["x"]}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request(t, srv, "GET", "/api/drift", nil, http.StatusNotFound, nil)
+	monitor := srv.MonitorDrift(state, time.Hour)
+	defer monitor.Close()
+	var report DriftReport
+	request(t, srv, "GET", "/api/drift", nil, http.StatusOK, &report)
+	drifts := make([]string, len(report.Drifts))
+	for i, drift := range report.Drifts {
+		drifts[i] = fmt.Sprintf("%s:%d %s %s %v %v", filepath.Base(drift.File), drift.Line, drift.Kind, drift.Path, drift.Desired, drift.Actual)
+	}
+	if strings.Join(drifts, "\n") != `hosts:2 unexpected 10.0.0.9 [] [evil]
+hosts:0 missing ::1 [localhost] []
+limits.conf:0 missing x [y] []
+sshd_config:1 different PermitRootLogin [no] [yes]
+sysctl.conf:2 different net.ipv4.ip_forward [1] [0]
+sysctl.conf:3 unexpected kernel.sysrq [] [1]
+sysctl.conf:0 missing net.ipv4.tcp_syncookies [1] []` || len(report.Errors) != 1 || report.Errors["/etc/passwd"] == "" || report.Remediation != nil {
+		t.Fatal(strings.Join(drifts, "\n"), report.Errors)
+	}
+	// The remediation restores the desired state
+	request(t, srv, "GET", "/api/drift?remediate=true", nil, http.StatusOK, &report)
+	if report.Remediation == nil || len(report.Remediation.Edits) != 4 {
+		t.Fatal(report.Remediation)
+	}
+	var result ChangeSetResult
+	request(t, srv, "POST", "/api/changeset/apply", report.Remediation, http.StatusOK, &result)
+	if content, _ := ioutil.ReadFile(sysctlPath); string(content) != "# tuning\nnet.ipv4.ip_forward = 1\nvm.swappiness = 60\nnet.ipv4.tcp_syncookies = 1\n" {
+		t.Fatal(string(content))
+	}
+	if content, _ := ioutil.ReadFile(hostsPath); string(content) != "127.0.0.1 localhost\n::1 localhost\n" {
+		t.Fatal(string(content))
+	}
+	request(t, srv, "GET", "/api/drift?refresh=true", nil, http.StatusOK, &report)
+	if len(report.Drifts) != 0 || len(report.Errors) != 1 {
+		t.Fatal(report)
+	}
+	// The remediation does not apply to files that have changed since
+	ioutil.WriteFile(sshdPath, []byte("PermitRootLogin yes\n"), 0600)
+	request(t, srv, "GET", "/api/drift?remediate=true", nil, http.StatusOK, &report)
+	ioutil.WriteFile(sshdPath, []byte("PermitRootLogin prohibit-password\n"), 0600)
+	request(t, srv, "POST", "/api/changeset/apply", report.Remediation, http.StatusPreconditionFailed, nil)
+	for _, malformed := range []string{`[{"values": {}}]`, `[{"file": "/a", "values": {"": ["b"]}}]`, `[{"file": "/a", "absent": ["a[b"]}]`} {
+		if _, err := ParseDesiredState([]byte(malformed)); err == nil {
+			t.Fatal("did not error", malformed)
+		}
+	}
+}
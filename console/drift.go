@@ -0,0 +1,340 @@
+package console
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/navigate"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/patch"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/writeback"
+)
+
+// Kinds of drift from the desired state.
+const (
+	DRIFT_MISSING    = "missing"    // a statement of desired values does not exist
+	DRIFT_DIFFERENT  = "different"  // a statement exists, but its values are not the desired ones
+	DRIFT_UNEXPECTED = "unexpected" // a statement exists, but it is declared absent, or it is not declared in an exclusive file
+)
+
+/*
+DesiredFile declares the desired state of a managed file. Values gives the desired values of the statement at each
+node path (e.g. "net.ipv4.ip_forward" in sysctl.conf, "PermitRootLogin" in sshd_config, "127.0.0.1" in hosts), leaving
+out the token break markers such as "=" that the file's format puts between a key and its values. The statements of
+Absent must not exist, and if the file is exclusive, no statement other than those of Values may exist.
+*/
+type DesiredFile struct {
+	File      string              `json:"file"`
+	Values    map[string][]string `json:"values"`
+	Absent    []string            `json:"absent,omitempty"`
+	Exclusive bool                `json:"exclusive,omitempty"`
+}
+
+// DesiredState is the table of desired files of a host.
+type DesiredState []DesiredFile
+
+// Drift is a statement of a file that differs from the desired state.
+type Drift struct {
+	Kind    string   `json:"kind"`
+	File    string   `json:"file"`
+	Path    string   `json:"path"`
+	Line    int      `json:"line,omitempty"`    // line of the statement, zero if it is missing
+	Desired []string `json:"desired,omitempty"` // the desired values
+	Actual  []string `json:"actual,omitempty"`  // the values in the file, without token break markers
+}
+
+/*
+DriftReport lists the drifts of the files from the desired state, ordered by file and line. The remediation is the
+change set that restores the desired state, if it is asked for; its edits carry the hash of each file as compared, so
+that the change set is not applied to files that have changed since.
+*/
+type DriftReport struct {
+	Time        time.Time         `json:"time"`
+	Drifts      []Drift           `json:"drifts"`
+	Errors      map[string]string `json:"errors,omitempty"` // why a file cannot be compared, by file path
+	Remediation *ChangeSet        `json:"remediation,omitempty"`
+}
+
+// Read a desired state from JSON file.
+func LoadDesiredState(filePath string) (DesiredState, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDesiredState(content)
+}
+
+// Deserialise a desired state from JSON and check its node paths.
+func ParseDesiredState(serialised []byte) (DesiredState, error) {
+	var state DesiredState
+	if err := json.Unmarshal(serialised, &state); err != nil {
+		return nil, err
+	}
+	for i, desired := range state {
+		if desired.File == "" {
+			return nil, fmt.Errorf("desired file %d does not have a file path", i)
+		}
+		for nodePath := range desired.Values {
+			if path, err := navigate.ParsePath(nodePath); err != nil || len(path) == 0 {
+				return nil, fmt.Errorf("desired file \"%s\" has a malformed path \"%s\"", desired.File, nodePath)
+			}
+		}
+		for _, nodePath := range desired.Absent {
+			if path, err := navigate.ParsePath(nodePath); err != nil || len(path) == 0 {
+				return nil, fmt.Errorf("desired file \"%s\" has a malformed path \"%s\"", desired.File, nodePath)
+			}
+		}
+	}
+	return state, nil
+}
+
+// Return the words of the statement that follow its key, without the token break markers of the configuration.
+func desiredWords(words []string, config *lexer.LexerConfig) []string {
+	ret := make([]string, 0, len(words))
+	for _, word := range words {
+		if !navigate.IsOneOf(word, config.TokenBreakMarkers) {
+			ret = append(ret, word)
+		}
+	}
+	return ret
+}
+
+/*
+Return the values to set on a statement so that it carries the desired values, keeping the token break marker that
+leads the current values. A new statement is given the first marker of the configuration, if there is one.
+*/
+func remediatedValues(current []string, desired []string, config *lexer.LexerConfig) []string {
+	values := make([]string, 0, len(desired)+1)
+	if current == nil && len(config.TokenBreakMarkers) > 0 {
+		values = append(values, config.TokenBreakMarkers[0])
+	} else if len(current) > 0 && navigate.IsOneOf(current[0], config.TokenBreakMarkers) {
+		values = append(values, current[0])
+	}
+	return append(values, desired...)
+}
+
+// Compare the text of the file with its desired state, return the drifts and the operations that remediate them.
+func compareDesired(file ManagedFile, text string, desired DesiredFile) (drifts []Drift, operations []patch.Operation) {
+	config := file.Config()
+	root := file.LexText(text)
+	orig := writeback.Track(text, root)
+	drifts = make([]Drift, 0, 4)
+	operations = make([]patch.Operation, 0, 4)
+	declared := make(map[string]bool)
+	nodePaths := make([]string, 0, len(desired.Values))
+	for nodePath := range desired.Values {
+		nodePaths = append(nodePaths, nodePath)
+	}
+	sort.Strings(nodePaths)
+	for _, nodePath := range nodePaths {
+		values := desired.Values[nodePath]
+		path, _ := navigate.ParsePath(nodePath)
+		declared[path.String()] = true
+		node := navigate.Find(root, path)
+		if node == nil {
+			drifts = append(drifts, Drift{Kind: DRIFT_MISSING, File: file.Path, Path: path.String(), Desired: values})
+			operations = append(operations, patch.Operation{Op: patch.OP_SET, Path: path.String(), Values: remediatedValues(nil, values, config)})
+			continue
+		}
+		var current []string
+		if stmt, isStatement := node.Entity.(*lexer.Statement); isStatement {
+			current = navigate.StatementTexts(stmt)[1:]
+		}
+		if actual := desiredWords(current, config); !navigate.EqualStrings(actual, values) {
+			drifts = append(drifts, Drift{Kind: DRIFT_DIFFERENT, File: file.Path, Path: path.String(), Line: orig.Line(node),
+				Desired: values, Actual: actual})
+			operations = append(operations, patch.Operation{Op: patch.OP_SET, Path: path.String(),
+				Values: remediatedValues(current, values, config), Expect: current})
+		}
+	}
+	absent := make(map[string]bool)
+	for _, nodePath := range desired.Absent {
+		path, _ := navigate.ParsePath(nodePath)
+		absent[path.String()] = true
+	}
+	// Remove the unexpected statements from the bottom up, so that removing one does not renumber the others
+	removals := make([]patch.Operation, 0, 4)
+	var walk func(node *lexer.DocumentNode)
+	walk = func(node *lexer.DocumentNode) {
+		if path, hasPath := navigate.NodePath(node); hasPath && len(path) > 0 && !declared[path.String()] {
+			stmt, isStatement := node.Entity.(*lexer.Statement)
+			if absent[path.String()] || desired.Exclusive && isStatement {
+				drift := Drift{Kind: DRIFT_UNEXPECTED, File: file.Path, Path: path.String(), Line: orig.Line(node)}
+				removal := patch.Operation{Op: patch.OP_REMOVE, Path: path.String()}
+				if isStatement {
+					current := navigate.StatementTexts(stmt)[1:]
+					drift.Actual, removal.Expect = desiredWords(current, config), current
+				}
+				drifts = append(drifts, drift)
+				removals = append([]patch.Operation{removal}, removals...)
+			}
+		}
+		for _, leaf := range node.Leaves {
+			walk(leaf)
+		}
+	}
+	walk(root)
+	operations = append(operations, removals...)
+	return drifts, operations
+}
+
+/*
+Compare the managed files with the desired state. A desired file that does not yet exist but matches the specifications
+of managed files is compared as an empty file, and the remediation creates it. Return the report, which carries the
+remediation if it is asked for.
+*/
+func (srv *Server) DetectDrift(state DesiredState, remediate bool) DriftReport {
+	report := DriftReport{Time: time.Now().UTC(), Drifts: make([]Drift, 0, 8), Errors: make(map[string]string)}
+	if remediate {
+		report.Remediation = &ChangeSet{Edits: make([]EditRequest, 0, len(state))}
+	}
+	for _, desired := range state {
+		text, create := "", false
+		file, managed := srv.file(desired.File)
+		if !managed {
+			var matched bool
+			if file, matched = MatchSpecs(srv.Specs, desired.File); !matched {
+				report.Errors[desired.File] = "the file is not managed"
+				continue
+			} else if _, err := os.Lstat(file.Path); !os.IsNotExist(err) {
+				report.Errors[desired.File] = "the file is not managed"
+				continue
+			}
+			create = true
+		} else {
+			content, err := ioutil.ReadFile(file.Path)
+			if err != nil {
+				report.Errors[desired.File] = err.Error()
+				continue
+			}
+			text = string(content)
+		}
+		drifts, operations := compareDesired(file, text, desired)
+		report.Drifts = append(report.Drifts, drifts...)
+		if remediate && len(operations) > 0 {
+			edit := EditRequest{Path: file.Path, Operations: operations, Create: create}
+			if !create {
+				edit.BaseHash = contentHash(text)
+			}
+			report.Remediation.Edits = append(report.Remediation.Edits, edit)
+		}
+	}
+	sort.SliceStable(report.Drifts, func(i, j int) bool {
+		a, b := report.Drifts[i], report.Drifts[j]
+		if a.File != b.File {
+			return a.File < b.File
+		} else if (a.Line == 0) != (b.Line == 0) {
+			return b.Line == 0
+		} else if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Path < b.Path
+	})
+	return report
+}
+
+// DriftMonitor compares the managed files with the desired state periodically, and keeps the latest report.
+type DriftMonitor struct {
+	State    DesiredState
+	Interval time.Duration
+	srv      *Server
+	lock     *sync.Mutex
+	latest   DriftReport
+	stop     chan struct{}
+}
+
+// Compare the server's files with the desired state now and then every interval, until the monitor is closed.
+func (srv *Server) MonitorDrift(state DesiredState, interval time.Duration) *DriftMonitor {
+	monitor := &DriftMonitor{State: state, Interval: interval, srv: srv, lock: new(sync.Mutex), stop: make(chan struct{})}
+	monitor.Check()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-monitor.stop:
+				return
+			case <-ticker.C:
+				monitor.Check()
+			}
+		}
+	}()
+	srv.Drift = monitor
+	return monitor
+}
+
+// Stop comparing the files periodically.
+func (monitor *DriftMonitor) Close() {
+	close(monitor.stop)
+}
+
+// Compare the files with the desired state now, keep and return the report.
+func (monitor *DriftMonitor) Check() DriftReport {
+	report := monitor.srv.DetectDrift(monitor.State, false)
+	monitor.lock.Lock()
+	monitor.latest = report
+	monitor.lock.Unlock()
+	return report
+}
+
+// Return the report of the latest comparison.
+func (monitor *DriftMonitor) Latest() DriftReport {
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	return monitor.latest
+}
+
+// Return the report without the drifts, errors, and remediation of the files that the user may not read.
+func (srv *Server) visibleDrift(r *http.Request, report DriftReport) DriftReport {
+	visible := DriftReport{Time: report.Time, Drifts: make([]Drift, 0, len(report.Drifts)), Errors: make(map[string]string)}
+	for _, drift := range report.Drifts {
+		if srv.check(r, PERMISSION_READ, drift.File, "") == nil {
+			visible.Drifts = append(visible.Drifts, drift)
+		}
+	}
+	for filePath, message := range report.Errors {
+		if srv.check(r, PERMISSION_READ, filePath, "") == nil {
+			visible.Errors[filePath] = message
+		}
+	}
+	if report.Remediation != nil {
+		visible.Remediation = &ChangeSet{Edits: make([]EditRequest, 0, len(report.Remediation.Edits))}
+		for _, edit := range report.Remediation.Edits {
+			if srv.check(r, PERMISSION_READ, edit.Path, "") == nil {
+				visible.Remediation.Edits = append(visible.Remediation.Edits, edit)
+			}
+		}
+	}
+	return visible
+}
+
+/*
+Respond with the latest drift report of the files that the user may read. The "refresh" parameter compares the files
+again right away, and the "remediate" parameter does so too and adds the change set that restores the desired state,
+which may be reviewed via the plan endpoint and applied via the change set endpoint.
+*/
+func (srv *Server) handleDrift(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	} else if srv.Drift == nil {
+		writeError(w, http.StatusNotFound, "drift detection is not in use")
+		return
+	}
+	var report DriftReport
+	switch {
+	case r.URL.Query().Get("remediate") == "true":
+		report = srv.DetectDrift(srv.Drift.State, true)
+	case r.URL.Query().Get("refresh") == "true":
+		report = srv.Drift.Check()
+	default:
+		report = srv.Drift.Latest()
+	}
+	writeJSON(w, http.StatusOK, srv.visibleDrift(r, report))
+}
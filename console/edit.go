@@ -0,0 +1,144 @@
+package console
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/diff"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/patch"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/writeback"
+)
+
+/*
+EditRequest asks for tree operations to be carried out on a managed file. In a change set, the request may create a
+new file that matches the specifications of managed files, in which case the operations start from an empty document.
+
+If the request tells the hash of the content that the operations are based on, and the file no longer has that content
+because it has been changed by someone else, the edit fails with a *Conflict. Unless the request asks for a rebase,
+in which case the operations are carried out on the file's current content instead.
+*/
+type EditRequest struct {
+	Path       string            `json:"path"`
+	Operations []patch.Operation `json:"operations"`
+	Create     bool              `json:"create,omitempty"`
+	BaseHash   string            `json:"baseHash,omitempty"`
+	Rebase     bool              `json:"rebase,omitempty"`
+}
+
+// Conflict is the error of an edit based on content that the file no longer has.
+type Conflict struct {
+	Path     string `json:"path"`
+	BaseHash string `json:"baseHash"` // hash of the content that the edit is based on
+	Hash     string `json:"hash"`     // hash of the file's current content
+}
+
+func (conflict *Conflict) Error() string {
+	return fmt.Sprintf("file \"%s\" has changed since it was loaded", conflict.Path)
+}
+
+/*
+Return a *Conflict if the request is based on content other than the text, unless the request asks for a rebase, in
+which case rebased is true.
+*/
+func (req EditRequest) checkBase(text string) (rebased bool, err error) {
+	if req.BaseHash == "" || req.BaseHash == contentHash(text) {
+		return false, nil
+	} else if req.Rebase {
+		return true, nil
+	}
+	return false, &Conflict{Path: req.Path, BaseHash: req.BaseHash, Hash: contentHash(text)}
+}
+
+/*
+EditResult tells the outcome of the operations, the semantic changes they make to the document, and the bytes they
+change in the file.
+*/
+type EditResult struct {
+	Path       string                  `json:"path"`
+	Results    []patch.OperationResult `json:"results"`
+	Succeeded  bool                    `json:"succeeded"` // all operations are either applied or satisfied
+	Changes    []diff.Change           `json:"changes"`
+	Splices    []writeback.Splice      `json:"splices"`
+	Text       string                  `json:"text"`                 // the text of the file after the edit
+	Hash       string                  `json:"hash"`                 // hash of the text after the edit
+	Rebased    bool                    `json:"rebased"`              // the file has changed, and the operations are carried out on its current content
+	Applied    bool                    `json:"applied"`              // the file has been written
+	Backup     string                  `json:"backup,omitempty"`     // path of the backup of the previous content
+	Rejected   bool                    `json:"rejected"`             // a validator of the file rejects the result
+	Validation []ValidationResult      `json:"validation,omitempty"` // output of the validators, which run before the file is written
+	Services   []ServiceResult         `json:"services,omitempty"`   // outcome of the service actions, which run after the file is written
+	RolledBack bool                    `json:"rolledBack"`           // a service is not healthy, hence the file is written back
+
+	original string // the text of the file before the edit
+}
+
+/*
+Carry out the operations of the request on the file's document without writing the file. Return the result of the
+edit, or a *Conflict if the request is based on content that the file no longer has.
+*/
+func (file ManagedFile) Edit(req EditRequest) (result EditResult, err error) {
+	content, err := ioutil.ReadFile(file.Path)
+	if err != nil {
+		return EditResult{Path: file.Path}, err
+	}
+	rebased, err := req.checkBase(string(content))
+	if err != nil {
+		return EditResult{Path: file.Path}, err
+	}
+	result, err = file.EditText(string(content), req.Operations)
+	result.Rebased = rebased
+	return
+}
+
+// Carry out the operations on the document of the text, which is the content of the file. Return the result of the edit.
+func (file ManagedFile) EditText(text string, operations []patch.Operation) (result EditResult, err error) {
+	result = EditResult{Path: file.Path, original: text}
+	root := file.LexText(text)
+	oldRoot := root.Clone()
+	orig := writeback.Track(text, root)
+	result.Results = patch.Apply(root, file.Config(), patch.Patch{Operations: operations})
+	result.Succeeded = patch.Succeeded(result.Results)
+	result.Changes = diff.Compare(oldRoot, root)
+	result.Splices = orig.Splices(root)
+	result.Text, err = writeback.Apply(text, result.Splices)
+	result.Hash = contentHash(result.Text)
+	return
+}
+
+/*
+Carry out the operations of the request on the file and write the file atomically via the writer, unless an operation
+fails or a validator rejects the result, in which case the file is left alone. The file is not written if the
+operations do not change anything. If the file is changed by someone else in the meantime, the file is left alone
+and a *Conflict is returned.
+*/
+func (file ManagedFile) Apply(req EditRequest, writer writeback.FileWriter, validators Validators) (EditResult, error) {
+	result, err := file.Edit(req)
+	if err != nil {
+		return result, err
+	}
+	return file.write(result, writer, validators)
+}
+
+// Validate and write the result of an edit of the file, see Apply.
+func (file ManagedFile) write(result EditResult, writer writeback.FileWriter, validators Validators) (EditResult, error) {
+	if !result.Succeeded || len(result.Splices) == 0 {
+		return result, nil
+	}
+	var err error
+	var valid bool
+	if result.Validation, valid, err = validators.Validate(file.Path, []byte(result.Text)); err != nil || !valid {
+		result.Rejected = err == nil
+		return result, err
+	}
+	// The validators take a while, make sure the result does not overwrite a change made meanwhile
+	if content, err := ioutil.ReadFile(file.Path); err != nil {
+		return result, err
+	} else if string(content) != result.original {
+		return result, &Conflict{Path: file.Path, BaseHash: contentHash(result.original), Hash: contentHash(string(content))}
+	}
+	if result.Backup, err = writer.WriteFile(file.Path, []byte(result.Text)); err != nil {
+		return result, err
+	}
+	result.Applied = true
+	return result, nil
+}
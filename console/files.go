@@ -0,0 +1,122 @@
+package console
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer/predef"
+)
+
+// ManagedFile is a configuration file that the console may browse and edit.
+type ManagedFile struct {
+	Path   string `json:"path"`
+	Format string `json:"format"` // name of the predefined format, see predef.Formats.
+}
+
+/*
+Find the files to manage. Each specification is a shell pattern of file paths, optionally led by a format name and an
+equal sign (e.g. "httpd=/etc/apache2/vhosts.d/*.conf") which overrides the format detected from the file name. Files
+whose format is neither given nor detected are not managed, and neither are backup files whose names end with a tilde.
+*/
+func FindFiles(specs []string) ([]ManagedFile, error) {
+	files := make([]ManagedFile, 0, 16)
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		format, pattern, err := parseSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("malformed pattern \"%s\": %v", pattern, err)
+		}
+		for _, match := range matches {
+			if strings.HasSuffix(match, "~") {
+				continue
+			}
+			absPath, err := filepath.Abs(match)
+			if err != nil {
+				return nil, err
+			}
+			matchFormat := format
+			if matchFormat == "" {
+				if matchFormat, _ = predef.FormatForPath(absPath); matchFormat == "" {
+					continue
+				}
+			}
+			if !seen[absPath] {
+				seen[absPath] = true
+				files = append(files, ManagedFile{Path: absPath, Format: matchFormat})
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// Split the file specification into the optional format name and the shell pattern.
+func parseSpec(spec string) (format, pattern string, err error) {
+	format, pattern = "", spec
+	if equal := strings.Index(spec, "="); equal != -1 {
+		format, pattern = spec[:equal], spec[equal+1:]
+		if _, found := predef.Formats[format]; !found {
+			return "", "", fmt.Errorf("unknown format \"%s\" in \"%s\"", format, spec)
+		}
+	}
+	return
+}
+
+/*
+Return the file of the absolute path as it would be managed by the specifications, regardless of whether the file
+exists. This tells whether a new file may be created. The path must be clean, so that "*" in a pattern does not match
+"..", which would lead the path out of the pattern's directory.
+*/
+func MatchSpecs(specs []string, filePath string) (file ManagedFile, found bool) {
+	if strings.HasSuffix(filePath, "~") || !filepath.IsAbs(filePath) || filepath.Clean(filePath) != filePath {
+		return
+	}
+	for _, spec := range specs {
+		format, pattern, err := parseSpec(spec)
+		if err != nil {
+			continue
+		}
+		if absPattern, err := filepath.Abs(pattern); err == nil {
+			pattern = absPattern
+		}
+		if matched, _ := filepath.Match(pattern, filePath); !matched {
+			continue
+		}
+		if format == "" {
+			format, _ = predef.FormatForPath(filePath)
+		}
+		if format != "" {
+			return ManagedFile{Path: filePath, Format: format}, true
+		}
+	}
+	return
+}
+
+// Return a copy of the lexer configuration of the file's format.
+func (file ManagedFile) Config() *lexer.LexerConfig {
+	config := *predef.Formats[file.Format]
+	return &config
+}
+
+// Read and lex the file, return its text and document.
+func (file ManagedFile) Lex() (string, *lexer.DocumentNode, error) {
+	content, err := ioutil.ReadFile(file.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	text := string(content)
+	return text, file.LexText(text), nil
+}
+
+// Lex the text in the file's format, the text may be a past or future version of the file.
+func (file ManagedFile) LexText(text string) *lexer.DocumentNode {
+	return lexer.NewLexer(text, file.Config(), &lexer.LexerDebugNoop{}).Run()
+}
@@ -0,0 +1,31 @@
+package console
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+/*
+Create the lock file if it does not yet exist and lock it, return an error if another process holds the lock. The lock
+is released when the file is closed, including when the process ends.
+*/
+func lockFile(filePath string) (*os.File, error) {
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("%s is locked by another process", filePath)
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// Release the lock taken by lockFile.
+func unlockFile(file *os.File) error {
+	return file.Close()
+}
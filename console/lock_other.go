@@ -0,0 +1,26 @@
+//go:build !linux
+
+package console
+
+import (
+	"fmt"
+	"os"
+)
+
+/*
+Create the lock file, return an error if it already exists. A lock file left behind by a process that ended without
+releasing it has to be removed by hand.
+*/
+func lockFile(filePath string) (*os.File, error) {
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("%s is locked by another process, remove it if there is none", filePath)
+	}
+	return file, err
+}
+
+// Release the lock taken by lockFile.
+func unlockFile(file *os.File) error {
+	os.Remove(file.Name())
+	return file.Close()
+}
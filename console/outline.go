@@ -0,0 +1,73 @@
+package console
+
+import (
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/navigate"
+)
+
+// Kinds of outline nodes.
+const (
+	OUTLINE_STATEMENT = "statement"
+	OUTLINE_SECTION   = "section"
+	OUTLINE_DISABLED  = "disabled" // a statement that is commented out, such as a default value written in comment.
+)
+
+/*
+OutlineNode describes a statement or section of a document by its path, so that a client can address the node in
+edit operations without understanding the lexed document tree.
+*/
+type OutlineNode struct {
+	Path     string        `json:"path"`
+	Kind     string        `json:"kind"`
+	Key      string        `json:"key"`
+	Args     []string      `json:"args,omitempty"`     // arguments of a section
+	Values   []string      `json:"values,omitempty"`   // values of a statement
+	Comments []string      `json:"comments,omitempty"` // comments that lead and trail the node
+	Children []OutlineNode `json:"children,omitempty"` // statements and sections of a section
+}
+
+// Return the outline of the statements and sections among the node's leaves, including those that are commented out.
+func Outline(node *lexer.DocumentNode, config *lexer.LexerConfig) []OutlineNode {
+	parentPath, _ := navigate.NodePath(node)
+	outline := make([]OutlineNode, 0, len(node.Leaves))
+	for _, leaf := range node.Leaves {
+		key, args, hasKey := navigate.NodeKey(leaf)
+		if !hasKey {
+			if commented := navigate.CommentedStatement(leaf, config); commented != nil {
+				// Enabling the statement makes it reachable by its key
+				texts := navigate.StatementTexts(commented)
+				outline = append(outline, OutlineNode{Kind: OUTLINE_DISABLED, Key: texts[0], Values: texts[1:],
+					Path: parentPath.Append(navigate.Segment{Key: texts[0]}).String()})
+			}
+			continue
+		}
+		path, _ := navigate.NodePath(leaf)
+		item := OutlineNode{Path: path.String(), Kind: OUTLINE_STATEMENT, Key: key, Comments: comments(leaf, config)}
+		if navigate.IsSection(leaf) {
+			item.Kind = OUTLINE_SECTION
+			item.Args = args
+			item.Children = Outline(leaf, config)
+		} else {
+			item.Values = navigate.StatementTexts(leaf.Entity.(*lexer.Statement))[1:]
+		}
+		outline = append(outline, item)
+	}
+	return outline
+}
+
+/*
+Return the comments that lead and trail the node, along with those written inside of a statement. Statements that are
+commented out are not among the comments, as they have their own place in the outline.
+*/
+func comments(node *lexer.DocumentNode, config *lexer.LexerConfig) []string {
+	ret := make([]string, 0, 0)
+	nodes := append(navigate.LeadingComments(node), node)
+	for _, commentNode := range append(nodes, navigate.TrailingComments(node)...) {
+		if commentNode != node && navigate.CommentedStatement(commentNode, config) != nil {
+			continue
+		} else if stmt, isStmt := commentNode.Entity.(*lexer.Statement); isStmt {
+			ret = append(ret, navigate.StatementComments(stmt)...)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,192 @@
+package console
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+/*
+Passwords are stored in the SHA-crypt format of crypt(3), which is written by "htpasswd -5", "openssl passwd -6", and
+mkpasswd, for example: $6$rounds=10000$saltstring$hash. Both SHA-256 ($5$) and SHA-512 ($6$) variants are understood,
+and so are the bcrypt hashes ($2y$) written by "htpasswd -B", see bcrypt. The time taken by SHA-crypt grows with the
+square of the password length, hence passwords longer than PASSWORD_MAX_LENGTH are refused.
+*/
+const (
+	SHA_CRYPT_ROUNDS_DEFAULT = 5000
+	SHA_CRYPT_ROUNDS_MIN     = 1000
+	SHA_CRYPT_ROUNDS_MAX     = 999999999
+	SHA_CRYPT_SALT_MAX       = 16
+	SHA_CRYPT_HASH_ROUNDS    = 100000 // rounds used by HashPassword
+	PASSWORD_MAX_LENGTH      = 256    // in bytes
+)
+
+var (
+	ErrUnsupportedHash = errors.New("the password hash is not in a supported format, use SHA-crypt ($5$ or $6$) or bcrypt ($2a$, $2b$, or $2y$)")
+	ErrPasswordTooLong = fmt.Errorf("the password is longer than %d bytes", PASSWORD_MAX_LENGTH)
+)
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// Order in which the digest bytes are encoded, three at a time, the last group is shorter.
+var (
+	sha256CryptOrder = [][]int{{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14}, {15, 25, 5}, {6, 16, 26},
+		{27, 7, 17}, {18, 28, 8}, {9, 19, 29}, {-1, 31, 30}}
+	sha512CryptOrder = [][]int{{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48},
+		{28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41}, {-1, -1, 63}}
+)
+
+// Return the hash of the password in SHA-crypt format, using the algorithm, rounds and salt given in the setting.
+func shaCrypt(password, setting string) (string, error) {
+	var newHash func() hash.Hash
+	var order [][]int
+	switch {
+	case strings.HasPrefix(setting, "$5$"):
+		newHash, order = sha256.New, sha256CryptOrder
+	case strings.HasPrefix(setting, "$6$"):
+		newHash, order = sha512.New, sha512CryptOrder
+	default:
+		return "", ErrUnsupportedHash
+	}
+	if len(password) > PASSWORD_MAX_LENGTH {
+		return "", ErrPasswordTooLong
+	}
+	prefix := setting[:3]
+	setting = setting[3:]
+	rounds, customRounds := SHA_CRYPT_ROUNDS_DEFAULT, false
+	if strings.HasPrefix(setting, "rounds=") {
+		end := strings.IndexByte(setting, '$')
+		if end == -1 {
+			return "", ErrUnsupportedHash
+		}
+		var err error
+		if rounds, err = strconv.Atoi(setting[len("rounds="):end]); err != nil {
+			return "", ErrUnsupportedHash
+		}
+		if rounds < SHA_CRYPT_ROUNDS_MIN {
+			rounds = SHA_CRYPT_ROUNDS_MIN
+		} else if rounds > SHA_CRYPT_ROUNDS_MAX {
+			rounds = SHA_CRYPT_ROUNDS_MAX
+		}
+		customRounds = true
+		setting = setting[end+1:]
+	}
+	salt := setting
+	if end := strings.IndexByte(salt, '$'); end != -1 {
+		salt = salt[:end]
+	}
+	if len(salt) > SHA_CRYPT_SALT_MAX {
+		salt = salt[:SHA_CRYPT_SALT_MAX]
+	}
+	pass := []byte(password)
+
+	digest := func(parts ...[]byte) []byte {
+		h := newHash()
+		for _, part := range parts {
+			h.Write(part)
+		}
+		return h.Sum(nil)
+	}
+	// repeat the bytes until the length is reached
+	repeat := func(b []byte, length int) []byte {
+		ret := make([]byte, 0, length)
+		for len(ret) < length {
+			ret = append(ret, b...)
+		}
+		return ret[:length]
+	}
+	alternate := digest(pass, []byte(salt), pass)
+	h := newHash()
+	h.Write(pass)
+	h.Write([]byte(salt))
+	h.Write(repeat(alternate, len(pass)))
+	for length := len(pass); length > 0; length >>= 1 {
+		if length&1 != 0 {
+			h.Write(alternate)
+		} else {
+			h.Write(pass)
+		}
+	}
+	sum := h.Sum(nil)
+	passSeq := repeat(digest(repeat(pass, len(pass)*len(pass))), len(pass))
+	saltSeq := repeat(digest(repeat([]byte(salt), len(salt)*(16+int(sum[0])))), len(salt))
+	for i := 0; i < rounds; i++ {
+		h := newHash()
+		if i&1 != 0 {
+			h.Write(passSeq)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write(saltSeq)
+		}
+		if i%7 != 0 {
+			h.Write(passSeq)
+		}
+		if i&1 != 0 {
+			h.Write(sum)
+		} else {
+			h.Write(passSeq)
+		}
+		sum = h.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(prefix)
+	if customRounds {
+		out.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+	out.WriteString(salt + "$")
+	for _, group := range order {
+		word, chars := 0, 1
+		for _, index := range group {
+			word <<= 8
+			if index != -1 {
+				word |= int(sum[index])
+				chars++
+			}
+		}
+		for ; chars > 0; chars-- {
+			out.WriteByte(cryptAlphabet[word&0x3f])
+			word >>= 6
+		}
+	}
+	return out.String(), nil
+}
+
+// Return the hash of the password in the format given by the setting, which is either SHA-crypt or bcrypt.
+func crypt(password, setting string) (string, error) {
+	if strings.HasPrefix(setting, "$2") {
+		return bcrypt(password, setting)
+	}
+	return shaCrypt(password, setting)
+}
+
+// Return true only if the password matches the SHA-crypt or bcrypt hash.
+func VerifyPassword(password, hashed string) (bool, error) {
+	computed, err := crypt(password, hashed)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hashed)) == 1, nil
+}
+
+// Return the SHA-512 crypt hash of the password with a random salt, suitable for the user file.
+func HashPassword(password string) (string, error) {
+	random := make([]byte, SHA_CRYPT_SALT_MAX)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	salt := make([]byte, len(random))
+	for i, b := range random {
+		salt[i] = cryptAlphabet[int(b)%len(cryptAlphabet)]
+	}
+	return shaCrypt(password, "$6$rounds="+strconv.Itoa(SHA_CRYPT_HASH_ROUNDS)+"$"+string(salt))
+}
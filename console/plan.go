@@ -0,0 +1,276 @@
+package console
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/diff"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/patch"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/writeback"
+)
+
+// PolicyCheck is a permission that a change needs, and whether the policy grants it.
+type PolicyCheck struct {
+	Permission string `json:"permission"`
+	Path       string `json:"path,omitempty"` // node path, empty for the whole file
+	Allowed    bool   `json:"allowed"`
+	Reason     string `json:"reason,omitempty"` // why the permission is denied
+}
+
+/*
+FilePlan tells what applying a change set would do to a file. The content of the file, i.e. the outcome of the
+operations, the changes, and the diff, is only told to those who may read the file.
+*/
+type FilePlan struct {
+	Path       string                  `json:"path"`
+	Create     bool                    `json:"create"`
+	Checks     []PolicyCheck           `json:"checks"`
+	Results    []patch.OperationResult `json:"results,omitempty"`
+	Succeeded  bool                    `json:"succeeded"` // all operations are either applied or satisfied
+	Write      bool                    `json:"write"`     // the file would be written
+	Changes    []diff.Change           `json:"changes,omitempty"`
+	Diff       string                  `json:"diff,omitempty"` // the changed lines of the file in unified diff style
+	Validators Validators              `json:"validators,omitempty"`
+	Error      string                  `json:"error,omitempty"` // why the file cannot be changed
+}
+
+// Plan tells what applying a change set would do, without writing anything.
+type Plan struct {
+	User      string         `json:"user"`
+	Files     []FilePlan     `json:"files"`
+	Services  ServiceActions `json:"services,omitempty"` // the service actions that would run after the files are written
+	Allowed   bool           `json:"allowed"`            // the policy allows all of the changes
+	Succeeded bool           `json:"succeeded"`          // all operations of all files succeed
+}
+
+// Return the lines changed by the splices in the text, in the style of a unified diff.
+func spliceDiff(filePath, text string, splices []writeback.Splice) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", filePath, filePath)
+	for _, splice := range splices {
+		lineStart := strings.LastIndex(text[:splice.Start], "\n") + 1
+		lineEnd := len(text)
+		if newLine := strings.Index(text[splice.End:], "\n"); newLine != -1 {
+			lineEnd = splice.End + newLine
+		}
+		oldLines := strings.Split(text[lineStart:lineEnd], "\n")
+		newLines := strings.Split(text[lineStart:splice.Start]+splice.New+text[splice.End:lineEnd], "\n")
+		// Lines that are the same on both ends are not part of the change
+		for len(oldLines) > 0 && len(newLines) > 0 && oldLines[0] == newLines[0] {
+			oldLines, newLines = oldLines[1:], newLines[1:]
+		}
+		for len(oldLines) > 0 && len(newLines) > 0 && oldLines[len(oldLines)-1] == newLines[len(newLines)-1] {
+			oldLines, newLines = oldLines[:len(oldLines)-1], newLines[:len(newLines)-1]
+		}
+		fmt.Fprintf(&out, "@@ line %d @@\n", splice.Line)
+		for _, line := range oldLines {
+			fmt.Fprintf(&out, "-%s\n", line)
+		}
+		for _, line := range newLines {
+			fmt.Fprintf(&out, "+%s\n", line)
+		}
+	}
+	return out.String()
+}
+
+// Return the checks of the permissions that the user of the request needs to apply the operations to the file.
+func (srv *Server) planChecks(r *http.Request, file ManagedFile, operations []patch.Operation) []PolicyCheck {
+	checks := make([]PolicyCheck, 0, 1+2*len(operations))
+	add := func(permission, nodePath string) {
+		check := PolicyCheck{Permission: permission, Path: nodePath, Allowed: true}
+		if err := srv.check(r, permission, file.Path, nodePath); err != nil {
+			check.Allowed, check.Reason = false, err.Error()
+		}
+		checks = append(checks, check)
+	}
+	add(PERMISSION_READ, "")
+	for _, op := range operations {
+		for _, nodePath := range operationPaths(op) {
+			add(PERMISSION_EDIT, nodePath)
+			add(PERMISSION_APPLY, nodePath)
+		}
+	}
+	return checks
+}
+
+/*
+Return the denied checks of the semantic changes of a file, other than those among the checks of its operations, as
+inserted text may carry nodes other than the one it names.
+*/
+func (srv *Server) changeChecks(r *http.Request, file ManagedFile, changes []diff.Change, checks []PolicyCheck) []PolicyCheck {
+	denied := make([]PolicyCheck, 0, 0)
+nextDenial:
+	for _, denial := range srv.checkChanges(r, file, changes, true) {
+		check := PolicyCheck{Reason: denial.Error()}
+		if accessDenied, ok := denial.(*AccessDenied); ok {
+			check.Permission, check.Path = accessDenied.Permission, accessDenied.Path
+		}
+		for _, existing := range append(checks, denied...) {
+			if existing.Permission == check.Permission && existing.Path == check.Path {
+				continue nextDenial
+			}
+		}
+		denied = append(denied, check)
+	}
+	return denied
+}
+
+// Return the plan of applying the change set on behalf of the user of the request.
+func (srv *Server) Plan(r *http.Request, set ChangeSet) Plan {
+	plan := Plan{User: UserOf(r), Files: make([]FilePlan, 0, len(set.Edits)), Allowed: true, Succeeded: true}
+	written := make([]string, 0, len(set.Edits))
+	for _, req := range set.Edits {
+		filePlan := FilePlan{Path: req.Path, Create: req.Create}
+		file, err := srv.editedFile(req)
+		if err == nil {
+			filePlan.Checks = srv.planChecks(r, file, req.Operations)
+			for _, check := range filePlan.Checks {
+				plan.Allowed = plan.Allowed && check.Allowed
+			}
+			text := ""
+			if !req.Create {
+				var content []byte
+				content, err = ioutil.ReadFile(file.Path)
+				text = string(content)
+			}
+			if err == nil {
+				_, err = req.checkBase(text)
+			}
+			if err == nil && filePlan.Checks[0].Allowed {
+				var result EditResult
+				if result, err = file.EditText(text, req.Operations); err == nil {
+					filePlan.Results, filePlan.Succeeded, filePlan.Changes = result.Results, result.Succeeded, result.Changes
+					filePlan.Checks = append(filePlan.Checks, srv.changeChecks(r, file, result.Changes, filePlan.Checks)...)
+					for _, check := range filePlan.Checks {
+						plan.Allowed = plan.Allowed && check.Allowed
+					}
+					filePlan.Write = len(result.Splices) > 0 || req.Create
+					if filePlan.Write {
+						filePlan.Diff = spliceDiff(file.Path, text, result.Splices)
+						filePlan.Validators = srv.Validators.For(file.Path)
+						written = append(written, file.Path)
+					}
+				}
+			}
+		}
+		if err != nil {
+			filePlan.Error = err.Error()
+		}
+		plan.Succeeded = plan.Succeeded && filePlan.Succeeded
+		plan.Files = append(plan.Files, filePlan)
+	}
+	plan.Services = srv.Services.For(written...)
+	return plan
+}
+
+// Return the plan in text for humans to review.
+func (plan Plan) String() string {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "Plan of change set by user \"%s\":\n", plan.User)
+	for _, file := range plan.Files {
+		verb := "Change"
+		if file.Create {
+			verb = "Create"
+		}
+		fmt.Fprintf(&out, "\n%s %s\n", verb, file.Path)
+		if file.Error != "" {
+			fmt.Fprintf(&out, "  Error: %s\n", file.Error)
+		}
+		if len(file.Checks) > 0 {
+			fmt.Fprintln(&out, "  Permissions:")
+			for _, check := range file.Checks {
+				what := file.Path
+				if check.Path != "" {
+					what = check.Path + " of " + file.Path
+				}
+				if check.Allowed {
+					fmt.Fprintf(&out, "    allowed %s %s\n", check.Permission, what)
+				} else {
+					fmt.Fprintf(&out, "    DENIED  %s %s: %s\n", check.Permission, what, check.Reason)
+				}
+			}
+		}
+		if len(file.Results) > 0 {
+			fmt.Fprintln(&out, "  Operations:")
+			for _, result := range file.Results {
+				fmt.Fprintf(&out, "    %-9s %s", result.Status, result.Operation)
+				if result.Reason != "" {
+					fmt.Fprintf(&out, " - %s", result.Reason)
+				}
+				fmt.Fprintln(&out)
+			}
+		}
+		if len(file.Changes) > 0 {
+			fmt.Fprintln(&out, "  Semantic changes:")
+			for _, change := range file.Changes {
+				fmt.Fprintf(&out, "    %s\n", change)
+			}
+		}
+		if file.Diff != "" {
+			fmt.Fprintln(&out, "  Diff:")
+			for _, line := range strings.SplitAfter(strings.TrimSuffix(file.Diff, "\n"), "\n") {
+				fmt.Fprintf(&out, "    %s", line)
+			}
+			fmt.Fprintln(&out)
+		}
+		if len(file.Validators) > 0 {
+			fmt.Fprintln(&out, "  Validators:")
+			for _, validator := range file.Validators {
+				fmt.Fprintf(&out, "    %s\n", strings.Join(validator.Command, " "))
+			}
+		}
+		if !file.Write && file.Error == "" && file.Checks[0].Allowed {
+			fmt.Fprintln(&out, "  The file would not be written.")
+		}
+	}
+	if len(plan.Services) > 0 {
+		fmt.Fprintln(&out, "\nService actions after the files are written:")
+		for _, action := range plan.Services {
+			fmt.Fprintf(&out, "  %s", strings.Join(action.Command, " "))
+			if len(action.Check) > 0 {
+				fmt.Fprintf(&out, ", then check with: %s", strings.Join(action.Check, " "))
+			}
+			if action.Probe != "" {
+				fmt.Fprintf(&out, ", then probe %s", action.Probe)
+			}
+			fmt.Fprintln(&out)
+		}
+	}
+	switch {
+	case !plan.Allowed:
+		fmt.Fprintln(&out, "\nThe change set would be denied by the policy.")
+	case !plan.Succeeded:
+		fmt.Fprintln(&out, "\nThe change set would fail, as not all operations succeed.")
+	default:
+		fmt.Fprintln(&out, "\nThe change set may be applied, subject to the validators and service actions.")
+	}
+	return out.String()
+}
+
+/*
+Respond with the plan of a change set, in JSON by default, or in text if the "format" parameter is "text" or the
+client accepts only plain text.
+*/
+func (srv *Server) handlePlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var set ChangeSet
+	if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+		writeError(w, http.StatusBadRequest, "malformed change set: "+err.Error())
+		return
+	}
+	plan := srv.Plan(r, set)
+	if r.URL.Query().Get("format") == "text" || r.Header.Get("Accept") == "text/plain" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, plan.String())
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
@@ -0,0 +1,195 @@
+package console
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/navigate"
+)
+
+// Permissions granted by policy rules.
+const (
+	PERMISSION_READ  = "read"  // see the file and its content
+	PERMISSION_EDIT  = "edit"  // preview operations on the file
+	PERMISSION_APPLY = "apply" // write the result of operations to the file
+)
+
+/*
+Rule grants or denies permissions on the files that match a glob, in which "*" matches within a directory and "**"
+matches across directories. If the rule has a node path, it only grants permissions on the node of the path and the
+nodes inside of it, whereas it denies permissions on those nodes as well as on the sections that contain them, because
+removing a section removes its content too. A segment of the rule's path matches nodes of the same key that carry the
+segment's arguments, regardless of occurrence index, and the key "*" matches any key. Node paths do not narrow down
+the read permission, which is always about whole files.
+*/
+type Rule struct {
+	Name        string   `json:"name,omitempty"` // tells the rule apart in error messages
+	Files       string   `json:"files"`
+	Path        string   `json:"path,omitempty"`
+	Permissions []string `json:"permissions"`
+	Deny        bool     `json:"deny,omitempty"`
+
+	filesRegexp *regexp.Regexp
+	path        navigate.Path
+}
+
+/*
+Policy assigns roles to users and rules to roles. A user may do something only if a rule of the user's roles grants it
+and no rule of the user's roles denies it.
+*/
+type Policy struct {
+	Users map[string][]string `json:"users"` // role names of each user
+	Roles map[string][]Rule   `json:"roles"` // rules of each role
+}
+
+// AccessDenied is the error of an action that the policy does not allow.
+type AccessDenied struct {
+	User       string `json:"user"`
+	Permission string `json:"permission"`
+	File       string `json:"file"`
+	Path       string `json:"path,omitempty"`
+	Rule       string `json:"rule,omitempty"` // name of the rule that denies the action, empty if no rule grants it
+}
+
+func (denied *AccessDenied) Error() string {
+	what := denied.File
+	if denied.Path != "" {
+		what = denied.Path + " of " + denied.File
+	}
+	if denied.Rule == "" {
+		return fmt.Sprintf("user \"%s\" may not %s %s, as no rule grants it", denied.User, denied.Permission, what)
+	}
+	return fmt.Sprintf("user \"%s\" may not %s %s, as denied by rule \"%s\"", denied.User, denied.Permission, what, denied.Rule)
+}
+
+// Read a policy from JSON file.
+func LoadPolicy(filePath string) (*Policy, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(content)
+}
+
+// Deserialise a policy from JSON and check its rules.
+func ParsePolicy(serialised []byte) (*Policy, error) {
+	policy := new(Policy)
+	if err := json.Unmarshal(serialised, policy); err != nil {
+		return nil, err
+	}
+	for roleName, rules := range policy.Roles {
+		for i := range rules {
+			rule := &rules[i]
+			if rule.Name == "" {
+				rule.Name = fmt.Sprintf("%s#%d", roleName, i)
+			}
+			for _, permission := range rule.Permissions {
+				if permission != PERMISSION_READ && permission != PERMISSION_EDIT && permission != PERMISSION_APPLY {
+					return nil, fmt.Errorf("rule \"%s\": unknown permission \"%s\"", rule.Name, permission)
+				}
+			}
+			rule.filesRegexp = globRegexp(rule.Files)
+			path, err := navigate.ParsePath(rule.Path)
+			if err != nil {
+				return nil, fmt.Errorf("rule \"%s\": %v", rule.Name, err)
+			}
+			rule.path = path
+		}
+	}
+	for user, roles := range policy.Users {
+		for _, role := range roles {
+			if _, found := policy.Roles[role]; !found {
+				return nil, fmt.Errorf("user \"%s\" has undefined role \"%s\"", user, role)
+			}
+		}
+	}
+	return policy, nil
+}
+
+// Return the regular expression that matches the same file paths as the glob.
+func globRegexp(glob string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			expr.WriteString("[^/]*")
+		case glob[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+/*
+Return true only if the rule concerns the node path. The node path must lie within the rule's path, or for a deny
+rule, either path may lie within the other.
+*/
+func (rule *Rule) matchPath(nodePath navigate.Path) bool {
+	common := len(rule.path)
+	if common > len(nodePath) {
+		if !rule.Deny {
+			return false
+		}
+		common = len(nodePath)
+	}
+	for i, seg := range rule.path[:common] {
+		if seg.Key != "*" && seg.Key != nodePath[i].Key || len(seg.Args) > len(nodePath[i].Args) {
+			return false
+		}
+		for j, arg := range seg.Args {
+			if nodePath[i].Args[j] != arg {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (rule *Rule) hasPermission(permission string) bool {
+	for _, candidate := range rule.Permissions {
+		if candidate == permission {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Return nil if the user has the permission on the node of the path (an empty string for the whole file) in the file,
+otherwise return an *AccessDenied that tells the rule which denies it.
+*/
+func (policy *Policy) Check(user, permission, filePath, nodePath string) error {
+	denied := &AccessDenied{User: user, Permission: permission, File: filePath, Path: nodePath}
+	path, err := navigate.ParsePath(nodePath)
+	if err != nil {
+		return err
+	}
+	granted := false
+	for _, role := range policy.Users[user] {
+		for i := range policy.Roles[role] {
+			rule := &policy.Roles[role][i]
+			if !rule.hasPermission(permission) || !rule.filesRegexp.MatchString(filePath) ||
+				permission != PERMISSION_READ && !rule.matchPath(path) {
+				continue
+			} else if rule.Deny {
+				denied.Rule = rule.Name
+				return denied
+			}
+			granted = true
+		}
+	}
+	if !granted {
+		return denied
+	}
+	return nil
+}
@@ -0,0 +1,418 @@
+package console
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Statuses of a scheduled change.
+const (
+	SCHEDULE_PENDING   = "pending"   // waiting for its time
+	SCHEDULE_RUNNING   = "running"   // being applied
+	SCHEDULE_APPLIED   = "applied"   // all files are written and the services are healthy
+	SCHEDULE_FAILED    = "failed"    // the change set is not applied, or it is rolled back
+	SCHEDULE_CANCELLED = "cancelled" // cancelled before its time
+)
+
+/*
+ScheduledChange is a change set queued to be applied at a later time on behalf of the user who queued it. When its
+time comes, the change set is checked against the policy and the validators again, and its operations are carried out
+on the files as they are then. A base hash in the change set's edits makes the change fail if the file has changed
+since, unless the edit asks for a rebase. If the console is not running at the time, the change is applied once the
+console is up again, unless that is past the end of the maintenance window.
+*/
+type ScheduledChange struct {
+	ID          int              `json:"id"`
+	User        string           `json:"user"`
+	Client      string           `json:"client"` // address of the client that queued the change
+	Queued      time.Time        `json:"queued"`
+	Time        time.Time        `json:"time"`  // when the change set is applied
+	Until       time.Time        `json:"until"` // end of the maintenance window, zero if the window has no end
+	ChangeSet   ChangeSet        `json:"changeSet"`
+	Status      string           `json:"status"`
+	Finished    time.Time        `json:"finished"` // when the change was applied, failed, or cancelled
+	CancelledBy string           `json:"cancelledBy,omitempty"`
+	Result      *ChangeSetResult `json:"result,omitempty"`
+	Error       string           `json:"error,omitempty"` // why the change set could not be carried out
+}
+
+// Return the paths of the files that the change edits.
+func (change ScheduledChange) paths() []string {
+	paths := make([]string, len(change.ChangeSet.Edits))
+	for i, edit := range change.ChangeSet.Edits {
+		paths[i] = edit.Path
+	}
+	return paths
+}
+
+// ScheduleRequest queues a change set to be applied at a time, optionally within a maintenance window that ends later.
+type ScheduleRequest struct {
+	Time      time.Time `json:"time"`
+	Until     time.Time `json:"until"`
+	ChangeSet ChangeSet `json:"changeSet"`
+}
+
+/*
+Schedule keeps the queue of scheduled changes in a JSON file, which is rewritten whenever a change is queued, applied,
+or cancelled, so that the queue survives restarts. Applied, failed, and cancelled changes stay in the queue as history.
+*/
+type Schedule struct {
+	Path    string
+	srv     *Server
+	lock    *sync.Mutex
+	changes []ScheduledChange // in the order they are queued
+	timer   *time.Timer       // fires at the time of the earliest pending change
+	closed  bool
+	now     func() time.Time
+}
+
+/*
+Open the schedule file, or create it if it does not yet exist, and apply the server's scheduled changes as their time
+comes. Changes that were being applied when the console stopped are marked failed, as they may be half done.
+*/
+func (srv *Server) OpenSchedule(filePath string) (*Schedule, error) {
+	schedule := &Schedule{Path: filePath, srv: srv, lock: new(sync.Mutex), changes: make([]ScheduledChange, 0, 8), now: time.Now}
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	} else if err == nil {
+		if err := json.Unmarshal(content, &schedule.changes); err != nil {
+			return nil, fmt.Errorf("schedule file \"%s\" is malformed: %v", filePath, err)
+		}
+	}
+	for i := range schedule.changes {
+		change := &schedule.changes[i]
+		if change.Status == SCHEDULE_RUNNING {
+			change.Status, change.Finished = SCHEDULE_FAILED, schedule.now().UTC()
+			change.Error = "the console stopped while the change was being applied, please check the files"
+			schedule.record(*change, scheduleClient(change.ID), "scheduled change "+strconv.Itoa(change.ID)+" is interrupted")
+		}
+	}
+	if err := schedule.save(); err != nil {
+		return nil, err
+	}
+	srv.Schedule = schedule
+	schedule.lock.Lock()
+	schedule.arm()
+	schedule.lock.Unlock()
+	return schedule, nil
+}
+
+// Stop applying scheduled changes, the pending changes stay in the schedule file.
+func (schedule *Schedule) Close() {
+	schedule.lock.Lock()
+	defer schedule.lock.Unlock()
+	schedule.closed = true
+	if schedule.timer != nil {
+		schedule.timer.Stop()
+	}
+}
+
+// Write the queue to the schedule file. The caller must hold the lock.
+func (schedule *Schedule) save() error {
+	serialised, err := json.MarshalIndent(schedule.changes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeDurably(schedule.Path, serialised)
+}
+
+// Set the timer to fire at the time of the earliest pending change. The caller must hold the lock.
+func (schedule *Schedule) arm() {
+	if schedule.timer != nil {
+		schedule.timer.Stop()
+	}
+	if schedule.closed {
+		return
+	}
+	var earliest time.Time
+	for _, change := range schedule.changes {
+		if change.Status == SCHEDULE_PENDING && (earliest.IsZero() || change.Time.Before(earliest)) {
+			earliest = change.Time
+		}
+	}
+	if !earliest.IsZero() {
+		schedule.timer = time.AfterFunc(earliest.Sub(schedule.now()), schedule.runDue)
+	}
+}
+
+// Return the name under which the scheduled change appears as the client in the audit log.
+func scheduleClient(id int) string {
+	return "schedule " + strconv.Itoa(id)
+}
+
+// Record the event in the audit log for each file of the change, if the audit log is in use.
+func (schedule *Schedule) record(change ScheduledChange, client, event string) error {
+	if schedule.srv.Audit == nil {
+		return nil
+	}
+	for _, filePath := range change.paths() {
+		if _, err := schedule.srv.Audit.RecordEvent(change.User, client, filePath, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return a copy of the scheduled changes, in the order they are queued.
+func (schedule *Schedule) Changes() []ScheduledChange {
+	schedule.lock.Lock()
+	defer schedule.lock.Unlock()
+	return append([]ScheduledChange{}, schedule.changes...)
+}
+
+/*
+Queue the change set on behalf of the user of the request. The user must be allowed to apply all of the operations,
+and the operations must succeed on the files as they are now.
+*/
+func (schedule *Schedule) Queue(r *http.Request, req ScheduleRequest) (change ScheduledChange, status int, err error) {
+	if req.Time.IsZero() {
+		return change, http.StatusBadRequest, errors.New("the scheduled change does not have a time")
+	} else if !req.Until.IsZero() && !req.Until.After(req.Time) {
+		return change, http.StatusBadRequest, errors.New("the maintenance window ends before the scheduled time")
+	} else if !req.Until.IsZero() && !req.Until.After(schedule.now()) {
+		return change, http.StatusBadRequest, errors.New("the maintenance window has already ended")
+	}
+	// Preview the change set with the permissions needed to apply it
+	denials := make(denialsError, 0, 0)
+	for _, edit := range req.ChangeSet.Edits {
+		denials = append(denials, schedule.srv.checkOperations(r, ManagedFile{Path: edit.Path}, edit.Operations, true)...)
+	}
+	if len(denials) > 0 {
+		return change, http.StatusForbidden, denials
+	}
+	result, status, err := schedule.srv.applyChangeSet(r, req.ChangeSet, false)
+	if err != nil {
+		return change, status, err
+	}
+	for i, edit := range result.Results {
+		denials = append(denials, schedule.srv.checkChanges(r, ManagedFile{Path: req.ChangeSet.Edits[i].Path}, edit.Changes, true)...)
+	}
+	if len(denials) > 0 {
+		return change, http.StatusForbidden, denials
+	} else if !result.Succeeded {
+		change.Result = &result
+		return change, http.StatusConflict, nil
+	}
+	schedule.lock.Lock()
+	defer schedule.lock.Unlock()
+	change = ScheduledChange{ID: 1, User: UserOf(r), Client: clientAddress(r), Queued: schedule.now().UTC(),
+		Time: req.Time.UTC(), Until: req.Until.UTC(), ChangeSet: req.ChangeSet, Status: SCHEDULE_PENDING}
+	if len(schedule.changes) > 0 {
+		change.ID = schedule.changes[len(schedule.changes)-1].ID + 1
+	}
+	schedule.changes = append(schedule.changes, change)
+	if err := schedule.save(); err != nil {
+		schedule.changes = schedule.changes[:len(schedule.changes)-1]
+		return change, http.StatusInternalServerError, err
+	}
+	schedule.arm()
+	event := fmt.Sprintf("scheduled change %d is queued for %s", change.ID, change.Time.Format(time.RFC3339))
+	if err := schedule.record(change, change.Client, event); err != nil {
+		return change, http.StatusInternalServerError, fmt.Errorf("the change is queued, but it cannot be recorded in the audit log: %v", err)
+	}
+	return change, http.StatusOK, nil
+}
+
+/*
+Cancel the pending change on behalf of the user of the request, who must either have queued the change or be allowed
+to apply all of its operations.
+*/
+func (schedule *Schedule) Cancel(r *http.Request, id int) (change ScheduledChange, status int, err error) {
+	schedule.lock.Lock()
+	defer schedule.lock.Unlock()
+	var found *ScheduledChange
+	for i := range schedule.changes {
+		if schedule.changes[i].ID == id {
+			found = &schedule.changes[i]
+		}
+	}
+	if found == nil {
+		return change, http.StatusNotFound, fmt.Errorf("scheduled change %d does not exist", id)
+	} else if found.Status != SCHEDULE_PENDING {
+		return *found, http.StatusConflict, fmt.Errorf("scheduled change %d is %s, only pending changes may be cancelled", id, found.Status)
+	}
+	if user := UserOf(r); user != found.User {
+		denials := make(denialsError, 0, 0)
+		for _, edit := range found.ChangeSet.Edits {
+			denials = append(denials, schedule.srv.checkOperations(r, ManagedFile{Path: edit.Path}, edit.Operations, true)...)
+		}
+		if len(denials) > 0 {
+			return *found, http.StatusForbidden, denials
+		}
+	}
+	previous := *found
+	found.Status, found.Finished, found.CancelledBy = SCHEDULE_CANCELLED, schedule.now().UTC(), UserOf(r)
+	if err := schedule.save(); err != nil {
+		*found = previous
+		return previous, http.StatusInternalServerError, err
+	}
+	schedule.arm()
+	if err := schedule.record(*found, clientAddress(r), fmt.Sprintf("scheduled change %d is cancelled by user \"%s\"", id, found.CancelledBy)); err != nil {
+		return *found, http.StatusInternalServerError, fmt.Errorf("the change is cancelled, but it cannot be recorded in the audit log: %v", err)
+	}
+	return *found, http.StatusOK, nil
+}
+
+// Apply the pending changes whose time has come, one after another in the order of their time.
+func (schedule *Schedule) runDue() {
+	for {
+		schedule.lock.Lock()
+		now := schedule.now()
+		var due *ScheduledChange
+		for i := range schedule.changes {
+			change := &schedule.changes[i]
+			if !schedule.closed && change.Status == SCHEDULE_PENDING && !change.Time.After(now) && (due == nil || change.Time.Before(due.Time)) {
+				due = change
+			}
+		}
+		if due == nil {
+			schedule.arm()
+			schedule.lock.Unlock()
+			return
+		}
+		due.Status = SCHEDULE_RUNNING
+		if err := schedule.save(); err != nil {
+			// Unless the file tells that the change is running, a restart would apply the change once more
+			due.Status, due.Finished = SCHEDULE_FAILED, now.UTC()
+			due.Error = "the change is not applied because the schedule cannot be saved: " + err.Error()
+			change := *due
+			schedule.lock.Unlock()
+			schedule.record(change, scheduleClient(change.ID), fmt.Sprintf("scheduled change %d failed: %s", change.ID, change.Error))
+			continue
+		}
+		change := *due
+		schedule.lock.Unlock()
+
+		change = schedule.run(change, now)
+
+		schedule.lock.Lock()
+		for i := range schedule.changes {
+			if schedule.changes[i].ID == change.ID {
+				schedule.changes[i] = change
+			}
+		}
+		err := schedule.save()
+		schedule.lock.Unlock()
+		if err != nil {
+			schedule.record(change, scheduleClient(change.ID), fmt.Sprintf("the outcome of scheduled change %d cannot be saved in the schedule: %v", change.ID, err))
+		}
+	}
+}
+
+/*
+Apply the change set of the change on behalf of the user who queued it, and record the outcome in the audit log.
+Return the change with its outcome.
+*/
+func (schedule *Schedule) run(change ScheduledChange, now time.Time) ScheduledChange {
+	change.Status = SCHEDULE_FAILED
+	if !change.Until.IsZero() && !now.Before(change.Until) {
+		change.Error = "the maintenance window ended before the change could be applied"
+	} else {
+		r, _ := http.NewRequest(http.MethodPost, "/api/changeset/apply", nil)
+		r.RemoteAddr = scheduleClient(change.ID)
+		r = r.WithContext(context.WithValue(r.Context(), sessionContextKey, &Session{User: change.User}))
+		result, status, err := schedule.srv.applyChangeSet(r, change.ChangeSet, true)
+		if err != nil {
+			change.Error = err.Error()
+		} else {
+			change.Result = &result
+			if status == http.StatusOK {
+				change.Status = SCHEDULE_APPLIED
+			} else if result.Error != "" {
+				change.Error = result.Error
+			} else {
+				change.Error = scheduleFailure(result)
+			}
+		}
+	}
+	change.Finished = schedule.now().UTC()
+	event := fmt.Sprintf("scheduled change %d is applied", change.ID)
+	if change.Status == SCHEDULE_FAILED {
+		event = fmt.Sprintf("scheduled change %d failed: %s", change.ID, change.Error)
+	}
+	if err := schedule.record(change, scheduleClient(change.ID), event); err != nil && change.Error == "" {
+		change.Error = "the outcome cannot be recorded in the audit log: " + err.Error()
+	}
+	return change
+}
+
+// Return why the change set result is not a success, when its failure does not come with an error.
+func scheduleFailure(result ChangeSetResult) string {
+	switch {
+	case !result.Succeeded:
+		return "not all operations succeed on the files as they are now"
+	case result.Rejected:
+		return "a validator rejects the result"
+	case result.RolledBack:
+		return "the change is rolled back as a service is not healthy"
+	}
+	return "the change set is not applied"
+}
+
+/*
+List the scheduled changes of the files that the user may read, queue a change with a ScheduleRequest, or cancel a
+pending change by its ID.
+*/
+func (srv *Server) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	if srv.Schedule == nil {
+		writeError(w, http.StatusNotFound, "scheduling is not in use")
+		return
+	}
+	var change ScheduledChange
+	var status int
+	var err error
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/schedule":
+		changes := make([]ScheduledChange, 0, 8)
+	nextChange:
+		for _, change := range srv.Schedule.Changes() {
+			for _, filePath := range change.paths() {
+				if srv.check(r, PERMISSION_READ, filePath, "") != nil {
+					continue nextChange
+				}
+			}
+			changes = append(changes, change)
+		}
+		sort.SliceStable(changes, func(i, j int) bool { return changes[i].Time.Before(changes[j].Time) })
+		writeJSON(w, http.StatusOK, changes)
+		return
+	case r.Method == http.MethodPost && r.URL.Path == "/api/schedule":
+		var req ScheduleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "malformed schedule request: "+err.Error())
+			return
+		}
+		change, status, err = srv.Schedule.Queue(r, req)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/schedule/cancel":
+		id, convErr := strconv.Atoi(r.URL.Query().Get("id"))
+		if convErr != nil {
+			writeError(w, http.StatusBadRequest, "malformed scheduled change ID")
+			return
+		}
+		change, status, err = srv.Schedule.Cancel(r, id)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if denials, ok := err.(denialsError); ok {
+		writeDenials(w, denials)
+	} else if conflict, ok := err.(*Conflict); ok {
+		writeConflict(w, conflict)
+	} else if err != nil {
+		writeError(w, status, err.Error())
+	} else if change.ID == 0 {
+		writeJSON(w, status, change.Result)
+	} else {
+		writeJSON(w, status, change)
+	}
+}
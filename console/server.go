@@ -0,0 +1,442 @@
+package console
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/diff"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/patch"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/writeback"
+)
+
+/*
+Server is the HTTP server of the management console. It serves the web user interface at "/", and offers a JSON API
+for browsing and editing the managed files:
+
+	POST /api/login              - log in with a LoginRequest, the response carries the session cookie and CSRF token
+	POST /api/logout             - end the session
+	GET  /api/session            - tell the logged in user and the CSRF token
+	GET  /api/files              - list managed files and their formats
+	GET  /api/file?path=...      - text, lexed document tree, and outline of a file
+	POST /api/preview            - carry out an EditRequest and report the result without writing the file
+	POST /api/apply              - carry out an EditRequest and write the file if all operations succeed and the
+	                               validators accept the result, otherwise respond with status 409 or 422 respectively.
+	                               Then reload the services of the file, and respond with status 424 if the change
+	                               is rolled back as a service is not healthy
+	GET  /api/audit              - query the audit log by file, user, directive, since and until (RFC 3339 time)
+	GET  /api/audit/verify       - check the chain of hashes in the audit log
+	GET  /api/versions           - list the stored versions of a file, or of all files if the path is not given
+	GET  /api/version            - text and outline of a stored version of a file, by path and hash
+	GET  /api/versions/diff      - semantic changes between two versions of a file, by path, from and to hashes
+	POST /api/changeset/preview  - carry out a ChangeSet of edits of several files without writing the files
+	POST /api/changeset/plan     - tell what applying a ChangeSet would do and which checks apply, in JSON or text
+	POST /api/changeset/apply    - carry out a ChangeSet and write all of the files, or none of them if any step fails
+	GET  /api/events             - stream of server-sent FileEvents of changes made outside of the console
+	POST /api/restore            - restore a file or all files to a version or point in time with a RestoreRequest
+	GET  /api/schedule           - list the scheduled changes
+	POST /api/schedule           - queue a ChangeSet to be applied at a later time with a ScheduleRequest
+	POST /api/schedule/cancel    - cancel a pending scheduled change by its id
+	GET  /api/drift              - report the drift of the files from the desired state, optionally with the
+	                               ChangeSet that remediates it
+
+The file endpoints respond with the hash of the file content as the ETag. The edit endpoints take the hash in the
+If-Match header (or in the request), and respond with status 412 if the file has changed since, unless the request
+asks for a rebase.
+
+All API endpoints other than login require a session, and POST requests must carry the session's CSRF token in the
+X-CSRF-Token header. If there is a policy, users only see the files they may read, and the edit endpoints refuse
+operations that the policy does not allow with status 403.
+*/
+type Server struct {
+	Files         []ManagedFile
+	Specs         []string       // specifications of the managed files, change sets may create new files that match them
+	Auth          Authenticator  // nil turns off authentication, which is only suitable for testing
+	Policy        *Policy        // nil lets every user do everything
+	Audit         *AuditLog      // records applied changes, nil turns off auditing
+	Snapshots     *SnapshotStore // keeps every version of the files, nil turns off versioning
+	Writer        writeback.FileWriter
+	Validators    Validators     // check new content before it is written
+	Services      ServiceActions // reload services after their files are written
+	Watcher       *Watcher       // tells clients about changes made outside of the console, nil turns off watching
+	Schedule      *Schedule      // applies change sets at a later time, nil turns off scheduling
+	Drift         *DriftMonitor  // compares the files with the desired state, nil turns off drift detection
+	Sessions      *Sessions
+	Throttle      *Throttle
+	SecureCookies bool // mark the session cookie secure even if the request did not arrive via TLS (e.g. behind a proxy)
+	mux           *http.ServeMux
+	lock          *sync.Mutex   // serialise edits so that concurrent applies do not overwrite each other
+	filesLock     *sync.RWMutex // guard the files, to which change sets add the files they create
+}
+
+// Create a server that manages the files, users log in via the authenticator.
+func NewServer(files []ManagedFile, auth Authenticator) *Server {
+	srv := &Server{Files: files, Auth: auth, Sessions: NewSessions(), Throttle: NewThrottle(), mux: http.NewServeMux(),
+		lock: new(sync.Mutex), filesLock: new(sync.RWMutex)}
+	srv.mux.HandleFunc("/api/login", srv.handleLogin)
+	srv.mux.Handle("/api/logout", srv.requireSession(http.HandlerFunc(srv.handleLogout)))
+	srv.mux.Handle("/api/session", srv.requireSession(http.HandlerFunc(srv.handleSession)))
+	srv.mux.Handle("/api/files", srv.requireSession(http.HandlerFunc(srv.handleFiles)))
+	srv.mux.Handle("/api/file", srv.requireSession(http.HandlerFunc(srv.handleFile)))
+	srv.mux.Handle("/api/preview", srv.requireSession(http.HandlerFunc(srv.handleEdit)))
+	srv.mux.Handle("/api/apply", srv.requireSession(http.HandlerFunc(srv.handleEdit)))
+	srv.mux.Handle("/api/audit", srv.requireSession(http.HandlerFunc(srv.handleAudit)))
+	srv.mux.Handle("/api/audit/verify", srv.requireSession(http.HandlerFunc(srv.handleAuditVerify)))
+	srv.mux.Handle("/api/versions", srv.requireSession(http.HandlerFunc(srv.handleVersions)))
+	srv.mux.Handle("/api/version", srv.requireSession(http.HandlerFunc(srv.handleVersion)))
+	srv.mux.Handle("/api/versions/diff", srv.requireSession(http.HandlerFunc(srv.handleVersionDiff)))
+	srv.mux.Handle("/api/changeset/preview", srv.requireSession(http.HandlerFunc(srv.handleChangeSet)))
+	srv.mux.Handle("/api/changeset/plan", srv.requireSession(http.HandlerFunc(srv.handlePlan)))
+	srv.mux.Handle("/api/changeset/apply", srv.requireSession(http.HandlerFunc(srv.handleChangeSet)))
+	srv.mux.Handle("/api/events", srv.requireSession(http.HandlerFunc(srv.handleEvents)))
+	srv.mux.Handle("/api/restore", srv.requireSession(http.HandlerFunc(srv.handleRestore)))
+	srv.mux.Handle("/api/schedule", srv.requireSession(http.HandlerFunc(srv.handleSchedule)))
+	srv.mux.Handle("/api/schedule/cancel", srv.requireSession(http.HandlerFunc(srv.handleSchedule)))
+	srv.mux.Handle("/api/drift", srv.requireSession(http.HandlerFunc(srv.handleDrift)))
+	srv.mux.Handle("/", uiHandler())
+	return srv
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mux.ServeHTTP(w, r)
+}
+
+// Return the managed files.
+func (srv *Server) managedFiles() []ManagedFile {
+	srv.filesLock.RLock()
+	defer srv.filesLock.RUnlock()
+	return srv.Files
+}
+
+// Start managing the new file.
+func (srv *Server) addFile(file ManagedFile) {
+	srv.filesLock.Lock()
+	defer srv.filesLock.Unlock()
+	files := make([]ManagedFile, len(srv.Files), len(srv.Files)+1)
+	copy(files, srv.Files)
+	files = append(files, file)
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	srv.Files = files
+}
+
+// Return the managed file of the path, or found is false if the file is not managed.
+func (srv *Server) file(path string) (file ManagedFile, found bool) {
+	for _, file = range srv.managedFiles() {
+		if file.Path == path {
+			return file, true
+		}
+	}
+	return
+}
+
+// Respond with the value encoded in JSON.
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// Respond with the error message encoded in JSON.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// Set the ETag header to the hash of the file content.
+func setETag(w http.ResponseWriter, hash string) {
+	w.Header().Set("ETag", "\""+hash+"\"")
+}
+
+// Return the content hash in the If-Match header of the request, or an empty string if the header is absent or "*".
+func ifMatch(r *http.Request) string {
+	tag := strings.TrimPrefix(strings.TrimSpace(r.Header.Get("If-Match")), "W/")
+	if tag == "*" {
+		return ""
+	}
+	return strings.Trim(tag, "\"")
+}
+
+// Respond with status 412, the conflict, and the ETag of the file's current content.
+func writeConflict(w http.ResponseWriter, conflict *Conflict) {
+	setETag(w, conflict.Hash)
+	writeJSON(w, http.StatusPreconditionFailed, map[string]interface{}{"error": conflict.Error(), "conflict": conflict})
+}
+
+// Respond with status 403 and the reasons why the request is denied.
+func writeDenials(w http.ResponseWriter, denials []error) {
+	writeJSON(w, http.StatusForbidden, map[string]interface{}{"error": denialsError(denials).Error(), "denied": denials})
+}
+
+// Return nil if the policy allows the user of the request to have the permission on the node of the file.
+func (srv *Server) check(r *http.Request, permission, filePath, nodePath string) error {
+	if srv.Policy == nil {
+		return nil
+	}
+	return srv.Policy.Check(UserOf(r), permission, filePath, nodePath)
+}
+
+func (srv *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	managed := srv.managedFiles()
+	files := make([]ManagedFile, 0, len(managed))
+	for _, file := range managed {
+		if srv.check(r, PERMISSION_READ, file.Path, "") == nil {
+			files = append(files, file)
+		}
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+// FileContent is the text, lexed document tree, and outline of a managed file.
+type FileContent struct {
+	ManagedFile
+	Text    string              `json:"text"`
+	Hash    string              `json:"hash"`    // hash of the text, which is also the ETag of the response
+	ModTime time.Time           `json:"modTime"` // modification time of the file when it is read
+	Tree    *lexer.DocumentNode `json:"tree"`
+	Outline []OutlineNode       `json:"outline"`
+}
+
+func (srv *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	file, found := srv.file(r.URL.Query().Get("path"))
+	if !found {
+		writeError(w, http.StatusNotFound, "file is not managed")
+		return
+	}
+	if err := srv.check(r, PERMISSION_READ, file.Path, ""); err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	info, err := os.Stat(file.Path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	text, root, err := file.Lex()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	content := FileContent{ManagedFile: file, Text: text, Hash: contentHash(text), ModTime: info.ModTime(), Tree: root,
+		Outline: Outline(root, file.Config())}
+	setETag(w, content.Hash)
+	writeJSON(w, http.StatusOK, content)
+}
+
+/*
+Return the reasons why the user of the request may not carry out the operations on the file. Previewing operations
+requires permission to edit the node of each operation, and applying them also requires permission to apply.
+*/
+func (srv *Server) checkOperations(r *http.Request, file ManagedFile, operations []patch.Operation, apply bool) []error {
+	denials := make([]error, 0, 0)
+	if err := srv.check(r, PERMISSION_READ, file.Path, ""); err != nil {
+		return append(denials, err)
+	}
+	permissions := []string{PERMISSION_EDIT}
+	if apply {
+		permissions = append(permissions, PERMISSION_APPLY)
+	}
+	for _, op := range operations {
+		for _, nodePath := range operationPaths(op) {
+			for _, permission := range permissions {
+				if err := srv.check(r, permission, file.Path, nodePath); err != nil {
+					denials = append(denials, err)
+					break
+				}
+			}
+		}
+	}
+	return denials
+}
+
+// Return the paths of the nodes that the operation changes, a move changes both the node's old and new places.
+func operationPaths(op patch.Operation) []string {
+	if op.Op == patch.OP_MOVE && op.From != op.Path {
+		return []string{op.Path, op.From}
+	}
+	return []string{op.Path}
+}
+
+/*
+Return the reasons why the user of the request may not make the semantic changes to the file. The changes of an edit
+are checked in addition to the paths of its operations, as inserted text may carry nodes other than the one it names.
+*/
+func (srv *Server) checkChanges(r *http.Request, file ManagedFile, changes []diff.Change, apply bool) []error {
+	denials := make([]error, 0, 0)
+	permissions := []string{PERMISSION_EDIT}
+	if apply {
+		permissions = append(permissions, PERMISSION_APPLY)
+	}
+	for _, change := range changes {
+		if !change.IsSemantic() {
+			continue
+		}
+		for _, nodePath := range []string{change.Path, change.OldPath} {
+			if nodePath == "" {
+				continue
+			}
+			for _, permission := range permissions {
+				if err := srv.check(r, permission, file.Path, nodePath); err != nil {
+					denials = append(denials, err)
+					break
+				}
+			}
+		}
+	}
+	return denials
+}
+
+func (srv *Server) handleEdit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req EditRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "malformed edit request: "+err.Error())
+		return
+	}
+	file, found := srv.file(req.Path)
+	if !found {
+		writeError(w, http.StatusNotFound, "file is not managed")
+		return
+	}
+	if denials := srv.checkOperations(r, file, req.Operations, r.URL.Path == "/api/apply"); len(denials) > 0 {
+		writeDenials(w, denials)
+		return
+	}
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	if req.BaseHash == "" {
+		req.BaseHash = ifMatch(r)
+	}
+	result, err := file.Edit(req)
+	if err == nil {
+		if denials := srv.checkChanges(r, file, result.Changes, r.URL.Path == "/api/apply"); len(denials) > 0 {
+			writeDenials(w, denials)
+			return
+		}
+		if r.URL.Path == "/api/apply" {
+			result, err = file.write(result, srv.Writer, srv.Validators)
+		}
+	}
+	if err == nil && result.Applied {
+		if err = srv.recordChange(r, file, result.Changes, result.original, result.Text); err == nil {
+			result.Services, result.RolledBack, err = srv.reloadServices(r, []fileChange{{file: file, before: result.original, after: result.Text}})
+		}
+	}
+	if conflict, ok := err.(*Conflict); ok {
+		writeConflict(w, conflict)
+		return
+	} else if err == nil {
+		if result.Applied && !result.RolledBack {
+			setETag(w, result.Hash)
+		} else {
+			setETag(w, contentHash(result.original))
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+	} else if !result.Succeeded {
+		writeJSON(w, http.StatusConflict, result)
+	} else if result.Rejected {
+		writeJSON(w, http.StatusUnprocessableEntity, result)
+	} else if result.RolledBack {
+		writeJSON(w, http.StatusFailedDependency, result)
+	} else {
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// Record a change written to the file in the audit log and the snapshot store, whichever are in use.
+func (srv *Server) recordChange(r *http.Request, file ManagedFile, changes []diff.Change, before, after string) error {
+	if srv.Audit != nil {
+		if _, err := srv.Audit.Record(UserOf(r), clientAddress(r), file.Path, changes, before, after); err != nil {
+			return fmt.Errorf("the change is applied, but it cannot be recorded in the audit log: %v", err)
+		}
+	}
+	if srv.Watcher != nil {
+		srv.Watcher.Refresh(file)
+	}
+	if srv.Snapshots != nil {
+		// The content before the change is captured too, in case the file has been changed outside of the console
+		for _, content := range []string{before, after} {
+			if _, _, err := srv.Snapshots.Capture(file.Path, []byte(content)); err != nil {
+				return fmt.Errorf("the change is applied, but it cannot be stored as a version: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Respond with the audit entries that satisfy the query, among those of the files that the user may read.
+func (srv *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	} else if srv.Audit == nil {
+		writeError(w, http.StatusNotFound, "audit log is not in use")
+		return
+	}
+	params := r.URL.Query()
+	query := AuditQuery{File: params.Get("file"), User: params.Get("user"), Directive: params.Get("directive")}
+	for name, dest := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if value := params.Get(name); value != "" {
+			var err error
+			if *dest, err = time.Parse(time.RFC3339, value); err != nil {
+				writeError(w, http.StatusBadRequest, "malformed time \""+name+"\": "+err.Error())
+				return
+			}
+		}
+	}
+	entries, err := srv.Audit.Query(query)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	visible := make([]AuditEntry, 0, len(entries))
+	for _, entry := range entries {
+		if srv.check(r, PERMISSION_READ, entry.File, "") == nil {
+			visible = append(visible, entry)
+		}
+	}
+	writeJSON(w, http.StatusOK, visible)
+}
+
+// AuditVerification tells whether the chain of the audit log is intact.
+type AuditVerification struct {
+	Intact   bool   `json:"intact"`
+	Entries  int    `json:"entries"`  // number of entries verified before the first problem
+	LastHash string `json:"lastHash"` // hash of the last intact entry
+	Error    string `json:"error,omitempty"`
+}
+
+func (srv *Server) handleAuditVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	} else if srv.Audit == nil {
+		writeError(w, http.StatusNotFound, "audit log is not in use")
+		return
+	}
+	count, lastHash, err := VerifyAuditLog(srv.Audit.Path)
+	verification := AuditVerification{Intact: err == nil, Entries: count, LastHash: lastHash}
+	if os.IsNotExist(err) {
+		verification.Intact = true
+	} else if err != nil {
+		verification.Error = err.Error()
+	}
+	writeJSON(w, http.StatusOK, verification)
+}
@@ -0,0 +1,274 @@
+package console
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/diff"
+)
+
+const (
+	SERVICE_TIMEOUT        = 30 * time.Second       // default time limit of the command, and of the health check
+	SERVICE_CHECK_INTERVAL = 500 * time.Millisecond // pause between health checks until the service is healthy
+)
+
+/*
+ServiceAction reloads or restarts the service of the files that match a glob after one of them is changed, in which
+"*" matches within a directory and "**" matches across directories. The service is then checked by the health check
+command, or by the probe which is either "tcp://host:port" that must accept a connection, or an HTTP(S) URL that must
+respond with a status below 400. The check is repeated until it passes or the timeout (e.g. "10s") runs out, and if it
+never passes, the change is rolled back.
+*/
+type ServiceAction struct {
+	Files   string   `json:"files"`
+	Command []string `json:"command"`
+	Check   []string `json:"check,omitempty"`
+	Probe   string   `json:"probe,omitempty"`
+	Timeout string   `json:"timeout,omitempty"`
+
+	filesRegexp *regexp.Regexp
+	timeout     time.Duration
+}
+
+// ServiceActions is the table of service actions, all actions that match a file run in order after it is changed.
+type ServiceActions []ServiceAction
+
+// ServiceResult is the outcome of a service action.
+type ServiceResult struct {
+	Command     []string `json:"command"`
+	Output      string   `json:"output"`
+	CheckOutput string   `json:"checkOutput,omitempty"` // output of the last health check command
+	Healthy     bool     `json:"healthy"`
+	Error       string   `json:"error,omitempty"`
+	Rollback    bool     `json:"rollback"` // the action ran after the change had been rolled back
+}
+
+// Read a table of service actions from JSON file.
+func LoadServiceActions(filePath string) (ServiceActions, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return ParseServiceActions(content)
+}
+
+// Deserialise a table of service actions from JSON and check the actions.
+func ParseServiceActions(serialised []byte) (ServiceActions, error) {
+	var actions ServiceActions
+	if err := json.Unmarshal(serialised, &actions); err != nil {
+		return nil, err
+	}
+	for i := range actions {
+		action := &actions[i]
+		if len(action.Command) == 0 {
+			return nil, fmt.Errorf("service action %d of files \"%s\" does not have a command", i, action.Files)
+		}
+		if action.Probe != "" {
+			probe, err := url.Parse(action.Probe)
+			if err != nil {
+				return nil, fmt.Errorf("service action %d: %v", i, err)
+			} else if probe.Scheme != "tcp" && probe.Scheme != "http" && probe.Scheme != "https" {
+				return nil, fmt.Errorf("service action %d: probe \"%s\" is neither tcp nor http(s)", i, action.Probe)
+			}
+		}
+		action.timeout = SERVICE_TIMEOUT
+		if action.Timeout != "" {
+			var err error
+			if action.timeout, err = time.ParseDuration(action.Timeout); err != nil {
+				return nil, fmt.Errorf("service action %d: %v", i, err)
+			}
+		}
+		action.filesRegexp = globRegexp(action.Files)
+	}
+	return actions, nil
+}
+
+// Return the actions whose glob matches any of the file paths, each action is returned once.
+func (actions ServiceActions) For(filePaths ...string) ServiceActions {
+	matched := make(ServiceActions, 0, 1)
+	for _, action := range actions {
+		if action.filesRegexp == nil {
+			action.filesRegexp = globRegexp(action.Files)
+		}
+		for _, filePath := range filePaths {
+			if action.filesRegexp.MatchString(filePath) {
+				matched = append(matched, action)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// Run the command, then check the service until it is healthy or the timeout runs out.
+func (action ServiceAction) Run() (result ServiceResult) {
+	result.Command = action.Command
+	timeout := action.timeout
+	if timeout == 0 {
+		timeout = SERVICE_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, action.Command[0], action.Command[1:]...).CombinedOutput()
+	result.Output = string(output)
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for {
+		if err = action.checkHealth(ctx, &result); err == nil {
+			result.Healthy = true
+			return
+		}
+		select {
+		case <-ctx.Done():
+			result.Error = "the service is not healthy: " + err.Error()
+			return
+		case <-time.After(SERVICE_CHECK_INTERVAL):
+		}
+	}
+}
+
+// Check the service once via the health check command and the probe, return nil if both pass.
+func (action ServiceAction) checkHealth(ctx context.Context, result *ServiceResult) error {
+	if len(action.Check) > 0 {
+		output, err := exec.CommandContext(ctx, action.Check[0], action.Check[1:]...).CombinedOutput()
+		result.CheckOutput = string(output)
+		if err != nil {
+			return err
+		}
+	}
+	if action.Probe == "" {
+		return nil
+	}
+	probe, err := url.Parse(action.Probe)
+	if err != nil {
+		return err
+	}
+	if probe.Scheme == "tcp" {
+		conn, err := new(net.Dialer).DialContext(ctx, "tcp", probe.Host)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, action.Probe, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("probe responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// fileChange is new content written to a managed file, which may be rolled back.
+type fileChange struct {
+	file          ManagedFile
+	before, after string
+	created       bool // the file did not exist before
+}
+
+/*
+Run the service actions of the changed files, each action runs once even if several of the files match it. If an
+action fails, roll back all of the changes, and run the actions again so that the services pick up the content before.
+Return the results of the actions, and whether the changes are rolled back. If some of the files cannot be written
+back, the error is a RollbackError that tells those files, the other files are still written back.
+*/
+func (srv *Server) reloadServices(r *http.Request, changes []fileChange) (results []ServiceResult, rolledBack bool, err error) {
+	filePaths := make([]string, len(changes))
+	for i, change := range changes {
+		filePaths[i] = change.file.Path
+	}
+	actions := srv.Services.For(filePaths...)
+	results = make([]ServiceResult, 0, len(actions))
+	for _, action := range actions {
+		result := action.Run()
+		results = append(results, result)
+		if result.Healthy {
+			continue
+		}
+		rolledBack = true
+		if rollBackErr := srv.rollBack(r, changes, "the service is not healthy"); rollBackErr != nil {
+			rolledBack, err = false, rollBackErr
+		}
+		for _, action := range actions {
+			result := action.Run()
+			result.Rollback = true
+			results = append(results, result)
+		}
+		return
+	}
+	return results, false, nil
+}
+
+// RollbackFailure is a file that cannot be written back to its content before a change.
+type RollbackFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+/*
+RollbackError tells that a change is not entirely rolled back: the files among the failures are either left as the
+change wrote them or written back without a record, whereas all other files of the change are written back.
+*/
+type RollbackError struct {
+	Cause    string // why the change is rolled back
+	Files    int    // the number of files to roll back
+	Failures []RollbackFailure
+}
+
+func (err *RollbackError) Error() string {
+	failures := make([]string, len(err.Failures))
+	for i, failure := range err.Failures {
+		failures[i] = failure.Path + ": " + failure.Error
+	}
+	return fmt.Sprintf("%s, and the rollback of %d of %d files failed (%s)", err.Cause, len(err.Failures), err.Files,
+		strings.Join(failures, "; "))
+}
+
+/*
+Restore the content of the files before the changes, remove the files that the changes have created, and record it.
+A file that cannot be restored does not stop the others from being restored, return the files that fail.
+*/
+func (srv *Server) rollBack(r *http.Request, changes []fileChange, cause string) *RollbackError {
+	failures := make([]RollbackFailure, 0, 0)
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		var err error
+		if change.created {
+			err = os.Remove(change.file.Path)
+		} else {
+			_, err = srv.Writer.WriteFile(change.file.Path, []byte(change.before))
+		}
+		if err != nil {
+			failures = append(failures, RollbackFailure{Path: change.file.Path, Error: err.Error()})
+			continue
+		}
+		undo := diff.Compare(change.file.LexText(change.after), change.file.LexText(change.before))
+		if err := srv.recordChange(r, change.file, undo, change.after, change.before); err != nil {
+			failures = append(failures, RollbackFailure{Path: change.file.Path, Error: "written back, but not recorded: " + err.Error()})
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	return &RollbackError{Cause: cause, Files: len(changes), Failures: failures}
+}
@@ -0,0 +1,236 @@
+package console
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SESSION_COOKIE       = "lmc_session"
+	CSRF_HEADER          = "X-CSRF-Token" // mutating requests carry the session's CSRF token in this header
+	SESSION_IDLE_TIMEOUT = 30 * time.Minute
+	SESSION_MAX_AGE      = 12 * time.Hour
+)
+
+// Session is a logged in user's visit to the console.
+type Session struct {
+	ID        string
+	User      string
+	CSRFToken string
+	Created   time.Time
+	LastSeen  time.Time
+}
+
+/*
+Sessions keeps the sessions of logged in users in memory. A session expires once it is left idle for IdleTimeout, or
+once it is older than MaxAge regardless of activity.
+*/
+type Sessions struct {
+	IdleTimeout time.Duration
+	MaxAge      time.Duration
+	lock        *sync.Mutex
+	sessions    map[string]*Session
+	now         func() time.Time
+}
+
+func NewSessions() *Sessions {
+	return &Sessions{IdleTimeout: SESSION_IDLE_TIMEOUT, MaxAge: SESSION_MAX_AGE, lock: new(sync.Mutex),
+		sessions: make(map[string]*Session), now: time.Now}
+}
+
+// Return a random string that is impossible to guess.
+func randomToken() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// Start a new session for the user.
+func (sessions *Sessions) Start(user string) (*Session, error) {
+	id, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	csrfToken, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	now := sessions.now()
+	session := &Session{ID: id, User: user, CSRFToken: csrfToken, Created: now, LastSeen: now}
+	sessions.lock.Lock()
+	defer sessions.lock.Unlock()
+	sessions.expire(now)
+	sessions.sessions[id] = session
+	return session, nil
+}
+
+// Return a copy of the session and mark it active, or nil if the session does not exist or has expired.
+func (sessions *Sessions) Get(id string) *Session {
+	sessions.lock.Lock()
+	defer sessions.lock.Unlock()
+	now := sessions.now()
+	sessions.expire(now)
+	session, found := sessions.sessions[id]
+	if !found {
+		return nil
+	}
+	session.LastSeen = now
+	copied := *session
+	return &copied
+}
+
+// End the session.
+func (sessions *Sessions) End(id string) {
+	sessions.lock.Lock()
+	defer sessions.lock.Unlock()
+	delete(sessions.sessions, id)
+}
+
+// Remove the expired sessions. The caller must hold the lock.
+func (sessions *Sessions) expire(now time.Time) {
+	for id, session := range sessions.sessions {
+		if now.Sub(session.LastSeen) > sessions.IdleTimeout || now.Sub(session.Created) > sessions.MaxAge {
+			delete(sessions.sessions, id)
+		}
+	}
+}
+
+type contextKey int
+
+const sessionContextKey contextKey = 0
+
+// Return the session of the request, or nil if authentication is turned off.
+func SessionOf(r *http.Request) *Session {
+	session, _ := r.Context().Value(sessionContextKey).(*Session)
+	return session
+}
+
+// Return the name of the user who made the request, or an empty string if authentication is turned off.
+func UserOf(r *http.Request) string {
+	if session := SessionOf(r); session != nil {
+		return session.User
+	}
+	return ""
+}
+
+/*
+Let the request through to the handler only if it belongs to a live session. Requests that modify anything must also
+carry the session's CSRF token in the header.
+*/
+func (srv *Server) requireSession(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if srv.Auth == nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		cookie, err := r.Cookie(SESSION_COOKIE)
+		var session *Session
+		if err == nil {
+			session = srv.Sessions.Get(cookie.Value)
+		}
+		if session == nil {
+			writeError(w, http.StatusUnauthorized, "please log in")
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead &&
+			subtle.ConstantTimeCompare([]byte(r.Header.Get(CSRF_HEADER)), []byte(session.CSRFToken)) != 1 {
+			writeError(w, http.StatusForbidden, "the request does not carry a valid CSRF token")
+			return
+		}
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionContextKey, session)))
+	})
+}
+
+// SessionInfo tells the client who is logged in, and the token to send along with mutating requests.
+type SessionInfo struct {
+	User      string `json:"user"`
+	CSRFToken string `json:"csrfToken"`
+}
+
+// LoginRequest carries the credentials of a user who logs in.
+type LoginRequest struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// Return the address of the client without port number.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (srv *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	} else if srv.Auth == nil {
+		writeJSON(w, http.StatusOK, SessionInfo{})
+		return
+	}
+	var req LoginRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, LOGIN_BODY_MAX)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "malformed login request: "+err.Error())
+		return
+	}
+	throttleKeys := []string{"user:" + req.User, "address:" + clientAddress(r)}
+	// The attempt counts as a failure until the password turns out to be right
+	if wait := srv.Throttle.Reserve(throttleKeys...); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds()+1)))
+		writeError(w, http.StatusTooManyRequests, "too many failed attempts, try again later")
+		return
+	}
+	if err := srv.Auth.Authenticate(req.User, req.Password); err == ErrBadCredentials {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.Throttle.Record(true, throttleKeys...)
+	session, err := srv.Sessions.Start(req.User)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: SESSION_COOKIE, Value: session.ID, Path: "/", HttpOnly: true,
+		Secure: srv.SecureCookies || r.TLS != nil, SameSite: http.SameSiteStrictMode})
+	writeJSON(w, http.StatusOK, SessionInfo{User: session.User, CSRFToken: session.CSRFToken})
+}
+
+func (srv *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if session := SessionOf(r); session != nil {
+		srv.Sessions.End(session.ID)
+	}
+	http.SetCookie(w, &http.Cookie{Name: SESSION_COOKIE, Value: "", Path: "/", MaxAge: -1, HttpOnly: true,
+		Secure: srv.SecureCookies || r.TLS != nil, SameSite: http.SameSiteStrictMode})
+	writeJSON(w, http.StatusOK, SessionInfo{})
+}
+
+func (srv *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	info := SessionInfo{}
+	if session := SessionOf(r); session != nil {
+		info = SessionInfo{User: session.User, CSRFToken: session.CSRFToken}
+	}
+	writeJSON(w, http.StatusOK, info)
+}
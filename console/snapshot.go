@@ -0,0 +1,626 @@
+package console
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/diff"
+)
+
+// Version is the content of a managed file at a point in time.
+type Version struct {
+	File string    `json:"file"`
+	Time time.Time `json:"time"`
+	Hash string    `json:"hash"` // SHA-256 of the content, which also names the content in the store
+	Size int64     `json:"size"`
+}
+
+/*
+Retention limits the versions kept in a snapshot store, a zero value means no limit. The latest version of each file
+is always kept, whatever the limits are.
+*/
+type Retention struct {
+	MaxCount int           `json:"maxCount"` // number of versions kept of each file
+	MaxAge   time.Duration `json:"maxAge"`   // versions older than this are removed
+	MaxSize  int64         `json:"maxSize"`  // total size of the stored content, the oldest versions are removed first
+}
+
+/*
+SnapshotStore keeps every version of the managed files in a directory. The content of each version is stored once
+under its hash in the "objects" sub-directory, and the versions are listed in an index file of JSON lines. The store
+locks the directory while it is open, so that a single process owns it.
+*/
+type SnapshotStore struct {
+	Dir       string
+	Retention Retention
+	lock      *sync.Mutex
+	dirLock   *os.File  // held until the store is closed
+	versions  []Version // in the order they are captured
+	now       func() time.Time
+}
+
+const (
+	SNAPSHOT_INDEX = "versions.jsonl"
+	SNAPSHOT_LOCK  = "lock"
+)
+
+/*
+Open the snapshot store in the directory, create the directory if it does not yet exist. Return an error if another
+store has the directory open.
+*/
+func OpenSnapshotStore(dir string, retention Retention) (*SnapshotStore, error) {
+	store := &SnapshotStore{Dir: dir, Retention: retention, lock: new(sync.Mutex), versions: make([]Version, 0, 64), now: time.Now}
+	if err := os.MkdirAll(filepath.Join(dir, "objects"), 0700); err != nil {
+		return nil, err
+	}
+	var err error
+	if store.dirLock, err = lockFile(filepath.Join(dir, SNAPSHOT_LOCK)); err != nil {
+		return nil, err
+	}
+	if err := store.load(); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// Read the versions from the index file.
+func (store *SnapshotStore) load() error {
+	index, err := os.Open(filepath.Join(store.Dir, SNAPSHOT_INDEX))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer index.Close()
+	scanner := bufio.NewScanner(index)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		var version Version
+		if err := json.Unmarshal(scanner.Bytes(), &version); err != nil {
+			return fmt.Errorf("%s line %d is malformed: %v", index.Name(), lineNum, err)
+		}
+		store.versions = append(store.versions, version)
+	}
+	return scanner.Err()
+}
+
+// Release the directory so that it may be opened again, the store must not be used afterwards.
+func (store *SnapshotStore) Close() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if store.dirLock == nil {
+		return nil
+	}
+	err := unlockFile(store.dirLock)
+	store.dirLock = nil
+	return err
+}
+
+func (store *SnapshotStore) objectPath(hash string) string {
+	return filepath.Join(store.Dir, "objects", hash)
+}
+
+/*
+Store the content as the latest version of the file. Nothing is stored if the content is the same as the file's
+latest version, in which case captured is false and the latest version is returned.
+*/
+func (store *SnapshotStore) Capture(filePath string, content []byte) (version Version, captured bool, err error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	version = Version{File: filePath, Time: store.now().UTC(), Hash: contentHash(string(content)), Size: int64(len(content))}
+	if latest, found := store.latest(filePath, time.Time{}); found && latest.Hash == version.Hash {
+		return latest, false, nil
+	}
+	if _, err := os.Stat(store.objectPath(version.Hash)); os.IsNotExist(err) {
+		if err := writeDurably(store.objectPath(version.Hash), content); err != nil {
+			return version, false, err
+		}
+	}
+	serialised, err := json.Marshal(version)
+	if err != nil {
+		return version, false, err
+	}
+	index, err := os.OpenFile(filepath.Join(store.Dir, SNAPSHOT_INDEX), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return version, false, err
+	}
+	defer index.Close()
+	if _, err := index.Write(append(serialised, '\n')); err != nil {
+		return version, false, err
+	}
+	if err := index.Sync(); err != nil {
+		return version, false, err
+	}
+	store.versions = append(store.versions, version)
+	return version, true, store.prune()
+}
+
+// Capture the current content of each file, so that the store knows how the files look before they are edited.
+func (store *SnapshotStore) CaptureFiles(files []ManagedFile) error {
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file.Path)
+		if err != nil {
+			return err
+		}
+		if _, _, err := store.Capture(file.Path, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the file via a temporary file and rename, so that an incomplete file is never seen under the name.
+func writeDurably(filePath string, content []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+/*
+Return the latest version of the file captured at or before the time, a zero time means now. The caller must hold the
+lock.
+*/
+func (store *SnapshotStore) latest(filePath string, at time.Time) (version Version, found bool) {
+	for _, candidate := range store.versions {
+		if candidate.File == filePath && (at.IsZero() || !candidate.Time.After(at)) {
+			version, found = candidate, true
+		}
+	}
+	return
+}
+
+// Return the latest version of the file captured at or before the time, a zero time means now.
+func (store *SnapshotStore) At(filePath string, at time.Time) (Version, bool) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.latest(filePath, at)
+}
+
+// Return the versions of the file from the oldest to the latest, or the versions of all files if the path is empty.
+func (store *SnapshotStore) Versions(filePath string) []Version {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	versions := make([]Version, 0, 16)
+	for _, version := range store.versions {
+		if filePath == "" || version.File == filePath {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+// Return the paths of all files that have versions in the store.
+func (store *SnapshotStore) Files() []string {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	seen := make(map[string]bool)
+	files := make([]string, 0, 16)
+	for _, version := range store.versions {
+		if !seen[version.File] {
+			seen[version.File] = true
+			files = append(files, version.File)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Return the content stored under the hash.
+func (store *SnapshotStore) Content(hash string) ([]byte, error) {
+	if len(hash) != 64 || filepath.Base(hash) != hash {
+		return nil, fmt.Errorf("malformed content hash \"%s\"", hash)
+	}
+	return ioutil.ReadFile(store.objectPath(hash))
+}
+
+// Remove the versions that exceed the retention limits, then remove their content unless other versions refer to it.
+func (store *SnapshotStore) Prune() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.prune()
+}
+
+// Apply the retention limits. The caller must hold the lock.
+func (store *SnapshotStore) prune() error {
+	limits := store.Retention
+	now := store.now()
+	// Count versions from the latest to the oldest
+	keep := make([]bool, len(store.versions))
+	count := make(map[string]int)
+	for i := len(store.versions) - 1; i >= 0; i-- {
+		version := store.versions[i]
+		count[version.File]++
+		keep[i] = count[version.File] == 1 || (limits.MaxCount == 0 || count[version.File] <= limits.MaxCount) &&
+			(limits.MaxAge == 0 || now.Sub(version.Time) <= limits.MaxAge)
+	}
+	if limits.MaxSize > 0 {
+		// Remove the oldest versions that are not the latest of their files until the content fits
+		size := func() (total int64) {
+			counted := make(map[string]bool)
+			for i, version := range store.versions {
+				if keep[i] && !counted[version.Hash] {
+					counted[version.Hash] = true
+					total += version.Size
+				}
+			}
+			return
+		}
+		latest := make(map[string]int)
+		for i, version := range store.versions {
+			latest[version.File] = i
+		}
+		for i := 0; i < len(store.versions) && size() > limits.MaxSize; i++ {
+			if latest[store.versions[i].File] != i {
+				keep[i] = false
+			}
+		}
+	}
+	kept := make([]Version, 0, len(store.versions))
+	removed := make([]Version, 0, len(store.versions))
+	for i, version := range store.versions {
+		if keep[i] {
+			kept = append(kept, version)
+		} else {
+			removed = append(removed, version)
+		}
+	}
+	if len(kept) == len(store.versions) {
+		return nil
+	}
+	var index []byte
+	referenced := make(map[string]bool)
+	for _, version := range kept {
+		serialised, err := json.Marshal(version)
+		if err != nil {
+			return err
+		}
+		index = append(append(index, serialised...), '\n')
+		referenced[version.Hash] = true
+	}
+	if err := writeDurably(filepath.Join(store.Dir, SNAPSHOT_INDEX), index); err != nil {
+		return err
+	}
+	store.versions = kept
+	for _, version := range removed {
+		if !referenced[version.Hash] {
+			if err := os.Remove(store.objectPath(version.Hash)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Return the version of the file that has the content hash, or found is false if the store does not have it.
+func (store *SnapshotStore) Find(filePath, hash string) (version Version, found bool) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, version = range store.versions {
+		if version.File == filePath && version.Hash == hash {
+			return version, true
+		}
+	}
+	return Version{}, false
+}
+
+// Respond with an error and return false if the server does not keep versions or the request method is wrong.
+func (srv *Server) checkVersioning(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	} else if srv.Snapshots == nil {
+		writeError(w, http.StatusNotFound, "versioning is not in use")
+		return false
+	}
+	return true
+}
+
+// Respond with the versions of a file, or the versions of all files that the user may read if the path is not given.
+func (srv *Server) handleVersions(w http.ResponseWriter, r *http.Request) {
+	if !srv.checkVersioning(w, r, http.MethodGet) {
+		return
+	}
+	filePath := r.URL.Query().Get("path")
+	if filePath != "" {
+		if _, found := srv.file(filePath); !found {
+			writeError(w, http.StatusNotFound, "file is not managed")
+			return
+		}
+		if err := srv.check(r, PERMISSION_READ, filePath, ""); err != nil {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+	}
+	versions := make([]Version, 0, 16)
+	for _, version := range srv.Snapshots.Versions(filePath) {
+		if _, found := srv.file(version.File); found && srv.check(r, PERMISSION_READ, version.File, "") == nil {
+			versions = append(versions, version)
+		}
+	}
+	writeJSON(w, http.StatusOK, versions)
+}
+
+// VersionContent is the text and outline of a stored version of a managed file.
+type VersionContent struct {
+	Version
+	Text    string        `json:"text"`
+	Outline []OutlineNode `json:"outline"`
+}
+
+/*
+Return the managed file of the path that the user of the request may read, and the text of the file's version of the
+hash, or the file's current text if the hash is empty. Respond with an error and return ok false if any is missing.
+*/
+func (srv *Server) readVersion(w http.ResponseWriter, r *http.Request, filePath, hash string) (file ManagedFile, text string, ok bool) {
+	file, found := srv.file(filePath)
+	if !found {
+		writeError(w, http.StatusNotFound, "file is not managed")
+		return
+	}
+	if err := srv.check(r, PERMISSION_READ, file.Path, ""); err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	var content []byte
+	var err error
+	if hash == "" {
+		content, err = ioutil.ReadFile(file.Path)
+	} else if _, found := srv.Snapshots.Find(file.Path, hash); !found {
+		writeError(w, http.StatusNotFound, "the file does not have version \""+hash+"\"")
+		return
+	} else {
+		content, err = srv.Snapshots.Content(hash)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	return file, string(content), true
+}
+
+// Respond with the text and outline of a version of a file.
+func (srv *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if !srv.checkVersioning(w, r, http.MethodGet) {
+		return
+	}
+	params := r.URL.Query()
+	if params.Get("hash") == "" {
+		writeError(w, http.StatusBadRequest, "please specify the hash of the version")
+		return
+	}
+	file, text, ok := srv.readVersion(w, r, params.Get("path"), params.Get("hash"))
+	if !ok {
+		return
+	}
+	version, _ := srv.Snapshots.Find(file.Path, params.Get("hash"))
+	writeJSON(w, http.StatusOK, VersionContent{Version: version, Text: text, Outline: Outline(file.LexText(text), file.Config())})
+}
+
+// VersionDiff is the semantic changes that turn one version of a file into another.
+type VersionDiff struct {
+	Path    string        `json:"path"`
+	From    string        `json:"from"` // hash of the older version, empty for the current content
+	To      string        `json:"to"`   // hash of the newer version, empty for the current content
+	Changes []diff.Change `json:"changes"`
+}
+
+// Respond with the semantic changes between two versions of a file, a version left empty is the file's current content.
+func (srv *Server) handleVersionDiff(w http.ResponseWriter, r *http.Request) {
+	if !srv.checkVersioning(w, r, http.MethodGet) {
+		return
+	}
+	params := r.URL.Query()
+	result := VersionDiff{Path: params.Get("path"), From: params.Get("from"), To: params.Get("to")}
+	file, fromText, ok := srv.readVersion(w, r, result.Path, result.From)
+	if !ok {
+		return
+	}
+	_, toText, ok := srv.readVersion(w, r, result.Path, result.To)
+	if !ok {
+		return
+	}
+	result.Changes = diff.Compare(file.LexText(fromText), file.LexText(toText))
+	writeJSON(w, http.StatusOK, result)
+}
+
+/*
+RestoreRequest asks for a file to be restored to the version of a hash, or to the version it had at a point in time.
+If the path is empty, all managed files that have versions are restored to the point in time.
+*/
+type RestoreRequest struct {
+	Path string    `json:"path,omitempty"`
+	Hash string    `json:"hash,omitempty"`
+	Time time.Time `json:"time,omitempty"`
+}
+
+// RestoreResult tells the outcome of restoring a file.
+type RestoreResult struct {
+	Path       string             `json:"path"`
+	Version    *Version           `json:"version,omitempty"` // the version restored to, nil if the file had no version then
+	Restored   bool               `json:"restored"`          // the file has been written
+	Changes    []diff.Change      `json:"changes"`
+	Backup     string             `json:"backup,omitempty"` // path of the backup of the content before restore
+	Rejected   bool               `json:"rejected"`         // a validator of the file rejects the version
+	Validation []ValidationResult `json:"validation,omitempty"`
+}
+
+/*
+RestoreSetResult tells the outcome of restoring files. Like a change set, the files are restored all together or not at
+all: none of them is written if a validator rejects a version, and those restored are written back if a file cannot
+be written or a service is not healthy afterwards.
+*/
+type RestoreSetResult struct {
+	Results    []RestoreResult `json:"results"`
+	Rejected   bool            `json:"rejected"`
+	Services   []ServiceResult `json:"services,omitempty"`
+	RolledBack bool            `json:"rolledBack"`
+
+	// The files that fail to roll back, whereas the other files are written back
+	RollbackFailures []RollbackFailure `json:"rollbackFailures,omitempty"`
+	Error            string            `json:"error,omitempty"` // why the restored files are written back
+}
+
+// Restore files to their past versions. Restoring a file requires permission to apply changes to the whole file.
+func (srv *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if !srv.checkVersioning(w, r, http.MethodPost) {
+		return
+	}
+	var req RestoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "malformed restore request: "+err.Error())
+		return
+	} else if req.Hash == "" && req.Time.IsZero() || req.Hash != "" && req.Path == "" {
+		writeError(w, http.StatusBadRequest, "please specify either a file and its version hash, or a point in time")
+		return
+	}
+	files := make([]ManagedFile, 0, 16)
+	if req.Path == "" {
+		for _, filePath := range srv.Snapshots.Files() {
+			if file, found := srv.file(filePath); found {
+				files = append(files, file)
+			}
+		}
+	} else if file, found := srv.file(req.Path); found {
+		files = append(files, file)
+	} else {
+		writeError(w, http.StatusNotFound, "file is not managed")
+		return
+	}
+	denials := make([]error, 0, 0)
+	for _, file := range files {
+		for _, permission := range []string{PERMISSION_READ, PERMISSION_APPLY} {
+			if err := srv.check(r, permission, file.Path, ""); err != nil {
+				denials = append(denials, err)
+				break
+			}
+		}
+	}
+	if len(denials) > 0 {
+		writeDenials(w, denials)
+		return
+	}
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	// Validate all versions before writing any of them
+	set := RestoreSetResult{Results: make([]RestoreResult, 0, len(files))}
+	changes := make([]fileChange, 0, len(files))
+	for _, file := range files {
+		var version Version
+		var found bool
+		if req.Hash != "" {
+			if version, found = srv.Snapshots.Find(file.Path, req.Hash); !found {
+				writeError(w, http.StatusNotFound, "the file does not have version \""+req.Hash+"\"")
+				return
+			}
+		} else if version, found = srv.Snapshots.At(file.Path, req.Time); !found {
+			set.Results = append(set.Results, RestoreResult{Path: file.Path})
+			changes = append(changes, fileChange{file: file})
+			continue
+		}
+		result, change, err := srv.prepareRestore(file, version)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		set.Results = append(set.Results, result)
+		set.Rejected = set.Rejected || result.Rejected
+		changes = append(changes, change)
+	}
+	if set.Rejected {
+		writeJSON(w, http.StatusUnprocessableEntity, set)
+		return
+	}
+	// Write the files one after another, the files restored so far are written back if one of them fails
+	written := make([]fileChange, 0, len(changes))
+	fail := func(status int, err error) {
+		set.Error = err.Error()
+		if rollBackErr := srv.rollBack(r, written, set.Error); rollBackErr != nil {
+			set.Error = rollBackErr.Error()
+			set.RollbackFailures = rollBackErr.Failures
+		} else {
+			set.RolledBack = len(written) > 0
+		}
+		writeJSON(w, status, set)
+	}
+	for i, change := range changes {
+		result := &set.Results[i]
+		if change.before == change.after {
+			continue
+		}
+		// The validators take a while, make sure the version does not overwrite a change made meanwhile
+		current, err := ioutil.ReadFile(change.file.Path)
+		if err != nil || string(current) != change.before {
+			fail(http.StatusPreconditionFailed, &Conflict{Path: change.file.Path, BaseHash: contentHash(change.before), Hash: contentHash(string(current))})
+			return
+		}
+		if result.Backup, err = srv.Writer.WriteFile(change.file.Path, []byte(change.after)); err != nil {
+			fail(http.StatusInternalServerError, err)
+			return
+		}
+		result.Restored = true
+		written = append(written, change)
+		if err := srv.recordChange(r, change.file, result.Changes, change.before, change.after); err != nil {
+			fail(http.StatusInternalServerError, err)
+			return
+		}
+	}
+	var err error
+	if set.Services, set.RolledBack, err = srv.reloadServices(r, written); err != nil {
+		set.Error = err.Error()
+		if rollBackErr, ok := err.(*RollbackError); ok {
+			set.RollbackFailures = rollBackErr.Failures
+		}
+		writeJSON(w, http.StatusInternalServerError, set)
+	} else if set.RolledBack {
+		writeJSON(w, http.StatusFailedDependency, set)
+	} else {
+		writeJSON(w, http.StatusOK, set)
+	}
+}
+
+/*
+Compare the file with the version and validate the version's content. Return the change that restores the version,
+its before and after are identical if the file already has the content of the version.
+*/
+func (srv *Server) prepareRestore(file ManagedFile, version Version) (result RestoreResult, change fileChange, err error) {
+	result = RestoreResult{Path: file.Path, Version: &version}
+	text, root, err := file.Lex()
+	if err != nil {
+		return
+	}
+	content, err := srv.Snapshots.Content(version.Hash)
+	if err != nil {
+		return
+	}
+	change = fileChange{file: file, before: text, after: string(content)}
+	if change.after == text {
+		return
+	}
+	result.Changes = diff.Compare(root, file.LexText(change.after))
+	var valid bool
+	result.Validation, valid, err = srv.Validators.Validate(file.Path, content)
+	result.Rejected = err == nil && !valid
+	return
+}
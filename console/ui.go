@@ -0,0 +1,21 @@
+package console
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// The web user interface is made of static files that are built into the program, it does not load anything else.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// Return the handler that serves the web user interface.
+func uiHandler() http.Handler {
+	root, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(root))
+}
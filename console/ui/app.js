@@ -0,0 +1,481 @@
+// The web user interface of the management console, it talks to the console's JSON API.
+"use strict";
+
+var state = {
+	files: [],    // managed files
+	current: "",  // path of the file shown in the editor
+	content: null, // text and outline of the current file
+	pending: {},  // edit operations waiting to be applied, by file path
+	bases: {},    // hash of the content that the pending operations are based on, by file path
+	csrfToken: "", // sent along with requests that modify anything
+	events: null  // stream of changes made outside of the console
+};
+
+/*
+Call the API, resolve with the response status and decoded body. When the session is gone, the login form is shown and
+the returned promise is never resolved.
+*/
+function api(method, url, body) {
+	var init = {method: method, headers: {}, credentials: "same-origin"};
+	if (body !== undefined) {
+		init.headers["Content-Type"] = "application/json";
+		init.body = JSON.stringify(body);
+	}
+	if (method !== "GET") {
+		init.headers["X-CSRF-Token"] = state.csrfToken;
+	}
+	return fetch(url, init).then(function (resp) {
+		return resp.json().then(function (data) {
+			if (resp.status === 401 && url !== "/api/login") {
+				showLogin();
+				return new Promise(function () {});
+			}
+			return {status: resp.status, data: data};
+		});
+	});
+}
+
+function showLogin() {
+	document.getElementById("main").hidden = true;
+	document.getElementById("logout").hidden = true;
+	document.getElementById("user").textContent = "";
+	document.getElementById("login").hidden = false;
+	document.getElementById("login-user").focus();
+}
+
+// Remember the session and show the managed files.
+function startSession(info) {
+	state.csrfToken = info.csrfToken;
+	document.getElementById("login").hidden = true;
+	document.getElementById("main").hidden = false;
+	document.getElementById("user").textContent = info.user;
+	document.getElementById("logout").hidden = !info.user;
+	api("GET", "/api/files").then(function (resp) {
+		state.files = resp.data || [];
+		renderFiles();
+		if (location.hash.length > 1) {
+			openFile(decodeURIComponent(location.hash.substring(1)));
+		}
+	});
+	watchFiles();
+}
+
+/*
+Follow the changes made to the files outside of the console. The current file is shown again if it has no pending
+operations, otherwise the user is told that the operations will be carried out on the new content.
+*/
+function watchFiles() {
+	if (state.events) {
+		state.events.close();
+	}
+	state.events = new EventSource("/api/events");
+	state.events.addEventListener("change", function (message) {
+		var event = JSON.parse(message.data);
+		if (event.created || event.removed) {
+			api("GET", "/api/files").then(function (resp) {
+				state.files = resp.data || [];
+				renderFiles();
+			});
+		}
+		if (event.path !== state.current || event.removed) {
+			return;
+		}
+		if ((state.pending[event.path] || []).length === 0) {
+			openFile(event.path);
+		} else {
+			var editor = document.getElementById("editor");
+			editor.insertBefore(el("p", "failed", "The file has been changed outside of the console, " +
+				"review the changes to see them carried out on its new content."), editor.firstChild);
+		}
+	});
+}
+
+function login(event) {
+	event.preventDefault();
+	var password = document.getElementById("login-password");
+	api("POST", "/api/login", {user: document.getElementById("login-user").value, password: password.value}).then(function (resp) {
+		password.value = "";
+		if (resp.status !== 200) {
+			document.getElementById("login-error").textContent = resp.data.error;
+			return;
+		}
+		document.getElementById("login-error").textContent = "";
+		startSession(resp.data);
+	});
+}
+
+function logout() {
+	api("POST", "/api/logout").then(function () {
+		state.csrfToken = "";
+		if (state.events) {
+			state.events.close();
+			state.events = null;
+		}
+		showLogin();
+	});
+}
+
+// Create an element with the class name and text content.
+function el(tag, className, text) {
+	var elem = document.createElement(tag);
+	if (className) {
+		elem.className = className;
+	}
+	if (text !== undefined) {
+		elem.textContent = text;
+	}
+	return elem;
+}
+
+function button(text, onclick) {
+	var elem = el("button", "", text);
+	elem.type = "button";
+	elem.onclick = onclick;
+	return elem;
+}
+
+// Split the text into words, a word may be quoted to contain spaces.
+function splitWords(text) {
+	var words = [], match, re = /"([^"]*)"|(\S+)/g;
+	while ((match = re.exec(text)) !== null) {
+		words.push(match[1] !== undefined ? match[1] : match[2]);
+	}
+	return words;
+}
+
+function joinWords(words) {
+	return (words || []).map(function (word) {
+		return /\s/.test(word) || word === "" ? '"' + word + '"' : word;
+	}).join(" ");
+}
+
+// Return the path segment that identifies a key, following the quotation rules of navigate.Segment.
+function segment(key) {
+	return key === "" || /[\/\[\]#" \t\\]/.test(key) ? JSON.stringify(key) : key;
+}
+
+function sameWords(a, b) {
+	return JSON.stringify(a || []) === JSON.stringify(b || []);
+}
+
+// Queue an edit operation of the current file. A later change of the same statement replaces the earlier one.
+function addOperation(op) {
+	var ops = state.pending[state.current] = state.pending[state.current] || [];
+	state.bases[state.current] = state.bases[state.current] || state.content.hash;
+	for (var i = 0; i < ops.length; i++) {
+		if (ops[i].path === op.path && ops[i].op === op.op && op.op === "set") {
+			ops[i].values = op.values;
+			updatePending();
+			return;
+		}
+	}
+	ops.push(op);
+	updatePending();
+}
+
+function pendingCount() {
+	var count = 0;
+	Object.keys(state.pending).forEach(function (path) {
+		count += state.pending[path].length;
+	});
+	return count;
+}
+
+function updatePending() {
+	var count = pendingCount();
+	var review = document.getElementById("review");
+	review.disabled = count === 0;
+	review.textContent = count === 0 ? "Review changes" : "Review " + count + " change" + (count > 1 ? "s" : "");
+	renderFiles();
+}
+
+function renderFiles() {
+	var nav = document.getElementById("files");
+	nav.textContent = "";
+	state.files.forEach(function (file) {
+		var link = el("a", "", file.path);
+		link.href = "#" + encodeURIComponent(file.path);
+		link.title = file.path;
+		link.classList.toggle("current", file.path === state.current);
+		link.classList.toggle("pending", (state.pending[file.path] || []).length > 0);
+		link.appendChild(el("span", "format", " " + file.format));
+		link.onclick = function (event) {
+			event.preventDefault();
+			openFile(file.path);
+		};
+		nav.appendChild(link);
+	});
+}
+
+function openFile(path) {
+	return api("GET", "/api/file?path=" + encodeURIComponent(path)).then(function (resp) {
+		var editor = document.getElementById("editor");
+		editor.textContent = "";
+		if (resp.status !== 200) {
+			editor.appendChild(el("p", "failed", resp.data.error));
+			return;
+		}
+		state.current = path;
+		state.content = resp.data;
+		editor.appendChild(el("h2", "", path));
+		var tree = el("div", "tree");
+		renderNodes(tree, resp.data.outline, "");
+		editor.appendChild(tree);
+		showPage("editor");
+		renderFiles();
+	});
+}
+
+// Render the outline nodes and a row for adding directives into the container.
+function renderNodes(container, nodes, parentPath) {
+	(nodes || []).forEach(function (node) {
+		container.appendChild(node.kind === "section" ? renderSection(node) : renderStatement(node));
+	});
+	container.appendChild(renderAdd(container, parentPath));
+}
+
+function renderSection(node) {
+	var div = el("div", "node section");
+	var details = el("details");
+	details.open = true;
+	var summary = el("summary", "", [node.key].concat(node.args || []).join(" "));
+	summary.appendChild(button("remove", function (event) {
+		event.preventDefault();
+		addOperation({op: "remove", path: node.path});
+		div.classList.add("removed");
+	}));
+	details.appendChild(summary);
+	var children = el("div", "children");
+	renderNodes(children, node.children, node.path);
+	details.appendChild(children);
+	div.appendChild(details);
+	return div;
+}
+
+function renderStatement(node) {
+	var disabled = node.kind === "disabled";
+	var div = el("div", "node statement" + (disabled ? " disabled" : ""));
+	div.appendChild(el("span", "key", node.key));
+	var input = el("input", "values");
+	input.value = joinWords(node.values);
+	div.appendChild(input);
+	if (disabled) {
+		div.appendChild(button("enable", function () {
+			addOperation({op: "enable", path: node.path, values: splitWords(input.value)});
+			div.classList.remove("disabled");
+			div.classList.add("changed");
+		}));
+	} else {
+		input.onchange = function () {
+			var values = splitWords(input.value);
+			if (!sameWords(values, node.values)) {
+				addOperation({op: "set", path: node.path, values: values, expect: node.values || []});
+				div.classList.add("changed");
+			}
+		};
+		div.appendChild(button("disable", function () {
+			addOperation({op: "disable", path: node.path, expect: node.values || []});
+			div.classList.add("disabled");
+		}));
+		div.appendChild(button("remove", function () {
+			addOperation({op: "remove", path: node.path, expect: node.values || []});
+			div.classList.add("removed");
+			input.disabled = true;
+		}));
+	}
+	(node.comments || []).forEach(function (comment) {
+		div.appendChild(el("span", "comment", comment));
+	});
+	return div;
+}
+
+// Render the row that adds a new directive to the section of the path, or to the top level if path is empty.
+function renderAdd(container, parentPath) {
+	var div = el("div", "node add");
+	var key = el("input", "key");
+	key.placeholder = "new directive";
+	var values = el("input", "values");
+	values.placeholder = "values";
+	div.appendChild(key);
+	div.appendChild(values);
+	div.appendChild(button("add", function () {
+		if (key.value.trim() === "") {
+			return;
+		}
+		var path = (parentPath ? parentPath + "/" : "") + segment(key.value.trim());
+		var words = splitWords(values.value);
+		addOperation({op: "set", path: path, values: words});
+		var added = renderStatement({kind: "statement", path: path, key: key.value.trim(), values: words});
+		added.classList.add("changed");
+		container.insertBefore(added, div);
+		key.value = values.value = "";
+	}));
+	return div;
+}
+
+function showPage(id) {
+	document.getElementById("editor").hidden = id !== "editor";
+	document.getElementById("apply").hidden = id !== "apply";
+}
+
+// Describe an operation in words.
+function describe(op) {
+	switch (op.op) {
+	case "set":
+		return "Set " + op.path + " to " + joinWords(op.values);
+	case "insert":
+		return "Insert " + op.path;
+	case "remove":
+		return "Remove " + op.path;
+	case "enable":
+		return "Enable " + op.path + (op.values && op.values.length ? " with " + joinWords(op.values) : "");
+	case "disable":
+		return "Comment out " + op.path;
+	case "move":
+		return "Move " + op.from + " to " + op.path + (op.after ? " after " + op.after : "");
+	}
+	return op.op + " " + op.path;
+}
+
+/*
+Return the lines changed by the splices as a list of [marker, line], where marker is "-" for removed lines and "+" for
+added lines. Splice offsets count bytes of UTF-8 encoded text.
+*/
+function diffLines(text, splices) {
+	var bytes = new TextEncoder().encode(text), decoder = new TextDecoder();
+	var slice = function (start, end) {
+		return decoder.decode(bytes.subarray(start, end));
+	};
+	var lines = [];
+	(splices || []).forEach(function (splice) {
+		var lineStart = splice.start, lineEnd = splice.end;
+		while (lineStart > 0 && bytes[lineStart - 1] !== 10) {
+			lineStart--;
+		}
+		while (lineEnd < bytes.length && bytes[lineEnd] !== 10) {
+			lineEnd++;
+		}
+		var oldLines = slice(lineStart, lineEnd).split("\n");
+		var newLines = (slice(lineStart, splice.start) + splice.new + slice(splice.end, lineEnd)).split("\n");
+		// Lines that are the same on both ends are not part of the change
+		while (oldLines.length > 0 && newLines.length > 0 && oldLines[0] === newLines[0]) {
+			oldLines.shift();
+			newLines.shift();
+		}
+		while (oldLines.length > 0 && newLines.length > 0 && oldLines[oldLines.length - 1] === newLines[newLines.length - 1]) {
+			oldLines.pop();
+			newLines.pop();
+		}
+		oldLines.forEach(function (line) {
+			lines.push(["-", line]);
+		});
+		newLines.forEach(function (line) {
+			lines.push(["+", line]);
+		});
+	});
+	return lines;
+}
+
+// Preview the pending operations of all files, and show the changes to be reviewed before they are applied.
+function review() {
+	var list = document.getElementById("changes");
+	list.textContent = "";
+	var proceed = document.getElementById("proceed");
+	proceed.disabled = true;
+	showPage("apply");
+	var paths = Object.keys(state.pending).filter(function (path) {
+		return state.pending[path].length > 0;
+	});
+	var allSucceeded = true;
+	var previews = paths.map(function (path) {
+		var item = el("li");
+		list.appendChild(item);
+		return Promise.all([
+			api("GET", "/api/file?path=" + encodeURIComponent(path)),
+			api("POST", "/api/preview", {path: path, operations: state.pending[path], baseHash: state.bases[path], rebase: true})
+		]).then(function (responses) {
+			var file = responses[0], preview = responses[1];
+			item.appendChild(el("span", "", "Change " + path + ":"));
+			if (preview.data.rebased) {
+				// The operations are reviewed against the file's current content, which becomes their base
+				item.appendChild(el("div", "failed", "The file has changed since you began editing it, " +
+					"the changes are carried out on its current content."));
+				state.bases[path] = file.data.hash;
+			}
+			if (preview.status !== 200 && preview.status !== 409) {
+				item.appendChild(el("div", "failed", preview.data.error));
+				allSucceeded = false;
+				return;
+			}
+			allSucceeded = allSucceeded && preview.data.succeeded;
+			var ops = el("ul");
+			(preview.data.results || []).forEach(function (result) {
+				var failed = result.status === "failed";
+				ops.appendChild(el("li", failed ? "failed" : "operation",
+					describe(result.operation) + (failed ? " - " + result.reason : result.status === "satisfied" ? " (already done)" : "")));
+			});
+			item.appendChild(ops);
+			var pre = el("pre", "diff");
+			diffLines(file.data.text, preview.data.splices).forEach(function (line) {
+				pre.appendChild(el("div", line[0] === "-" ? "del" : "ins", line[0] + " " + line[1]));
+			});
+			item.appendChild(pre);
+		});
+	});
+	Promise.all(previews).then(function () {
+		proceed.disabled = !allSucceeded;
+	});
+}
+
+// Apply the pending operations of all files, then show the current file again.
+function proceed() {
+	var paths = Object.keys(state.pending);
+	var failures = [];
+	Promise.all(paths.map(function (path) {
+		return api("POST", "/api/apply", {path: path, operations: state.pending[path], baseHash: state.bases[path]}).then(function (resp) {
+			if (resp.status === 200) {
+				delete state.pending[path];
+				delete state.bases[path];
+			} else if (resp.status === 412) {
+				failures.push(path + ": the file has changed since the review, please review the changes again");
+			} else if (resp.data.rejected) {
+				failures.push(path + ": rejected by validation\n" + resp.data.validation.filter(function (result) {
+					return !result.passed;
+				}).map(function (result) {
+					return result.command.join(" ") + "\n" + result.output + (result.error || "");
+				}).join("\n"));
+			} else if (resp.data.rolledBack) {
+				failures.push(path + ": rolled back as the service is not healthy\n" + resp.data.services.filter(function (result) {
+					return !result.healthy;
+				}).map(function (result) {
+					return result.command.join(" ") + "\n" + result.output + (result.checkOutput || "") + (result.error || "");
+				}).join("\n"));
+			} else {
+				failures.push(path + ": " + (resp.data.error || "some operations failed"));
+			}
+		});
+	})).then(function () {
+		updatePending();
+		if (failures.length > 0) {
+			alert("Failed to apply:\n" + failures.join("\n"));
+			review();
+		} else if (state.current) {
+			openFile(state.current);
+		} else {
+			showPage("editor");
+		}
+	});
+}
+
+window.onload = function () {
+	document.getElementById("review").onclick = review;
+	document.getElementById("cancel").onclick = function () {
+		showPage("editor");
+	};
+	document.getElementById("proceed").onclick = proceed;
+	document.getElementById("login").onsubmit = login;
+	document.getElementById("logout").onclick = logout;
+	api("GET", "/api/session").then(function (resp) {
+		startSession(resp.data);
+	});
+};
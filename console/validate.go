@@ -0,0 +1,189 @@
+package console
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// VALIDATOR_TIMEOUT is how long a validator command may run before it is killed and the validation fails.
+const VALIDATOR_TIMEOUT = 30 * time.Second
+
+/*
+Validator checks the new content of the files that match a glob before the content is written, in which "*" matches
+within a directory and "**" matches across directories. The command's arguments may carry placeholders:
+
+	{file}     - path of the staged copy of the new content, which lies in the same directory as the file
+	{stagedir} - a temporary directory with the staged copy under the file's name, and links to the file's neighbours
+	{path}     - path of the file itself
+	{dir}      - directory of the file
+	{name}     - name of the file without its extension, e.g. the zone name "example.com" of "example.com.zone"
+
+The validation fails if the command exits with a non-zero status.
+*/
+type Validator struct {
+	Files   string   `json:"files"`
+	Command []string `json:"command"`
+
+	filesRegexp *regexp.Regexp
+}
+
+// Validators is the table of validators, all validators that match a file take turns to check its content.
+type Validators []Validator
+
+// DefaultValidators runs the checkers that come with the daemons on their usual configuration files.
+var DefaultValidators = Validators{
+	{Files: "**/named.conf", Command: []string{"named-checkconf", "{file}"}},
+	{Files: "**/*.zone", Command: []string{"named-checkzone", "{name}", "{file}"}},
+	{Files: "**/httpd.conf", Command: []string{"apachectl", "-t", "-f", "{file}"}},
+	{Files: "/etc/apache2/**.conf", Command: []string{"apachectl", "-t", "-f", "{file}"}},
+	{Files: "/etc/sudoers", Command: []string{"visudo", "-cf", "{file}"}},
+	{Files: "/etc/sudoers.d/*", Command: []string{"visudo", "-cf", "{file}"}},
+	{Files: "**/sshd_config", Command: []string{"sshd", "-t", "-f", "{file}"}},
+	{Files: "/etc/postfix/main.cf", Command: []string{"postfix", "-c", "{stagedir}", "check"}},
+}
+
+// ValidationResult is the outcome of a validator command.
+type ValidationResult struct {
+	Command []string `json:"command"` // the command with placeholders filled in
+	Passed  bool     `json:"passed"`
+	Output  string   `json:"output"` // standard output and error of the command
+	Error   string   `json:"error,omitempty"`
+}
+
+// Read a validator table from JSON file.
+func LoadValidators(filePath string) (Validators, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var validators Validators
+	if err := json.Unmarshal(content, &validators); err != nil {
+		return nil, err
+	}
+	for i, validator := range validators {
+		if len(validator.Command) == 0 {
+			return nil, fmt.Errorf("validator %d of files \"%s\" does not have a command", i, validator.Files)
+		}
+	}
+	return validators, nil
+}
+
+// Return the validators whose glob matches the file path.
+func (validators Validators) For(filePath string) Validators {
+	matched := make(Validators, 0, 2)
+	for _, validator := range validators {
+		if validator.filesRegexp == nil {
+			validator.filesRegexp = globRegexp(validator.Files)
+		}
+		if validator.filesRegexp.MatchString(filePath) {
+			matched = append(matched, validator)
+		}
+	}
+	return matched
+}
+
+/*
+Stage the content in a copy of the file and run the validators of the file on the copy. Return the result of each
+validator that has run, the validation passes only if all of them pass.
+*/
+func (validators Validators) Validate(filePath string, content []byte) (results []ValidationResult, passed bool, err error) {
+	matched := validators.For(filePath)
+	results = make([]ValidationResult, 0, len(matched))
+	if len(matched) == 0 {
+		return results, true, nil
+	}
+	// Stage the copy beside the file, so that relative includes resolve in the same way, and so that its name ends
+	// with a tilde which keeps it out of the managed files.
+	staged, err := ioutil.TempFile(filepath.Dir(filePath), "."+filepath.Base(filePath)+".lmc-check*~")
+	if err != nil {
+		return
+	}
+	defer os.Remove(staged.Name())
+	if info, statErr := os.Stat(filePath); statErr == nil {
+		staged.Chmod(info.Mode().Perm())
+	}
+	if _, err = staged.Write(content); err != nil {
+		staged.Close()
+		return
+	}
+	if err = staged.Close(); err != nil {
+		return
+	}
+	passed = true
+	for _, validator := range matched {
+		result := runValidator(validator, filePath, staged.Name())
+		results = append(results, result)
+		passed = passed && result.Passed
+	}
+	return
+}
+
+// Run the validator command on the staged copy of the file.
+func runValidator(validator Validator, filePath, stagedPath string) (result ValidationResult) {
+	name := filepath.Base(filePath)
+	if dot := strings.LastIndex(name, "."); dot > 0 {
+		name = name[:dot]
+	}
+	placeholders := []string{"{file}", stagedPath, "{path}", filePath, "{dir}", filepath.Dir(filePath), "{name}", name}
+	if strings.Contains(strings.Join(validator.Command, " "), "{stagedir}") {
+		stageDir, err := stageDirectory(filePath, stagedPath)
+		if err != nil {
+			result.Error = err.Error()
+			return
+		}
+		defer os.RemoveAll(stageDir)
+		placeholders = append(placeholders, "{stagedir}", stageDir)
+	}
+	replacer := strings.NewReplacer(placeholders...)
+	result.Command = make([]string, len(validator.Command))
+	for i, arg := range validator.Command {
+		result.Command[i] = replacer.Replace(arg)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), VALIDATOR_TIMEOUT)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, result.Command[0], result.Command[1:]...).CombinedOutput()
+	result.Output = string(output)
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	result.Passed = true
+	return
+}
+
+/*
+Create a temporary directory that has the staged copy under the file's name, and symbolic links to the other entries
+of the file's directory, for checkers that read a whole configuration directory.
+*/
+func stageDirectory(filePath, stagedPath string) (string, error) {
+	stageDir, err := ioutil.TempDir("", "lmc-check")
+	if err != nil {
+		return "", err
+	}
+	entries, err := ioutil.ReadDir(filepath.Dir(filePath))
+	if err != nil {
+		os.RemoveAll(stageDir)
+		return "", err
+	}
+	for _, entry := range entries {
+		target := filepath.Join(filepath.Dir(filePath), entry.Name())
+		if entry.Name() == filepath.Base(filePath) {
+			target = stagedPath
+		} else if target == stagedPath {
+			continue
+		}
+		if err := os.Symlink(target, filepath.Join(stageDir, entry.Name())); err != nil {
+			os.RemoveAll(stageDir)
+			return "", err
+		}
+	}
+	return stageDir, nil
+}
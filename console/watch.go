@@ -0,0 +1,297 @@
+package console
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/diff"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lsp"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/navigate"
+)
+
+const (
+	WATCH_DEBOUNCE    = 200 * time.Millisecond // a file is re-lexed once it has not been written for this long
+	EVENTS_KEEP_ALIVE = 30 * time.Second       // interval of comments sent to event stream clients to keep them connected
+	EVENTS_BUFFER     = 64                     // events queued for each client, further events are dropped if it is slow
+)
+
+// FileEvent tells that a managed file has been changed by someone other than the console.
+type FileEvent struct {
+	Path    string        `json:"path"`
+	Time    time.Time     `json:"time"`
+	Hash    string        `json:"hash,omitempty"` // hash of the new content, empty if the file is removed
+	Created bool          `json:"created"`        // the file is new, and it is managed from now on
+	Removed bool          `json:"removed"`
+	Changes []diff.Change `json:"changes"` // semantic changes from the previous content
+}
+
+// watchedFile is the latest known content of a managed file.
+type watchedFile struct {
+	text   string
+	root   *lexer.DocumentNode
+	exists bool
+}
+
+/*
+Watcher watches the directories of the managed files and the directories of the files they include. When a managed
+file is changed by someone else, the watcher waits for the writes to settle, re-lexes the file, and tells the
+subscribers the semantic changes. New files that match the specifications of managed files become managed.
+*/
+type Watcher struct {
+	Debounce    time.Duration
+	srv         *Server
+	lock        *sync.Mutex
+	index       map[string]watchedFile // the latest known content of each managed file
+	timers      map[string]*time.Timer // pending re-lex of the files being written
+	dirs        map[string]bool        // directories being watched
+	subscribers map[chan FileEvent]bool
+	notifier    *notifier
+}
+
+/*
+Start watching the managed files of the server, and push events to the clients of the server's event stream. The
+watcher runs until it is closed.
+*/
+func (srv *Server) Watch() (*Watcher, error) {
+	watcher := &Watcher{
+		Debounce:    WATCH_DEBOUNCE,
+		srv:         srv,
+		lock:        new(sync.Mutex),
+		index:       make(map[string]watchedFile),
+		timers:      make(map[string]*time.Timer),
+		dirs:        make(map[string]bool),
+		subscribers: make(map[chan FileEvent]bool),
+	}
+	for _, file := range srv.managedFiles() {
+		watcher.index[file.Path] = readWatchedFile(file)
+	}
+	var err error
+	if watcher.notifier, err = newNotifier(watcher.changed); err != nil {
+		return nil, err
+	}
+	if err := watcher.watchDirectories(); err != nil {
+		watcher.notifier.close()
+		return nil, err
+	}
+	srv.Watcher = watcher
+	return watcher, nil
+}
+
+// Stop watching, the subscribers no longer receive events.
+func (watcher *Watcher) Close() error {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+	for _, timer := range watcher.timers {
+		timer.Stop()
+	}
+	return watcher.notifier.close()
+}
+
+// Read and lex the file, a file that cannot be read is considered removed.
+func readWatchedFile(file ManagedFile) watchedFile {
+	content, err := ioutil.ReadFile(file.Path)
+	return watchedFile{text: string(content), root: file.LexText(string(content)), exists: err == nil}
+}
+
+// Read the file again without telling the subscribers, it is called after the console itself changes the file.
+func (watcher *Watcher) Refresh(file ManagedFile) {
+	watched := readWatchedFile(file)
+	watcher.lock.Lock()
+	watcher.index[file.Path] = watched
+	watcher.lock.Unlock()
+	watcher.watchDirectories()
+}
+
+/*
+Return the directories of the managed files, and the directories of the files they include. Relative include paths
+are resolved against the directory of the including file, and against its server root if it specifies one.
+*/
+func (watcher *Watcher) directories() []string {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+	dirs := make(map[string]bool)
+	for filePath, watched := range watcher.index {
+		dirs[filepath.Dir(filePath)] = true
+		if watched.root == nil {
+			continue
+		}
+		serverRoot := lsp.ServerRoot(watched.root)
+		includes := make([]string, 0, 4)
+		var walk func(node *lexer.DocumentNode)
+		walk = func(node *lexer.DocumentNode) {
+			if stmt, isStatement := node.Entity.(*lexer.Statement); isStatement {
+				if texts := navigate.StatementTexts(stmt); len(texts) > 1 && lsp.IsIncludeKey(texts[0]) {
+					includes = append(includes, texts[len(texts)-1])
+				}
+			}
+			for _, leaf := range node.Leaves {
+				walk(leaf)
+			}
+		}
+		walk(watched.root)
+		for _, include := range includes {
+			if filepath.IsAbs(include) {
+				dirs[filepath.Dir(include)] = true
+				continue
+			}
+			dirs[filepath.Dir(filepath.Join(filepath.Dir(filePath), include))] = true
+			if serverRoot != "" {
+				dirs[filepath.Dir(filepath.Join(serverRoot, include))] = true
+			}
+		}
+	}
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// Watch the directories that are not watched yet, those that do not exist are left out.
+func (watcher *Watcher) watchDirectories() error {
+	for _, dir := range watcher.directories() {
+		watcher.lock.Lock()
+		watched := watcher.dirs[dir]
+		watcher.lock.Unlock()
+		if watched {
+			continue
+		}
+		if err := watcher.notifier.add(dir); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to watch directory \"%s\": %v", dir, err)
+		}
+		watcher.lock.Lock()
+		watcher.dirs[dir] = true
+		watcher.lock.Unlock()
+	}
+	return nil
+}
+
+// Re-lex the file once it has not been written for a while, if it is or may become a managed file.
+func (watcher *Watcher) changed(filePath string) {
+	if _, managed := watcher.srv.file(filePath); !managed {
+		if _, matched := MatchSpecs(watcher.srv.Specs, filePath); !matched {
+			return
+		}
+	}
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+	if timer, found := watcher.timers[filePath]; found {
+		timer.Reset(watcher.Debounce)
+		return
+	}
+	watcher.timers[filePath] = time.AfterFunc(watcher.Debounce, func() { watcher.reload(filePath) })
+}
+
+// Re-lex the file, and tell the subscribers about its changes.
+func (watcher *Watcher) reload(filePath string) {
+	file, managed := watcher.srv.file(filePath)
+	if !managed {
+		var matched bool
+		if file, matched = MatchSpecs(watcher.srv.Specs, filePath); !matched {
+			return
+		}
+	}
+	current := readWatchedFile(file)
+	watcher.lock.Lock()
+	delete(watcher.timers, filePath)
+	previous, found := watcher.index[filePath]
+	if !managed && !current.exists || found && previous.exists == current.exists && previous.text == current.text {
+		// The file is gone before it became managed, or the change is made by the console itself
+		watcher.lock.Unlock()
+		return
+	}
+	watcher.index[filePath] = current
+	watcher.lock.Unlock()
+	if !managed {
+		watcher.srv.addFile(file)
+	}
+	if previous.root == nil {
+		previous.root = file.LexText("")
+	}
+	event := FileEvent{Path: filePath, Time: time.Now(), Created: !managed, Removed: !current.exists,
+		Changes: diff.Compare(previous.root, current.root)}
+	if current.exists {
+		event.Hash = contentHash(current.text)
+	}
+	watcher.watchDirectories()
+	watcher.publish(event)
+}
+
+// Subscribe to file events. Call the cancel function to stop receiving them.
+func (watcher *Watcher) Subscribe() (events <-chan FileEvent, cancel func()) {
+	channel := make(chan FileEvent, EVENTS_BUFFER)
+	watcher.lock.Lock()
+	watcher.subscribers[channel] = true
+	watcher.lock.Unlock()
+	return channel, func() {
+		watcher.lock.Lock()
+		delete(watcher.subscribers, channel)
+		watcher.lock.Unlock()
+	}
+}
+
+// Send the event to all subscribers, a subscriber whose queue is full misses the event.
+func (watcher *Watcher) publish(event FileEvent) {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+	for channel := range watcher.subscribers {
+		select {
+		case channel <- event:
+		default:
+		}
+	}
+}
+
+// Stream the events of the files that the user may read to the client as server-sent events.
+func (srv *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	} else if srv.Watcher == nil {
+		writeError(w, http.StatusNotFound, "file watching is not in use")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	events, cancel := srv.Watcher.Subscribe()
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepAlive := time.NewTicker(EVENTS_KEEP_ALIVE)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-events:
+			if srv.check(r, PERMISSION_READ, event.Path, "") != nil {
+				continue
+			}
+			serialised, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", serialised)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,76 @@
+package console
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// WATCH_EVENTS are the inotify events that tell a file in a watched directory may have changed.
+const WATCH_EVENTS = syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// notifier reports the paths of files that are written, created, moved, or removed in the watched directories.
+type notifier struct {
+	fd   int
+	file *os.File // reads events from the descriptor via the runtime poller, so that closing it stops the reader
+	lock *sync.Mutex
+	dirs map[int32]string // watched directories by watch descriptor
+}
+
+// Start an inotify instance, and call the function with the path of each file that an event concerns.
+func newNotifier(changed func(filePath string)) (*notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	n := &notifier{fd: fd, file: os.NewFile(uintptr(fd), "inotify"), lock: new(sync.Mutex), dirs: make(map[int32]string)}
+	go n.run(changed)
+	return n, nil
+}
+
+// Watch the directory.
+func (n *notifier) add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(n.fd, dir, WATCH_EVENTS)
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+	n.lock.Lock()
+	n.dirs[int32(wd)] = dir
+	n.lock.Unlock()
+	return nil
+}
+
+// Read events until the notifier is closed.
+func (n *notifier) run(changed func(filePath string)) {
+	buf := make([]byte, 64*1024)
+	for {
+		count, err := n.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= count; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			offset = nameStart + int(event.Len)
+			if event.Len == 0 || offset > count {
+				continue
+			}
+			name := strings.TrimRight(string(buf[nameStart:offset]), "\x00")
+			n.lock.Lock()
+			dir := n.dirs[event.Wd]
+			n.lock.Unlock()
+			if dir != "" {
+				changed(filepath.Join(dir, name))
+			}
+		}
+	}
+}
+
+// Stop watching.
+func (n *notifier) close() error {
+	return n.file.Close()
+}
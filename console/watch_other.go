@@ -0,0 +1,95 @@
+//go:build !linux
+
+package console
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// WATCH_POLL_INTERVAL is how often the watched directories are scanned for changes where inotify is not available.
+const WATCH_POLL_INTERVAL = time.Second
+
+// notifier reports the paths of files that are written, created, or removed in the watched directories.
+type notifier struct {
+	lock *sync.Mutex
+	dirs map[string]map[string]os.FileInfo // the entries of each watched directory as of the last scan
+	stop chan struct{}
+}
+
+// Start polling the directories, and call the function with the path of each file that has changed.
+func newNotifier(changed func(filePath string)) (*notifier, error) {
+	n := &notifier{lock: new(sync.Mutex), dirs: make(map[string]map[string]os.FileInfo), stop: make(chan struct{})}
+	go n.run(changed)
+	return n, nil
+}
+
+// Return the entries of the directory by name.
+func scanDir(dir string) (map[string]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]os.FileInfo, len(infos))
+	for _, info := range infos {
+		entries[info.Name()] = info
+	}
+	return entries, nil
+}
+
+// Watch the directory.
+func (n *notifier) add(dir string) error {
+	entries, err := scanDir(dir)
+	if err != nil {
+		return err
+	}
+	n.lock.Lock()
+	n.dirs[dir] = entries
+	n.lock.Unlock()
+	return nil
+}
+
+// Scan the directories until the notifier is closed.
+func (n *notifier) run(changed func(filePath string)) {
+	ticker := time.NewTicker(WATCH_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+		n.lock.Lock()
+		dirs := make([]string, 0, len(n.dirs))
+		for dir := range n.dirs {
+			dirs = append(dirs, dir)
+		}
+		n.lock.Unlock()
+		for _, dir := range dirs {
+			entries, _ := scanDir(dir)
+			n.lock.Lock()
+			previous := n.dirs[dir]
+			n.dirs[dir] = entries
+			n.lock.Unlock()
+			for name, info := range entries {
+				if before, found := previous[name]; !found || !before.ModTime().Equal(info.ModTime()) || before.Size() != info.Size() {
+					changed(filepath.Join(dir, name))
+				}
+			}
+			for name := range previous {
+				if _, found := entries[name]; !found {
+					changed(filepath.Join(dir, name))
+				}
+			}
+		}
+	}
+}
+
+// Stop watching.
+func (n *notifier) close() error {
+	close(n.stop)
+	return nil
+}
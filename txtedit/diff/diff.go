@@ -0,0 +1,416 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/navigate"
+)
+
+const (
+	CHANGE_ADDED      = "added"      // the node exists only in the new document
+	CHANGE_REMOVED    = "removed"    // the node exists only in the old document
+	CHANGE_MODIFIED   = "changed"    // the node exists in both documents, and its words are different
+	CHANGE_MOVED      = "moved"      // the node is found at a different place (or order) with identical words
+	CHANGE_WHITESPACE = "whitespace" // only spaces, indentation, or blank lines are different
+	CHANGE_COMMENT    = "comment"    // only comments are different
+)
+
+const (
+	NODE_STATEMENT = "statement"
+	NODE_SECTION   = "section"
+	NODE_COMMENT   = "comment" // comments and blank lines that do not belong to a directive
+)
+
+type ChangeKind string
+
+/*
+Change describes a difference between two documents at a node identified by path. Removed nodes are identified by
+their path in the old document, all other changes are identified by path in the new document.
+*/
+type Change struct {
+	Kind      ChangeKind `json:"kind"`
+	NodeType  string     `json:"nodeType"`
+	Path      string     `json:"path"`
+	OldPath   string     `json:"oldPath,omitempty"` // the node's path in the old document, only set for moved nodes.
+	OldValues []string   `json:"oldValues,omitempty"`
+	NewValues []string   `json:"newValues,omitempty"`
+	OldText   string     `json:"oldText,omitempty"` // verbatim text in the old document
+	NewText   string     `json:"newText,omitempty"` // verbatim text in the new document
+
+	OldNode *lexer.DocumentNode `json:"-"` // the node in the old document, nil if the node is added.
+	NewNode *lexer.DocumentNode `json:"-"` // the node in the new document, nil if the node is removed.
+}
+
+func (change Change) String() string {
+	switch change.Kind {
+	case CHANGE_MODIFIED:
+		return fmt.Sprintf("%s %s %s: %v => %v", change.Kind, change.NodeType, change.Path, change.OldValues, change.NewValues)
+	case CHANGE_MOVED:
+		return fmt.Sprintf("%s %s %s => %s", change.Kind, change.NodeType, change.OldPath, change.Path)
+	case CHANGE_ADDED:
+		return fmt.Sprintf("%s %s %s: %v", change.Kind, change.NodeType, change.Path, change.NewValues)
+	case CHANGE_REMOVED:
+		return fmt.Sprintf("%s %s %s: %v", change.Kind, change.NodeType, change.Path, change.OldValues)
+	}
+	return fmt.Sprintf("%s %s %s", change.Kind, change.NodeType, change.Path)
+}
+
+// Return true only if the change alters meaning of the document, that is, it is not about spaces or comments.
+func (change Change) IsSemantic() bool {
+	return change.Kind != CHANGE_WHITESPACE && change.Kind != CHANGE_COMMENT
+}
+
+// Report is the JSON presentation of all changes between two documents.
+type Report struct {
+	Changes []Change           `json:"changes"`
+	Summary map[ChangeKind]int `json:"summary"` // number of changes of each kind
+}
+
+// Serialise the changes and their summary into JSON.
+func JSON(changes []Change) ([]byte, error) {
+	report := Report{Changes: changes, Summary: make(map[ChangeKind]int)}
+	if report.Changes == nil {
+		report.Changes = []Change{}
+	}
+	for _, change := range changes {
+		report.Summary[change.Kind]++
+	}
+	return json.Marshal(report)
+}
+
+// A leaf node that is identified by its kind, key, and arguments, which it may share with some of its siblings.
+type keyedLeaf struct {
+	group string
+	node  *lexer.DocumentNode
+}
+
+/*
+Compare two documents (or two sections) node by node, and return the changes made to the old document that result
+in the new document. Statements and sections are matched by their key and values rather than position, so that
+an inserted line does not turn every following line into a change.
+*/
+func Compare(oldRoot, newRoot *lexer.DocumentNode) []Change {
+	changes := make([]Change, 0, 8)
+	compareLeaves(oldRoot, newRoot, navigate.Path{}, navigate.Path{}, &changes)
+	return detectMoves(changes)
+}
+
+/*
+Pair statements and sections of the new document with their counterparts in the old document, the same way Compare
+does. Return the old node paired with each new node that has a counterpart.
+*/
+func Pair(oldRoot, newRoot *lexer.DocumentNode) map[*lexer.DocumentNode]*lexer.DocumentNode {
+	pairs := make(map[*lexer.DocumentNode]*lexer.DocumentNode)
+	pairLeaves(oldRoot, newRoot, pairs)
+	return pairs
+}
+
+func pairLeaves(oldNode, newNode *lexer.DocumentNode, pairs map[*lexer.DocumentNode]*lexer.DocumentNode) {
+	oldKeyed, _ := splitLeaves(oldNode)
+	newKeyed, _ := splitLeaves(newNode)
+	for oldIndex, newIndex := range matchLeaves(oldKeyed, newKeyed) {
+		oldLeaf, newLeaf := oldKeyed[oldIndex].node, newKeyed[newIndex].node
+		pairs[newLeaf] = oldLeaf
+		if navigate.IsSection(oldLeaf) {
+			pairLeaves(oldLeaf, newLeaf, pairs)
+		}
+	}
+}
+
+// Separate the leaves that have a key from the leaves made of comments and spaces.
+func splitLeaves(node *lexer.DocumentNode) (keyed []keyedLeaf, unkeyed []*lexer.DocumentNode) {
+	keyed = make([]keyedLeaf, 0, len(node.Leaves))
+	unkeyed = make([]*lexer.DocumentNode, 0, 0)
+	for _, leaf := range node.Leaves {
+		if seg, ok := navigate.NodeSegment(leaf); ok {
+			seg.Index = 0
+			keyed = append(keyed, keyedLeaf{seg.String(), leaf})
+		} else if leaf.Entity != nil {
+			unkeyed = append(unkeyed, leaf)
+		}
+	}
+	return
+}
+
+/*
+Pair each old leaf with a new leaf of the same kind, key, and arguments. Among the leaves of a group, those of
+identical content are paired first in their order, the remaining leaves in between such pairs are then paired by
+their order. Return the index of the new leaf paired with each paired old leaf.
+*/
+func matchLeaves(oldKeyed, newKeyed []keyedLeaf) map[int]int {
+	newGroups := make(map[string][]int)
+	for i, leaf := range newKeyed {
+		newGroups[leaf.group] = append(newGroups[leaf.group], i)
+	}
+	oldGroups := make(map[string][]int)
+	for i, leaf := range oldKeyed {
+		oldGroups[leaf.group] = append(oldGroups[leaf.group], i)
+	}
+	oldToNew := make(map[int]int)
+	for group, oldIndexes := range oldGroups {
+		newIndexes := newGroups[group]
+		oldPrints := make([]string, len(oldIndexes))
+		for i, index := range oldIndexes {
+			oldPrints[i] = fingerprint(oldKeyed[index].node)
+		}
+		newPrints := make([]string, len(newIndexes))
+		for i, index := range newIndexes {
+			newPrints[i] = fingerprint(newKeyed[index].node)
+		}
+		// Between two pairs of identical content, the remaining leaves are paired by their order
+		oldStart, newStart := 0, 0
+		pairs := append(commonSubsequence(oldPrints, newPrints), [2]int{len(oldIndexes), len(newIndexes)})
+		for _, pair := range pairs {
+			for i, j := oldStart, newStart; i < pair[0] && j < pair[1]; i, j = i+1, j+1 {
+				oldToNew[oldIndexes[i]] = newIndexes[j]
+			}
+			if pair[0] < len(oldIndexes) {
+				oldToNew[oldIndexes[pair[0]]] = newIndexes[pair[1]]
+			}
+			oldStart, newStart = pair[0]+1, pair[1]+1
+		}
+	}
+	return oldToNew
+}
+
+// Compare leaves of the two nodes and place the differences in changes.
+func compareLeaves(oldNode, newNode *lexer.DocumentNode, oldPath, newPath navigate.Path, changes *[]Change) {
+	oldKeyed, oldUnkeyed := splitLeaves(oldNode)
+	newKeyed, newUnkeyed := splitLeaves(newNode)
+
+	oldToNew := matchLeaves(oldKeyed, newKeyed)
+	newToOld := make(map[int]int)
+	for oldIndex, newIndex := range oldToNew {
+		newToOld[newIndex] = oldIndex
+	}
+	// Nodes that exist in both documents yet their relative order has changed are moved
+	commonOld := make([]string, 0, len(oldToNew))
+	for i := range oldKeyed {
+		if newIndex, exists := oldToNew[i]; exists {
+			commonOld = append(commonOld, strconv.Itoa(newIndex))
+		}
+	}
+	commonNew := make([]string, 0, len(newToOld))
+	for i := range newKeyed {
+		if _, exists := newToOld[i]; exists {
+			commonNew = append(commonNew, strconv.Itoa(i))
+		}
+	}
+	inOrder := make(map[int]bool)
+	for _, pair := range commonSubsequence(commonOld, commonNew) {
+		newIndex, _ := strconv.Atoi(commonNew[pair[1]])
+		inOrder[newIndex] = true
+	}
+
+	for i, leaf := range oldKeyed {
+		if _, exists := oldToNew[i]; !exists {
+			seg, _ := navigate.NodeSegment(leaf.node)
+			*changes = append(*changes, newChange(CHANGE_REMOVED, oldPath.Append(seg), leaf.node, nil))
+		}
+	}
+	for i, leaf := range newKeyed {
+		seg, _ := navigate.NodeSegment(leaf.node)
+		leafPath := newPath.Append(seg)
+		oldIndex, exists := newToOld[i]
+		if !exists {
+			*changes = append(*changes, newChange(CHANGE_ADDED, leafPath, nil, leaf.node))
+			continue
+		}
+		oldLeaf := oldKeyed[oldIndex].node
+		oldSeg, _ := navigate.NodeSegment(oldLeaf)
+		oldLeafPath := oldPath.Append(oldSeg)
+		if !inOrder[i] {
+			moved := newChange(CHANGE_MOVED, leafPath, oldLeaf, leaf.node)
+			moved.OldPath = oldLeafPath.String()
+			*changes = append(*changes, moved)
+		}
+		compareMatched(oldLeaf, leaf.node, oldLeafPath, leafPath, changes)
+	}
+
+	// Comments and blank lines that do not belong to directives are compared as a whole
+	oldComments, oldSpaces := describeUnkeyed(oldUnkeyed)
+	newComments, newSpaces := describeUnkeyed(newUnkeyed)
+	if oldComments != newComments {
+		change := Change{Kind: CHANGE_COMMENT, NodeType: NODE_COMMENT, Path: newPath.String(),
+			OldText: oldComments, NewText: newComments, OldNode: oldNode, NewNode: newNode}
+		*changes = append(*changes, change)
+	} else if oldSpaces != newSpaces {
+		change := Change{Kind: CHANGE_WHITESPACE, NodeType: NODE_COMMENT, Path: newPath.String(),
+			OldNode: oldNode, NewNode: newNode}
+		*changes = append(*changes, change)
+	}
+}
+
+// Compare two nodes that are paired with each other and place the differences in changes.
+func compareMatched(oldLeaf, newLeaf *lexer.DocumentNode, oldPath, newPath navigate.Path, changes *[]Change) {
+	switch oldEntity := oldLeaf.Entity.(type) {
+	case *lexer.Statement:
+		newEntity := newLeaf.Entity.(*lexer.Statement)
+		oldTexts, newTexts := navigate.StatementTexts(oldEntity), navigate.StatementTexts(newEntity)
+		if !navigate.EqualStrings(oldTexts, newTexts) {
+			*changes = append(*changes, newChange(CHANGE_MODIFIED, newPath, oldLeaf, newLeaf))
+		} else if oldEntity.VerbatimText() != newEntity.VerbatimText() {
+			*changes = append(*changes, newCosmeticChange(newPath, oldLeaf, newLeaf,
+				navigate.StatementComments(oldEntity), navigate.StatementComments(newEntity)))
+		}
+	case *lexer.Section:
+		newEntity := newLeaf.Entity.(*lexer.Section)
+		// Section key and arguments are identical, only look for differences in spaces and comments.
+		if sectionFrameText(oldEntity) != sectionFrameText(newEntity) {
+			oldComments := append(navigate.StatementComments(oldEntity.FirstStatement), navigate.StatementComments(oldEntity.FinalStatement)...)
+			newComments := append(navigate.StatementComments(newEntity.FirstStatement), navigate.StatementComments(newEntity.FinalStatement)...)
+			*changes = append(*changes, newCosmeticChange(newPath, oldLeaf, newLeaf, oldComments, newComments))
+		}
+		compareLeaves(oldLeaf, newLeaf, oldPath, newPath, changes)
+	}
+}
+
+// Return the verbatim text of section opening and closing, excluding the leaves.
+func sectionFrameText(sect *lexer.Section) string {
+	return (&lexer.DocumentNode{Entity: sect}).VerbatimText()
+}
+
+// Create a change that is about either comments or spaces.
+func newCosmeticChange(path navigate.Path, oldLeaf, newLeaf *lexer.DocumentNode, oldComments, newComments []string) Change {
+	kind := ChangeKind(CHANGE_WHITESPACE)
+	if !navigate.EqualStrings(oldComments, newComments) {
+		kind = CHANGE_COMMENT
+	}
+	change := newChange(kind, path, oldLeaf, newLeaf)
+	change.OldValues, change.NewValues = nil, nil
+	return change
+}
+
+// Create a change and fill in its node type, values, and text.
+func newChange(kind ChangeKind, path navigate.Path, oldLeaf, newLeaf *lexer.DocumentNode) Change {
+	change := Change{Kind: kind, NodeType: NODE_STATEMENT, Path: path.String(), OldNode: oldLeaf, NewNode: newLeaf}
+	if navigate.IsSection(oldLeaf) || navigate.IsSection(newLeaf) {
+		change.NodeType = NODE_SECTION
+	}
+	if oldLeaf != nil {
+		change.OldValues = nodeValues(oldLeaf)
+		change.OldText = oldLeaf.VerbatimText()
+	}
+	if newLeaf != nil {
+		change.NewValues = nodeValues(newLeaf)
+		change.NewText = newLeaf.VerbatimText()
+	}
+	return change
+}
+
+// Return words of a statement that follow its key, or arguments of a section.
+func nodeValues(node *lexer.DocumentNode) []string {
+	switch entity := node.Entity.(type) {
+	case *lexer.Statement:
+		if texts := navigate.StatementTexts(entity); len(texts) > 1 {
+			return texts[1:]
+		}
+	case *lexer.Section:
+		_, args, _ := navigate.NodeKey(node)
+		return args
+	}
+	return nil
+}
+
+// Return the comments and the spaces among the nodes, each joined into a string.
+func describeUnkeyed(nodes []*lexer.DocumentNode) (comments, spaces string) {
+	commentLines := make([]string, 0, len(nodes))
+	spaceLines := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if stmt, ok := node.Entity.(*lexer.Statement); ok {
+			if stmtComments := navigate.StatementComments(stmt); len(stmtComments) > 0 {
+				commentLines = append(commentLines, stmtComments...)
+			} else {
+				spaceLines = append(spaceLines, stmt.VerbatimText())
+			}
+		}
+	}
+	return strings.Join(commentLines, "\n"), strings.Join(spaceLines, "")
+}
+
+/*
+Return a fingerprint of node content for detecting moves. Fingerprint of a statement is made of its words,
+fingerprint of a section is made of its words and its leaves' fingerprints.
+*/
+func fingerprint(node *lexer.DocumentNode) string {
+	switch entity := node.Entity.(type) {
+	case *lexer.Statement:
+		return strings.Join(navigate.StatementTexts(entity), "\x00")
+	case *lexer.Section:
+		parts := []string{"section", strings.Join(navigate.StatementTexts(entity.FirstStatement), "\x00")}
+		for _, leaf := range node.Leaves {
+			if print := fingerprint(leaf); print != "" {
+				parts = append(parts, print)
+			}
+		}
+		return strings.Join(parts, "\x01")
+	}
+	return ""
+}
+
+// Pair removed nodes with added nodes of identical content, and turn each pair into a single move.
+func detectMoves(changes []Change) []Change {
+	pairedAdditions := make(map[int]bool)
+	for i, removed := range changes {
+		if removed.Kind != CHANGE_REMOVED {
+			continue
+		}
+		removedPrint := fingerprint(removed.OldNode)
+		for j, added := range changes {
+			if added.Kind != CHANGE_ADDED || pairedAdditions[j] || fingerprint(added.NewNode) != removedPrint {
+				continue
+			}
+			pairedAdditions[j] = true
+			changes[i].Kind = CHANGE_MOVED
+			changes[i].OldPath = removed.Path
+			changes[i].Path = added.Path
+			changes[i].NewNode = added.NewNode
+			changes[i].NewValues = added.NewValues
+			changes[i].NewText = added.NewText
+			break
+		}
+	}
+	ret := make([]Change, 0, len(changes))
+	for i, change := range changes {
+		if !pairedAdditions[i] {
+			ret = append(ret, change)
+		}
+	}
+	return ret
+}
+
+// Return the index pairs of the elements that make up the longest common subsequence of a and b, in their order.
+func commonSubsequence(a, b []string) [][2]int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	ret := make([][2]int, 0, lengths[0][0])
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		if a[i] == b[j] {
+			ret = append(ret, [2]int{i, j})
+			i++
+			j++
+		} else if lengths[i+1][j] >= lengths[i][j+1] {
+			i++
+		} else {
+			j++
+		}
+	}
+	return ret
+}
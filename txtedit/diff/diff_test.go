@@ -0,0 +1,111 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer/predef"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/navigate"
+)
+
+func lex(text string, config lexer.LexerConfig) *lexer.DocumentNode {
+	return lexer.NewLexer(text, &config, &lexer.LexerDebugNoop{}).Run()
+}
+
+var namedBefore = `# main configuration
+options {
+	directory "/var/lib/named";
+	notify yes;
+};
+zone "localhost" in {
+	type master;
+	file "localhost.zone";
+};
+zone "example.com" in {
+	type slave;
+};
+`
+
+var namedAfter = `# main configuration, edited
+options {
+	directory  "/var/lib/named";
+	notify no;
+	forward first;
+};
+zone "example.com" in {
+	type slave;
+};
+zone "localhost" in {
+	type master;
+	file "localhost.zone";
+};
+`
+
+func TestCompare(t *testing.T) {
+	changes := Compare(lex(namedBefore, predef.NamedConf), lex(namedAfter, predef.NamedConf))
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	// The leading comment is lexed into the opening statement of options section
+	expected := map[string]ChangeKind{
+		`options[]`:              CHANGE_COMMENT,
+		`options/directory`:      CHANGE_WHITESPACE,
+		`options/notify`:         CHANGE_MODIFIED,
+		`options/forward`:        CHANGE_ADDED,
+		`zone["localhost" "in"]`: CHANGE_MOVED,
+	}
+	if len(changes) != len(expected) {
+		t.Fatal(changes)
+	}
+	for _, change := range changes {
+		if expected[change.Path] != change.Kind {
+			t.Fatal(change)
+		}
+	}
+	if _, err := JSON(changes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompareMoveAcrossSections(t *testing.T) {
+	before := lex("[Unit]\nDescription=a\nAfter=network.target\n[Service]\nExecStart=/bin/a\n", predef.SystemdConf)
+	after := lex("[Unit]\nDescription=a\n[Service]\nExecStart=/bin/a\nAfter=network.target\n", predef.SystemdConf)
+	changes := Compare(before, after)
+	if len(changes) != 1 || changes[0].Kind != CHANGE_MOVED || changes[0].OldPath != "Unit/After" ||
+		changes[0].Path != "Service/After" {
+		t.Fatal(changes)
+	}
+	serialised, err := JSON(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report Report
+	if err := json.Unmarshal(serialised, &report); err != nil || report.Summary[CHANGE_MOVED] != 1 {
+		t.Fatal(err, string(serialised))
+	}
+}
+
+func TestCompareIdentical(t *testing.T) {
+	if changes := Compare(lex(namedBefore, predef.NamedConf), lex(namedBefore, predef.NamedConf)); len(changes) != 0 {
+		t.Fatal(changes)
+	}
+}
+
+func TestCompareLeadingInsert(t *testing.T) {
+	before := lex("LoadModule a\nLoadModule b\n", predef.HttpdConf)
+	after := lex("LoadModule z\nLoadModule a\nLoadModule b\n", predef.HttpdConf)
+	changes := Compare(before, after)
+	if len(changes) != 1 || changes[0].Kind != CHANGE_ADDED || changes[0].Path != "LoadModule" ||
+		!navigate.EqualStrings(changes[0].NewValues, []string{"z"}) {
+		t.Fatal(changes)
+	}
+	// The remaining statements of the key are paired by their order
+	after = lex("LoadModule c\nLoadModule a\nLoadModule d\n", predef.HttpdConf)
+	changes = Compare(before, after)
+	if len(changes) != 2 || changes[0].Kind != CHANGE_ADDED || changes[0].Path != "LoadModule" ||
+		changes[1].Kind != CHANGE_MODIFIED || changes[1].Path != "LoadModule#2" {
+		t.Fatal(changes)
+	}
+}
@@ -0,0 +1,440 @@
+package format
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/diff"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/navigate"
+)
+
+const (
+	BREAK_SPACING_KEEP   = 0 // leave the spaces around token break markers alone
+	BREAK_SPACING_NONE   = 1 // glue token break markers to the surrounding words, e.g. key=value
+	BREAK_SPACING_SINGLE = 2 // surround token break markers by a space, e.g. key = value
+)
+
+const (
+	KEEP_BLANK_LINES = 0  // leave the number of blank lines between sections alone
+	NO_BLANK_LINES   = -1 // remove the blank lines between sections
+)
+
+// Style describes how a document should be laid out. The zero value of each attribute leaves the layout alone.
+type Style struct {
+	IndentUnit                string // indentation per nesting depth of sections, e.g. "\t" or "    ".
+	AlignColumns              bool   // align the words of consecutive single-line statements into columns
+	ColumnGap                 int    // minimum number of spaces between aligned columns, 1 if not specified.
+	BreakMarkerSpacing        int    // spaces around token break markers, see BREAK_SPACING_*
+	BlankLinesBetweenSections int    // number of blank lines between a section and the section before it, or NO_BLANK_LINES.
+	TrimTrailingSpaces        bool   // remove spaces at the end of lines
+}
+
+var ErrMeaningChanged = errors.New("formatting would have changed the meaning of the document")
+
+/*
+Lay out the document according to the style and return the formatted document, the input document is not modified.
+Formatting only changes spaces, tabs, and blank lines. The formatted text is lexed again and compared against the input
+document, ErrMeaningChanged is returned if a statement, section, or comment turns out to be different.
+*/
+func Format(root *lexer.DocumentNode, config *lexer.LexerConfig, style Style) (*lexer.DocumentNode, error) {
+	doc := root.Clone()
+	navigate.SplitLeadingNewLines(doc)
+	if style.BreakMarkerSpacing != BREAK_SPACING_KEEP {
+		spaceBreakMarkers(doc, config.TokenBreakMarkers, style.BreakMarkerSpacing)
+	}
+	lines := splitLines(collectFragments(doc, 0))
+	if style.IndentUnit != "" {
+		indent(lines, style.IndentUnit)
+	}
+	if style.TrimTrailingSpaces {
+		trimTrailingSpaces(lines)
+	}
+	if style.AlignColumns {
+		gap := style.ColumnGap
+		if gap < 1 {
+			gap = 1
+		}
+		alignColumns(lines, gap)
+	}
+	if style.BlankLinesBetweenSections == NO_BLANK_LINES {
+		separateSections(lines, 0)
+	} else if style.BlankLinesBetweenSections != KEEP_BLANK_LINES {
+		separateSections(lines, style.BlankLinesBetweenSections)
+	}
+	configCopy := *config
+	formatted := lexer.NewLexer(doc.VerbatimText(), &configCopy, &lexer.LexerDebugNoop{}).Run()
+	for _, change := range diff.Compare(root, formatted) {
+		if change.Kind != diff.CHANGE_WHITESPACE {
+			return nil, ErrMeaningChanged
+		}
+	}
+	return formatted, nil
+}
+
+// Adjust the spaces around words that are token break markers.
+func spaceBreakMarkers(node *lexer.DocumentNode, markers []string, spacing int) {
+	if stmt, ok := node.Entity.(*lexer.Statement); ok && !hasContinuation(stmt) {
+		words := navigate.WordPieces(stmt)
+		for i := 1; i < len(words)-1; i++ {
+			if words[i].QuoteStyle != "" || !navigate.IsOneOf(words[i].Text, markers) {
+				continue
+			}
+			if spacing == BREAK_SPACING_NONE {
+				words[i-1].TrailingSpaces, words[i].TrailingSpaces = "", ""
+			} else {
+				words[i-1].TrailingSpaces, words[i].TrailingSpaces = " ", " "
+			}
+		}
+	}
+	for _, leaf := range node.Leaves {
+		spaceBreakMarkers(leaf, markers, spacing)
+	}
+}
+
+/*
+Fragment is a piece of text that makes up a line of the document. A fragment that holds spaces refers to the string in
+the document where the spaces are kept, so that they can be adjusted.
+*/
+type fragment struct {
+	ref     *string             // refers to the characters in the document, nil if the characters must not change
+	content string              // the characters if they must not change
+	slot    bool                // the characters are spaces or tabs that may be adjusted
+	depth   int                 // nesting depth of sections that contain the fragment
+	header  bool                // the fragment is the first word or marker of a section's opening
+	stmt    *lexer.Statement    // the statement that the fragment belongs to
+	word    *lexer.Text         // the text piece if the fragment is a word
+	comment bool                // the fragment is a comment
+	owner   *lexer.DocumentNode // the innermost section that the fragment belongs to, nil if at top level
+}
+
+func (frag *fragment) str() string {
+	if frag.ref != nil {
+		return *frag.ref
+	}
+	return frag.content
+}
+
+// Return true only if the fragment carries characters other than spaces, tabs, and new-lines.
+func (frag *fragment) hasContent() bool {
+	return strings.TrimSpace(frag.str()) != ""
+}
+
+// Walk the document in the order of verbatim text, and collect fragments of all entities.
+func collectFragments(node *lexer.DocumentNode, depth int) []*fragment {
+	frags := make([]*fragment, 0, 16)
+	leafDepth := depth
+	switch entity := node.Entity.(type) {
+	case *lexer.Statement:
+		frags = append(frags, statementFragments(entity, depth, false)...)
+	case *lexer.Section:
+		if !navigate.IsUnclosedSection(entity) {
+			leafDepth++
+		}
+		frags = append(frags, &fragment{ref: &entity.OpeningPrefix, depth: depth, header: entity.OpeningPrefix != ""})
+		frags = append(frags, statementFragments(entity.FirstStatement, depth, entity.OpeningPrefix == "")...)
+		frags = append(frags, &fragment{ref: &entity.OpeningSuffix, depth: depth})
+	}
+	for _, leaf := range node.Leaves {
+		frags = append(frags, collectFragments(leaf, leafDepth)...)
+	}
+	if sect, ok := node.Entity.(*lexer.Section); ok {
+		frags = append(frags, &fragment{ref: &sect.ClosingPrefix, depth: depth})
+		frags = append(frags, statementFragments(sect.FinalStatement, depth, false)...)
+		frags = append(frags, &fragment{ref: &sect.ClosingSuffix, depth: depth})
+		for _, frag := range frags {
+			if frag.owner == nil {
+				frag.owner = node
+			}
+		}
+	}
+	return frags
+}
+
+func statementFragments(stmt *lexer.Statement, depth int, header bool) []*fragment {
+	if stmt == nil {
+		return nil
+	}
+	frags := []*fragment{{ref: &stmt.Indent, slot: true, depth: depth, stmt: stmt}}
+	for _, piece := range stmt.Pieces {
+		switch thing := piece.(type) {
+		case *lexer.Text:
+			if thing.QuoteStyle == "" && strings.TrimSpace(thing.Text) == "" {
+				frags = append(frags, &fragment{ref: &thing.Text, depth: depth, stmt: stmt})
+			} else {
+				frags = append(frags, &fragment{content: thing.QuoteStyle + thing.Text + thing.QuoteStyle,
+					depth: depth, stmt: stmt, word: thing, header: header})
+				header = false
+			}
+			frags = append(frags, &fragment{ref: &thing.TrailingSpaces, slot: true, depth: depth, stmt: stmt})
+		case *lexer.Comment:
+			frags = append(frags, &fragment{content: thing.VerbatimText(), depth: depth, stmt: stmt, comment: true})
+		default:
+			frags = append(frags, &fragment{content: piece.VerbatimText(), depth: depth, stmt: stmt})
+		}
+	}
+	return append(frags, &fragment{ref: &stmt.Ending, depth: depth, stmt: stmt})
+}
+
+// A line is made of fragments, the last fragment ends with a new-line character unless it is the last line.
+type line []*fragment
+
+// Return index of the first fragment that carries content, or -1 if the line is blank.
+func (ln line) firstContent() int {
+	for i, frag := range ln {
+		if frag.hasContent() {
+			return i
+		}
+	}
+	return -1
+}
+
+// Return index of the last fragment that carries content, or -1 if the line is blank.
+func (ln line) lastContent() int {
+	for i := len(ln) - 1; i >= 0; i-- {
+		if ln[i].hasContent() {
+			return i
+		}
+	}
+	return -1
+}
+
+// Return true only if the line is made of nothing but comments.
+func (ln line) isComment() bool {
+	hasComment := false
+	for _, frag := range ln {
+		if frag.comment {
+			hasComment = true
+		} else if frag.hasContent() {
+			return false
+		}
+	}
+	return hasComment
+}
+
+// Return true only if one of the fragments is the beginning of a section.
+func (ln line) isHeader() bool {
+	for _, frag := range ln {
+		if frag.header {
+			return true
+		}
+	}
+	return false
+}
+
+func splitLines(frags []*fragment) []line {
+	lines := make([]line, 0, 16)
+	current := make(line, 0, 8)
+	for _, frag := range frags {
+		current = append(current, frag)
+		if strings.HasSuffix(frag.str(), "\n") {
+			lines = append(lines, current)
+			current = make(line, 0, 8)
+		}
+	}
+	if len(current) > 0 {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+/*
+Place the characters in front of the line's content: in the leading spaces if there are some, otherwise after the
+previous line's new-line character. Return false if there is no place for the characters.
+*/
+func insertAtLineStart(lines []line, index int, str string) bool {
+	for _, frag := range lines[index][:lines[index].firstContent()+1] {
+		if frag.slot {
+			*frag.ref = str + *frag.ref
+			return true
+		}
+	}
+	if index > 0 {
+		if terminator := lines[index-1][len(lines[index-1])-1]; terminator.ref != nil {
+			*terminator.ref += str
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Return true only if the line begins in the middle of a statement that spans multiple lines. A statement may also carry
+comments on the lines above its words, in which case the words do not continue the statement.
+*/
+func continuesStatement(lines []line, index int) bool {
+	if index == 0 {
+		return false
+	}
+	first := lines[index].firstContent()
+	prevLast := lines[index-1].lastContent()
+	return first != -1 && prevLast != -1 && lines[index][first].stmt != nil && !lines[index-1][prevLast].comment &&
+		lines[index][first].stmt == lines[index-1][prevLast].stmt
+}
+
+// Indent each line by its nesting depth. Lines that continue a statement keep their indentation.
+func indent(lines []line, unit string) {
+	for i, ln := range lines {
+		first := ln.firstContent()
+		if first == -1 || continuesStatement(lines, i) {
+			continue
+		}
+		placed := false
+		for _, frag := range ln[:first] {
+			if frag.slot {
+				if !placed {
+					*frag.ref = strings.Repeat(unit, ln[first].depth)
+					placed = true
+				} else {
+					*frag.ref = ""
+				}
+			}
+		}
+		if !placed && ln[first].depth > 0 {
+			insertAtLineStart(lines, i, strings.Repeat(unit, ln[first].depth))
+		}
+	}
+}
+
+// Remove the spaces that follow the last content of each line, and the spaces on blank lines.
+func trimTrailingSpaces(lines []line) {
+	for _, ln := range lines {
+		for _, frag := range ln[ln.lastContent()+1:] {
+			if frag.slot {
+				*frag.ref = ""
+			}
+		}
+	}
+}
+
+// Return the statement that makes up the entire line and is suitable for alignment, or nil if there is not one.
+func alignableStatement(lines []line, index int) *lexer.Statement {
+	ln := lines[index]
+	first := ln.firstContent()
+	if first == -1 || ln.isHeader() || ln.isComment() || continuesStatement(lines, index) {
+		return nil
+	}
+	stmt := ln[first].stmt
+	for _, frag := range ln {
+		if frag.stmt != stmt {
+			return nil
+		}
+	}
+	if stmt == nil || hasContinuation(stmt) || strings.Contains(stmt.VerbatimText(), "\n") &&
+		!strings.HasSuffix(strings.TrimRight(stmt.VerbatimText(), " \t"), "\n") {
+		return nil
+	}
+	words := navigate.WordPieces(stmt)
+	if len(words) < 2 {
+		return nil
+	}
+	for _, word := range words[:len(words)-1] {
+		if word.TrailingSpaces == "" {
+			// Glued by a token break marker
+			return nil
+		}
+	}
+	return stmt
+}
+
+// Align words of consecutive statements of the same depth into columns.
+func alignColumns(lines []line, gap int) {
+	for start := 0; start < len(lines); {
+		stmt := alignableStatement(lines, start)
+		if stmt == nil {
+			start++
+			continue
+		}
+		depth := lines[start][lines[start].firstContent()].depth
+		run := []*lexer.Statement{stmt}
+		end := start + 1
+		for ; end < len(lines); end++ {
+			next := alignableStatement(lines, end)
+			if next == nil || lines[end][lines[end].firstContent()].depth != depth {
+				break
+			}
+			run = append(run, next)
+		}
+		if len(run) > 1 {
+			widths := make([]int, 0, 4)
+			for _, stmt := range run {
+				words := navigate.WordPieces(stmt)
+				for i, word := range words[:len(words)-1] {
+					width := utf8.RuneCountInString(word.QuoteStyle + word.Text + word.QuoteStyle)
+					if i >= len(widths) {
+						widths = append(widths, width)
+					} else if width > widths[i] {
+						widths[i] = width
+					}
+				}
+			}
+			for _, stmt := range run {
+				words := navigate.WordPieces(stmt)
+				for i, word := range words[:len(words)-1] {
+					width := utf8.RuneCountInString(word.QuoteStyle + word.Text + word.QuoteStyle)
+					word.TrailingSpaces = strings.Repeat(" ", widths[i]-width+gap)
+				}
+			}
+		}
+		start = end
+	}
+}
+
+// Place the specified number of blank lines between a section and the section before it.
+func separateSections(lines []line, numBlankLines int) {
+	for i := 0; i < len(lines); i++ {
+		if !lines[i].isHeader() || continuesStatement(lines, i) {
+			continue
+		}
+		// Comments immediately above the section header belong to the section
+		blockStart := i
+		for blockStart > 0 && lines[blockStart-1].isComment() {
+			blockStart--
+		}
+		gapStart := blockStart
+		for gapStart > 0 && lines[gapStart-1].firstContent() == -1 {
+			gapStart--
+		}
+		if gapStart == 0 {
+			continue // the section is at the beginning of document
+		}
+		prev := lines[gapStart-1]
+		if !followsSection(prev[prev.lastContent()], lines[i][lines[i].firstContent()].owner) {
+			continue // only a section that comes after another section is separated
+		}
+		gap := blockStart - gapStart
+		for j := gapStart; j < blockStart && gap > numBlankLines; j++ {
+			if terminator := lines[j][len(lines[j])-1]; terminator.ref != nil {
+				for _, frag := range lines[j] {
+					if frag.ref != nil {
+						*frag.ref = ""
+					}
+				}
+				gap--
+			}
+		}
+		if gap < numBlankLines {
+			insertAtLineStart(lines, blockStart, strings.Repeat("\n", numBlankLines-gap))
+		}
+	}
+}
+
+// Return true only if the fragment belongs to a sibling section that comes before the section.
+func followsSection(prev *fragment, sect *lexer.DocumentNode) bool {
+	for node := prev.owner; node != nil; node = node.Parent {
+		if node.Parent == sect.Parent {
+			return node != sect
+		}
+	}
+	return false
+}
+
+func hasContinuation(stmt *lexer.Statement) bool {
+	for _, piece := range stmt.Pieces {
+		if _, ok := piece.(*lexer.StatementContinue); ok {
+			return true
+		}
+	}
+	return false
+}
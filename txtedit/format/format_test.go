@@ -0,0 +1,106 @@
+package format
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/diff"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer/predef"
+)
+
+var sampleTextLocation = os.Getenv("GOPATH") + "/src/github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer/predef/samples/"
+
+func lex(text string, config lexer.LexerConfig) *lexer.DocumentNode {
+	return lexer.NewLexer(text, &config, &lexer.LexerDebugNoop{}).Run()
+}
+
+func format(t *testing.T, text string, config lexer.LexerConfig, style Style) string {
+	formatted, err := Format(lex(text, config), &config, style)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return formatted.VerbatimText()
+}
+
+func TestFormatNested(t *testing.T) {
+	input := "options {\n  directory \"/var\";   \n      notify yes; # why\n\tlisten-on {\n1.2.3.4;\n\t};\n  };\n\n\n\nzone \"a\" {\ntype master;\n};\n// slave\nzone \"b\" {\ntype slave;\n};\n"
+	expected := "options {\n\tdirectory \"/var\";\n\tnotify yes; # why\n\tlisten-on {\n\t\t1.2.3.4;\n\t};\n};\n\nzone \"a\" {\n\ttype master;\n};\n\n// slave\nzone \"b\" {\n\ttype slave;\n};\n"
+	if text := format(t, input, predef.NamedConf, NamedConf); text != expected {
+		t.Fatal(text)
+	}
+	input = "<VirtualHost *:80>\nServerName a\n<Directory \"/x\">\n  Options None\n      </Directory>\n</VirtualHost>\n"
+	expected = "<VirtualHost *:80>\n    ServerName a\n    <Directory \"/x\">\n        Options None\n    </Directory>\n</VirtualHost>\n"
+	if text := format(t, input, predef.HttpdConf, HttpdConf); text != expected {
+		t.Fatal(text)
+	}
+}
+
+func TestFormatColumns(t *testing.T) {
+	input := "# domain type item value\n*\tsoft core 0\n@admin  hard\tnofile   8192\n\nroot - nproc unlimited\n"
+	expected := "# domain type item value\n*       soft  core    0\n@admin  hard  nofile  8192\n\nroot - nproc unlimited\n"
+	if text := format(t, input, predef.LimitsConf, LimitsConf); text != expected {
+		t.Fatal(text)
+	}
+	input = "net.ipv4.ip_forward=1\nkernel.panic   =  5\n"
+	expected = "net.ipv4.ip_forward = 1\nkernel.panic        = 5\n"
+	if text := format(t, input, predef.SysctlConf, SysctlConf); text != expected {
+		t.Fatal(text)
+	}
+	input = "# comment\n[Unit]\n  Description = a\n[Service]\nExecStart=/bin/a\n\n\n\n[Install]\nWantedBy=multi-user.target\n"
+	expected = "# comment\n[Unit]\nDescription=a\n\n[Service]\nExecStart=/bin/a\n\n[Install]\nWantedBy=multi-user.target\n"
+	if text := format(t, input, predef.SystemdConf, SystemdConf); text != expected {
+		t.Fatal(text)
+	}
+}
+
+func TestFormatZeroStyle(t *testing.T) {
+	// The zero value of style leaves the layout alone
+	input := "[Unit]\nA=b\n\n[Service]\n  B = c \n"
+	if text := format(t, input, predef.SystemdConf, Style{}); text != input {
+		t.Fatal(text)
+	}
+	if text := format(t, input, predef.SystemdConf, Style{BlankLinesBetweenSections: NO_BLANK_LINES}); text != "[Unit]\nA=b\n[Service]\n  B = c \n" {
+		t.Fatal(text)
+	}
+}
+
+func TestFormatSamples(t *testing.T) {
+	samples := []struct {
+		config   lexer.LexerConfig
+		style    Style
+		fileName string
+	}{
+		{predef.DhcpdConf, DhcpdConf, "dhcpd.conf"},
+		{predef.HttpdConf, HttpdConf, "httpd.conf"},
+		{predef.LimitsConf, LimitsConf, "limits.conf"},
+		{predef.LoginDefs, LoginDefs, "login.defs"},
+		{predef.NamedConf, NamedConf, "named.conf"},
+		{predef.PostfixMainCf, PostfixMainCf, "postfix-main.cf"},
+		{predef.SysctlConf, SysctlConf, "sysctl.conf"},
+		{predef.SystemdConf, SystemdConf, "systemd.conf"},
+	}
+	for _, sample := range samples {
+		content, err := ioutil.ReadFile(path.Join(sampleTextLocation, sample.fileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := lex(string(content), sample.config)
+		formatted, err := Format(original, &sample.config, sample.style)
+		if err != nil {
+			t.Fatal(sample.fileName, err)
+		}
+		for _, change := range diff.Compare(original, formatted) {
+			if change.IsSemantic() {
+				t.Fatal(sample.fileName, change)
+			}
+		}
+		// Formatting twice gives the same result
+		again, err := Format(formatted, &sample.config, sample.style)
+		if err != nil || again.VerbatimText() != formatted.VerbatimText() {
+			t.Fatal(sample.fileName, err)
+		}
+	}
+}
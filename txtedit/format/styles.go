@@ -0,0 +1,90 @@
+package format
+
+// The predefined styles are named after the predefined lexer configurations in package predef.
+
+var Sysconfig = Style{
+	BreakMarkerSpacing:        BREAK_SPACING_NONE,
+	BlankLinesBetweenSections: KEEP_BLANK_LINES,
+	TrimTrailingSpaces:        true,
+}
+
+var SysctlConf = Style{
+	AlignColumns:              true,
+	BreakMarkerSpacing:        BREAK_SPACING_SINGLE,
+	BlankLinesBetweenSections: KEEP_BLANK_LINES,
+	TrimTrailingSpaces:        true,
+}
+
+var SystemdConf = Style{
+	IndentUnit:                "\t", // sections do not nest, hence this removes indentation.
+	BreakMarkerSpacing:        BREAK_SPACING_NONE,
+	BlankLinesBetweenSections: 1,
+	TrimTrailingSpaces:        true,
+}
+
+var Crontab = Style{
+	BlankLinesBetweenSections: KEEP_BLANK_LINES,
+	TrimTrailingSpaces:        true,
+}
+
+var Hosts = Style{
+	AlignColumns:              true,
+	ColumnGap:                 1,
+	BlankLinesBetweenSections: KEEP_BLANK_LINES,
+	TrimTrailingSpaces:        true,
+}
+
+var LoginDefs = Style{
+	IndentUnit:                "\t",
+	AlignColumns:              true,
+	ColumnGap:                 2,
+	BlankLinesBetweenSections: KEEP_BLANK_LINES,
+	TrimTrailingSpaces:        true,
+}
+
+var Nsswitch = Style{
+	AlignColumns:              true,
+	BlankLinesBetweenSections: KEEP_BLANK_LINES,
+	TrimTrailingSpaces:        true,
+}
+
+var HttpdConf = Style{
+	IndentUnit:                "    ",
+	BlankLinesBetweenSections: 1,
+	TrimTrailingSpaces:        true,
+}
+
+var NamedConf = Style{
+	IndentUnit:                "\t",
+	BlankLinesBetweenSections: 1,
+	TrimTrailingSpaces:        true,
+}
+
+var NamedZone = Style{
+	BlankLinesBetweenSections: KEEP_BLANK_LINES,
+	TrimTrailingSpaces:        true,
+}
+
+var DhcpdConf = Style{
+	IndentUnit:                "  ",
+	BlankLinesBetweenSections: 1,
+	TrimTrailingSpaces:        true,
+}
+
+var NtpConf = Style{
+	BlankLinesBetweenSections: KEEP_BLANK_LINES,
+	TrimTrailingSpaces:        true,
+}
+
+var LimitsConf = Style{
+	AlignColumns:              true,
+	ColumnGap:                 2,
+	BlankLinesBetweenSections: KEEP_BLANK_LINES,
+	TrimTrailingSpaces:        true,
+}
+
+var PostfixMainCf = Style{
+	BreakMarkerSpacing:        BREAK_SPACING_SINGLE,
+	BlankLinesBetweenSections: KEEP_BLANK_LINES,
+	TrimTrailingSpaces:        true,
+}
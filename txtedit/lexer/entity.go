@@ -134,6 +134,30 @@ func (stmt *Statement) IndexOfTextSeq(skip int, seq []string, ignoreCase bool) (
 	return
 }
 
+// Return a deep copy of the statement and its pieces.
+func (stmt *Statement) Clone() *Statement {
+	if stmt == nil {
+		return nil
+	}
+	ret := &Statement{Indent: stmt.Indent, Ending: stmt.Ending, Pieces: make([]ContainVerbatimText, 0, len(stmt.Pieces))}
+	for _, piece := range stmt.Pieces {
+		switch thing := piece.(type) {
+		case *Text:
+			copied := *thing
+			ret.Pieces = append(ret.Pieces, &copied)
+		case *Comment:
+			copied := *thing
+			ret.Pieces = append(ret.Pieces, &copied)
+		case *StatementContinue:
+			copied := *thing
+			ret.Pieces = append(ret.Pieces, &copied)
+		default:
+			ret.Pieces = append(ret.Pieces, piece)
+		}
+	}
+	return ret
+}
+
 /*
 Section's opening and closing are determined by markers. Optionally, the markers surround statements.
 Section is an entity of DocumentNode. Section content such as statements and nested sections are
@@ -171,6 +195,14 @@ func (sect *Section) DebugInfo() string {
 		sect.ClosingPrefix, endStmtStr, sect.ClosingSuffix)
 }
 
+// Return a deep copy of the section, including its first and final statements.
+func (sect *Section) Clone() *Section {
+	ret := *sect
+	ret.FirstStatement = sect.FirstStatement.Clone()
+	ret.FinalStatement = sect.FinalStatement.Clone()
+	return &ret
+}
+
 /*
 DocumentNode contains a Section or Statement as its entity.
 Section node has leaf section/statement as its entity.
@@ -222,6 +254,25 @@ func (node *DocumentNode) VerbatimText() string {
 	return out.String()
 }
 
+// Return a deep copy of this node, its entity, and all of its leaves recursively. The copy does not have a parent.
+func (node *DocumentNode) Clone() *DocumentNode {
+	ret := &DocumentNode{Leaves: make([]*DocumentNode, 0, len(node.Leaves))}
+	switch entity := node.Entity.(type) {
+	case *Statement:
+		ret.Entity = entity.Clone()
+	case *Section:
+		ret.Entity = entity.Clone()
+	default:
+		ret.Entity = node.Entity
+	}
+	for _, leaf := range node.Leaves {
+		leafCopy := leaf.Clone()
+		leafCopy.Parent = ret
+		ret.Leaves = append(ret.Leaves, leafCopy)
+	}
+	return ret
+}
+
 /*
 If this node has leaves and the leaf is among them, return the index of the leaf.
 Otherwise, return -1.
@@ -285,7 +336,7 @@ func (node *DocumentNode) InsertAfterSelf(newNode *DocumentNode) bool {
 	// newLeaves = [leaves before and at i], newNode, [leaves after i]
 	copy(newLeaves, node.Parent.Leaves[:i+1])
 	newLeaves[i+1] = newNode
-	copy(newLeaves[i+2:], node.Parent.Leaves[i:+1])
+	copy(newLeaves[i+2:], node.Parent.Leaves[i+1:])
 	node.Parent.Leaves = newLeaves
 	newNode.Parent = node.Parent
 	return true
@@ -347,7 +398,7 @@ func (node *DocumentNode) InsertAfter(leaf *DocumentNode, newNode *DocumentNode)
 	// newLeaves = [leaves before and at i], newNode, [leaves after i]
 	copy(newLeaves, node.Leaves[:i+1])
 	newLeaves[i+1] = newNode
-	copy(newLeaves[i+2:], node.Leaves[i:+1])
+	copy(newLeaves[i+2:], node.Leaves[i+1:])
 	node.Leaves = newLeaves
 	newNode.Parent = node
 	return true
@@ -43,6 +43,14 @@ func TestManipulatingParentNode(t *testing.T) {
 	if len(base.Leaves) != 3 {
 		t.Fatal(base.Leaves)
 	}
+	// Insert in the middle
+	node05 := &DocumentNode{Entity: &Text{Text: "node0.5"}}
+	if !base.InsertAfter(node0, node05) {
+		t.Fatal("not done")
+	}
+	if i := base.FindLeafIndex(node05); i != 1 || base.FindLeafIndex(node2) != 3 {
+		t.Fatal(i)
+	}
 	fmt.Println(DebugNode(base, 0))
 }
 
@@ -84,3 +92,30 @@ func TestManipulatingNode(t *testing.T) {
 	}
 	fmt.Println(DebugNode(base, 0))
 }
+
+func TestClone(t *testing.T) {
+	config := &LexerConfig{
+		StatementEndingMarkers: []string{"\n"},
+		CommentStyles:          []CommentStyle{{Opening: "#", Closing: "\n"}},
+		TextQuoteStyle:         []string{"\""},
+		SectionStyle: SectionStyle{
+			OpeningPrefix: "<", OpeningSuffix: ">",
+			ClosingPrefix: "</", ClosingSuffix: ">",
+			OpenSectionWithAStatement: true, CloseSectionWithAStatement: true,
+		},
+	}
+	root := NewLexer(input, config, &LexerDebugNoop{}).Run()
+	clone := root.Clone()
+	if clone.VerbatimText() != input {
+		t.Fatal(clone.VerbatimText())
+	}
+	// Changing the clone must not affect the original
+	clone.Leaves[0].Entity.(*Section).FirstStatement.Pieces[0].(*Text).Text = "x"
+	clone.Leaves[0].Leaves[0].DeleteSelf()
+	if root.VerbatimText() != input || clone.VerbatimText() == input {
+		t.Fatal(root.VerbatimText(), clone.VerbatimText())
+	}
+	if clone.Leaves[0].Parent != clone {
+		t.Fatal("wrong parent")
+	}
+}
@@ -0,0 +1,182 @@
+package lexer
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Type tags of document entities in JSON encoding.
+const (
+	JSON_TEXT      = "text"
+	JSON_COMMENT   = "comment"
+	JSON_CONTINUE  = "continue"
+	JSON_STATEMENT = "statement"
+	JSON_SECTION   = "section"
+	JSON_DOCUMENT  = "document" // the root node, which does not have an entity
+)
+
+// jsonPiece is the JSON encoding of a statement piece, the type tag tells which attributes are relevant.
+type jsonPiece struct {
+	Type           string `json:"type"`
+	QuoteStyle     string `json:"quoteStyle,omitempty"`
+	Text           string `json:"text,omitempty"`
+	TrailingSpaces string `json:"trailingSpaces,omitempty"`
+	CommentOpening string `json:"commentOpening,omitempty"`
+	CommentClosing string `json:"commentClosing,omitempty"`
+	Closed         bool   `json:"closed,omitempty"`
+	Content        string `json:"content,omitempty"`
+	Style          string `json:"style,omitempty"`
+}
+
+type jsonStatement struct {
+	Indent string      `json:"indent"`
+	Pieces []jsonPiece `json:"pieces"`
+	Ending string      `json:"ending"`
+}
+
+type jsonSection struct {
+	FirstStatement            *Statement `json:"firstStatement"`
+	OpeningPrefix             string     `json:"openingPrefix"`
+	OpeningSuffix             string     `json:"openingSuffix"`
+	ClosingPrefix             string     `json:"closingPrefix"`
+	ClosingSuffix             string     `json:"closingSuffix"`
+	FinalStatement            *Statement `json:"finalStatement"`
+	StatementCounterAtOpening int        `json:"statementCounterAtOpening"`
+	MissingOpeningStatement   bool       `json:"missingOpeningStatement"`
+	StatementCounterAtClosing int        `json:"statementCounterAtClosing"`
+	MissingClosingStatement   bool       `json:"missingClosingStatement"`
+}
+
+type jsonNode struct {
+	Type      string          `json:"type"`
+	Statement *Statement      `json:"statement,omitempty"`
+	Section   *Section        `json:"section,omitempty"`
+	Leaves    []*DocumentNode `json:"leaves"`
+}
+
+func (stmt *Statement) MarshalJSON() ([]byte, error) {
+	encoded := jsonStatement{Indent: stmt.Indent, Pieces: make([]jsonPiece, 0, len(stmt.Pieces)), Ending: stmt.Ending}
+	for _, piece := range stmt.Pieces {
+		switch thing := piece.(type) {
+		case *Text:
+			encoded.Pieces = append(encoded.Pieces, jsonPiece{Type: JSON_TEXT, QuoteStyle: thing.QuoteStyle,
+				Text: thing.Text, TrailingSpaces: thing.TrailingSpaces})
+		case *Comment:
+			encoded.Pieces = append(encoded.Pieces, jsonPiece{Type: JSON_COMMENT, CommentOpening: thing.CommentStyle.Opening,
+				CommentClosing: thing.CommentStyle.Closing, Closed: thing.Closed, Content: thing.Content})
+		case *StatementContinue:
+			encoded.Pieces = append(encoded.Pieces, jsonPiece{Type: JSON_CONTINUE, Style: thing.Style})
+		default:
+			return nil, fmt.Errorf("statement piece of type %T cannot be encoded", piece)
+		}
+	}
+	return json.Marshal(encoded)
+}
+
+func (stmt *Statement) UnmarshalJSON(serialised []byte) error {
+	var decoded jsonStatement
+	if err := json.Unmarshal(serialised, &decoded); err != nil {
+		return err
+	}
+	stmt.Indent = decoded.Indent
+	stmt.Ending = decoded.Ending
+	stmt.Pieces = make([]ContainVerbatimText, 0, len(decoded.Pieces))
+	for _, piece := range decoded.Pieces {
+		switch piece.Type {
+		case JSON_TEXT:
+			stmt.Pieces = append(stmt.Pieces, &Text{QuoteStyle: piece.QuoteStyle, Text: piece.Text, TrailingSpaces: piece.TrailingSpaces})
+		case JSON_COMMENT:
+			stmt.Pieces = append(stmt.Pieces, &Comment{CommentStyle: CommentStyle{Opening: piece.CommentOpening,
+				Closing: piece.CommentClosing}, Closed: piece.Closed, Content: piece.Content})
+		case JSON_CONTINUE:
+			stmt.Pieces = append(stmt.Pieces, &StatementContinue{Style: piece.Style})
+		default:
+			return fmt.Errorf("unknown type of statement piece \"%s\"", piece.Type)
+		}
+	}
+	return nil
+}
+
+func (sect *Section) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonSection{
+		FirstStatement: sect.FirstStatement,
+		OpeningPrefix:  sect.OpeningPrefix, OpeningSuffix: sect.OpeningSuffix,
+		ClosingPrefix: sect.ClosingPrefix, ClosingSuffix: sect.ClosingSuffix,
+		FinalStatement:            sect.FinalStatement,
+		StatementCounterAtOpening: sect.StatementCounterAtOpening, MissingOpeningStatement: sect.MissingOpeningStatement,
+		StatementCounterAtClosing: sect.StatementCounterAtClosing, MissingClosingStatement: sect.MissingClosingStatement,
+	})
+}
+
+func (sect *Section) UnmarshalJSON(serialised []byte) error {
+	var decoded jsonSection
+	if err := json.Unmarshal(serialised, &decoded); err != nil {
+		return err
+	}
+	*sect = Section{
+		FirstStatement: decoded.FirstStatement,
+		OpeningPrefix:  decoded.OpeningPrefix, OpeningSuffix: decoded.OpeningSuffix,
+		ClosingPrefix: decoded.ClosingPrefix, ClosingSuffix: decoded.ClosingSuffix,
+		FinalStatement:            decoded.FinalStatement,
+		StatementCounterAtOpening: decoded.StatementCounterAtOpening, MissingOpeningStatement: decoded.MissingOpeningStatement,
+		StatementCounterAtClosing: decoded.StatementCounterAtClosing, MissingClosingStatement: decoded.MissingClosingStatement,
+	}
+	return nil
+}
+
+/*
+Encode the node, its entity, and all of its leaves recursively into JSON. Each node and statement piece carries a type
+tag, and every attribute is encoded, so that the decoded node reproduces the exact verbatim text.
+*/
+func (node *DocumentNode) MarshalJSON() ([]byte, error) {
+	encoded := jsonNode{Leaves: node.Leaves}
+	if encoded.Leaves == nil {
+		encoded.Leaves = make([]*DocumentNode, 0, 0)
+	}
+	switch entity := node.Entity.(type) {
+	case nil:
+		encoded.Type = JSON_DOCUMENT
+	case *Statement:
+		encoded.Type = JSON_STATEMENT
+		encoded.Statement = entity
+	case *Section:
+		encoded.Type = JSON_SECTION
+		encoded.Section = entity
+	default:
+		return nil, fmt.Errorf("node entity of type %T cannot be encoded", node.Entity)
+	}
+	return json.Marshal(encoded)
+}
+
+// Decode the node and its leaves from JSON, the leaves' parent is set to the node.
+func (node *DocumentNode) UnmarshalJSON(serialised []byte) error {
+	var decoded jsonNode
+	if err := json.Unmarshal(serialised, &decoded); err != nil {
+		return err
+	}
+	switch decoded.Type {
+	case JSON_DOCUMENT:
+		node.Entity = nil
+	case JSON_STATEMENT:
+		if decoded.Statement == nil {
+			return fmt.Errorf("statement node does not have a statement")
+		}
+		node.Entity = decoded.Statement
+	case JSON_SECTION:
+		if decoded.Section == nil {
+			return fmt.Errorf("section node does not have a section")
+		}
+		node.Entity = decoded.Section
+	default:
+		return fmt.Errorf("unknown type of node \"%s\"", decoded.Type)
+	}
+	node.Leaves = make([]*DocumentNode, 0, len(decoded.Leaves))
+	for _, leaf := range decoded.Leaves {
+		if leaf == nil {
+			return fmt.Errorf("a leaf node is null")
+		}
+		leaf.Parent = node
+		node.Leaves = append(node.Leaves, leaf)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package lexer
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var jsonInput = `<a x>
+b c /* d */ # e
+<f>
+'g'=h
+</f>
+</a>`
+
+func TestJSON(t *testing.T) {
+	root := NewLexer(jsonInput, &LexerConfig{
+		StatementContinuationMarkers: []string{"\\"},
+		StatementEndingMarkers:       []string{"\n"},
+		CommentStyles:                []CommentStyle{{Opening: "/*", Closing: "*/"}, {Opening: "#", Closing: "\n"}},
+		TextQuoteStyle:               []string{"\"", "'"},
+		TokenBreakMarkers:            []string{"="},
+		SectionStyle: SectionStyle{
+			OpeningPrefix: "<", OpeningSuffix: ">",
+			ClosingPrefix: "</", ClosingSuffix: ">",
+			OpenSectionWithAStatement: true, CloseSectionWithAStatement: true,
+		},
+	}, &LexerDebugNoop{}).Run()
+	// Continuation marker is placed by hand, because the lexer does not keep the new-line that follows it.
+	root.Leaves = append(root.Leaves, &DocumentNode{Parent: root, Leaves: make([]*DocumentNode, 0, 0), Entity: &Statement{
+		Pieces: []ContainVerbatimText{&Text{Text: "\ni", TrailingSpaces: " "}, &StatementContinue{Style: "\\"}, &Text{Text: "j"}}}})
+	expected := jsonInput + "\ni \\j"
+	serialised, err := json.Marshal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := new(DocumentNode)
+	if err := json.Unmarshal(serialised, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if text := decoded.VerbatimText(); text != expected {
+		t.Fatal(text)
+	}
+	if DebugNode(decoded, 0) != DebugNode(root, 0) {
+		t.Fatal(DebugNode(decoded, 0))
+	}
+	// Parent of each leaf is restored
+	sect := decoded.Leaves[0]
+	if sect.Parent != decoded || sect.Leaves[0].Parent != sect {
+		t.Fatal("wrong parent")
+	}
+	// Encoding the decoded node gives the same JSON
+	if again, err := json.Marshal(decoded); err != nil || string(again) != string(serialised) {
+		t.Fatal(err, string(again))
+	}
+	for _, malformed := range []string{`{"type":"x","leaves":[]}`, `{"type":"statement","leaves":[]}`,
+		`{"type":"document","leaves":[{"type":"statement","statement":{"pieces":[{"type":"x"}]}}]}`} {
+		if err := json.Unmarshal([]byte(malformed), new(DocumentNode)); err == nil {
+			t.Fatal("did not fail", malformed)
+		}
+	}
+}
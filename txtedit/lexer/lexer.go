@@ -25,8 +25,10 @@ type Lexer struct {
 // Initialise a new text lexer.
 func NewLexer(textInput string, config *LexerConfig, debugger LexerDebug) (ret *Lexer) {
 	ret = &Lexer{textInput: textInput, config: config, debug: debugger}
-	ret.thisNode = &DocumentNode{Parent: nil, Entity: nil, Leaves: make([]*DocumentNode, 0, 8)}
-	ret.rootNode = ret.thisNode
+	// The root node never holds an entity, document content begins in its first leaf.
+	ret.rootNode = &DocumentNode{Parent: nil, Entity: nil, Leaves: make([]*DocumentNode, 0, 8)}
+	ret.thisNode = ret.rootNode
+	ret.createLeaf()
 	ret.config.SectionStyle.SetSectionMatchMechanism()
 	ret.debug.Printfln("NewLexer: initialised with section match mechanism being %v", ret.config.SectionStyle.SectionMatchMechanism)
 	return
@@ -261,7 +263,7 @@ func (an *Lexer) saveSpaces(spaces string) {
 			an.debug.Printfln("saveSpaces: %d spaces go into last text piece %p", length, t)
 		case *StatementContinue:
 			an.createTextIfNil()
-			an.debug.Printfln("saveSpaces: %d spaces go into new text piece %p", an.contextText)
+			an.debug.Printfln("saveSpaces: %d spaces go into new text piece %p", length, an.contextText)
 			an.contextText.TrailingSpaces += spaces
 			an.endText()
 		case *Comment:
@@ -308,7 +310,7 @@ func (an *Lexer) saveSpaces(spaces string) {
 			length, an.contextStatement)
 		an.contextStatement.Indent += spaces
 	} else {
-		an.debug.Printfln("saveSpaces: %d spaces have nowhere to go")
+		an.debug.Printfln("saveSpaces: %d spaces have nowhere to go", length)
 	}
 }
 
@@ -756,7 +758,7 @@ func (an *Lexer) setQuote(quoteStyle string) {
 			an.debug.Printfln("setQuote: finish quoting in text %p", an.contextText)
 			an.endText()
 		} else {
-			an.debug.Printfln("setQuote: quote '%s' goes into context text %p", an.contextText)
+			an.debug.Printfln("setQuote: quote '%s' goes into context text %p", quoteStyle, an.contextText)
 			an.saveMissedCharacters()
 			an.contextText.Text += quoteStyle
 		}
@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -132,3 +133,35 @@ func TestLexer4(t *testing.T) {
 		t.Fatal("no match")
 	}
 }
+
+// Keep the debug messages of a lexer.
+type lexerDebugRecorder []string
+
+func (rec *lexerDebugRecorder) Printfln(format string, msg ...interface{}) {
+	*rec = append(*rec, fmt.Sprintf(format, msg...))
+}
+
+func TestLexerRootNode(t *testing.T) {
+	config := LexerConfig{
+		StatementContinuationMarkers: []string{"\\"},
+		StatementEndingMarkers:       []string{"\n", ";"},
+		CommentStyles:                []CommentStyle{{Opening: "#", Closing: "\n"}},
+		TextQuoteStyle:               []string{"\"", "'"},
+		SectionStyle:                 SectionStyle{OpeningSuffix: "{", ClosingSuffix: "};", OpenSectionWithAStatement: true},
+	}
+	for _, text := range []string{"a b\n", "  a b\n", "a {\n\tb;\n};\n", "a \\  b", "a \"it's\"\n"} {
+		var messages lexerDebugRecorder
+		configCopy := config
+		root := NewLexer(text, &configCopy, &messages).Run()
+		// Even a document of a single statement is held by a leaf, so that the root node never holds an entity
+		if root.Entity != nil || len(root.Leaves) == 0 || root.VerbatimText() != text {
+			t.Fatal(text, DebugNode(root, 0))
+		}
+		// Debug messages carry all of their arguments
+		for _, message := range messages {
+			if strings.Contains(message, "%!") {
+				t.Fatal(text, message)
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package predef
+
+import (
+	"path"
+	"strings"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+)
+
+// The predefined configurations by the name of their format.
+var Formats = map[string]*lexer.LexerConfig{
+	"sysconfig":    &Sysconfig,
+	"sysctl":       &SysctlConf,
+	"systemd":      &SystemdConf,
+	"cron-allow":   &CronAllow,
+	"crontab":      &Crontab,
+	"hosts":        &Hosts,
+	"login-defs":   &LoginDefs,
+	"nsswitch":     &Nsswitch,
+	"httpd":        &HttpdConf,
+	"named":        &NamedConf,
+	"named-zone":   &NamedZone,
+	"dhcpd":        &DhcpdConf,
+	"ntp":          &NtpConf,
+	"limits":       &LimitsConf,
+	"postfix-main": &PostfixMainCf,
+}
+
+/*
+Shell patterns of the files written in each predefined format. A pattern that contains a slash is matched against the
+whole file path, other patterns are matched against the file name alone. The first match wins.
+*/
+var FilePatterns = []struct {
+	Pattern string
+	Format  string // key of the format in Formats
+}{
+	{"/etc/sysconfig/*", "sysconfig"},
+	{"/etc/sysconfig/*/*", "sysconfig"},
+	{"/etc/default/*", "sysconfig"},
+	{"sysctl.conf", "sysctl"},
+	{"/etc/sysctl.d/*.conf", "sysctl"},
+	{"/usr/lib/sysctl.d/*.conf", "sysctl"},
+	{"/etc/systemd/*.conf", "systemd"},
+	{"*.service", "systemd"},
+	{"*.socket", "systemd"},
+	{"*.timer", "systemd"},
+	{"*.target", "systemd"},
+	{"*.mount", "systemd"},
+	{"*.automount", "systemd"},
+	{"*.path", "systemd"},
+	{"*.slice", "systemd"},
+	{"*.swap", "systemd"},
+	{"*.network", "systemd"},
+	{"*.netdev", "systemd"},
+	{"*.link", "systemd"},
+	{"cron.allow", "cron-allow"},
+	{"cron.deny", "cron-allow"},
+	{"at.allow", "cron-allow"},
+	{"at.deny", "cron-allow"},
+	{"crontab", "crontab"},
+	{"/etc/cron.d/*", "crontab"},
+	{"/var/spool/cron/*", "crontab"},
+	{"/var/spool/cron/tabs/*", "crontab"},
+	{"hosts", "hosts"},
+	{"login.defs", "login-defs"},
+	{"nsswitch.conf", "nsswitch"},
+	{"httpd.conf", "httpd"},
+	{"apache2.conf", "httpd"},
+	{"/etc/apache2/*.conf", "httpd"},
+	{"/etc/apache2/*/*.conf", "httpd"},
+	{"/etc/httpd/*/*.conf", "httpd"},
+	{"named.conf", "named"},
+	{"named.conf.*", "named"},
+	{"*.zones", "named"},
+	{"*.zone", "named-zone"},
+	{"db.*", "named-zone"},
+	{"/var/named/*", "named-zone"},
+	{"/var/lib/named/*", "named-zone"},
+	{"dhcpd.conf", "dhcpd"},
+	{"dhcpd6.conf", "dhcpd"},
+	{"ntp.conf", "ntp"},
+	{"limits.conf", "limits"},
+	{"/etc/security/limits.d/*.conf", "limits"},
+	{"main.cf", "postfix-main"},
+}
+
+// Return the name of the format that the file is written in, or found is false if the file is not recognised.
+func FormatForPath(filePath string) (format string, found bool) {
+	filePath = path.Clean(filePath)
+	for _, candidate := range FilePatterns {
+		subject := path.Base(filePath)
+		if strings.Contains(candidate.Pattern, "/") {
+			subject = filePath
+		}
+		if matched, _ := path.Match(candidate.Pattern, subject); matched {
+			return candidate.Format, true
+		}
+	}
+	return
+}
+
+// Return a copy of the predefined configuration that lexes the file, or found is false if the file is not recognised.
+func ConfigForPath(filePath string) (config lexer.LexerConfig, found bool) {
+	format, found := FormatForPath(filePath)
+	if found {
+		config = *Formats[format]
+	}
+	return
+}
@@ -1,6 +1,7 @@
 package predef
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
 	"io/ioutil"
@@ -81,3 +82,40 @@ func TestTextBreakdown(t *testing.T) {
 		}
 	}
 }
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, sample := range samples {
+		txtInput, err := ioutil.ReadFile(path.Join(sampleTextLocation + sample.fileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootNode := lexer.NewLexer(string(txtInput), &sample.config, &lexer.LexerDebugNoop{}).Run()
+		serialised, err := json.Marshal(rootNode)
+		if err != nil {
+			t.Fatal(sample.fileName, err)
+		}
+		decoded := new(lexer.DocumentNode)
+		if err := json.Unmarshal(serialised, decoded); err != nil {
+			t.Fatal(sample.fileName, err)
+		}
+		if decoded.VerbatimText() != rootNode.VerbatimText() {
+			t.Fatal("Mismatch in file", sample.fileName)
+		}
+	}
+}
+
+func TestConfigForPath(t *testing.T) {
+	if config, found := ConfigForPath("/etc/named.conf"); !found || config.SectionStyle.OpeningSuffix != NamedConf.SectionStyle.OpeningSuffix {
+		t.Fatal(config, found)
+	}
+	if config, found := ConfigForPath("/etc/sysconfig/network/config"); !found || len(config.TokenBreakMarkers) != 1 ||
+		config.TextQuoteStyle[0] != "\"" {
+		t.Fatal(config, found)
+	}
+	if config, found := ConfigForPath("/usr/lib/systemd/system/sshd.service"); !found || config.SectionStyle.OpeningPrefix != "[" {
+		t.Fatal(config, found)
+	}
+	if _, found := ConfigForPath("/etc/motd"); found {
+		t.Fatal("should not have found")
+	}
+}
@@ -0,0 +1,462 @@
+package lsp
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/navigate"
+)
+
+const DIAGNOSTIC_SOURCE = "lmc" // the source of diagnostics published by the server
+
+// Keys of statements that include other files, compared in lower case.
+var IncludeKeys = []string{"include", "includeoptional", "$include"}
+
+// The offsets (in bytes) of a node's characters in the document text.
+type nodeOffsets struct {
+	start, end       int   // all characters of the node, including the comments that are stored in the node
+	keyStart, keyEnd int   // the key, or the opening markers of a section that does not have a key
+	hasKey           bool  // false if the node is a statement made of only comments or spaces
+	closingStart     int   // the closing markers of a section, or the end of an unclosed section.
+	contentEnd       int   // the end of the last character that is not a space
+	unclosedComments []int // comments that lack their closing marker
+}
+
+// document is a text document opened by the client, along with the outcome of lexing its text.
+type document struct {
+	uri        string
+	filePath   string // empty if the URI does not refer to a local file
+	text       string
+	config     *lexer.LexerConfig // nil if the format of the document is unknown
+	root       *lexer.DocumentNode
+	lexErr     error
+	lineStarts []int
+	offsets    map[*lexer.DocumentNode]*nodeOffsets
+}
+
+// Lex the text of the document and calculate the offsets of its nodes.
+func newDocument(uri, text string, config *lexer.LexerConfig) *document {
+	doc := &document{uri: uri, filePath: uriToPath(uri), text: text, config: config, lineStarts: []int{0},
+		offsets: make(map[*lexer.DocumentNode]*nodeOffsets)}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			doc.lineStarts = append(doc.lineStarts, i+1)
+		}
+	}
+	if config == nil {
+		return doc
+	}
+	doc.root, doc.lexErr = lex(text, config)
+	if doc.root != nil {
+		doc.measure(doc.root, 0)
+	}
+	return doc
+}
+
+// Lex the text, the lexer may panic on certain malformed documents, which is turned into an error.
+func lex(text string, config *lexer.LexerConfig) (root *lexer.DocumentNode, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			root, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	return lexer.NewLexer(text, config, &lexer.LexerDebugNoop{}).Run(), nil
+}
+
+// Calculate offsets of the node and its leaves, given the offset of the node's first character. Return the node's end.
+func (doc *document) measure(node *lexer.DocumentNode, offset int) int {
+	offsets := &nodeOffsets{start: offset}
+	doc.offsets[node] = offsets
+	sect, isSection := node.Entity.(*lexer.Section)
+	if isSection {
+		offset += len(sect.OpeningPrefix)
+		offset = doc.measureStatement(sect.FirstStatement, offset, offsets)
+		if !offsets.hasKey {
+			offsets.keyStart, offsets.keyEnd, offsets.hasKey = offsets.start, offset+len(sect.OpeningSuffix), true
+		}
+		offset += len(sect.OpeningSuffix)
+	} else if stmt, isStatement := node.Entity.(*lexer.Statement); isStatement {
+		offset = doc.measureStatement(stmt, offset, offsets)
+	}
+	for _, leaf := range node.Leaves {
+		offset = doc.measure(leaf, offset)
+	}
+	offsets.closingStart = offset
+	if isSection {
+		offset += len(sect.ClosingPrefix)
+		if sect.FinalStatement != nil {
+			offset += len(sect.FinalStatement.VerbatimText())
+		}
+		offset += len(sect.ClosingSuffix)
+	}
+	offsets.end = offset
+	offsets.contentEnd = offsets.start + len(strings.TrimRightFunc(doc.text[offsets.start:offset], unicode.IsSpace))
+	return offset
+}
+
+// Calculate offsets of the statement's key and its unclosed comments. Return offset of the statement's end.
+func (doc *document) measureStatement(stmt *lexer.Statement, offset int, offsets *nodeOffsets) int {
+	if stmt == nil {
+		return offset
+	}
+	offset += len(stmt.Indent)
+	for _, piece := range stmt.Pieces {
+		switch thing := piece.(type) {
+		case *lexer.Text:
+			if trimmed := strings.TrimSpace(thing.Text); !offsets.hasKey && (trimmed != "" || thing.QuoteStyle != "") {
+				leading := len(thing.Text) - len(strings.TrimLeftFunc(thing.Text, unicode.IsSpace))
+				if thing.QuoteStyle != "" {
+					leading, trimmed = 0, thing.QuoteStyle+thing.Text+thing.QuoteStyle
+				}
+				offsets.keyStart, offsets.keyEnd, offsets.hasKey = offset+leading, offset+leading+len(trimmed), true
+			}
+		case *lexer.Comment:
+			if thing.CommentStyle.Closing != "" && !thing.Closed {
+				offsets.unclosedComments = append(offsets.unclosedComments, offset)
+			}
+		}
+		offset += len(piece.VerbatimText())
+	}
+	return offset + len(stmt.Ending)
+}
+
+// Return the position of the byte offset in the document, the character offset is counted in UTF-16 code units.
+func (doc *document) position(offset int) Position {
+	if offset > len(doc.text) {
+		offset = len(doc.text)
+	}
+	line := sort.Search(len(doc.lineStarts), func(i int) bool { return doc.lineStarts[i] > offset }) - 1
+	return Position{Line: line, Character: len(utf16.Encode([]rune(doc.text[doc.lineStarts[line]:offset])))}
+}
+
+func (doc *document) rangeOf(start, end int) Range {
+	return Range{Start: doc.position(start), End: doc.position(end)}
+}
+
+// Return the byte offset of the position in the document.
+func (doc *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	} else if pos.Line >= len(doc.lineStarts) {
+		return len(doc.text)
+	}
+	offset := doc.lineStarts[pos.Line]
+	for units := 0; units < pos.Character && offset < len(doc.text) && doc.text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(doc.text[offset:])
+		units += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset
+}
+
+// Return the offset of the end of the line on which the offset is.
+func (doc *document) lineEnd(offset int) int {
+	if end := strings.IndexByte(doc.text[offset:], '\n'); end != -1 {
+		return offset + end
+	}
+	return len(doc.text)
+}
+
+// Return the offset of the first character from the offset on that is not a space.
+func (doc *document) skipSpaces(offset int) int {
+	return offset + len(doc.text[offset:]) - len(strings.TrimLeftFunc(doc.text[offset:], unicode.IsSpace))
+}
+
+// Return the nodes that carry a section or statement, in document order.
+func (doc *document) nodes() []*lexer.DocumentNode {
+	ret := make([]*lexer.DocumentNode, 0, 64)
+	var collect func(node *lexer.DocumentNode)
+	collect = func(node *lexer.DocumentNode) {
+		if node.Entity != nil {
+			ret = append(ret, node)
+		}
+		for _, leaf := range node.Leaves {
+			collect(leaf)
+		}
+	}
+	if doc.root != nil {
+		collect(doc.root)
+	}
+	return ret
+}
+
+// Return true only if the section style requires sections to be closed by markers.
+func (doc *document) sectionsAreClosed() bool {
+	mechanism := doc.config.SectionStyle.SectionMatchMechanism
+	return mechanism == lexer.SECTION_MATCH_NESTED_DOUBLE_ANCHOR || mechanism == lexer.SECTION_MATCH_NESTED_QUAD_ANCHOR
+}
+
+// Return the name of the section made of the words of its opening statement, e.g. "VirtualHost *:80".
+func sectionName(sect *lexer.Section) string {
+	if sect.FirstStatement == nil {
+		return ""
+	}
+	words := make([]string, 0, 4)
+	for _, piece := range sect.FirstStatement.Pieces {
+		if text, isText := piece.(*lexer.Text); isText {
+			words = append(words, text.VerbatimText())
+		}
+	}
+	return strings.Join(strings.Fields(strings.Join(words, "")), " ")
+}
+
+/*
+Find problems in the document: text that the lexer cannot reproduce (usually caused by a quotation mark that is not
+closed), sections and comments that are not closed, and sections closed by a different name than their opening.
+*/
+func (doc *document) diagnostics() []Diagnostic {
+	ret := make([]Diagnostic, 0, 0)
+	add := func(start, end int, message string) {
+		ret = append(ret, Diagnostic{Range: doc.rangeOf(start, end), Severity: SEVERITY_ERROR, Source: DIAGNOSTIC_SOURCE, Message: message})
+	}
+	if doc.config == nil {
+		return ret
+	} else if doc.lexErr != nil {
+		add(0, doc.lineEnd(0), "the document cannot be lexed: "+doc.lexErr.Error())
+		return ret
+	}
+	if verbatim := doc.root.VerbatimText(); verbatim != doc.text {
+		mismatch := 0
+		for mismatch < len(verbatim) && mismatch < len(doc.text) && verbatim[mismatch] == doc.text[mismatch] {
+			mismatch++
+		}
+		add(mismatch, doc.lineEnd(mismatch), "the text from here on is not understood, is a quotation mark left open?")
+	}
+	for _, node := range doc.nodes() {
+		offsets := doc.offsets[node]
+		for _, comment := range offsets.unclosedComments {
+			add(comment, doc.lineEnd(comment), "the comment is not closed")
+		}
+		sect, isSection := node.Entity.(*lexer.Section)
+		if !isSection {
+			continue
+		}
+		name := sectionName(sect)
+		if sect.MissingOpeningStatement {
+			add(offsets.keyStart, offsets.keyEnd, "the section does not have a name")
+		}
+		if doc.sectionsAreClosed() && sect.ClosingSuffix == "" {
+			add(offsets.keyStart, offsets.keyEnd, fmt.Sprintf("section \"%s\" is not closed", name))
+		} else if sect.FinalStatement != nil {
+			key, _, _ := navigate.NodeKey(node)
+			closingTexts := navigate.StatementTexts(sect.FinalStatement)
+			if len(closingTexts) == 0 || !strings.EqualFold(closingTexts[0], key) {
+				add(offsets.closingStart, offsets.end, fmt.Sprintf("section \"%s\" is closed by \"%s\"", name,
+					strings.Join(closingTexts, " ")))
+			}
+		}
+	}
+	return ret
+}
+
+// Return the sections as symbols, sections nested in a section are the children of its symbol.
+func (doc *document) symbols(node *lexer.DocumentNode) []DocumentSymbol {
+	ret := make([]DocumentSymbol, 0, 0)
+	if node == nil {
+		return ret
+	}
+	for _, leaf := range node.Leaves {
+		sect, isSection := leaf.Entity.(*lexer.Section)
+		if !isSection {
+			continue
+		}
+		offsets := doc.offsets[leaf]
+		symbol := DocumentSymbol{
+			Name:           sectionName(sect),
+			Detail:         "section",
+			Kind:           SYMBOL_KIND_NAMESPACE,
+			Range:          doc.rangeOf(doc.skipSpaces(offsets.start), offsets.contentEnd),
+			SelectionRange: doc.rangeOf(offsets.keyStart, offsets.keyEnd),
+			Children:       doc.symbols(leaf),
+		}
+		if symbol.Name == "" {
+			symbol.Name = "(unnamed)"
+		}
+		ret = append(ret, symbol)
+	}
+	return ret
+}
+
+/*
+Return the folding ranges of sections and of comment blocks that span several lines. The closing line of a section
+remains visible when the section is folded.
+*/
+func (doc *document) foldingRanges() []FoldingRange {
+	ret := make([]FoldingRange, 0, 0)
+	for _, node := range doc.nodes() {
+		offsets := doc.offsets[node]
+		switch entity := node.Entity.(type) {
+		case *lexer.Section:
+			startLine := doc.position(offsets.keyStart).Line
+			endLine := doc.position(offsets.contentEnd).Line
+			if !navigate.IsUnclosedSection(entity) {
+				endLine = doc.position(offsets.closingStart).Line - 1
+			}
+			if endLine > startLine {
+				ret = append(ret, FoldingRange{StartLine: startLine, EndLine: endLine, Kind: FOLDING_KIND_REGION})
+			}
+		case *lexer.Statement:
+			if comments := commentBlock(node); len(comments) > 1 {
+				start := doc.skipSpaces(doc.offsets[node].start)
+				end := doc.lastCommentEnd(comments[len(comments)-1])
+				if startLine, endLine := doc.position(start).Line, doc.position(end).Line; endLine > startLine {
+					ret = append(ret, FoldingRange{StartLine: startLine, EndLine: endLine, Kind: FOLDING_KIND_COMMENT})
+				}
+			}
+		}
+	}
+	return ret
+}
+
+/*
+Return the comment statements in a row that begin with the node, in document order. Nothing is returned if the node
+is not a comment statement, or if it continues the comment statement in front of it.
+*/
+func commentBlock(node *lexer.DocumentNode) []*lexer.DocumentNode {
+	if node.Parent == nil {
+		return nil
+	}
+	stmt, _ := node.Entity.(*lexer.Statement)
+	if len(navigate.StatementComments(stmt)) == 0 || len(navigate.StatementTexts(stmt)) > 0 {
+		return nil
+	}
+	if index := node.GetMyLeafIndex(); index > 0 {
+		if prev, isStmt := node.Parent.Leaves[index-1].Entity.(*lexer.Statement); isStmt &&
+			len(navigate.StatementTexts(prev)) == 0 && len(navigate.StatementComments(prev)) > 0 {
+			return nil
+		}
+	}
+	ret := []*lexer.DocumentNode{node}
+	for i := node.GetMyLeafIndex() + 1; i < len(node.Parent.Leaves); i++ {
+		next, isStmt := node.Parent.Leaves[i].Entity.(*lexer.Statement)
+		if !isStmt || len(navigate.StatementTexts(next)) > 0 || len(navigate.StatementComments(next)) == 0 {
+			break
+		}
+		ret = append(ret, node.Parent.Leaves[i])
+	}
+	return ret
+}
+
+// Return the offset of the end of the last comment in the statement node.
+func (doc *document) lastCommentEnd(node *lexer.DocumentNode) int {
+	stmt := node.Entity.(*lexer.Statement)
+	offset := doc.offsets[node].start + len(stmt.Indent)
+	end := offset
+	for _, piece := range stmt.Pieces {
+		offset += len(piece.VerbatimText())
+		if _, isComment := piece.(*lexer.Comment); isComment {
+			end = offset
+		}
+	}
+	return doc.offsets[node].start + len(strings.TrimRightFunc(doc.text[doc.offsets[node].start:end], unicode.IsSpace))
+}
+
+// Return the deepest statement or section that has a key on the line at the offset.
+func (doc *document) nodeAt(offset int) *lexer.DocumentNode {
+	var found *lexer.DocumentNode
+	line := doc.position(offset).Line
+	for _, node := range doc.nodes() {
+		if offsets := doc.offsets[node]; offsets.hasKey && doc.position(offsets.keyStart).Line == line {
+			found = node
+		}
+	}
+	return found
+}
+
+// Return the hover text of the node at the offset, which is made of the comments that lead and trail the node.
+func (doc *document) hover(offset int) *Hover {
+	node := doc.nodeAt(offset)
+	if node == nil {
+		return nil
+	}
+	comments := make([]string, 0, 4)
+	for _, leading := range navigate.LeadingComments(node) {
+		comments = append(comments, navigate.StatementComments(leading.Entity.(*lexer.Statement))...)
+	}
+	switch entity := node.Entity.(type) {
+	case *lexer.Statement:
+		comments = append(comments, navigate.StatementComments(entity)...)
+	case *lexer.Section:
+		comments = append(comments, navigate.StatementComments(entity.FirstStatement)...)
+	}
+	for _, trailing := range navigate.TrailingComments(node) {
+		comments = append(comments, navigate.StatementComments(trailing.Entity.(*lexer.Statement))...)
+	}
+	if len(comments) == 0 {
+		return nil
+	}
+	offsets := doc.offsets[node]
+	keyRange := doc.rangeOf(offsets.keyStart, offsets.keyEnd)
+	return &Hover{Contents: MarkupContent{Kind: "plaintext", Value: strings.Join(comments, "\n")}, Range: &keyRange}
+}
+
+/*
+Return the locations of the files included by the statement at the offset. A relative path is resolved against the
+directory of the document, and then against the server root if the document specifies one (e.g. httpd "ServerRoot").
+Paths may contain shell patterns, every file that matches is a location.
+*/
+func (doc *document) definition(offset int) []Location {
+	ret := make([]Location, 0, 0)
+	node := doc.nodeAt(offset)
+	if node == nil || doc.filePath == "" {
+		return ret
+	}
+	stmt, isStatement := node.Entity.(*lexer.Statement)
+	if !isStatement {
+		return ret
+	}
+	texts := navigate.StatementTexts(stmt)
+	if len(texts) < 2 || !IsIncludeKey(texts[0]) {
+		return ret
+	}
+	candidates := []string{texts[len(texts)-1]}
+	if !filepath.IsAbs(candidates[0]) {
+		relative := candidates[0]
+		candidates = []string{filepath.Join(filepath.Dir(doc.filePath), relative)}
+		if serverRoot := ServerRoot(doc.root); serverRoot != "" {
+			candidates = append(candidates, filepath.Join(serverRoot, relative))
+		}
+	}
+	for _, candidate := range candidates {
+		matches, _ := filepath.Glob(candidate)
+		sort.Strings(matches)
+		for _, match := range matches {
+			ret = append(ret, Location{URI: pathToURI(match)})
+		}
+		if len(matches) > 0 {
+			break
+		}
+	}
+	return ret
+}
+
+// Return true only if the statement key includes other files, see IncludeKeys.
+func IsIncludeKey(key string) bool {
+	for _, includeKey := range IncludeKeys {
+		if strings.ToLower(key) == includeKey {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Return the value of the top level "ServerRoot" statement of the document, or an empty string if there is none. Relative
+paths of included files are resolved against it.
+*/
+func ServerRoot(root *lexer.DocumentNode) string {
+	for _, leaf := range root.Leaves {
+		if stmt, isStatement := leaf.Entity.(*lexer.Statement); isStatement {
+			if texts := navigate.StatementTexts(stmt); len(texts) == 2 && strings.EqualFold(texts[0], "ServerRoot") {
+				return texts[1]
+			}
+		}
+	}
+	return ""
+}
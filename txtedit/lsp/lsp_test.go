@@ -0,0 +1,199 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer/predef"
+)
+
+var namedConf = `options {
+	// where zones live
+	directory "/var"; # dir
+};
+zone "a" in {
+	type master;
+};
+include "extra.zones";
+`
+
+func TestDocument(t *testing.T) {
+	config := predef.NamedConf
+	doc := newDocument("file:///etc/named.conf", namedConf, &config)
+	if diagnostics := doc.diagnostics(); len(diagnostics) != 0 {
+		t.Fatal(diagnostics)
+	}
+	symbols := doc.symbols(doc.root)
+	if len(symbols) != 2 || symbols[0].Name != "options" || symbols[1].Name != `zone "a" in` ||
+		symbols[1].Range != (Range{Position{4, 0}, Position{6, 2}}) || symbols[1].SelectionRange != (Range{Position{4, 0}, Position{4, 4}}) {
+		t.Fatal(symbols)
+	}
+	if folds := doc.foldingRanges(); !reflect.DeepEqual(folds, []FoldingRange{{0, 2, FOLDING_KIND_REGION}, {4, 5, FOLDING_KIND_REGION}}) {
+		t.Fatal(folds)
+	}
+	if hover := doc.hover(doc.offset(Position{2, 12})); hover == nil || hover.Contents.Value != "where zones live\ndir" ||
+		*hover.Range != (Range{Position{2, 1}, Position{2, 10}}) {
+		t.Fatal(hover)
+	}
+	if hover := doc.hover(doc.offset(Position{5, 1})); hover != nil {
+		t.Fatal(hover)
+	}
+
+	config = predef.SysctlConf
+	doc = newDocument("file:///etc/sysctl.conf", "# one\n# two\n# three\nkernel.panic = 5\n", &config)
+	if folds := doc.foldingRanges(); !reflect.DeepEqual(folds, []FoldingRange{{0, 2, FOLDING_KIND_COMMENT}}) {
+		t.Fatal(folds)
+	}
+	if hover := doc.hover(doc.offset(Position{3, 0})); hover == nil || hover.Contents.Value != "one\ntwo\nthree" {
+		t.Fatal(hover)
+	}
+
+	// Positions are counted in UTF-16 code units
+	config = predef.SystemdConf
+	doc = newDocument("file:///etc/systemd/system/a.service", "[Unit]\nDescription=日本𝄞\n\n[Service]\nExecStart=/bin/a\n\n", &config)
+	if pos := doc.position(len("[Unit]\nDescription=日本𝄞")); pos != (Position{1, 16}) {
+		t.Fatal(pos)
+	} else if offset := doc.offset(pos); offset != len("[Unit]\nDescription=日本𝄞") {
+		t.Fatal(offset)
+	}
+	symbols = doc.symbols(doc.root)
+	if len(symbols) != 2 || symbols[0].Name != "Unit" || symbols[1].Range != (Range{Position{3, 0}, Position{4, 16}}) {
+		t.Fatal(symbols)
+	}
+	if folds := doc.foldingRanges(); !reflect.DeepEqual(folds, []FoldingRange{{0, 1, FOLDING_KIND_REGION}, {3, 4, FOLDING_KIND_REGION}}) {
+		t.Fatal(folds)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	for _, test := range []struct {
+		fileName, text, message string
+		line                    int
+	}{
+		{"named.conf", "options {\n\ta;\n", `section "options" is not closed`, 0},
+		{"named.conf", "a;\nb \"c;\nd;\n", "the text from here on is not understood, is a quotation mark left open?", 1},
+		{"named.conf", "a;\n/* b\n", "the comment is not closed", 1},
+		{"named.conf", "a;\n};\n", "the document cannot be lexed: runtime error: invalid memory address or nil pointer dereference", 0},
+		{"httpd.conf", "<A x>\n  b\n</B>\n", `section "A x" is closed by "B"`, 2},
+		{"httpd.conf", "<A x>\n  b\n", `section "A x" is not closed`, 0},
+	} {
+		config, _ := predef.ConfigForPath(test.fileName)
+		diagnostics := newDocument("file:///etc/"+test.fileName, test.text, &config).diagnostics()
+		if len(diagnostics) != 1 || diagnostics[0].Message != test.message || diagnostics[0].Range.Start.Line != test.line {
+			t.Fatal(test.text, diagnostics)
+		}
+	}
+	config := predef.HttpdConf
+	if diagnostics := newDocument("file:///etc/httpd.conf", "<A x>\n  b\n</A>\n", &config).diagnostics(); len(diagnostics) != 0 {
+		t.Fatal(diagnostics)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lmc-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"extra.zones", "root/conf.d/b.conf", "root/conf.d/a.conf"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0700)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte{}, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := predef.NamedConf
+	doc := newDocument(pathToURI(filepath.Join(dir, "named.conf")), namedConf, &config)
+	if locations := doc.definition(doc.offset(Position{7, 12})); len(locations) != 1 || locations[0].URI != pathToURI(filepath.Join(dir, "extra.zones")) {
+		t.Fatal(locations)
+	}
+	if locations := doc.definition(doc.offset(Position{4, 0})); len(locations) != 0 {
+		t.Fatal(locations)
+	}
+	config = predef.HttpdConf
+	doc = newDocument(pathToURI(filepath.Join(dir, "httpd.conf")), "ServerRoot "+filepath.Join(dir, "root")+"\nInclude conf.d/*.conf\n", &config)
+	if locations := doc.definition(doc.offset(Position{1, 0})); len(locations) != 2 ||
+		locations[0].URI != pathToURI(filepath.Join(dir, "root/conf.d/a.conf")) || locations[1].URI != pathToURI(filepath.Join(dir, "root/conf.d/b.conf")) {
+		t.Fatal(locations)
+	}
+}
+
+func TestServer(t *testing.T) {
+	var in, out bytes.Buffer
+	send := func(id int, method string, params interface{}) {
+		msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+		if id != 0 {
+			msg["id"] = id
+		}
+		content, _ := json.Marshal(msg)
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(content), content)
+	}
+	uri := "file:///etc/named.conf"
+	send(1, "initialize", map[string]interface{}{})
+	send(0, "initialized", map[string]interface{}{})
+	send(0, "textDocument/didOpen", map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri, "languageId": "named", "version": 1, "text": namedConf}})
+	send(0, "textDocument/didChange", map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri},
+		"contentChanges": []interface{}{map[string]interface{}{"text": "options {\n"}}})
+	send(2, "textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri}})
+	send(3, "textDocument/hover", map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri}, "position": Position{0, 0}})
+	send(4, "textDocument/rename", map[string]interface{}{})
+	send(5, "shutdown", nil)
+	send(0, "exit", nil)
+	if err := NewServer(&in, &out).Serve(); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(&out)
+	responses := make([]map[string]interface{}, 0, 8)
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		var length int
+		fmt.Sscanf(header, "Content-Length: %d", &length)
+		reader.ReadString('\n')
+		content := make([]byte, length)
+		reader.Read(content)
+		response := make(map[string]interface{})
+		if err := json.Unmarshal(content, &response); err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, response)
+	}
+	if len(responses) != 7 {
+		t.Fatal(responses)
+	}
+	if capabilities := responses[0]["result"].(map[string]interface{})["capabilities"].(map[string]interface{}); capabilities["hoverProvider"] != true {
+		t.Fatal(responses[0])
+	}
+	if diagnostics := responses[1]["params"].(map[string]interface{})["diagnostics"].([]interface{}); responses[1]["method"] != "textDocument/publishDiagnostics" || len(diagnostics) != 0 {
+		t.Fatal(responses[1])
+	}
+	if diagnostics := responses[2]["params"].(map[string]interface{})["diagnostics"].([]interface{}); len(diagnostics) != 1 {
+		t.Fatal(responses[2])
+	}
+	if symbols := responses[3]["result"].([]interface{}); len(symbols) != 1 || responses[3]["id"] != float64(2) {
+		t.Fatal(responses[3])
+	}
+	if result, found := responses[4]["result"]; !found || result != nil {
+		t.Fatal(responses[4])
+	}
+	if responses[5]["error"].(map[string]interface{})["code"] != float64(ERR_METHOD_NOT_FOUND) {
+		t.Fatal(responses[5])
+	}
+	if result, found := responses[6]["result"]; !found || result != nil || responses[6]["id"] != float64(5) {
+		t.Fatal(responses[6])
+	}
+	// Exiting without shutting down is an error
+	in.Reset()
+	send(0, "exit", nil)
+	if err := NewServer(&in, &out).Serve(); err != ErrExitWithoutShutdown {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,219 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"path/filepath"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer/predef"
+)
+
+// Language identifiers of documents whose file names are not recognised by predef.ConfigForPath.
+var LanguageConfigs = map[string]*lexer.LexerConfig{
+	"apache":     &predef.HttpdConf,
+	"apacheconf": &predef.HttpdConf,
+	"named":      &predef.NamedConf,
+	"bind":       &predef.NamedConf,
+	"zone":       &predef.NamedZone,
+	"dns":        &predef.NamedZone,
+	"dhcpd":      &predef.DhcpdConf,
+	"systemd":    &predef.SystemdConf,
+	"sysctl":     &predef.SysctlConf,
+	"crontab":    &predef.Crontab,
+	"hosts":      &predef.Hosts,
+}
+
+/*
+Server is a language server for the configuration files in predefined formats. It reads requests from the client and
+writes responses, one at a time, the documents that are opened by the client are synchronised in full.
+*/
+type Server struct {
+	in          *bufio.Reader
+	out         io.Writer
+	documents   map[string]*document
+	initialised bool
+	shutdown    bool
+}
+
+// Create a server that talks to a client via the reader and writer, usually standard input and output.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{in: bufio.NewReader(in), out: out, documents: make(map[string]*document)}
+}
+
+var ErrExitWithoutShutdown = errors.New("client asked the server to exit without shutting it down first")
+
+/*
+Serve the client until it asks the server to exit, or until the input is closed. The error is nil if the client
+shuts down the server and then asks it to exit.
+*/
+func (srv *Server) Serve() error {
+	for {
+		msg, err := readMessage(srv.in)
+		if rpcErr, isRPCErr := err.(*responseError); isRPCErr {
+			if err := writeMessage(srv.out, errorResponse{JSONRPC: "2.0", Error: rpcErr}); err != nil {
+				return err
+			}
+			continue
+		} else if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			if !srv.shutdown {
+				return ErrExitWithoutShutdown
+			}
+			return nil
+		}
+		result, rpcErr, err := srv.handle(msg)
+		if err != nil {
+			return err
+		} else if msg.ID == nil {
+			// Notifications do not have responses
+			continue
+		}
+		if rpcErr != nil {
+			err = writeMessage(srv.out, errorResponse{JSONRPC: "2.0", ID: msg.ID, Error: rpcErr})
+		} else {
+			err = writeMessage(srv.out, response{JSONRPC: "2.0", ID: msg.ID, Result: result})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/*
+Handle a request or notification, return the result of a request or the error to respond with. The error err is only
+returned if the server fails to write to the client.
+*/
+func (srv *Server) handle(msg *message) (result interface{}, rpcErr *responseError, err error) {
+	if !srv.initialised && msg.Method != "initialize" {
+		if msg.ID == nil {
+			return nil, nil, nil
+		}
+		return nil, &responseError{Code: ERR_NOT_INITIALISED, Message: "server is not initialised"}, nil
+	}
+	switch msg.Method {
+	case "initialize":
+		srv.initialised = true
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1, // the client sends the full text of a document upon changes
+				"documentSymbolProvider": true,
+				"hoverProvider":          true,
+				"foldingRangeProvider":   true,
+				"definitionProvider":     true,
+			},
+			"serverInfo": map[string]string{"name": "lmc-lsp"},
+		}, nil, nil
+	case "shutdown":
+		srv.shutdown = true
+		return nil, nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err), nil
+		}
+		srv.documents[params.TextDocument.URI] = newDocument(params.TextDocument.URI, params.TextDocument.Text,
+			configFor(params.TextDocument.URI, params.TextDocument.LanguageID))
+		return nil, nil, srv.publishDiagnostics(params.TextDocument.URI)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err), nil
+		}
+		doc, found := srv.documents[params.TextDocument.URI]
+		if !found || len(params.ContentChanges) == 0 {
+			return nil, nil, nil
+		}
+		// Only full synchronisation is offered, hence the last change carries the entire text.
+		srv.documents[doc.uri] = newDocument(doc.uri, params.ContentChanges[len(params.ContentChanges)-1].Text, doc.config)
+		return nil, nil, srv.publishDiagnostics(doc.uri)
+	case "textDocument/didClose":
+		var params textDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err), nil
+		}
+		delete(srv.documents, params.TextDocument.URI)
+		return nil, nil, nil
+	case "textDocument/documentSymbol", "textDocument/foldingRange":
+		var params textDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err), nil
+		}
+		doc, found := srv.documents[params.TextDocument.URI]
+		if !found {
+			return nil, &responseError{Code: ERR_INVALID_PARAMS, Message: "document is not open"}, nil
+		}
+		if msg.Method == "textDocument/documentSymbol" {
+			return doc.symbols(doc.root), nil, nil
+		}
+		return doc.foldingRanges(), nil, nil
+	case "textDocument/hover", "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err), nil
+		}
+		doc, found := srv.documents[params.TextDocument.URI]
+		if !found {
+			return nil, &responseError{Code: ERR_INVALID_PARAMS, Message: "document is not open"}, nil
+		}
+		if doc.root == nil {
+			return nil, nil, nil
+		}
+		if msg.Method == "textDocument/hover" {
+			if hover := doc.hover(doc.offset(params.Position)); hover != nil {
+				return hover, nil, nil
+			}
+			return nil, nil, nil
+		}
+		return doc.definition(doc.offset(params.Position)), nil, nil
+	}
+	if msg.ID == nil {
+		// Unknown notifications such as "initialized" and "$/cancelRequest" are ignored
+		return nil, nil, nil
+	}
+	return nil, &responseError{Code: ERR_METHOD_NOT_FOUND, Message: "method \"" + msg.Method + "\" is not supported"}, nil
+}
+
+func invalidParams(err error) *responseError {
+	return &responseError{Code: ERR_INVALID_PARAMS, Message: err.Error()}
+}
+
+// Publish the diagnostics of the document to the client.
+func (srv *Server) publishDiagnostics(uri string) error {
+	params := publishDiagnosticsParams{URI: uri, Diagnostics: srv.documents[uri].diagnostics()}
+	return writeMessage(srv.out, notification{JSONRPC: "2.0", Method: "textDocument/publishDiagnostics", Params: params})
+}
+
+// Return a copy of the lexer configuration for the document, or nil if its format is not known.
+func configFor(uri, languageID string) *lexer.LexerConfig {
+	if filePath := uriToPath(uri); filePath != "" {
+		if config, found := predef.ConfigForPath(filePath); found {
+			return &config
+		}
+	}
+	if config, found := LanguageConfigs[languageID]; found {
+		copied := *config
+		return &copied
+	}
+	return nil
+}
+
+// Return the local file path of a "file" URI, or an empty string if the URI refers to something else.
+func uriToPath(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(parsed.Path)
+}
+
+func pathToURI(filePath string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filePath)}).String()
+}
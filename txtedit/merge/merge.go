@@ -0,0 +1,380 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/diff"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/navigate"
+)
+
+const (
+	RESOLVE_OURS   = "ours"   // keep the local version
+	RESOLVE_THEIRS = "theirs" // take the new vendor version
+)
+
+/*
+Conflict is a node that has been changed differently by both the local administrator and the new vendor default.
+The merged document keeps the local version of the node until the conflict is resolved.
+*/
+type Conflict struct {
+	Path         string          `json:"path"`
+	OursKind     diff.ChangeKind `json:"oursKind"`   // how the local file changed the node, empty if the vendor change cannot be placed
+	TheirsKind   diff.ChangeKind `json:"theirsKind"` // how the new vendor default changed the node
+	BaseValues   []string        `json:"baseValues,omitempty"`
+	OursValues   []string        `json:"oursValues,omitempty"`
+	TheirsValues []string        `json:"theirsValues,omitempty"`
+	BaseText     string          `json:"baseText,omitempty"`
+	OursText     string          `json:"oursText,omitempty"`
+	TheirsText   string          `json:"theirsText,omitempty"`
+	Resolution   string          `json:"resolution,omitempty"` // empty until resolved
+
+	theirs diff.Change // the vendor change that could not be merged
+}
+
+// Result carries the merged document, the vendor changes that were merged, and the conflicts that await resolution.
+type Result struct {
+	Merged    *lexer.DocumentNode
+	Applied   []diff.Change
+	Conflicts []*Conflict
+
+	theirsToBase map[*lexer.DocumentNode]*lexer.DocumentNode // vendor nodes paired with their old default nodes
+}
+
+var ErrNoSuchConflict = errors.New("conflict does not exist")
+var ErrAlreadyResolved = errors.New("conflict has already been resolved")
+var ErrUnknownResolution = errors.New("unknown resolution")
+var ErrCannotPlace = errors.New("cannot find the place for the node in merged document")
+
+/*
+Merge vendor changes made between the old default (base) and the new default (theirs) into the local file (ours).
+The local file is not modified, merge takes place in a copy. Local comments and layout are kept; comments that lead
+a newly added directive are carried along with it. Vendor changes to comments and spaces alone are not merged.
+*/
+func Merge(base, theirs, ours *lexer.DocumentNode) *Result {
+	result := &Result{Merged: ours.Clone(), Applied: make([]diff.Change, 0, 8), Conflicts: make([]*Conflict, 0, 0),
+		theirsToBase: diff.Pair(base, theirs)}
+	localChanges := make([]diff.Change, 0, 8)
+	for _, change := range diff.Compare(base, ours) {
+		if change.IsSemantic() {
+			localChanges = append(localChanges, change)
+		}
+	}
+	// Paths of the changes lead to the nodes only until the first change is applied, resolve them all in advance.
+	pending := make([]*resolvedChange, 0, 8)
+	for _, vendorChange := range diff.Compare(base, theirs) {
+		if !vendorChange.IsSemantic() {
+			continue
+		}
+		if local, overlaps := findOverlap(vendorChange, localChanges); overlaps {
+			if sameOutcome(vendorChange, local) {
+				continue
+			}
+			result.Conflicts = append(result.Conflicts, newConflict(vendorChange, local))
+			continue
+		}
+		pending = append(pending, resolveChange(result, vendorChange))
+	}
+	for _, resolved := range pending {
+		if err := resolved.apply(); err != nil {
+			// The change cannot be placed, let the administrator decide.
+			result.Conflicts = append(result.Conflicts, newConflict(resolved.change, diff.Change{}))
+			continue
+		}
+		result.Applied = append(result.Applied, resolved.change)
+	}
+	return result
+}
+
+// Return true only if all conflicts have been resolved.
+func (result *Result) Resolved() bool {
+	for _, conflict := range result.Conflicts {
+		if conflict.Resolution == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Resolve a conflict by keeping the local version or taking the vendor version of the node.
+func (result *Result) Resolve(index int, resolution string) error {
+	if index < 0 || index >= len(result.Conflicts) {
+		return ErrNoSuchConflict
+	}
+	conflict := result.Conflicts[index]
+	if conflict.Resolution != "" {
+		return ErrAlreadyResolved
+	}
+	switch resolution {
+	case RESOLVE_OURS:
+	case RESOLVE_THEIRS:
+		if err := forceChange(result, conflict); err != nil {
+			return err
+		}
+	default:
+		return ErrUnknownResolution
+	}
+	conflict.Resolution = resolution
+	return nil
+}
+
+// Return the paths affected by a change.
+func changePaths(change diff.Change) []string {
+	if change.OldPath != "" {
+		return []string{change.Path, change.OldPath}
+	}
+	return []string{change.Path}
+}
+
+// Return true only if one path leads to the other or both paths are identical.
+func pathsOverlap(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+// Find a local change that touches the same node (or its parent/child nodes) as the vendor change.
+func findOverlap(vendorChange diff.Change, localChanges []diff.Change) (diff.Change, bool) {
+	for _, local := range localChanges {
+		for _, vendorPath := range changePaths(vendorChange) {
+			for _, localPath := range changePaths(local) {
+				if pathsOverlap(vendorPath, localPath) {
+					return local, true
+				}
+			}
+		}
+	}
+	return diff.Change{}, false
+}
+
+// Return true only if both sides made the identical change to the node.
+func sameOutcome(vendorChange, local diff.Change) bool {
+	if vendorChange.Kind != local.Kind || vendorChange.Path != local.Path {
+		return false
+	}
+	switch vendorChange.Kind {
+	case diff.CHANGE_REMOVED:
+		return true
+	case diff.CHANGE_MODIFIED:
+		return strings.Join(vendorChange.NewValues, "\x00") == strings.Join(local.NewValues, "\x00")
+	}
+	return vendorChange.NewNode.VerbatimText() == local.NewNode.VerbatimText()
+}
+
+func newConflict(vendorChange, local diff.Change) *Conflict {
+	conflict := &Conflict{
+		Path:         vendorChange.Path,
+		OursKind:     local.Kind,
+		TheirsKind:   vendorChange.Kind,
+		BaseValues:   vendorChange.OldValues,
+		BaseText:     vendorChange.OldText,
+		OursValues:   local.NewValues,
+		OursText:     local.NewText,
+		TheirsValues: vendorChange.NewValues,
+		TheirsText:   vendorChange.NewText,
+		theirs:       vendorChange,
+	}
+	return conflict
+}
+
+// Look for the node by path string in the merged document.
+func findByPath(root *lexer.DocumentNode, pathStr string) *lexer.DocumentNode {
+	path, err := navigate.ParsePath(pathStr)
+	if err != nil || len(path) == 0 {
+		return nil
+	}
+	return navigate.Find(root, path)
+}
+
+/*
+A vendor change along with the nodes of the merged document that it concerns. The nodes are looked up before any
+change is applied, because an applied change shifts the positional paths of the nodes that follow it.
+*/
+type resolvedChange struct {
+	change   diff.Change
+	result   *Result
+	merged   *lexer.DocumentNode
+	target   *lexer.DocumentNode   // the node to remove, modify, or move
+	node     *lexer.DocumentNode   // the node to place, which is the target if it is moved
+	comments []*lexer.DocumentNode // the comments that are placed along with the node
+	parent   *lexer.DocumentNode   // the parent to place the node in
+	before   []*lexer.DocumentNode // keyed siblings that precede the node's place, the closest first
+	after    []*lexer.DocumentNode // keyed siblings that follow the node's place, the closest first
+	err      error
+}
+
+/*
+Look for the nodes of the merged document that the vendor change concerns. The local file is expected to follow the
+layout of the old default, hence the nodes are looked up by their paths in the old default.
+*/
+func resolveChange(result *Result, change diff.Change) *resolvedChange {
+	resolved := &resolvedChange{change: change, result: result, merged: result.Merged}
+	switch change.Kind {
+	case diff.CHANGE_ADDED:
+		resolved.node = change.NewNode.Clone()
+		resolved.resolvePlace(change.NewNode, true)
+	case diff.CHANGE_REMOVED, diff.CHANGE_MODIFIED:
+		resolved.target = resolved.findBase(change.OldNode)
+	case diff.CHANGE_MOVED:
+		resolved.target = resolved.findBase(change.OldNode)
+		resolved.node = resolved.target
+		resolved.resolvePlace(change.NewNode, false)
+	default:
+		resolved.err = fmt.Errorf("change kind %s cannot be merged", change.Kind)
+	}
+	return resolved
+}
+
+/*
+Look for the place in the merged document that corresponds to the place of theirsNode in the vendor document: after
+a keyed sibling that precedes it, or before a keyed sibling that follows it. If withComments is true, the comments that
+immediately precede theirsNode are placed along with the node.
+*/
+func (resolved *resolvedChange) resolvePlace(theirsNode *lexer.DocumentNode, withComments bool) {
+	resolved.parent = resolved.merged
+	if theirsNode.Parent.Parent != nil {
+		resolved.parent = resolved.findBase(resolved.result.theirsToBase[theirsNode.Parent])
+	}
+	if resolved.parent == nil {
+		return
+	}
+	siblings := theirsNode.Parent.Leaves
+	myIndex := theirsNode.GetMyLeafIndex()
+	if withComments {
+		for i := myIndex - 1; i >= 0; i-- {
+			stmt, ok := siblings[i].Entity.(*lexer.Statement)
+			if !ok || len(navigate.StatementComments(stmt)) == 0 || len(navigate.StatementTexts(stmt)) > 0 {
+				break
+			}
+			resolved.comments = append([]*lexer.DocumentNode{siblings[i].Clone()}, resolved.comments...)
+		}
+	}
+	for i := myIndex - 1; i >= 0; i-- {
+		if anchor := resolved.findSibling(siblings[i]); anchor != nil {
+			resolved.before = append(resolved.before, anchor)
+		}
+	}
+	for i := myIndex + 1; i < len(siblings); i++ {
+		if anchor := resolved.findSibling(siblings[i]); anchor != nil {
+			resolved.after = append(resolved.after, anchor)
+		}
+	}
+}
+
+// Return the node of the merged document that corresponds to the vendor node, if it is among the parent's leaves.
+func (resolved *resolvedChange) findSibling(theirsNode *lexer.DocumentNode) *lexer.DocumentNode {
+	if node := resolved.findBase(resolved.result.theirsToBase[theirsNode]); node != nil && node.Parent == resolved.parent {
+		return node
+	}
+	return nil
+}
+
+// Return the node of the merged document found at the path of the old default node, or nil if it is not found.
+func (resolved *resolvedChange) findBase(baseNode *lexer.DocumentNode) *lexer.DocumentNode {
+	if baseNode == nil {
+		return nil
+	}
+	if path, ok := navigate.NodePath(baseNode); ok && len(path) > 0 {
+		return navigate.Find(resolved.merged, path)
+	}
+	return nil
+}
+
+// Apply the vendor change to the merged document.
+func (resolved *resolvedChange) apply() error {
+	if resolved.err != nil {
+		return resolved.err
+	}
+	switch resolved.change.Kind {
+	case diff.CHANGE_REMOVED:
+		if !attached(resolved.merged, resolved.target) || !resolved.target.DeleteSelf() {
+			return ErrCannotPlace
+		}
+	case diff.CHANGE_MODIFIED:
+		if !attached(resolved.merged, resolved.target) {
+			return ErrCannotPlace
+		}
+		stmt, ok := resolved.target.Entity.(*lexer.Statement)
+		if !ok || !navigate.SetStatementValues(stmt, resolved.change.NewValues) {
+			return ErrCannotPlace
+		}
+	case diff.CHANGE_MOVED:
+		if !attached(resolved.merged, resolved.target) || !resolved.target.DeleteSelf() {
+			return ErrCannotPlace
+		}
+		return resolved.place()
+	case diff.CHANGE_ADDED:
+		return resolved.place()
+	}
+	return nil
+}
+
+/*
+Place the node and its comments at the resolved place: after the closest preceding sibling that is still in place,
+or before the closest following one, or as the last keyed leaf of the parent.
+*/
+func (resolved *resolvedChange) place() error {
+	if !attached(resolved.merged, resolved.parent) {
+		return ErrCannotPlace
+	}
+	toPlace := append(append([]*lexer.DocumentNode{}, resolved.comments...), resolved.node)
+	placed := false
+	for _, anchor := range resolved.before {
+		if placed = attached(resolved.merged, anchor) && anchor.Parent == resolved.parent && navigate.PlaceAfter(anchor, toPlace[0]); placed {
+			break
+		}
+	}
+	for i := 0; !placed && i < len(resolved.after); i++ {
+		anchor := resolved.after[i]
+		placed = attached(resolved.merged, anchor) && anchor.Parent == resolved.parent && navigate.PlaceBefore(anchor, toPlace[0])
+	}
+	if !placed && !navigate.MoveInto(toPlace[0], resolved.parent) {
+		return ErrCannotPlace
+	}
+	for i := 1; i < len(toPlace); i++ {
+		navigate.PlaceAfter(toPlace[i-1], toPlace[i])
+	}
+	return nil
+}
+
+// Return true only if the node is reachable from the root, it may have been removed by an earlier change.
+func attached(root, node *lexer.DocumentNode) bool {
+	for ; node != nil && node != root; node = node.Parent {
+		if node.Parent == nil || node.Parent.FindLeafIndex(node) == -1 {
+			return false
+		}
+	}
+	return node == root
+}
+
+// Apply the vendor side of a conflict regardless of the local change.
+func forceChange(result *Result, conflict *Conflict) error {
+	merged := result.Merged
+	change := conflict.theirs
+	var local *lexer.DocumentNode
+	if change.OldPath != "" {
+		local = findByPath(merged, change.OldPath)
+	}
+	if local == nil {
+		local = findByPath(merged, change.Path)
+	}
+	resolved := &resolvedChange{change: change, result: result, merged: merged}
+	if change.NewNode != nil {
+		resolved.node = change.NewNode.Clone()
+		resolved.resolvePlace(change.NewNode, change.Kind == diff.CHANGE_ADDED)
+	}
+	if local != nil && change.NewNode != nil && change.Kind != diff.CHANGE_MOVED {
+		// The vendor version takes the place of the local version, local comments around the node stay.
+		local.InsertBeforeSelf(resolved.node)
+		local.DeleteSelf()
+		return nil
+	}
+	if local != nil {
+		local.DeleteSelf()
+	}
+	if change.NewNode == nil {
+		// The vendor removed the node
+		return nil
+	}
+	return resolved.place()
+}
@@ -0,0 +1,127 @@
+package merge
+
+import (
+	"testing"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer/predef"
+)
+
+func lex(text string, config lexer.LexerConfig) *lexer.DocumentNode {
+	return lexer.NewLexer(text, &config, &lexer.LexerDebugNoop{}).Run()
+}
+
+var base = `[Unit]
+Description=Daemon
+After=network.target
+
+[Service]
+ExecStart=/usr/bin/daemon
+Restart=no
+Nice=0
+`
+
+var theirs = `[Unit]
+Description=Daemon
+After=network-online.target
+
+[Service]
+ExecStart=/usr/bin/daemon --foreground
+Restart=no
+# Harden the service
+ProtectSystem=full
+`
+
+var ours = `[Unit]
+Description=Daemon
+After=network.target
+
+[Service]
+# Local tweak
+ExecStart=/usr/bin/daemon -v
+Restart=always
+Nice=0
+`
+
+func TestMerge(t *testing.T) {
+	result := Merge(lex(base, predef.SystemdConf), lex(theirs, predef.SystemdConf), lex(ours, predef.SystemdConf))
+	if len(result.Conflicts) != 1 || result.Conflicts[0].Path != "Service/ExecStart" {
+		t.Fatal(result.Conflicts)
+	}
+	// After, ProtectSystem are merged, Nice is removed
+	if len(result.Applied) != 3 {
+		t.Fatal(result.Applied)
+	}
+	merged := `[Unit]
+Description=Daemon
+After=network-online.target
+
+[Service]
+# Local tweak
+ExecStart=/usr/bin/daemon -v
+Restart=always
+# Harden the service
+ProtectSystem=full
+`
+	if text := result.Merged.VerbatimText(); text != merged {
+		t.Fatal(text)
+	}
+	if result.Resolved() {
+		t.Fatal("should not be resolved")
+	}
+	if err := result.Resolve(0, RESOLVE_THEIRS); err != nil {
+		t.Fatal(err)
+	}
+	if err := result.Resolve(0, RESOLVE_OURS); err != ErrAlreadyResolved {
+		t.Fatal(err)
+	}
+	if !result.Resolved() {
+		t.Fatal("should be resolved")
+	}
+	merged = `[Unit]
+Description=Daemon
+After=network-online.target
+
+[Service]
+# Local tweak
+ExecStart=/usr/bin/daemon --foreground
+Restart=always
+# Harden the service
+ProtectSystem=full
+`
+	if text := result.Merged.VerbatimText(); text != merged {
+		t.Fatal(text)
+	}
+}
+
+func TestMergeNested(t *testing.T) {
+	base := "options {\n\tnotify yes;\n};\n"
+	theirs := "options {\n\tnotify yes;\n};\nzone \"localhost\" in {\n\ttype master;\n};\n"
+	ours := "options {\n\t// keep quiet\n\tnotify no;\n};\n"
+	result := Merge(lex(base, predef.NamedConf), lex(theirs, predef.NamedConf), lex(ours, predef.NamedConf))
+	if len(result.Conflicts) != 0 {
+		t.Fatal(result.Conflicts)
+	}
+	if text := result.Merged.VerbatimText(); text != "options {\n\t// keep quiet\n\tnotify no;\n};\nzone \"localhost\" in {\n\ttype master;\n};\n" {
+		t.Fatal(text)
+	}
+}
+
+func TestMergeShiftedPaths(t *testing.T) {
+	base := "LoadModule a\nLoadModule b\nLoadModule c\n"
+	ours := "LoadModule a\nLoadModule b\nLoadModule c\nListen 80\n"
+	// The leading insert shifts the positions of the statements that follow it
+	theirs := "LoadModule z\nLoadModule a\nLoadModule b\nLoadModule c2\n"
+	result := Merge(lex(base, predef.HttpdConf), lex(theirs, predef.HttpdConf), lex(ours, predef.HttpdConf))
+	if len(result.Conflicts) != 0 || len(result.Applied) != 2 {
+		t.Fatal(result.Conflicts, result.Applied)
+	}
+	if text := result.Merged.VerbatimText(); text != "LoadModule z\nLoadModule a\nLoadModule b\nLoadModule c2\nListen 80\n" {
+		t.Fatal(text)
+	}
+	theirs = "LoadModule b\nLoadModule c2\n"
+	result = Merge(lex(base, predef.HttpdConf), lex(theirs, predef.HttpdConf), lex(ours, predef.HttpdConf))
+	if text := result.Merged.VerbatimText(); len(result.Conflicts) != 0 || text != "LoadModule b\nLoadModule c2\nListen 80\n" {
+		t.Fatal(result.Conflicts, text)
+	}
+}
@@ -0,0 +1,481 @@
+package navigate
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+)
+
+// Return the text pieces that carry the statement's words, in the same order as StatementTexts.
+func WordPieces(stmt *lexer.Statement) []*lexer.Text {
+	ret := make([]*lexer.Text, 0, len(stmt.Pieces))
+	for _, piece := range stmt.Pieces {
+		if text, ok := piece.(*lexer.Text); ok && (text.QuoteStyle != "" || strings.TrimSpace(text.Text) != "") {
+			ret = append(ret, text)
+		}
+	}
+	return ret
+}
+
+/*
+Replace the words that follow the statement's key by the values. Existing text pieces are reused so that their quotation
+and spaces are kept. Surplus text pieces are removed, and additional values are placed after the last word, separated
+by a space character. Comments stay where they were. Return false if the statement does not have a key.
+*/
+func SetStatementValues(stmt *lexer.Statement, values []string) bool {
+	words := WordPieces(stmt)
+	if len(words) == 0 {
+		return false
+	}
+	oldValues := words[1:]
+	for i, value := range values {
+		if i < len(oldValues) {
+			oldValues[i].Text = value
+			continue
+		}
+		// Append a new word after the last one
+		last := words[len(words)-1]
+		if last.TrailingSpaces == "" {
+			last.TrailingSpaces = " "
+		}
+		newText := &lexer.Text{Text: value}
+		if strings.ContainsAny(value, " \t") && last.QuoteStyle != "" {
+			newText.QuoteStyle = last.QuoteStyle
+		}
+		stmt.Pieces = insertPieceAfter(stmt.Pieces, last, newText)
+		words = append(words, newText)
+	}
+	if len(values) < len(oldValues) {
+		// Remove surplus words, the last remaining word takes over the trailing spaces of the last removed word.
+		surplus := oldValues[len(values):]
+		remaining := words[len(values)]
+		remaining.TrailingSpaces = surplus[len(surplus)-1].TrailingSpaces
+		for _, piece := range surplus {
+			stmt.Pieces = removePiece(stmt.Pieces, piece)
+		}
+	}
+	return true
+}
+
+/*
+Create a statement out of the words. If the template statement is not nil, the new statement copies its indentation,
+ending, the spaces that lead its first word, and the spaces that trail each word in the same position. Otherwise the
+words are separated by a space character.
+*/
+func NewStatement(words []string, template *lexer.Statement) *lexer.Statement {
+	stmt := &lexer.Statement{Pieces: make([]lexer.ContainVerbatimText, 0, len(words)+1)}
+	templateWords := make([]*lexer.Text, 0, 0)
+	if template != nil {
+		stmt.Indent = template.Indent
+		stmt.Ending = template.Ending
+		// Some lexer configurations place new-line and indentation characters in a text piece before the first word
+		for _, piece := range template.Pieces {
+			if text, ok := piece.(*lexer.Text); ok && text.QuoteStyle == "" && strings.TrimSpace(text.Text) == "" {
+				copied := *text
+				stmt.Pieces = append(stmt.Pieces, &copied)
+			} else {
+				break
+			}
+		}
+		templateWords = WordPieces(template)
+	}
+	for i, word := range words {
+		text := &lexer.Text{Text: word}
+		if strings.ContainsAny(word, " \t") {
+			text.QuoteStyle = "\""
+		}
+		if i < len(words)-1 {
+			text.TrailingSpaces = " "
+			if i < len(templateWords) {
+				/*
+					Words of the template may be glued together by a token break marker (e.g. Key=Value), keep them
+					glued only if the marker is also among the new words.
+				*/
+				glued := i > 0 && words[i] == templateWords[i].Text || i+1 < len(templateWords) && words[i+1] == templateWords[i+1].Text
+				if templateWords[i].TrailingSpaces != "" || glued {
+					text.TrailingSpaces = templateWords[i].TrailingSpaces
+				}
+			}
+		}
+		stmt.Pieces = append(stmt.Pieces, text)
+	}
+	return stmt
+}
+
+/*
+Return a statement among the node's leaves that is suitable as the template for a new statement: the last statement
+that has a key, preferably one that is not led by new-line characters. Return nil if there is not such statement.
+*/
+func StatementTemplate(node *lexer.DocumentNode) (template *lexer.Statement) {
+	for i := len(node.Leaves) - 1; i >= 0; i-- {
+		stmt, ok := node.Leaves[i].Entity.(*lexer.Statement)
+		if !ok || len(WordPieces(stmt)) == 0 {
+			continue
+		}
+		if first, isText := stmt.Pieces[0].(*lexer.Text); isText && strings.TrimSpace(first.Text) == "" && first.QuoteStyle == "" {
+			if template == nil {
+				template = stmt
+			}
+			continue
+		}
+		return stmt
+	}
+	return
+}
+
+/*
+Place the statement among the node's leaves, right after the last leaf that has a key (so that trailing comments and
+blank lines stay at the end), or as the last leaf if there is not one. A section without closing markers extends to
+the next section, so the statement is placed before such section. Return the new leaf.
+*/
+func AppendStatement(node *lexer.DocumentNode, stmt *lexer.Statement) *lexer.DocumentNode {
+	newLeaf := &lexer.DocumentNode{Entity: stmt, Leaves: make([]*lexer.DocumentNode, 0, 0)}
+	end := len(node.Leaves)
+	for i, leaf := range node.Leaves {
+		if sect, ok := leaf.Entity.(*lexer.Section); ok && IsUnclosedSection(sect) {
+			end = i
+			break
+		}
+	}
+	anchor := -1
+	for i := end - 1; i >= 0; i-- {
+		if _, _, ok := NodeKey(node.Leaves[i]); ok {
+			anchor = i
+			break
+		}
+	}
+	if anchor == -1 {
+		if end < len(node.Leaves) {
+			node.InsertBefore(node.Leaves[end], newLeaf)
+		} else {
+			newLeaf.Parent = node
+			node.Leaves = append(node.Leaves, newLeaf)
+		}
+		PlaceOnOwnLine(newLeaf)
+	} else {
+		PlaceAfter(node.Leaves[anchor], newLeaf)
+	}
+	return newLeaf
+}
+
+/*
+Place the new node after the anchor node among the anchor's siblings. A comment that trails the anchor on the same
+line stays with the anchor, and the new node is placed after the comment. A new statement is placed on its own line.
+*/
+func PlaceAfter(anchor, newNode *lexer.DocumentNode) bool {
+	if anchor.Parent == nil {
+		return false
+	}
+	if trailing := trailingComments(anchor, false); len(trailing) > 0 {
+		anchor = trailing[len(trailing)-1]
+	}
+	if !anchor.InsertAfterSelf(newNode) {
+		return false
+	}
+	PlaceOnOwnLine(newNode)
+	return true
+}
+
+/*
+Place the new node before the anchor node among the anchor's siblings. Comments that lead the anchor stay with the
+anchor, and the new node is placed before the comments. A new statement is placed on its own line.
+*/
+func PlaceBefore(anchor, newNode *lexer.DocumentNode) bool {
+	if anchor.Parent == nil {
+		return false
+	}
+	if leading := LeadingComments(anchor); len(leading) > 0 {
+		anchor = leading[0]
+	}
+	if !anchor.InsertBeforeSelf(newNode) {
+		return false
+	}
+	PlaceOnOwnLine(newNode)
+	return true
+}
+
+/*
+Adjust new-line characters of the statement held by the node, so that it does not share a line with the text that
+comes before or after it. The node must already be placed among its parent's leaves.
+*/
+func PlaceOnOwnLine(node *lexer.DocumentNode) {
+	if _, ok := node.Entity.(*lexer.Statement); !ok || node.Parent == nil {
+		return
+	}
+	breakLineBefore(node)
+	breakLineAfter(node)
+}
+
+// Return the statement whose text begins the node, or nil if the node begins with a section marker.
+func openingStatement(node *lexer.DocumentNode) *lexer.Statement {
+	switch entity := node.Entity.(type) {
+	case *lexer.Statement:
+		return entity
+	case *lexer.Section:
+		if entity.OpeningPrefix == "" {
+			return entity.FirstStatement
+		}
+	}
+	return nil
+}
+
+// Return the verbatim text that comes right before the node in the document, or an empty string if there is none.
+func textBefore(node *lexer.DocumentNode) string {
+	for ; node.Parent != nil; node = node.Parent {
+		for i := node.GetMyLeafIndex() - 1; i >= 0; i-- {
+			if text := node.Parent.Leaves[i].VerbatimText(); text != "" {
+				return text
+			}
+		}
+		if sect, isSect := node.Parent.Entity.(*lexer.Section); isSect {
+			text := sect.OpeningPrefix
+			if sect.FirstStatement != nil {
+				text += sect.FirstStatement.VerbatimText()
+			}
+			if text += sect.OpeningSuffix; text != "" {
+				return text
+			}
+		}
+	}
+	return ""
+}
+
+// Return the verbatim text that comes right after the node among its siblings or in its parent's closing markers.
+func textAfter(node *lexer.DocumentNode) string {
+	for i := node.GetMyLeafIndex() + 1; i < len(node.Parent.Leaves); i++ {
+		if text := node.Parent.Leaves[i].VerbatimText(); text != "" {
+			return text
+		}
+	}
+	if sect, isSect := node.Parent.Entity.(*lexer.Section); isSect {
+		after := sect.ClosingPrefix
+		if sect.FinalStatement != nil {
+			after += sect.FinalStatement.VerbatimText()
+		}
+		return after + sect.ClosingSuffix
+	}
+	return ""
+}
+
+// Make sure that the node begins on a new line, and that it does not begin with a superfluous new-line character.
+func breakLineBefore(node *lexer.DocumentNode) {
+	stmt := openingStatement(node)
+	if stmt == nil {
+		return
+	}
+	var leading *lexer.Text
+	if len(stmt.Pieces) > 0 {
+		if text, isText := stmt.Pieces[0].(*lexer.Text); isText && text.QuoteStyle == "" && strings.TrimSpace(text.Text) == "" {
+			leading = text
+		}
+	}
+	if before := textBefore(node); before == "" || strings.HasSuffix(before, "\n") {
+		// Already on a new line, the statement itself should not begin with another new line.
+		if leading != nil {
+			leading.Text = strings.Replace(leading.Text, "\n", "", -1)
+		}
+	} else if !strings.HasPrefix(stmt.VerbatimText(), "\n") {
+		if leading != nil {
+			leading.Text = "\n" + leading.Text
+		} else {
+			// The indentation moves along to the new line
+			stmt.Pieces = append([]lexer.ContainVerbatimText{&lexer.Text{Text: "\n", TrailingSpaces: stmt.Indent}}, stmt.Pieces...)
+			stmt.Indent = ""
+		}
+	}
+}
+
+// Make sure that the text following a statement node begins on a new line.
+func breakLineAfter(node *lexer.DocumentNode) {
+	stmt, ok := node.Entity.(*lexer.Statement)
+	if !ok {
+		return
+	}
+	if after := textAfter(node); after != "" && !strings.HasPrefix(after, "\n") && !strings.HasSuffix(stmt.VerbatimText(), "\n") {
+		stmt.Ending += "\n"
+	}
+}
+
+/*
+The lexer occasionally places new-line characters in front of a word in the same text piece (e.g. "\nzone"). Split
+such pieces of the node and its leaves so that each new-line character is held by its own text piece, and the spaces
+that follow the last new-line character become the trailing spaces of that piece. Verbatim text does not change.
+*/
+func SplitLeadingNewLines(node *lexer.DocumentNode) {
+	switch entity := node.Entity.(type) {
+	case *lexer.Statement:
+		splitStatementNewLines(entity)
+	case *lexer.Section:
+		splitStatementNewLines(entity.FirstStatement)
+		splitStatementNewLines(entity.FinalStatement)
+	}
+	for _, leaf := range node.Leaves {
+		SplitLeadingNewLines(leaf)
+	}
+}
+
+func splitStatementNewLines(stmt *lexer.Statement) {
+	if stmt == nil {
+		return
+	}
+	pieces := make([]lexer.ContainVerbatimText, 0, len(stmt.Pieces))
+	for _, piece := range stmt.Pieces {
+		text, ok := piece.(*lexer.Text)
+		if !ok || text.QuoteStyle != "" || !strings.Contains(text.Text, "\n") {
+			pieces = append(pieces, piece)
+			continue
+		}
+		word := strings.TrimLeft(text.Text, " \t\n")
+		leading := text.Text[:len(text.Text)-len(word)]
+		if strings.Contains(word, "\n") {
+			// New-line characters in the middle of a word are left alone
+			pieces = append(pieces, piece)
+			continue
+		}
+		lastNewLine := strings.LastIndex(leading, "\n")
+		spaces := leading[lastNewLine+1:]
+		for i, line := range strings.SplitAfter(leading[:lastNewLine+1], "\n") {
+			if line == "" {
+				continue
+			}
+			newLine := &lexer.Text{Text: line}
+			if i == strings.Count(leading, "\n")-1 {
+				newLine.TrailingSpaces = spaces
+			}
+			pieces = append(pieces, newLine)
+		}
+		if word == "" {
+			pieces[len(pieces)-1].(*lexer.Text).TrailingSpaces += text.TrailingSpaces
+		} else {
+			pieces = append(pieces, &lexer.Text{Text: word, TrailingSpaces: text.TrailingSpaces})
+		}
+	}
+	stmt.Pieces = pieces
+}
+
+// Return true only if the section does not have closing markers, hence it extends to the next section.
+func IsUnclosedSection(sect *lexer.Section) bool {
+	return sect.ClosingPrefix == "" && sect.ClosingSuffix == "" && sect.FinalStatement == nil
+}
+
+func insertPieceAfter(pieces []lexer.ContainVerbatimText, after, newPiece lexer.ContainVerbatimText) []lexer.ContainVerbatimText {
+	ret := make([]lexer.ContainVerbatimText, 0, len(pieces)+1)
+	for _, piece := range pieces {
+		ret = append(ret, piece)
+		if piece == after {
+			ret = append(ret, newPiece)
+		}
+	}
+	return ret
+}
+
+func removePiece(pieces []lexer.ContainVerbatimText, toRemove lexer.ContainVerbatimText) []lexer.ContainVerbatimText {
+	ret := make([]lexer.ContainVerbatimText, 0, len(pieces))
+	for _, piece := range pieces {
+		if piece != toRemove {
+			ret = append(ret, piece)
+		}
+	}
+	return ret
+}
+
+// Return the leading pieces of the statement that carry only spaces and new-line characters.
+func leadingSpaces(stmt *lexer.Statement) []lexer.ContainVerbatimText {
+	for i, piece := range stmt.Pieces {
+		if text, ok := piece.(*lexer.Text); !ok || text.QuoteStyle != "" || strings.TrimSpace(text.Text) != "" {
+			return stmt.Pieces[:i]
+		}
+	}
+	return stmt.Pieces
+}
+
+/*
+Return the statement that is commented out by the node, such as "#Listen 80" of httpd configuration, or nil if the
+node is not a statement made of a single-line comment, or if the comment's content is not a statement of the
+configuration. By convention the text of a commented out statement follows the comment marker immediately, whereas
+a remark written in prose is separated from the marker by a space. The returned statement does not belong to the
+document.
+*/
+func CommentedStatement(node *lexer.DocumentNode, config *lexer.LexerConfig) *lexer.Statement {
+	stmt, ok := node.Entity.(*lexer.Statement)
+	if !ok || len(stmt.Pieces) != len(leadingSpaces(stmt))+1 {
+		return nil
+	}
+	comment, ok := stmt.Pieces[len(stmt.Pieces)-1].(*lexer.Comment)
+	if !ok || strings.Contains(comment.Content, "\n") || strings.TrimSpace(comment.Content) == "" ||
+		strings.TrimLeft(comment.Content, " \t") != comment.Content {
+		return nil
+	}
+	configCopy := *config
+	root := lexer.NewLexer(comment.Content, &configCopy, &lexer.LexerDebugNoop{}).Run()
+	var commented *lexer.Statement
+	for _, leaf := range root.Leaves {
+		if _, _, hasKey := NodeKey(leaf); !hasKey {
+			continue
+		} else if leafStmt, isStmt := leaf.Entity.(*lexer.Statement); isStmt && commented == nil {
+			commented = leafStmt
+		} else {
+			// Sections and multiple statements are not considered commented out directives
+			return nil
+		}
+	}
+	if commented == nil || len(StatementComments(commented)) > 0 {
+		return nil
+	}
+	return commented
+}
+
+/*
+Turn a statement that is commented out (see CommentedStatement) into a working statement. The indentation and the
+spaces in front of the comment marker are kept. Return false if the node is not a commented out statement.
+*/
+func Enable(node *lexer.DocumentNode, config *lexer.LexerConfig) bool {
+	commented := CommentedStatement(node, config)
+	if commented == nil {
+		return false
+	}
+	stmt := node.Entity.(*lexer.Statement)
+	comment := stmt.Pieces[len(stmt.Pieces)-1].(*lexer.Comment)
+	pieces := append([]lexer.ContainVerbatimText{}, leadingSpaces(stmt)...)
+	stmt.Pieces = append(pieces, commented.Pieces[len(leadingSpaces(commented)):]...)
+	ending := commented.Ending
+	if comment.Closed && strings.TrimSpace(comment.CommentStyle.Closing) == "" {
+		ending += comment.CommentStyle.Closing
+	}
+	stmt.Ending = ending + stmt.Ending
+	return true
+}
+
+/*
+Turn the statement held by the node into a comment made of the statement's text, using the first comment style of the
+configuration that ends with the line. The indentation is kept. Return false if the node is not a statement that has a
+key, or if the configuration does not have a suitable comment style.
+*/
+func Disable(node *lexer.DocumentNode, config *lexer.LexerConfig) bool {
+	stmt, ok := node.Entity.(*lexer.Statement)
+	if !ok || len(WordPieces(stmt)) == 0 || len(StatementComments(stmt)) > 0 {
+		return false
+	}
+	var style *lexer.CommentStyle
+	for i, candidate := range config.CommentStyles {
+		if candidate.Opening != "" && (candidate.Closing == "\n" || candidate.Closing == "") {
+			style = &config.CommentStyles[i]
+			break
+		}
+	}
+	if style == nil {
+		return false
+	}
+	leading := leadingSpaces(stmt)
+	var content bytes.Buffer
+	for _, piece := range stmt.Pieces[len(leading):] {
+		content.WriteString(piece.VerbatimText())
+	}
+	// The comment carries the statement ending marker, but not the new-line characters that end the line.
+	lineEnd := len(stmt.Ending) - len(strings.TrimRight(stmt.Ending, "\r\n"))
+	content.WriteString(stmt.Ending[:len(stmt.Ending)-lineEnd])
+	stmt.Pieces = append(append([]lexer.ContainVerbatimText{}, leading...), &lexer.Comment{CommentStyle: *style, Content: content.String()})
+	stmt.Ending = stmt.Ending[len(stmt.Ending)-lineEnd:]
+	return true
+}
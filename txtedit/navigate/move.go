@@ -0,0 +1,458 @@
+package navigate
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+)
+
+/*
+Return the sibling statements that lead the node and belong to it, in document order: comments written on their own
+lines right above the node, and the spaces that indent a section's opening marker. A blank line or another statement
+ends the leading comments.
+*/
+func LeadingComments(node *lexer.DocumentNode) []*lexer.DocumentNode {
+	ret := make([]*lexer.DocumentNode, 0, 0)
+	if node.Parent == nil {
+		return ret
+	}
+	for i := node.GetMyLeafIndex() - 1; i >= 0; i-- {
+		leaf := node.Parent.Leaves[i]
+		stmt, isStmt := leaf.Entity.(*lexer.Statement)
+		if !isStmt || len(StatementTexts(stmt)) > 0 {
+			break
+		}
+		text := stmt.VerbatimText()
+		if indentOnly := text != "" && strings.Trim(text, " \t") == ""; !indentOnly {
+			if len(StatementComments(stmt)) == 0 {
+				break
+			}
+			// A comment that trails the previous statement on the same line belongs to the previous statement
+			if before := textBefore(leaf); before != "" && !strings.HasSuffix(before, "\n") &&
+				!strings.HasPrefix(strings.TrimLeft(text, " \t"), "\n") {
+				break
+			}
+		}
+		ret = append([]*lexer.DocumentNode{leaf}, ret...)
+	}
+	return ret
+}
+
+/*
+Return the sibling statements that finish the line of the node: comments that trail the node on the same line, and
+optionally the statement that holds nothing but the new-line character which ends the line.
+*/
+func trailingComments(node *lexer.DocumentNode, withLineEnd bool) []*lexer.DocumentNode {
+	ret := make([]*lexer.DocumentNode, 0, 0)
+	if node.Parent == nil {
+		return ret
+	}
+	siblings := node.Parent.Leaves
+	text := node.VerbatimText()
+	for i := node.GetMyLeafIndex() + 1; i < len(siblings) && !strings.HasSuffix(text, "\n"); i++ {
+		stmt, isStmt := siblings[i].Entity.(*lexer.Statement)
+		if !isStmt || len(StatementTexts(stmt)) > 0 {
+			break
+		}
+		lineEnd := len(stmt.Pieces) == 0 && stmt.VerbatimText() == "\n"
+		if lineEnd && !withLineEnd || !lineEnd && (len(StatementComments(stmt)) == 0 ||
+			strings.HasPrefix(strings.TrimLeft(stmt.VerbatimText(), " \t"), "\n")) {
+			break
+		}
+		ret = append(ret, siblings[i])
+		text += stmt.VerbatimText()
+	}
+	return ret
+}
+
+// Return the comment statements that trail the node on the same line, e.g. "# why" in "notify yes; # why".
+func TrailingComments(node *lexer.DocumentNode) []*lexer.DocumentNode {
+	return trailingComments(node, false)
+}
+
+// Return the node along with its leading and trailing comments, in document order.
+func nodeGroup(node *lexer.DocumentNode, withLineEnd bool) []*lexer.DocumentNode {
+	group := append(LeadingComments(node), node)
+	return append(group, trailingComments(node, withLineEnd)...)
+}
+
+// A piece of verbatim text; spaces that may indent a line are referred to, so that they can be adjusted.
+type indentItem struct {
+	spaces *string
+	text   string
+}
+
+func collectIndentItems(node *lexer.DocumentNode, items []indentItem) []indentItem {
+	switch entity := node.Entity.(type) {
+	case *lexer.Statement:
+		items = collectStatementItems(entity, items)
+	case *lexer.Section:
+		items = append(items, indentItem{text: entity.OpeningPrefix})
+		items = collectStatementItems(entity.FirstStatement, items)
+		items = append(items, indentItem{text: entity.OpeningSuffix})
+	}
+	for _, leaf := range node.Leaves {
+		items = collectIndentItems(leaf, items)
+	}
+	if sect, ok := node.Entity.(*lexer.Section); ok {
+		items = append(items, indentItem{text: sect.ClosingPrefix})
+		items = collectStatementItems(sect.FinalStatement, items)
+		items = append(items, indentItem{text: sect.ClosingSuffix})
+	}
+	return items
+}
+
+func collectStatementItems(stmt *lexer.Statement, items []indentItem) []indentItem {
+	if stmt == nil {
+		return items
+	}
+	items = append(items, indentItem{spaces: &stmt.Indent})
+	for _, piece := range stmt.Pieces {
+		if text, ok := piece.(*lexer.Text); ok && text.QuoteStyle == "" && strings.TrimSpace(text.Text) == "" {
+			items = append(items, indentItem{text: text.Text}, indentItem{spaces: &text.TrailingSpaces})
+		} else {
+			items = append(items, indentItem{text: piece.VerbatimText()})
+		}
+	}
+	return append(items, indentItem{text: stmt.Ending})
+}
+
+/*
+Return the spaces that indent the lines of the nodes, one for each line that carries content, or nil if the line does
+not begin with spaces. The nodes must be consecutive, atLineStart tells whether the first node begins on a new line.
+*/
+func indentSpaces(nodes []*lexer.DocumentNode, atLineStart bool) []*string {
+	items := make([]indentItem, 0, 16)
+	for _, node := range nodes {
+		items = collectIndentItems(node, items)
+	}
+	ret := make([]*string, 0, 8)
+	for i := 0; i < len(items); i++ {
+		if !atLineStart {
+			atLineStart = items[i].spaces == nil && strings.HasSuffix(items[i].text, "\n")
+			continue
+		}
+		// Among the spaces that lead the line, the first non-empty ones indent the line
+		var indent *string
+		for ; i < len(items) && (items[i].spaces != nil || items[i].text == ""); i++ {
+			if spaces := items[i].spaces; spaces != nil && (indent == nil || *indent == "") {
+				indent = spaces
+			}
+		}
+		if i < len(items) && !strings.HasPrefix(items[i].text, "\n") {
+			ret = append(ret, indent)
+		}
+		i--
+		atLineStart = false
+	}
+	return ret
+}
+
+// Return true only if the node begins on a new line.
+func beginsLine(node *lexer.DocumentNode) bool {
+	before := textBefore(node)
+	return before == "" || strings.HasSuffix(before, "\n")
+}
+
+// Return the indentation of the first line of the consecutive nodes.
+func indentation(nodes []*lexer.DocumentNode) string {
+	if spaces := indentSpaces(nodes, beginsLine(nodes[0])); len(spaces) > 0 && spaces[0] != nil {
+		return *spaces[0]
+	}
+	return ""
+}
+
+/*
+The closing marker of a section may begin a line without spaces in front of it, in which case an empty statement is
+placed at the end of the section's leaves so that the closing marker can be indented.
+*/
+func makeClosingIndent(node *lexer.DocumentNode) {
+	for _, leaf := range node.Leaves {
+		makeClosingIndent(leaf)
+	}
+	sect, ok := node.Entity.(*lexer.Section)
+	if !ok || IsUnclosedSection(sect) || len(node.Leaves) == 0 {
+		return
+	}
+	last := node.Leaves[len(node.Leaves)-1]
+	if stmt, isStmt := last.Entity.(*lexer.Statement); isStmt && len(stmt.Pieces) == 0 && stmt.Ending == "" {
+		return
+	}
+	if strings.HasSuffix(last.VerbatimText(), "\n") {
+		node.Leaves = append(node.Leaves, &lexer.DocumentNode{Parent: node, Entity: &lexer.Statement{}, Leaves: make([]*lexer.DocumentNode, 0, 0)})
+	}
+}
+
+// Replace the indentation prefix of each line of the consecutive nodes.
+func reindent(nodes []*lexer.DocumentNode, from, to string) {
+	for _, node := range nodes {
+		makeClosingIndent(node)
+	}
+	for _, spaces := range indentSpaces(nodes, beginsLine(nodes[0])) {
+		if spaces != nil && strings.HasPrefix(*spaces, from) {
+			*spaces = to + (*spaces)[len(from):]
+		}
+	}
+}
+
+// Return true only if the node is the ancestor or the same as the other node.
+func isAncestor(node, other *lexer.DocumentNode) bool {
+	for ; other != nil; other = other.Parent {
+		if other == node {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Take the node, its leading comments, and the comments that trail the node on the same line, out of the document. Then
+place them at the leaf index of the destination, and replace their indentation by the new indentation.
+*/
+func moveGroup(node, dest *lexer.DocumentNode, index int, toIndent string) bool {
+	group := nodeGroup(node, true)
+	for _, member := range group {
+		if isAncestor(member, dest) {
+			return false
+		}
+	}
+	for _, member := range group {
+		SplitLeadingNewLines(member)
+	}
+	// The node that follows the group may carry the new-line character that ends the group's last line
+	var following *lexer.DocumentNode
+	if last := group[len(group)-1]; last.Parent != nil && last.GetMyLeafIndex()+1 < len(last.Parent.Leaves) {
+		following = last.Parent.Leaves[last.GetMyLeafIndex()+1]
+		SplitLeadingNewLines(following)
+	}
+	fromIndent := indentation(group)
+	for _, member := range group {
+		if member.GetMyLeafIndex() < index && member.Parent == dest {
+			index--
+		}
+		member.DeleteSelf()
+	}
+	for i, member := range group {
+		member.Parent = dest
+		dest.Leaves = append(dest.Leaves[:index+i], append([]*lexer.DocumentNode{member}, dest.Leaves[index+i:]...)...)
+	}
+	breakLineBefore(group[0])
+	last := group[len(group)-1]
+	breakLineAfter(last)
+	if next := index + len(group); next < len(dest.Leaves) && !strings.HasSuffix(last.VerbatimText(), "\n") {
+		breakLineBefore(dest.Leaves[next])
+	}
+	if following != nil && following.Parent != nil {
+		breakLineBefore(following)
+	}
+	reindent(group, fromIndent, toIndent)
+	return true
+}
+
+/*
+Move the node along with its comments to right after the anchor (and the comments that trail the anchor), the node
+takes the indentation of the anchor. The anchor may be in a different section. Return false if the node cannot be
+moved there, for example into itself.
+*/
+func MoveAfter(node, anchor *lexer.DocumentNode) bool {
+	if anchor.Parent == nil || node == anchor {
+		return false
+	}
+	SplitLeadingNewLines(anchor)
+	anchorGroup := nodeGroup(anchor, true)
+	last := anchorGroup[len(anchorGroup)-1]
+	if last == node || isAncestor(node, anchor) {
+		return false
+	}
+	return moveGroup(node, anchor.Parent, last.GetMyLeafIndex()+1, indentation(nodeGroup(anchor, false)))
+}
+
+/*
+Move the node along with its comments to right before the anchor (and the comments that lead the anchor), the node
+takes the indentation of the anchor. The anchor may be in a different section. Return false if the node cannot be
+moved there, for example into itself.
+*/
+func MoveBefore(node, anchor *lexer.DocumentNode) bool {
+	if anchor.Parent == nil || node == anchor || isAncestor(node, anchor) {
+		return false
+	}
+	SplitLeadingNewLines(anchor)
+	anchorGroup := nodeGroup(anchor, false)
+	return moveGroup(node, anchor.Parent, anchorGroup[0].GetMyLeafIndex(), indentation(anchorGroup))
+}
+
+/*
+Move the node along with its comments into the section, after the last leaf that has a key. If the section does not
+yet have such leaf, the node is placed after the section's comments and indented one level deeper than the section.
+A section without closing markers extends to the next section, hence other nodes are placed before such section.
+The node may also be a new node that is not yet placed in a document.
+*/
+func MoveInto(node, section *lexer.DocumentNode) bool {
+	end := len(section.Leaves)
+	if sect, ok := node.Entity.(*lexer.Section); !ok || !IsUnclosedSection(sect) {
+		for i, leaf := range section.Leaves {
+			if sect, ok := leaf.Entity.(*lexer.Section); ok && IsUnclosedSection(sect) {
+				end = i
+				break
+			}
+		}
+	}
+	for i := end - 1; i >= 0; i-- {
+		if _, _, ok := NodeKey(section.Leaves[i]); ok && section.Leaves[i] != node {
+			return MoveAfter(node, section.Leaves[i])
+		}
+	}
+	if isAncestor(node, section) {
+		return false
+	}
+	// Spaces and new-lines at the end of section belong to its closing marker, except the end of a line.
+	for end > 0 {
+		stmt, ok := section.Leaves[end-1].Entity.(*lexer.Statement)
+		if !ok || len(stmt.Pieces) == 0 && stmt.VerbatimText() == "\n" ||
+			len(StatementTexts(stmt)) > 0 || len(StatementComments(stmt)) > 0 {
+			break
+		}
+		end--
+	}
+	toIndent := ""
+	if sect, ok := section.Entity.(*lexer.Section); ok {
+		SplitLeadingNewLines(node)
+		SplitLeadingNewLines(section)
+		toIndent = indentation(nodeGroup(section, false))
+		if !IsUnclosedSection(sect) {
+			toIndent += indentUnit(node)
+		}
+	}
+	return moveGroup(node, section, end, toIndent)
+}
+
+// Learn the unit of indentation from the node's nesting in its current section, tab is the default.
+func indentUnit(node *lexer.DocumentNode) string {
+	if node.Parent == nil {
+		return "\t"
+	}
+	sect, ok := node.Parent.Entity.(*lexer.Section)
+	if !ok || IsUnclosedSection(sect) {
+		return "\t"
+	}
+	return strings.TrimPrefix(indentation(nodeGroup(node, false)), indentation(nodeGroup(node.Parent, false)))
+}
+
+// Remove the node from the document along with its leading and trailing comments. Return false if it has no parent.
+func Remove(node *lexer.DocumentNode) bool {
+	if node.Parent == nil {
+		return false
+	}
+	for _, member := range nodeGroup(node, true) {
+		member.DeleteSelf()
+	}
+	return true
+}
+
+/*
+Sort the leaves of the node that have a key, using the less function. Comments that lead and trail a leaf move along
+with the leaf, whereas blank lines and the comments that do not belong to a leaf stay where they are. The sort is
+stable, and leaves keep their indentation.
+*/
+func Sort(node *lexer.DocumentNode, less func(a, b *lexer.DocumentNode) bool) {
+	type sortGroup struct {
+		node    *lexer.DocumentNode
+		members []*lexer.DocumentNode
+	}
+	groups := make([]sortGroup, 0, len(node.Leaves))
+	firstMembers := make(map[*lexer.DocumentNode]bool)
+	members := make(map[*lexer.DocumentNode]bool)
+	for _, leaf := range node.Leaves {
+		if _, _, ok := NodeKey(leaf); !ok {
+			continue
+		}
+		SplitLeadingNewLines(leaf)
+		group := sortGroup{node: leaf, members: nodeGroup(leaf, false)}
+		groups = append(groups, group)
+		firstMembers[group.members[0]] = true
+		for _, member := range group.members {
+			members[member] = true
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return less(groups[i].node, groups[j].node)
+	})
+	leaves := make([]*lexer.DocumentNode, 0, len(node.Leaves))
+	next := 0
+	for _, leaf := range node.Leaves {
+		if firstMembers[leaf] {
+			leaves = append(leaves, groups[next].members...)
+			next++
+		} else if !members[leaf] {
+			leaves = append(leaves, leaf)
+		}
+	}
+	node.Leaves = leaves
+	for _, group := range groups {
+		breakLineBefore(group.members[0])
+		breakLineAfter(group.members[len(group.members)-1])
+	}
+}
+
+/*
+Compare two values, return a negative number if a comes before b, 0 if they are equal, or a positive number if a comes
+after b. IP addresses and numbers are compared by their magnitude, other values are compared as strings.
+*/
+func CompareValues(a, b string) int {
+	if ipA, ipB := net.ParseIP(a), net.ParseIP(b); ipA != nil && ipB != nil {
+		return bytes.Compare(ipA.To16(), ipB.To16())
+	}
+	numA, errA := strconv.ParseFloat(a, 64)
+	numB, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		if numA < numB {
+			return -1
+		} else if numA > numB {
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+// A less function for Sort, which orders nodes by their key and then by their arguments.
+func ByKey(a, b *lexer.DocumentNode) bool {
+	keyA, argsA, _ := NodeKey(a)
+	keyB, argsB, _ := NodeKey(b)
+	if cmp := CompareValues(keyA, keyB); cmp != 0 {
+		return cmp < 0
+	}
+	for i := 0; i < len(argsA) && i < len(argsB); i++ {
+		if cmp := CompareValues(argsA[i], argsB[i]); cmp != 0 {
+			return cmp < 0
+		}
+	}
+	return len(argsA) < len(argsB)
+}
+
+/*
+Return a less function for Sort, which orders nodes by the last value of a statement of the key. The statement is
+either the node itself, or a leaf of the node if it is a section (e.g. order dhcpd hosts by "fixed-address"). Nodes
+without such statement come first.
+*/
+func ByValueOf(key string) func(a, b *lexer.DocumentNode) bool {
+	return func(a, b *lexer.DocumentNode) bool {
+		return CompareValues(valueOf(a, key), valueOf(b, key)) < 0
+	}
+}
+
+func valueOf(node *lexer.DocumentNode, key string) string {
+	candidates := append([]*lexer.DocumentNode{node}, node.Leaves...)
+	for _, candidate := range candidates {
+		if stmt, ok := candidate.Entity.(*lexer.Statement); ok {
+			if texts := StatementTexts(stmt); len(texts) > 1 && texts[0] == key {
+				return texts[len(texts)-1]
+			}
+		}
+	}
+	return ""
+}
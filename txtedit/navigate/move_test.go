@@ -0,0 +1,139 @@
+package navigate
+
+import (
+	"testing"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer/predef"
+)
+
+func lex(text string, config lexer.LexerConfig) *lexer.DocumentNode {
+	return lexer.NewLexer(text, &config, &lexer.LexerDebugNoop{}).Run()
+}
+
+func mustFind(t *testing.T, root *lexer.DocumentNode, str string) *lexer.DocumentNode {
+	path, err := ParsePath(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := Find(root, path)
+	if node == nil {
+		t.Fatal("cannot find", str)
+	}
+	return node
+}
+
+func TestMove(t *testing.T) {
+	root := lex("options {\n\t// dir\n\tdirectory \"/var\";\n\tnotify yes; # why\n};\nzone \"a\" {\n\ttype master;\n};\n", predef.NamedConf)
+	if !MoveInto(mustFind(t, root, "options/notify"), mustFind(t, root, `zone["a"]`)) {
+		t.Fatal("did not move")
+	}
+	if text := root.VerbatimText(); text != "options {\n\t// dir\n\tdirectory \"/var\";\n};\nzone \"a\" {\n\ttype master;\n\tnotify yes; # why\n};\n" {
+		t.Fatal(text)
+	}
+	// The section moves along with its leaves and closing marker, the comment stays with the directory.
+	if !MoveBefore(mustFind(t, root, `zone["a"]`), mustFind(t, root, "options/directory")) {
+		t.Fatal("did not move")
+	}
+	if text := root.VerbatimText(); text != "options {\n\tzone \"a\" {\n\t\ttype master;\n\t\tnotify yes; # why\n\t};\n\t// dir\n\tdirectory \"/var\";\n};\n" {
+		t.Fatal(text)
+	}
+	if MoveInto(mustFind(t, root, `options`), mustFind(t, root, `options/zone["a"]`)) {
+		t.Fatal("moved into itself")
+	}
+
+	root = lex("<VirtualHost *:80>\n    ServerName a\n    <Directory \"/x\">\n        Options None\n    </Directory>\n</VirtualHost>\n<VirtualHost *:81>\n    ServerName b\n</VirtualHost>\n", predef.HttpdConf)
+	if !MoveAfter(mustFind(t, root, `VirtualHost["*" ":" "80"]/Directory`), mustFind(t, root, `VirtualHost["*" ":" "81"]/ServerName`)) {
+		t.Fatal("did not move")
+	}
+	if text := root.VerbatimText(); text != "<VirtualHost *:80>\n    ServerName a\n</VirtualHost>\n<VirtualHost *:81>\n    ServerName b\n    <Directory \"/x\">\n        Options None\n    </Directory>\n</VirtualHost>\n" {
+		t.Fatal(text)
+	}
+	if !MoveInto(mustFind(t, root, `VirtualHost["*" ":" "81"]/Directory`), root) {
+		t.Fatal("did not move")
+	}
+	if text := root.VerbatimText(); text != "<VirtualHost *:80>\n    ServerName a\n</VirtualHost>\n<VirtualHost *:81>\n    ServerName b\n</VirtualHost>\n<Directory \"/x\">\n    Options None\n</Directory>\n" {
+		t.Fatal(text)
+	}
+
+	root = lex("[Unit]\nDescription=a\n[Service]\nExecStart=/bin/a\nUser=x\n[Install]\n", predef.SystemdConf)
+	if !MoveInto(mustFind(t, root, "Service/User"), mustFind(t, root, "Install")) {
+		t.Fatal("did not move")
+	}
+	if text := root.VerbatimText(); text != "[Unit]\nDescription=a\n[Service]\nExecStart=/bin/a\n[Install]\nUser=x\n" {
+		t.Fatal(text)
+	}
+}
+
+func TestSort(t *testing.T) {
+	root := lex("# fwd\nnet.ipv4.ip_forward = 1\n\n# panic\n# more\nkernel.panic = 5 # inline\nvm.a = 1", predef.SysctlConf)
+	Sort(root, ByKey)
+	if text := root.VerbatimText(); text != "# panic\n# more\nkernel.panic = 5 # inline\n\n# fwd\nnet.ipv4.ip_forward = 1\nvm.a = 1" {
+		t.Fatal(text)
+	}
+	root = lex("net.b = 1\nnet.a = 2", predef.SysctlConf)
+	Sort(root, ByKey)
+	if text := root.VerbatimText(); text != "net.a = 2\nnet.b = 1\n" {
+		t.Fatal(text)
+	}
+	root = lex("subnet 10.0.0.0 netmask 255.0.0.0 {\n  option routers 10.0.0.1;\n  # c\n  host c {\n    fixed-address 10.0.0.10;\n  }\n  host b {\n    fixed-address 10.0.0.9;\n  }\n}\n", predef.DhcpdConf)
+	Sort(root.Leaves[0], ByValueOf("fixed-address"))
+	if text := root.VerbatimText(); text != "subnet 10.0.0.0 netmask 255.0.0.0 {\n  option routers 10.0.0.1;\n  host b {\n    fixed-address 10.0.0.9;\n  }\n  # c\n  host c {\n    fixed-address 10.0.0.10;\n  }\n}\n" {
+		t.Fatal(text)
+	}
+	root = lex("zone \"b\" {\n\ttype slave;\n};\nzone \"a\" {\n\ttype master;\n};\n", predef.NamedConf)
+	Sort(root, ByKey)
+	if text := root.VerbatimText(); text != "zone \"a\" {\n\ttype master;\n};\nzone \"b\" {\n\ttype slave;\n};\n" {
+		t.Fatal(text)
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	if CompareValues("10.0.0.9", "10.0.0.10") >= 0 || CompareValues("9", "10") >= 0 || CompareValues("b", "a") <= 0 ||
+		CompareValues("::1", "::1") != 0 {
+		t.Fatal("wrong order")
+	}
+}
+
+func TestEnableDisable(t *testing.T) {
+	config := predef.HttpdConf
+	root := lex("<VirtualHost *:80>\n  #Listen 80\n  # the name\n  ServerName a\n</VirtualHost>\n", config)
+	vhost := root.Leaves[0]
+	if CommentedStatement(vhost.Leaves[2], &config) != nil {
+		t.Fatal("a remark is not a statement")
+	}
+	if !Enable(vhost.Leaves[1], &config) || Enable(vhost.Leaves[2], &config) {
+		t.Fatal("did not enable")
+	}
+	if !Disable(mustFind(t, root, `VirtualHost["*" ":" "80"]/ServerName`), &config) {
+		t.Fatal("did not disable")
+	}
+	if text := root.VerbatimText(); text != "<VirtualHost *:80>\n  Listen 80\n  # the name\n  #ServerName a\n</VirtualHost>\n" {
+		t.Fatal(text)
+	}
+	if node := mustFind(t, root, `VirtualHost["*" ":" "80"]/Listen`); !Disable(node, &config) || !Enable(node, &config) {
+		t.Fatal("did not disable and enable")
+	}
+	// The statement ending marker is commented out along with the statement
+	config = predef.NamedConf
+	root = lex("options {\n\tnotify yes;\n};\n", config)
+	notify := mustFind(t, root, "options/notify")
+	if !Disable(notify, &config) || root.VerbatimText() != "options {\n\t//notify yes;\n};\n" {
+		t.Fatal(root.VerbatimText())
+	}
+	if !Enable(notify, &config) || root.VerbatimText() != "options {\n\tnotify yes;\n};\n" {
+		t.Fatal(root.VerbatimText())
+	}
+}
+
+func TestAppendToBareSection(t *testing.T) {
+	root := lex("{};\n", predef.NamedConf)
+	sect := root.Leaves[0]
+	if s, ok := sect.Entity.(*lexer.Section); !ok || s.FirstStatement != nil {
+		t.Fatal("not a bare section")
+	}
+	AppendStatement(sect, NewStatement([]string{"notify", "no"}, StatementTemplate(root)))
+	if text := root.VerbatimText(); text != "{\nnotify no\n};\n" {
+		t.Fatalf("%q", text)
+	}
+}
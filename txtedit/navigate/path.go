@@ -0,0 +1,340 @@
+package navigate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+)
+
+/*
+Return the words of a statement, that is the content of its text pieces. Comments and continuation markers are not
+words. Unquoted text is trimmed of surrounding spaces and new-line characters (the lexer occasionally stores them in
+the text), and becomes ignored if nothing remains. Quoted text is kept as-is even if it is empty.
+*/
+func StatementTexts(stmt *lexer.Statement) []string {
+	texts := make([]string, 0, 4)
+	if stmt == nil {
+		return texts
+	}
+	for _, piece := range stmt.Pieces {
+		if text, ok := piece.(*lexer.Text); ok {
+			if text.QuoteStyle != "" {
+				texts = append(texts, text.Text)
+			} else if trimmed := strings.TrimSpace(text.Text); trimmed != "" {
+				texts = append(texts, trimmed)
+			}
+		}
+	}
+	return texts
+}
+
+// Return the content of comment pieces in a statement, each trimmed of surrounding spaces.
+func StatementComments(stmt *lexer.Statement) []string {
+	comments := make([]string, 0, 0)
+	if stmt == nil {
+		return comments
+	}
+	for _, piece := range stmt.Pieces {
+		if comment, ok := piece.(*lexer.Comment); ok {
+			comments = append(comments, strings.TrimSpace(comment.Content))
+		}
+	}
+	return comments
+}
+
+// Return true only if both string slices have the same strings in the same order, e.g. the words of two statements.
+func EqualStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Return true only if the string is among the candidates, e.g. a word among token break markers.
+func IsOneOf(str string, candidates []string) bool {
+	for _, candidate := range candidates {
+		if str == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Return the key and arguments that identify the node.
+A statement is identified by its first word, the remaining words are its values rather than arguments.
+A section is identified by the first word of its opening statement, the remaining words are its arguments.
+Statements made of only comments or spaces do not have a key, and ok will be false.
+*/
+func NodeKey(node *lexer.DocumentNode) (key string, args []string, ok bool) {
+	if node == nil {
+		return
+	}
+	switch entity := node.Entity.(type) {
+	case *lexer.Statement:
+		if texts := StatementTexts(entity); len(texts) > 0 {
+			return texts[0], nil, true
+		}
+	case *lexer.Section:
+		// A section without opening statement is still identifiable, its key is empty.
+		texts := StatementTexts(entity.FirstStatement)
+		if len(texts) == 0 {
+			return "", []string{}, true
+		}
+		return texts[0], texts[1:], true
+	}
+	return
+}
+
+// Segment is a step in a Path, it identifies a statement or section among its siblings.
+type Segment struct {
+	Key     string
+	Args    []string // only sections have arguments
+	Section bool     // the segment identifies a section rather than a statement
+	Index   int      // the occurrence among siblings of the same kind, key and arguments, counting from 0.
+}
+
+// Return true only if the node has the same key as the segment, and node arguments begin with the segment's arguments.
+func (seg Segment) MatchNode(node *lexer.DocumentNode) bool {
+	key, args, ok := NodeKey(node)
+	if !ok || key != seg.Key || len(args) < len(seg.Args) {
+		return false
+	}
+	for i, arg := range seg.Args {
+		if args[i] != arg {
+			return false
+		}
+	}
+	return true
+}
+
+func (seg Segment) String() string {
+	var out bytes.Buffer
+	if seg.Key == "" || strings.ContainsAny(seg.Key, "/[]#\" \t\\") {
+		out.WriteString(strconv.Quote(seg.Key))
+	} else {
+		out.WriteString(seg.Key)
+	}
+	// A section is written with its arguments in brackets, which may be empty.
+	if len(seg.Args) > 0 || seg.Section {
+		out.WriteRune('[')
+		for i, arg := range seg.Args {
+			if i > 0 {
+				out.WriteRune(' ')
+			}
+			out.WriteString(strconv.Quote(arg))
+		}
+		out.WriteRune(']')
+	}
+	if seg.Index > 0 {
+		out.WriteString(fmt.Sprintf("#%d", seg.Index))
+	}
+	return out.String()
+}
+
+/*
+Path identifies a node in a document by walking from the root node through sections.
+The string form of a path looks like: zone["localhost"]/notify, or Service/ExecStart#1. The final segment of a path
+that leads to a section carries brackets (e.g. options[]), the segments before it always lead to sections.
+*/
+type Path []Segment
+
+func (path Path) String() string {
+	segs := make([]string, len(path))
+	for i, seg := range path {
+		if i < len(path)-1 {
+			seg.Section = false
+		}
+		segs[i] = seg.String()
+	}
+	return strings.Join(segs, "/")
+}
+
+// Return the path of the node's parent. Path of the root and top level nodes is empty.
+func (path Path) Parent() Path {
+	if len(path) == 0 {
+		return path
+	}
+	return path[:len(path)-1]
+}
+
+// Return a new path made of this path followed by the segment.
+func (path Path) Append(seg Segment) Path {
+	ret := make(Path, len(path), len(path)+1)
+	copy(ret, path)
+	return append(ret, seg)
+}
+
+var ErrMalformedPath = errors.New("malformed path")
+
+// Parse the string form of a path. See Path.String() for the format.
+func ParsePath(str string) (path Path, err error) {
+	path = make(Path, 0, 4)
+	for pos := 0; pos < len(str); {
+		var seg Segment
+		// Read the key, which may be quoted.
+		if str[pos] == '"' {
+			var quoted string
+			if quoted, err = strconv.QuotedPrefix(str[pos:]); err != nil {
+				return nil, ErrMalformedPath
+			}
+			seg.Key, _ = strconv.Unquote(quoted)
+			pos += len(quoted)
+		} else {
+			end := pos
+			for end < len(str) && strings.IndexByte("/[#", str[end]) == -1 {
+				end++
+			}
+			seg.Key = str[pos:end]
+			pos = end
+		}
+		// Read the arguments
+		if pos < len(str) && str[pos] == '[' {
+			seg.Section = true
+			seg.Args = make([]string, 0, 2)
+			for pos++; ; {
+				for pos < len(str) && str[pos] == ' ' {
+					pos++
+				}
+				if pos >= len(str) {
+					return nil, ErrMalformedPath
+				} else if str[pos] == ']' {
+					pos++
+					break
+				}
+				quoted, err := strconv.QuotedPrefix(str[pos:])
+				if err != nil {
+					return nil, ErrMalformedPath
+				}
+				arg, _ := strconv.Unquote(quoted)
+				seg.Args = append(seg.Args, arg)
+				pos += len(quoted)
+			}
+		}
+		// Read the occurrence index
+		if pos < len(str) && str[pos] == '#' {
+			end := pos + 1
+			for end < len(str) && str[end] >= '0' && str[end] <= '9' {
+				end++
+			}
+			if seg.Index, err = strconv.Atoi(str[pos+1 : end]); err != nil {
+				return nil, ErrMalformedPath
+			}
+			pos = end
+		}
+		if pos < len(str) {
+			if str[pos] != '/' {
+				return nil, ErrMalformedPath
+			}
+			pos++
+		}
+		path = append(path, seg)
+	}
+	return path, nil
+}
+
+/*
+Return the segment that identifies the node among its siblings, the index counts the previous siblings of identical
+key and arguments. Return ok being false if the node does not have a key.
+*/
+func NodeSegment(node *lexer.DocumentNode) (seg Segment, ok bool) {
+	key, args, ok := NodeKey(node)
+	if !ok {
+		return
+	}
+	seg = Segment{Key: key, Args: args, Section: IsSection(node)}
+	if node.Parent == nil {
+		return
+	}
+	for _, sibling := range node.Parent.Leaves {
+		if sibling == node {
+			break
+		}
+		siblingKey, siblingArgs, siblingOK := NodeKey(sibling)
+		if siblingOK && siblingKey == key && strings.Join(siblingArgs, "\x00") == strings.Join(args, "\x00") &&
+			IsSection(sibling) == IsSection(node) {
+			seg.Index++
+		}
+	}
+	return
+}
+
+/*
+Return the path that leads from the document root to the node. Return ok being false if the node or any of its
+parent sections does not have a key.
+*/
+func NodePath(node *lexer.DocumentNode) (path Path, ok bool) {
+	path = make(Path, 0, 4)
+	for ; node != nil && node.Parent != nil; node = node.Parent {
+		seg, segOK := NodeSegment(node)
+		if !segOK {
+			return nil, false
+		}
+		path = append(Path{seg}, path...)
+	}
+	return path, true
+}
+
+/*
+Walk down from the node along the path, return the node found at the end of the path, or nil if it is not found.
+A segment that carries fewer arguments than a section may match the section by the leading arguments, though
+sections of exactly matching arguments are preferred. The segment index counts among the matched nodes of the same
+kind. The final segment that does not specify a section leads to a statement, or to a section if there is not such
+statement.
+*/
+func Find(node *lexer.DocumentNode, path Path) *lexer.DocumentNode {
+	for i, seg := range path {
+		// Only sections are walked through, the final segment may point to a statement as well.
+		var next *lexer.DocumentNode
+		if i == len(path)-1 && !seg.Section && len(seg.Args) == 0 {
+			next = findLeaf(node, seg, false, true)
+		}
+		if next == nil {
+			next = findLeaf(node, seg, true, true)
+		}
+		if next == nil {
+			next = findLeaf(node, seg, true, false)
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+// Return the leaf of the kind that matches the segment, or nil if it is not found.
+func findLeaf(node *lexer.DocumentNode, seg Segment, section, exactArgs bool) *lexer.DocumentNode {
+	occurrence := 0
+	for _, leaf := range node.Leaves {
+		if IsSection(leaf) != section || !seg.MatchNode(leaf) {
+			continue
+		}
+		if _, args, _ := NodeKey(leaf); exactArgs && len(args) != len(seg.Args) {
+			continue
+		}
+		if occurrence == seg.Index {
+			return leaf
+		}
+		occurrence++
+	}
+	return nil
+}
+
+// Return true only if the node holds a section.
+func IsSection(node *lexer.DocumentNode) bool {
+	if node == nil {
+		return false
+	}
+	_, ok := node.Entity.(*lexer.Section)
+	return ok
+}
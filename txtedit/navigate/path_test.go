@@ -0,0 +1,85 @@
+package navigate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer/predef"
+)
+
+func TestParsePath(t *testing.T) {
+	for _, str := range []string{``, `a`, `zone["localhost" "in"]/notify`, `Service/ExecStart#1`, `"*/5"/"a b"#2`, `""["x"]`, `options/also-notify[]#1`} {
+		path, err := ParsePath(str)
+		if err != nil {
+			t.Fatal(str, err)
+		}
+		if path.String() != str {
+			t.Fatal(str, path.String())
+		}
+	}
+	for _, str := range []string{`a["b"`, `a[b]`, `a#x`, `"a`} {
+		if _, err := ParsePath(str); err == nil {
+			t.Fatal("did not fail", str)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	config := predef.NamedConf
+	root := lexer.NewLexer(`options {
+	notify yes;
+};
+zone "localhost" in {
+	type master;
+	also-notify { 10.0.0.1; };
+	also-notify { 10.0.0.2; };
+};
+`, &config, &lexer.LexerDebugNoop{}).Run()
+	path, _ := ParsePath(`zone["localhost"]/type`)
+	node := Find(root, path)
+	if texts := StatementTexts(node.Entity.(*lexer.Statement)); len(texts) != 2 || texts[1] != "master" {
+		t.Fatal(texts)
+	}
+	path, _ = ParsePath(`zone["localhost"]/also-notify#1`)
+	node = Find(root, path)
+	if !IsSection(node) {
+		t.Fatal(lexer.DebugNode(node, 0))
+	}
+	if nodePath, ok := NodePath(node); !ok || nodePath.String() != `zone["localhost" "in"]/also-notify[]#1` {
+		t.Fatal(nodePath)
+	}
+	if Find(root, Path{{Key: "zone", Args: []string{"nonexistent"}}}) != nil {
+		t.Fatal("should not find")
+	}
+}
+
+func TestFindNodePath(t *testing.T) {
+	config := predef.NamedConf
+	root := lexer.NewLexer("foo {\n\ta;\n};\nfoo;\nfoo {\n\tb;\n};\nfoo x;\nbar \"1\" {\n\tfoo;\n\tfoo {\n\t\tc;\n\t};\n};\n",
+		&config, &lexer.LexerDebugNoop{}).Run()
+	var walk func(node *lexer.DocumentNode)
+	count := 0
+	walk = func(node *lexer.DocumentNode) {
+		for _, leaf := range node.Leaves {
+			if path, ok := NodePath(leaf); ok {
+				if found := Find(root, path); found != leaf {
+					t.Fatal(path)
+				}
+				count++
+			}
+			walk(leaf)
+		}
+	}
+	walk(root)
+	if count != 10 {
+		t.Fatal(count)
+	}
+	// A path that does not specify a section prefers the statement
+	if path, _ := ParsePath("foo"); IsSection(Find(root, path)) {
+		t.Fatal("found the section")
+	}
+	if path, _ := ParsePath("foo[]#1"); !strings.Contains(Find(root, path).VerbatimText(), "b;") {
+		t.Fatal("did not find the second section")
+	}
+}
@@ -0,0 +1,435 @@
+package patch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/diff"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/navigate"
+)
+
+const (
+	OP_SET     = "set"     // set values of a statement, create the statement if it does not yet exist.
+	OP_INSERT  = "insert"  // insert a statement or section given in verbatim text.
+	OP_REMOVE  = "remove"  // remove a statement or section.
+	OP_ENABLE  = "enable"  // uncomment a statement that is commented out, optionally set its values.
+	OP_DISABLE = "disable" // comment out a statement.
+	OP_MOVE    = "move"    // move a statement or section to a different place.
+)
+
+const (
+	STATUS_APPLIED   = "applied"   // the operation has changed the document
+	STATUS_SATISFIED = "satisfied" // the document already looked like what the operation intends to do
+	STATUS_FAILED    = "failed"    // the document does not meet the operation's preconditions
+)
+
+/*
+Operation is a change to a single node of a document, the node is identified by its path rather than line number, so
+that the operation may be replayed against another copy of the same file that has different comments or layout.
+*/
+type Operation struct {
+	Op     string   `json:"op"`
+	Path   string   `json:"path"`             // path of the node to change, see navigate.Path.
+	Values []string `json:"values,omitempty"` // the words that follow statement key (set, enable)
+	Expect []string `json:"expect,omitempty"` // precondition - the statement must currently carry these values (set, remove, disable)
+	Text   string   `json:"text,omitempty"`   // verbatim text of the new node (insert), or of the node before it moves (move)
+	After  string   `json:"after,omitempty"`  // path of the sibling that the node is placed after (insert, move)
+	From   string   `json:"from,omitempty"`   // path of the node before it moves (move)
+}
+
+func (op Operation) String() string {
+	path, _ := navigate.ParsePath(op.Path)
+	where := "at top level"
+	if parent := path.Parent(); len(parent) > 0 {
+		where = "in section " + parent.String()
+	}
+	switch op.Op {
+	case OP_SET:
+		return fmt.Sprintf("%s set %s=%s", where, op.Path, strings.Join(op.Values, " "))
+	case OP_INSERT:
+		return fmt.Sprintf("%s insert %s", where, op.Path)
+	case OP_MOVE:
+		return fmt.Sprintf("%s move %s to %s", where, op.From, op.Path)
+	}
+	return fmt.Sprintf("%s %s %s", where, op.Op, op.Path)
+}
+
+// Patch is a list of operations applied in order.
+type Patch struct {
+	Operations []Operation `json:"operations"`
+}
+
+// Serialise the patch into JSON.
+func (patch Patch) JSON() ([]byte, error) {
+	return json.MarshalIndent(patch, "", "  ")
+}
+
+// Deserialise a patch from JSON.
+func FromJSON(serialised []byte) (patch Patch, err error) {
+	err = json.Unmarshal(serialised, &patch)
+	return
+}
+
+/*
+Create a patch out of the semantic changes between two documents. Changes to comments and spaces are not part of the
+patch. The values of changed and removed statements, and the text of moved nodes, become preconditions of the
+operations.
+*/
+func FromChanges(changes []diff.Change) Patch {
+	patch := Patch{Operations: make([]Operation, 0, len(changes))}
+	for _, change := range changes {
+		switch change.Kind {
+		case diff.CHANGE_MODIFIED:
+			patch.Operations = append(patch.Operations, Operation{Op: OP_SET, Path: change.Path,
+				Values: change.NewValues, Expect: nonNil(change.OldValues)})
+		case diff.CHANGE_ADDED:
+			patch.Operations = append(patch.Operations, insertOperation(change))
+		case diff.CHANGE_REMOVED:
+			op := Operation{Op: OP_REMOVE, Path: change.Path}
+			if change.NodeType == diff.NODE_STATEMENT {
+				op.Expect = nonNil(change.OldValues)
+			}
+			patch.Operations = append(patch.Operations, op)
+		case diff.CHANGE_MOVED:
+			patch.Operations = append(patch.Operations, Operation{Op: OP_MOVE, Path: change.Path,
+				From: change.OldPath, Text: change.OldText, After: precedingPath(change.NewNode)})
+		}
+	}
+	return patch
+}
+
+// Create an operation that inserts the new node of the change, after its preceding sibling that has a key.
+func insertOperation(change diff.Change) Operation {
+	return Operation{Op: OP_INSERT, Path: change.Path, Text: change.NewText, After: precedingPath(change.NewNode)}
+}
+
+// Return the path of the node's preceding sibling that has a key, or an empty string if there is not one.
+func precedingPath(node *lexer.DocumentNode) string {
+	if sibling := precedingSibling(node); sibling != nil {
+		if path, ok := navigate.NodePath(sibling); ok {
+			return path.String()
+		}
+	}
+	return ""
+}
+
+// Return the node's preceding sibling that has a key, or nil if there is not one.
+func precedingSibling(node *lexer.DocumentNode) *lexer.DocumentNode {
+	if node == nil || node.Parent == nil {
+		return nil
+	}
+	siblings := node.Parent.Leaves
+	for i := node.GetMyLeafIndex() - 1; i >= 0; i-- {
+		if _, _, ok := navigate.NodeKey(siblings[i]); ok {
+			return siblings[i]
+		}
+	}
+	return nil
+}
+
+// A statement without values is expected to carry no values, which is different from having no expectation at all.
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// OperationResult tells the outcome of an operation.
+type OperationResult struct {
+	Operation Operation `json:"operation"`
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason,omitempty"` // why the operation failed
+}
+
+// Return true only if all operations are either applied or satisfied.
+func Succeeded(results []OperationResult) bool {
+	for _, result := range results {
+		if result.Status == STATUS_FAILED {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Apply the operations in order to the document, which is modified in-place. An operation whose preconditions fail is
+skipped, and the remaining operations still get their turn. The config is used for lexing the text of inserted nodes.
+Applying the same patch twice results in all operations being satisfied the second time.
+*/
+func Apply(root *lexer.DocumentNode, config *lexer.LexerConfig, patch Patch) []OperationResult {
+	results := make([]OperationResult, 0, len(patch.Operations))
+	for _, op := range patch.Operations {
+		result := OperationResult{Operation: op, Status: STATUS_APPLIED}
+		satisfied, err := applyOperation(root, config, op)
+		if err != nil {
+			result.Status = STATUS_FAILED
+			result.Reason = err.Error()
+		} else if satisfied {
+			result.Status = STATUS_SATISFIED
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Apply a single operation. Return satisfied being true if the operation did not have to change the document.
+func applyOperation(root *lexer.DocumentNode, config *lexer.LexerConfig, op Operation) (satisfied bool, err error) {
+	path, err := navigate.ParsePath(op.Path)
+	if err != nil {
+		return false, err
+	} else if len(path) == 0 {
+		return false, fmt.Errorf("operation path is empty")
+	}
+	node := navigate.Find(root, path)
+	var parent *lexer.DocumentNode
+	if parentPath := path.Parent(); len(parentPath) == 0 {
+		parent = root
+	} else if parent = navigate.Find(root, parentPath); parent == nil || !navigate.IsSection(parent) {
+		return false, fmt.Errorf("section %s does not exist", parentPath.String())
+	}
+	switch op.Op {
+	case OP_SET:
+		return setValues(parent, node, path, config, op)
+	case OP_INSERT:
+		return insert(root, parent, node, path, config, op)
+	case OP_REMOVE, OP_DISABLE:
+		if node == nil {
+			return true, nil
+		}
+		if op.Expect != nil {
+			if current := statementValues(node); current == nil || !navigate.EqualStrings(current, op.Expect) {
+				return false, fmt.Errorf("%s is expected to be %v, but it is %v", op.Path, op.Expect, current)
+			}
+		}
+		if op.Op == OP_REMOVE {
+			node.DeleteSelf()
+		} else if !navigate.Disable(node, config) {
+			return false, fmt.Errorf("%s cannot be commented out", op.Path)
+		}
+		return false, nil
+	case OP_ENABLE:
+		return enable(parent, node, path, config, op)
+	case OP_MOVE:
+		return move(root, parent, node, config, op)
+	}
+	return false, fmt.Errorf("unknown operation \"%s\"", op.Op)
+}
+
+// Return the words that follow the statement key, or nil if the node is not a statement.
+func statementValues(node *lexer.DocumentNode) []string {
+	stmt, ok := node.Entity.(*lexer.Statement)
+	if !ok {
+		return nil
+	}
+	texts := navigate.StatementTexts(stmt)
+	if len(texts) == 0 {
+		return nil
+	}
+	return texts[1:]
+}
+
+func setValues(parent, node *lexer.DocumentNode, path navigate.Path, config *lexer.LexerConfig, op Operation) (satisfied bool, err error) {
+	if node == nil {
+		if op.Expect != nil {
+			return false, fmt.Errorf("%s is expected to be %v, but it does not exist", op.Path, op.Expect)
+		}
+		last := path[len(path)-1]
+		words := append([]string{last.Key}, op.Values...)
+		if template := navigate.StatementTemplate(parent); template != nil {
+			navigate.AppendStatement(parent, navigate.NewStatement(words, template))
+			return false, nil
+		}
+		/*
+			Without a template, the statement takes the default ending marker and the indentation of the section. The
+			placement breaks lines as necessary, hence the marker does not bring along its new-line character.
+		*/
+		stmt := navigate.NewStatement(words, nil)
+		if len(config.StatementEndingMarkers) > 0 {
+			stmt.Ending = strings.TrimRight(config.StatementEndingMarkers[0], "\n")
+		}
+		navigate.MoveInto(&lexer.DocumentNode{Entity: stmt, Leaves: make([]*lexer.DocumentNode, 0, 0)}, parent)
+		return false, nil
+	}
+	current := statementValues(node)
+	if current == nil {
+		return false, fmt.Errorf("%s is not a statement", op.Path)
+	} else if navigate.EqualStrings(current, op.Values) {
+		return true, nil
+	} else if op.Expect != nil && !navigate.EqualStrings(current, op.Expect) {
+		return false, fmt.Errorf("%s is expected to be %v, but it is %v", op.Path, op.Expect, current)
+	}
+	navigate.SetStatementValues(node.Entity.(*lexer.Statement), op.Values)
+	return false, nil
+}
+
+func enable(parent, node *lexer.DocumentNode, path navigate.Path, config *lexer.LexerConfig, op Operation) (satisfied bool, err error) {
+	if node == nil {
+		// Uncomment the first commented out statement that has the key
+		last := path[len(path)-1]
+		for _, leaf := range parent.Leaves {
+			if commented := navigate.CommentedStatement(leaf, config); commented != nil &&
+				last.MatchNode(&lexer.DocumentNode{Entity: commented}) {
+				node = leaf
+				break
+			}
+		}
+		if node == nil {
+			return false, fmt.Errorf("%s is not commented out", op.Path)
+		}
+		navigate.Enable(node, config)
+	} else if _, isStmt := node.Entity.(*lexer.Statement); !isStmt {
+		return false, fmt.Errorf("%s is not a statement", op.Path)
+	} else {
+		satisfied = true
+	}
+	if op.Values != nil && !navigate.EqualStrings(statementValues(node), op.Values) {
+		navigate.SetStatementValues(node.Entity.(*lexer.Statement), op.Values)
+		satisfied = false
+	}
+	return satisfied, nil
+}
+
+/*
+Lex the text of the node at the path. The text must carry exactly the node of the path, so that nothing else slips in
+under the guise of the path.
+*/
+func lexNode(text string, path navigate.Path, config *lexer.LexerConfig) (newRoot, newNode *lexer.DocumentNode, err error) {
+	configCopy := *config
+	newRoot = lexer.NewLexer(text, &configCopy, &lexer.LexerDebugNoop{}).Run()
+	for _, leaf := range newRoot.Leaves {
+		if _, _, ok := navigate.NodeKey(leaf); !ok {
+			continue
+		} else if newNode != nil {
+			return nil, nil, fmt.Errorf("text of %s contains more than one statement or section", path.String())
+		}
+		newNode = leaf
+	}
+	if newNode == nil {
+		return nil, nil, fmt.Errorf("text of %s does not contain a statement or section", path.String())
+	} else if !path[len(path)-1].MatchNode(newNode) {
+		return nil, nil, fmt.Errorf("text of %s contains a different statement or section", path.String())
+	}
+	return
+}
+
+func insert(root, parent, node *lexer.DocumentNode, path navigate.Path, config *lexer.LexerConfig, op Operation) (satisfied bool, err error) {
+	newRoot, newNode, err := lexNode(op.Text, path, config)
+	if err != nil {
+		return false, err
+	}
+	if node != nil {
+		if sameContent(node, newNode) {
+			return true, nil
+		}
+		return false, fmt.Errorf("%s already exists with different content", op.Path)
+	}
+	// Comments and spaces that surround the node in the text are placed along with the node
+	toPlace := make([]*lexer.DocumentNode, 0, len(newRoot.Leaves))
+	for _, leaf := range newRoot.Leaves {
+		if leaf.Entity != nil {
+			toPlace = append(toPlace, leaf)
+		}
+	}
+	anchor := findAnchor(root, parent, op)
+	if anchor != nil {
+		for i := len(toPlace) - 1; i >= 0; i-- {
+			navigate.PlaceAfter(anchor, toPlace[i])
+		}
+	} else if stmt, isStmt := newNode.Entity.(*lexer.Statement); isStmt && len(toPlace) == 1 {
+		navigate.AppendStatement(parent, stmt)
+	} else {
+		for _, leaf := range toPlace {
+			leaf.Parent = parent
+			parent.Leaves = append(parent.Leaves, leaf)
+		}
+	}
+	return false, nil
+}
+
+// Return the sibling that the node of the operation is placed after, or nil if it is not among the parent's leaves.
+func findAnchor(root, parent *lexer.DocumentNode, op Operation) *lexer.DocumentNode {
+	if op.After == "" {
+		return nil
+	}
+	afterPath, err := navigate.ParsePath(op.After)
+	if err != nil {
+		return nil
+	}
+	if anchor := navigate.Find(root, afterPath); anchor != nil && anchor.Parent == parent {
+		return anchor
+	}
+	return nil
+}
+
+/*
+Move the node at the From path to right after the anchor, or to the front of the parent if the operation does not have
+an anchor. The operation is satisfied if the node at the path already follows the anchor. If the anchor no longer
+exists, the node is placed at the end of the parent, unless the node is already found at the path.
+*/
+func move(root, parent, node *lexer.DocumentNode, config *lexer.LexerConfig, op Operation) (satisfied bool, err error) {
+	anchor := findAnchor(root, parent, op)
+	if node != nil && (precedingSibling(node) == anchor || anchor == nil && op.After != "") {
+		return true, nil
+	}
+	fromPath, err := navigate.ParsePath(op.From)
+	if err != nil {
+		return false, err
+	} else if len(fromPath) == 0 {
+		return false, fmt.Errorf("operation path to move from is empty")
+	}
+	source := navigate.Find(root, fromPath)
+	if source == nil {
+		return false, fmt.Errorf("%s does not exist", op.From)
+	} else if node != nil && node != source {
+		return false, fmt.Errorf("%s already exists", op.Path)
+	}
+	if op.Text != "" {
+		_, oldNode, err := lexNode(op.Text, fromPath, config)
+		if err != nil {
+			return false, err
+		} else if !sameContent(source, oldNode) {
+			return false, fmt.Errorf("%s has different content than expected", op.From)
+		}
+	}
+	var moved bool
+	if anchor != nil {
+		moved = navigate.MoveAfter(source, anchor)
+	} else if first := firstKeyed(parent, source); op.After == "" && first != nil {
+		moved = navigate.MoveBefore(source, first)
+	} else {
+		moved = navigate.MoveInto(source, parent)
+	}
+	if !moved {
+		return false, fmt.Errorf("%s cannot be moved to %s", op.From, op.Path)
+	}
+	return false, nil
+}
+
+// Return the first leaf of the parent that has a key, other than the node.
+func firstKeyed(parent, node *lexer.DocumentNode) *lexer.DocumentNode {
+	for _, leaf := range parent.Leaves {
+		if _, _, ok := navigate.NodeKey(leaf); ok && leaf != node {
+			return leaf
+		}
+	}
+	return nil
+}
+
+// Return true only if the two nodes do not have semantic differences.
+func sameContent(a, b *lexer.DocumentNode) bool {
+	if navigate.IsSection(a) != navigate.IsSection(b) {
+		return false
+	}
+	if !navigate.IsSection(a) {
+		return navigate.EqualStrings(navigate.StatementTexts(a.Entity.(*lexer.Statement)), navigate.StatementTexts(b.Entity.(*lexer.Statement)))
+	}
+	for _, change := range diff.Compare(a, b) {
+		if change.IsSemantic() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,220 @@
+package patch
+
+import (
+	"testing"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/diff"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer/predef"
+)
+
+func lex(text string, config lexer.LexerConfig) *lexer.DocumentNode {
+	return lexer.NewLexer(text, &config, &lexer.LexerDebugNoop{}).Run()
+}
+
+var before = `options {
+	directory "/var/lib/named";
+};
+zone "localhost" in {
+	type master;
+	notify yes;
+};
+`
+
+var after = `options {
+	directory "/var/lib/named";
+	forward first;
+};
+zone "localhost" in {
+	type master;
+	notify no;
+};
+zone "example.com" in {
+	type slave;
+};
+`
+
+// Another host's copy of the file has different comments and layout
+var otherHost = `# Managed by hand
+options {
+	directory "/var/lib/named"; // data
+};
+
+zone "localhost" in {
+	// local zone
+	type   master;
+	notify yes;
+};
+`
+
+var expected = `# Managed by hand
+options {
+	directory "/var/lib/named"; // data
+	forward first;
+};
+
+zone "localhost" in {
+	// local zone
+	type   master;
+	notify no;
+};
+zone "example.com" in {
+	type slave;
+};
+`
+
+func TestApply(t *testing.T) {
+	patch := FromChanges(diff.Compare(lex(before, predef.NamedConf), lex(after, predef.NamedConf)))
+	serialised, err := patch.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patch, err = FromJSON(serialised); err != nil || len(patch.Operations) != 3 {
+		t.Fatal(err, string(serialised))
+	}
+	doc := lex(otherHost, predef.NamedConf)
+	config := predef.NamedConf
+	results := Apply(doc, &config, patch)
+	if !Succeeded(results) {
+		t.Fatal(results)
+	}
+	for _, result := range results {
+		if result.Status != STATUS_APPLIED {
+			t.Fatal(result)
+		}
+	}
+	if text := doc.VerbatimText(); text != expected {
+		t.Fatal(text)
+	}
+	// Applying again changes nothing
+	results = Apply(doc, &config, patch)
+	for _, result := range results {
+		if result.Status != STATUS_SATISFIED {
+			t.Fatal(result)
+		}
+	}
+	if text := doc.VerbatimText(); text != expected {
+		t.Fatal(text)
+	}
+}
+
+func TestApplyPrecondition(t *testing.T) {
+	doc := lex("net.ipv4.ip_forward = 0\nkernel.panic = 5\n", predef.SysctlConf)
+	config := predef.SysctlConf
+	results := Apply(doc, &config, Patch{Operations: []Operation{
+		{Op: OP_SET, Path: "net.ipv4.ip_forward", Values: []string{"=", "1"}, Expect: []string{"=", "0"}},
+		{Op: OP_SET, Path: "kernel.panic", Values: []string{"=", "10"}, Expect: []string{"=", "0"}},
+		{Op: OP_REMOVE, Path: "vm.swappiness"},
+		{Op: OP_SET, Path: "vm.swappiness", Values: []string{"=", "10"}},
+		{Op: OP_SET, Path: "nonexistent/key", Values: []string{"1"}},
+	}})
+	statuses := []string{STATUS_APPLIED, STATUS_FAILED, STATUS_SATISFIED, STATUS_APPLIED, STATUS_FAILED}
+	for i, result := range results {
+		if result.Status != statuses[i] {
+			t.Fatal(i, result)
+		}
+	}
+	if text := doc.VerbatimText(); text != "net.ipv4.ip_forward = 1\nkernel.panic = 5\nvm.swappiness = 10\n" {
+		t.Fatal(text)
+	}
+}
+
+func TestApplySetWithoutTemplate(t *testing.T) {
+	config := predef.NamedConf
+	doc := lex("options {\n};\n", config)
+	results := Apply(doc, &config, Patch{Operations: []Operation{
+		{Op: OP_SET, Path: "options/notify", Values: []string{"no"}},
+		{Op: OP_SET, Path: "forwarders", Values: []string{"x"}},
+	}})
+	if !Succeeded(results) {
+		t.Fatal(results)
+	}
+	if text := doc.VerbatimText(); text != "options {\n\tnotify no;\n};\nforwarders x;\n" {
+		t.Fatalf("%q", text)
+	}
+}
+
+func TestApplyEnableDisable(t *testing.T) {
+	doc := lex("#kernel.panic = 5\n# a remark\nvm.swappiness = 10\n", predef.SysctlConf)
+	config := predef.SysctlConf
+	patch := Patch{Operations: []Operation{
+		{Op: OP_ENABLE, Path: "kernel.panic", Values: []string{"=", "10"}},
+		{Op: OP_DISABLE, Path: "vm.swappiness", Expect: []string{"=", "10"}},
+		{Op: OP_ENABLE, Path: "net.ipv4.ip_forward"},
+	}}
+	results := Apply(doc, &config, patch)
+	statuses := []string{STATUS_APPLIED, STATUS_APPLIED, STATUS_FAILED}
+	for i, result := range results {
+		if result.Status != statuses[i] {
+			t.Fatal(i, result)
+		}
+	}
+	if text := doc.VerbatimText(); text != "kernel.panic = 10\n# a remark\n#vm.swappiness = 10\n" {
+		t.Fatal(text)
+	}
+	results = Apply(doc, &config, Patch{Operations: patch.Operations[:2]})
+	for _, result := range results {
+		if result.Status != STATUS_SATISFIED {
+			t.Fatal(result)
+		}
+	}
+}
+
+func TestApplyInsertMismatch(t *testing.T) {
+	doc := lex(before, predef.NamedConf)
+	config := predef.NamedConf
+	results := Apply(doc, &config, Patch{Operations: []Operation{
+		{Op: OP_INSERT, Path: `zone["x"]`, Text: "options { notify no; };\n"},
+		{Op: OP_INSERT, Path: `zone["x"]`, Text: "zone \"x\" { type slave; };\noptions { notify no; };\n"},
+		{Op: OP_INSERT, Path: `zone["x"]`, Text: "# nothing\n"},
+		{Op: OP_INSERT, Path: `zone["x"]`, Text: "zone \"x\" { type slave; };\n"},
+	}})
+	statuses := []string{STATUS_FAILED, STATUS_FAILED, STATUS_FAILED, STATUS_APPLIED}
+	for i, result := range results {
+		if result.Status != statuses[i] {
+			t.Fatal(i, result)
+		}
+	}
+}
+
+func TestApplyMove(t *testing.T) {
+	for _, test := range []struct {
+		config                 lexer.LexerConfig
+		before, after, onOther string
+		expected               string
+	}{
+		{predef.SysctlConf, "a = 1\nb = 2\nc = 3\n", "b = 2\nc = 3\na = 1\n",
+			"a=1\nb = 2\n\nc = 3\n", "b = 2\n\nc = 3\na=1\n"},
+		{predef.NamedConf, "zone \"a\" {\n\ttype master;\n};\nzone \"b\" {\n\ttype slave;\n};\n",
+			"zone \"b\" {\n\ttype slave;\n};\nzone \"a\" {\n\ttype master;\n};\n",
+			"zone \"a\" {\n\ttype master;\n};\nzone \"b\" {\n\ttype slave;\n};\n",
+			"zone \"b\" {\n\ttype slave;\n};\nzone \"a\" {\n\ttype master;\n};\n"},
+	} {
+		config := test.config
+		patch := FromChanges(diff.Compare(lex(test.before, config), lex(test.after, config)))
+		if len(patch.Operations) != 1 || patch.Operations[0].Op != OP_MOVE {
+			t.Fatal(patch.Operations)
+		}
+		doc := lex(test.onOther, config)
+		if results := Apply(doc, &config, patch); results[0].Status != STATUS_APPLIED {
+			t.Fatal(results)
+		}
+		if text := doc.VerbatimText(); text != test.expected {
+			t.Fatal(text)
+		}
+		// Applying again changes nothing
+		if results := Apply(doc, &config, patch); results[0].Status != STATUS_SATISFIED {
+			t.Fatal(results)
+		}
+		if text := doc.VerbatimText(); text != test.expected {
+			t.Fatal(text)
+		}
+	}
+	// The node to move must carry the content it had when the patch was made
+	config := predef.SysctlConf
+	doc := lex("a = 5\nb = 2\n", config)
+	results := Apply(doc, &config, Patch{Operations: []Operation{{Op: OP_MOVE, Path: "a", From: "a", Text: "a = 1\n", After: "b"}}})
+	if results[0].Status != STATUS_FAILED || doc.VerbatimText() != "a = 5\nb = 2\n" {
+		t.Fatal(results, doc.VerbatimText())
+	}
+}
@@ -0,0 +1,42 @@
+package render
+
+import (
+	"bytes"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+)
+
+const ANSI_RESET = "\x1b[0m"
+
+// ANSI escape sequences that colour each kind of span. Kinds that are not in the map are not coloured.
+var ANSIColours = map[string]string{
+	KIND_KEY:      "\x1b[1;34m", // bold blue
+	KIND_QUOTED:   "\x1b[31m",   // red
+	KIND_COMMENT:  "\x1b[32m",   // green
+	KIND_SECTION:  "\x1b[1;35m", // bold magenta
+	KIND_CONTINUE: "\x1b[33m",   // yellow
+	KIND_BREAK:    "\x1b[36m",   // cyan
+}
+
+/*
+Render the document into text coloured by ANSI escape sequences for terminals. The colour is reset at the end of each
+span and never carries over a new-line character, so that the output remains correct when it is paged or cut into
+lines. Removing the escape sequences reproduces the verbatim text of the document.
+*/
+func ANSI(root *lexer.DocumentNode, config *lexer.LexerConfig) string {
+	var out bytes.Buffer
+	for i, line := range splitLines(Spans(root, config)) {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		for _, span := range line {
+			colour, found := ANSIColours[span.Kind]
+			if !found {
+				out.WriteString(span.Text)
+				continue
+			}
+			out.WriteString(colour + span.Text + ANSI_RESET)
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,60 @@
+package render
+
+import (
+	"bytes"
+	"html"
+	"strconv"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+)
+
+const CSS_CLASS_PREFIX = "lmc-" // prefix of the CSS classes given to the rendered HTML elements
+
+/*
+The default style sheet for rendered HTML. Line numbers are drawn by the style sheet from the "data-line" attribute
+of each line, hence they are not part of the text that is selected and copied from the page.
+*/
+const STYLESHEET = `pre.lmc-document { font-family: monospace; }
+.lmc-line::before { content: attr(data-line); display: inline-block; width: 4em; margin-right: 1em; padding-right: 0.5em;
+  text-align: right; color: #999; border-right: 1px solid #ddd; user-select: none; }
+.lmc-key { color: #00008b; font-weight: bold; }
+.lmc-text { color: #222; }
+.lmc-quoted { color: #a31515; }
+.lmc-comment { color: #008000; font-style: italic; }
+.lmc-section { color: #800080; font-weight: bold; }
+.lmc-continue { color: #b8860b; }
+.lmc-break { color: #666; }
+`
+
+/*
+Render the document into an HTML "pre" element. Each line is an element that carries its line number in the
+"data-line" attribute, and each span of highlighted text is an element of CSS class "lmc-" followed by the kind of the
+span (e.g. "lmc-comment"). Spaces are not wrapped in elements. Removing the tags and unescaping the HTML entities
+reproduces the verbatim text of the document.
+*/
+func HTML(root *lexer.DocumentNode, config *lexer.LexerConfig) string {
+	var out bytes.Buffer
+	out.WriteString(`<pre class="` + CSS_CLASS_PREFIX + `document">`)
+	lines := splitLines(Spans(root, config))
+	for i, line := range lines {
+		if i > 0 {
+			out.WriteString("\n")
+			if i == len(lines)-1 && len(line) == 0 {
+				break
+			}
+		}
+		out.WriteString(`<span class="` + CSS_CLASS_PREFIX + `line" data-line="` + strconv.Itoa(i+1) + `">`)
+		for _, span := range line {
+			if span.Kind == KIND_SPACE {
+				out.WriteString(html.EscapeString(span.Text))
+				continue
+			}
+			out.WriteString(`<span class="` + CSS_CLASS_PREFIX + span.Kind + `">`)
+			out.WriteString(html.EscapeString(span.Text))
+			out.WriteString(`</span>`)
+		}
+		out.WriteString(`</span>`)
+	}
+	out.WriteString(`</pre>`)
+	return out.String()
+}
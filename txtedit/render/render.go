@@ -0,0 +1,153 @@
+package render
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+)
+
+// Kinds of document entities that are highlighted differently.
+const (
+	KIND_SPACE    = "space"    // spaces, tabs, and new-line characters
+	KIND_KEY      = "key"      // the first word of a statement
+	KIND_TEXT     = "text"     // words that follow the key
+	KIND_QUOTED   = "quoted"   // quoted text together with its quotation marks
+	KIND_COMMENT  = "comment"  // comment together with its markers
+	KIND_SECTION  = "section"  // section opening and closing markers, and the statement in the closing marker
+	KIND_CONTINUE = "continue" // statement continuation marker
+	KIND_BREAK    = "break"    // token break markers and statement ending markers
+)
+
+// Span is a run of characters from the document that are highlighted in the same way.
+type Span struct {
+	Kind string
+	Text string
+}
+
+/*
+Break down the document into spans of highlighted characters, in the order they are written in the document.
+Concatenation of the text of all spans reproduces the verbatim text of the document.
+*/
+func Spans(root *lexer.DocumentNode, config *lexer.LexerConfig) []Span {
+	spans := make([]Span, 0, 64)
+	nodeSpans(&spans, root, config)
+	return spans
+}
+
+func nodeSpans(spans *[]Span, node *lexer.DocumentNode, config *lexer.LexerConfig) {
+	sect, isSection := node.Entity.(*lexer.Section)
+	if isSection {
+		addSpans(spans, KIND_SECTION, sect.OpeningPrefix)
+		if sect.FirstStatement != nil {
+			statementSpans(spans, sect.FirstStatement, config, KIND_KEY)
+		}
+		addSpans(spans, KIND_SECTION, sect.OpeningSuffix)
+	} else if stmt, isStatement := node.Entity.(*lexer.Statement); isStatement {
+		statementSpans(spans, stmt, config, KIND_KEY)
+	}
+	for _, leaf := range node.Leaves {
+		nodeSpans(spans, leaf, config)
+	}
+	if isSection {
+		addSpans(spans, KIND_SECTION, sect.ClosingPrefix)
+		if sect.FinalStatement != nil {
+			statementSpans(spans, sect.FinalStatement, config, KIND_SECTION)
+		}
+		addSpans(spans, KIND_SECTION, sect.ClosingSuffix)
+	}
+}
+
+// Add spans of the statement, the first word of the statement is of the key kind.
+func statementSpans(spans *[]Span, stmt *lexer.Statement, config *lexer.LexerConfig, keyKind string) {
+	addSpans(spans, KIND_SPACE, stmt.Indent)
+	for _, piece := range stmt.Pieces {
+		switch thing := piece.(type) {
+		case *lexer.Text:
+			if thing.QuoteStyle != "" {
+				addSpans(spans, KIND_QUOTED, thing.QuoteStyle+thing.Text+thing.QuoteStyle)
+			} else if isBreakMarker(thing.Text, config) {
+				addSpans(spans, KIND_BREAK, thing.Text)
+			} else {
+				addSpans(spans, keyKind, thing.Text)
+			}
+			if keyKind == KIND_KEY && strings.TrimSpace(thing.Text) != "" {
+				keyKind = KIND_TEXT
+			}
+			addSpans(spans, KIND_SPACE, thing.TrailingSpaces)
+		case *lexer.Comment:
+			addSpans(spans, KIND_COMMENT, thing.VerbatimText())
+		case *lexer.StatementContinue:
+			addSpans(spans, KIND_CONTINUE, thing.VerbatimText())
+		default:
+			addSpans(spans, KIND_TEXT, piece.VerbatimText())
+		}
+	}
+	addSpans(spans, KIND_BREAK, stmt.Ending)
+}
+
+func isBreakMarker(text string, config *lexer.LexerConfig) bool {
+	for _, marker := range config.TokenBreakMarkers {
+		if text == marker {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Add the text as spans of the kind, except that spaces and new-line characters are split into spans of their own.
+Comments and quoted text are kept in one piece, their new-line characters are only split apart by the renderers.
+*/
+func addSpans(spans *[]Span, kind, text string) {
+	if kind == KIND_COMMENT || kind == KIND_QUOTED {
+		appendSpan(spans, kind, text)
+		return
+	}
+	for text != "" {
+		space := strings.IndexFunc(text, unicode.IsSpace)
+		if space == -1 {
+			appendSpan(spans, kind, text)
+			return
+		}
+		appendSpan(spans, kind, text[:space])
+		text = text[space:]
+		end := strings.IndexFunc(text, func(r rune) bool { return !unicode.IsSpace(r) })
+		if end == -1 {
+			end = len(text)
+		}
+		appendSpan(spans, KIND_SPACE, text[:end])
+		text = text[end:]
+	}
+}
+
+// Append the span, or extend the last span if it is of the same kind.
+func appendSpan(spans *[]Span, kind, text string) {
+	if text == "" {
+		return
+	}
+	if last := len(*spans) - 1; last >= 0 && (*spans)[last].Kind == kind {
+		(*spans)[last].Text += text
+		return
+	}
+	*spans = append(*spans, Span{Kind: kind, Text: text})
+}
+
+/*
+Split the spans into lines, the new-line characters are left out. The last line is empty if the document ends with a
+new-line character.
+*/
+func splitLines(spans []Span) [][]Span {
+	lines := [][]Span{make([]Span, 0, 8)}
+	for _, span := range spans {
+		for i, text := range strings.Split(span.Text, "\n") {
+			if i > 0 {
+				lines = append(lines, make([]Span, 0, 8))
+			}
+			if text != "" {
+				lines[len(lines)-1] = append(lines[len(lines)-1], Span{Kind: span.Kind, Text: text})
+			}
+		}
+	}
+	return lines
+}
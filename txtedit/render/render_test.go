@@ -0,0 +1,72 @@
+package render
+
+import (
+	"html"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"testing"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer/predef"
+)
+
+func lex(text string, config lexer.LexerConfig) *lexer.DocumentNode {
+	return lexer.NewLexer(text, &config, &lexer.LexerDebugNoop{}).Run()
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func TestSpans(t *testing.T) {
+	config := predef.NamedConf
+	spans := Spans(lex("zone \"a\" {\n\ttype master; // m\n};\n", config), &config)
+	expected := []Span{{KIND_KEY, "zone"}, {KIND_SPACE, " "}, {KIND_QUOTED, `"a"`}, {KIND_SPACE, " "}, {KIND_SECTION, "{"},
+		{KIND_SPACE, "\n\t"}, {KIND_KEY, "type"}, {KIND_SPACE, " "}, {KIND_TEXT, "master"}, {KIND_BREAK, ";"},
+		{KIND_SPACE, " "}, {KIND_COMMENT, "// m\n"}, {KIND_SECTION, "};"}, {KIND_SPACE, "\n"}}
+	if len(spans) != len(expected) {
+		t.Fatal(spans)
+	}
+	for i, span := range spans {
+		if span != expected[i] {
+			t.Fatal(i, spans)
+		}
+	}
+	config = predef.HttpdConf
+	spans = Spans(lex("<VirtualHost *:80>\n</VirtualHost>\n", config), &config)
+	if spans[0] != (Span{KIND_SECTION, "<"}) || spans[4] != (Span{KIND_BREAK, ":"}) || spans[len(spans)-2] != (Span{KIND_SECTION, "</VirtualHost>"}) {
+		t.Fatal(spans)
+	}
+}
+
+func TestRender(t *testing.T) {
+	config := predef.SystemdConf
+	root := lex("[Unit]\n# a < b\nA=b\n", config)
+	if text := HTML(root, &config); text != `<pre class="lmc-document"><span class="lmc-line" data-line="1"><span class="lmc-section">[</span><span class="lmc-key">Unit</span><span class="lmc-section">]</span></span>
+<span class="lmc-line" data-line="2"><span class="lmc-comment"># a &lt; b</span></span>
+<span class="lmc-line" data-line="3"><span class="lmc-key">A</span><span class="lmc-break">=</span><span class="lmc-text">b</span></span>
+</pre>` {
+		t.Fatal(text)
+	}
+	if text := ANSI(root, &config); text != "\x1b[1;35m[\x1b[0m\x1b[1;34mUnit\x1b[0m\x1b[1;35m]\x1b[0m\n\x1b[32m# a < b\x1b[0m\n\x1b[1;34mA\x1b[0m\x1b[36m=\x1b[0mb\n" {
+		t.Fatalf("%q", text)
+	}
+	// Both renderers reproduce the verbatim text of every sample
+	samples := map[string]lexer.LexerConfig{"dhcpd.conf": predef.DhcpdConf, "httpd.conf": predef.HttpdConf,
+		"named.conf": predef.NamedConf, "named.zone": predef.NamedZone, "ntp.conf": predef.NtpConf,
+		"sysctl.conf": predef.SysctlConf, "systemd.conf": predef.SystemdConf, "crontab": predef.Crontab}
+	for fileName, config := range samples {
+		content, err := ioutil.ReadFile(path.Join("../lexer/predef/samples", fileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		root := lex(string(content), config)
+		verbatim := root.VerbatimText()
+		if text := html.UnescapeString(htmlTag.ReplaceAllString(HTML(root, &config), "")); text != verbatim {
+			t.Fatal(fileName, text)
+		}
+		if text := ansiEscape.ReplaceAllString(ANSI(root, &config), ""); text != verbatim {
+			t.Fatal(fileName, text)
+		}
+	}
+}
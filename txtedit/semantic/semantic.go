@@ -0,0 +1,440 @@
+package semantic
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/navigate"
+)
+
+/*
+Export the meaning of a document into a map, leaving out comments and layout:
+  - A statement becomes its key mapped to the text of its values, the token break markers that follow the key
+    (e.g. "=" in "key = value") are left out. A key that appears more than once is mapped to a list of texts.
+  - A section without arguments becomes its key mapped to the export of its leaves (e.g. systemd "[Service]").
+  - A section with arguments becomes its key mapped to another map, in which the text of the arguments is mapped to the
+    export of the section's leaves (e.g. named.conf `zone "example.com" in {...}`).
+
+The values are strings, lists, and maps, which are ready for encoding into JSON or YAML.
+*/
+func Export(root *lexer.DocumentNode, config *lexer.LexerConfig) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for _, leaf := range root.Leaves {
+		key, _, ok := navigate.NodeKey(leaf)
+		if !ok {
+			continue
+		}
+		switch entity := leaf.Entity.(type) {
+		case *lexer.Statement:
+			addValue(ret, key, valueText(entity, config))
+		case *lexer.Section:
+			if args := argsText(entity); args == "" {
+				addValue(ret, key, Export(leaf, config))
+			} else {
+				byArgs, isMap := ret[key].(map[string]interface{})
+				if !isMap {
+					byArgs = make(map[string]interface{})
+					addValue(ret, key, byArgs)
+				}
+				addValue(byArgs, args, Export(leaf, config))
+			}
+		}
+	}
+	return ret
+}
+
+// Map the key to the value, or add the value to a list if the key is already mapped.
+func addValue(m map[string]interface{}, key string, value interface{}) {
+	existing, exists := m[key]
+	if !exists {
+		m[key] = value
+	} else if list, isList := existing.([]interface{}); isList {
+		m[key] = append(list, value)
+	} else {
+		m[key] = []interface{}{existing, value}
+	}
+}
+
+// Return the words joined by the spaces between them. Quotation marks are left out.
+func joinWords(words []*lexer.Text) string {
+	var text string
+	for i, word := range words {
+		text += strings.TrimSpace(word.Text)
+		if i < len(words)-1 {
+			text += word.TrailingSpaces
+		}
+	}
+	return text
+}
+
+// Return the number of token break markers that immediately follow the statement's key.
+func countMarkers(words []*lexer.Text, config *lexer.LexerConfig) (count int) {
+	for _, word := range words[1:] {
+		if word.QuoteStyle != "" || !navigate.IsOneOf(strings.TrimSpace(word.Text), config.TokenBreakMarkers) {
+			break
+		}
+		count++
+	}
+	return
+}
+
+// Return the text of the statement's values.
+func valueText(stmt *lexer.Statement, config *lexer.LexerConfig) string {
+	words := navigate.WordPieces(stmt)
+	return joinWords(words[1+countMarkers(words, config):])
+}
+
+// Return the text of the section's arguments.
+func argsText(sect *lexer.Section) string {
+	if sect.FirstStatement == nil {
+		return ""
+	}
+	words := navigate.WordPieces(sect.FirstStatement)
+	if len(words) < 2 {
+		return ""
+	}
+	return joinWords(words[1:])
+}
+
+// Return the text of a scalar value decoded from JSON or YAML.
+func scalarText(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(v), nil
+	}
+	return "", fmt.Errorf("%v is not a scalar value", value)
+}
+
+/*
+Change the document so that its export becomes the desired map, the map is usually decoded from JSON or YAML. The
+edits are minimal: a statement that already carries the desired value is left alone, a changed statement keeps its
+quotation marks and spaces, and comments and layout of other statements are not touched. Statements and sections that
+are not in the desired map are removed along with their comments. New statements and sections imitate the existing
+ones in the same section. The config is used for breaking down the desired values into words.
+*/
+func Import(root *lexer.DocumentNode, config *lexer.LexerConfig, desired map[string]interface{}) error {
+	// Remove the nodes that are no longer desired
+	for _, leaf := range append([]*lexer.DocumentNode{}, root.Leaves...) {
+		if key, _, ok := navigate.NodeKey(leaf); ok {
+			if _, wanted := desired[key]; !wanted {
+				navigate.Remove(leaf)
+			}
+		}
+	}
+	keys := make([]string, 0, len(desired))
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	// New nodes are placed in the order of their keys
+	sort.Strings(keys)
+	for _, key := range keys {
+		var err error
+		statements, sections, sectionsWithArgs := nodesOfKey(root, key)
+		value := desired[key]
+		if m, isMap := value.(map[string]interface{}); isMap && (len(sectionsWithArgs) > 0 || len(sections) == 0 && mapsOnly(m)) {
+			err = importSectionsWithArgs(root, config, key, sectionsWithArgs, m)
+			removeAll(statements)
+			removeAll(sections)
+		} else if hasMap(value) {
+			err = importSections(root, config, key, sections, asList(value))
+			removeAll(statements)
+			removeAll(sectionsWithArgs)
+		} else {
+			err = importStatements(root, config, key, statements, asList(value))
+			removeAll(sections)
+			removeAll(sectionsWithArgs)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// Return the leaves that carry the key, categorised into statements, sections without arguments, and sections with.
+func nodesOfKey(node *lexer.DocumentNode, key string) (statements, sections, sectionsWithArgs []*lexer.DocumentNode) {
+	for _, leaf := range node.Leaves {
+		if leafKey, args, ok := navigate.NodeKey(leaf); !ok || leafKey != key {
+			continue
+		} else if !navigate.IsSection(leaf) {
+			statements = append(statements, leaf)
+		} else if len(args) == 0 {
+			sections = append(sections, leaf)
+		} else {
+			sectionsWithArgs = append(sectionsWithArgs, leaf)
+		}
+	}
+	return
+}
+
+func removeAll(nodes []*lexer.DocumentNode) {
+	for _, node := range nodes {
+		navigate.Remove(node)
+	}
+}
+
+// Return true only if the map is not empty and all of its values are maps.
+func mapsOnly(m map[string]interface{}) bool {
+	for _, value := range m {
+		if _, isMap := value.(map[string]interface{}); !isMap {
+			return false
+		}
+	}
+	return len(m) > 0
+}
+
+// Return true only if the value is a map or a list that has a map.
+func hasMap(value interface{}) bool {
+	for _, item := range asList(value) {
+		if _, isMap := item.(map[string]interface{}); isMap {
+			return true
+		}
+	}
+	return false
+}
+
+func asList(value interface{}) []interface{} {
+	if list, isList := value.([]interface{}); isList {
+		return list
+	}
+	return []interface{}{value}
+}
+
+// Break down the text into words using the lexer configuration.
+func lexWords(config *lexer.LexerConfig, text string) ([]*lexer.Text, error) {
+	configCopy := *config
+	root := lexer.NewLexer(text, &configCopy, &lexer.LexerDebugNoop{}).Run()
+	var words []*lexer.Text
+	for _, leaf := range root.Leaves {
+		stmt, ok := leaf.Entity.(*lexer.Statement)
+		if !ok {
+			return nil, fmt.Errorf("\"%s\" is not a plain value", text)
+		} else if leafWords := navigate.WordPieces(stmt); len(leafWords) > 0 {
+			if words != nil {
+				return nil, fmt.Errorf("\"%s\" is not a single value", text)
+			}
+			words = leafWords
+		}
+	}
+	return words, nil
+}
+
+func wordTexts(words []*lexer.Text) []string {
+	ret := make([]string, 0, len(words))
+	for _, word := range words {
+		ret = append(ret, strings.TrimSpace(word.Text))
+	}
+	return ret
+}
+
+// Set the statements of the key to carry the desired values in order, then create or remove statements as necessary.
+func importStatements(parent *lexer.DocumentNode, config *lexer.LexerConfig, key string, statements []*lexer.DocumentNode, values []interface{}) error {
+	template := statementTemplate(parent)
+	var last *lexer.DocumentNode
+	for i, value := range values {
+		text, err := scalarText(value)
+		if err != nil {
+			return err
+		}
+		valueWords, err := lexWords(config, text)
+		if err != nil {
+			return err
+		}
+		if i < len(statements) {
+			last = statements[i]
+			stmt := last.Entity.(*lexer.Statement)
+			if valueText(stmt, config) == text {
+				continue
+			}
+			words := navigate.WordPieces(stmt)
+			navigate.SetStatementValues(stmt, append(wordTexts(words[1:1+countMarkers(words, config)]), wordTexts(valueWords)...))
+			continue
+		}
+		// Create a new statement, which carries the same token break markers as the template.
+		words := []string{key}
+		if template != nil {
+			templateWords := navigate.WordPieces(template)
+			words = append(words, wordTexts(templateWords[1:1+countMarkers(templateWords, config)])...)
+		}
+		stmt := navigate.NewStatement(append(words, wordTexts(valueWords)...), template)
+		if template == nil && len(config.StatementEndingMarkers) > 0 {
+			stmt.Ending = config.StatementEndingMarkers[0]
+		}
+		newNode := &lexer.DocumentNode{Entity: stmt, Leaves: make([]*lexer.DocumentNode, 0, 0)}
+		if last != nil {
+			navigate.MoveAfter(newNode, last)
+		} else {
+			navigate.MoveInto(newNode, parent)
+		}
+		last = newNode
+	}
+	if len(statements) > len(values) {
+		removeAll(statements[len(values):])
+	}
+	return nil
+}
+
+/*
+Return a statement of the node that is suitable as the template for a new statement. If the node does not have one,
+look for the last one in the entire document.
+*/
+func statementTemplate(node *lexer.DocumentNode) *lexer.Statement {
+	if template := navigate.StatementTemplate(node); template != nil {
+		return template
+	}
+	for node.Parent != nil {
+		node = node.Parent
+	}
+	return lastTemplate(node)
+}
+
+func lastTemplate(node *lexer.DocumentNode) *lexer.Statement {
+	for i := len(node.Leaves) - 1; i >= 0; i-- {
+		if template := lastTemplate(node.Leaves[i]); template != nil {
+			return template
+		}
+	}
+	return navigate.StatementTemplate(node)
+}
+
+// Import each map into a section of the key, then create or remove sections as necessary.
+func importSections(parent *lexer.DocumentNode, config *lexer.LexerConfig, key string, sections []*lexer.DocumentNode, values []interface{}) error {
+	for i, value := range values {
+		m, isMap := value.(map[string]interface{})
+		if !isMap {
+			return fmt.Errorf("a section cannot be mixed with statements of the same key")
+		}
+		var sect *lexer.DocumentNode
+		var err error
+		if i < len(sections) {
+			sect = sections[i]
+		} else if sect, err = newSection(parent, config, key, nil); err != nil {
+			return err
+		}
+		if err := Import(sect, config, m); err != nil {
+			return err
+		}
+	}
+	if len(sections) > len(values) {
+		removeAll(sections[len(values):])
+	}
+	return nil
+}
+
+// Import each map into the section identified by the key and the arguments, then create or remove sections as necessary.
+func importSectionsWithArgs(parent *lexer.DocumentNode, config *lexer.LexerConfig, key string, sections []*lexer.DocumentNode, byArgs map[string]interface{}) error {
+	existing := make(map[string][]*lexer.DocumentNode)
+	for _, sect := range sections {
+		args := argsText(sect.Entity.(*lexer.Section))
+		if _, wanted := byArgs[args]; !wanted {
+			navigate.Remove(sect)
+		} else {
+			existing[args] = append(existing[args], sect)
+		}
+	}
+	allArgs := make([]string, 0, len(byArgs))
+	for args := range byArgs {
+		allArgs = append(allArgs, args)
+	}
+	sort.Strings(allArgs)
+	for _, args := range allArgs {
+		values := asList(byArgs[args])
+		for i, value := range values {
+			m, isMap := value.(map[string]interface{})
+			if !isMap {
+				return fmt.Errorf("section %s %s must be a map", key, args)
+			}
+			var sect *lexer.DocumentNode
+			if i < len(existing[args]) {
+				sect = existing[args][i]
+			} else {
+				argWords, err := lexWords(config, args)
+				if err != nil {
+					return err
+				}
+				if sect, err = newSection(parent, config, key, wordTexts(argWords)); err != nil {
+					return err
+				}
+			}
+			if err := Import(sect, config, m); err != nil {
+				return err
+			}
+		}
+		if len(existing[args]) > len(values) {
+			removeAll(existing[args][len(values):])
+		}
+	}
+	return nil
+}
+
+/*
+Create an empty section of the key and arguments, and place it in the parent. The new section imitates an existing
+section of the same key, or it is written in the style of lexer configuration if there is not one. Return an error if
+the format does not have sections.
+*/
+func newSection(parent *lexer.DocumentNode, config *lexer.LexerConfig, key string, args []string) (*lexer.DocumentNode, error) {
+	var newNode *lexer.DocumentNode
+	_, sections, sectionsWithArgs := nodesOfKey(parent, key)
+	if template := append(sections, sectionsWithArgs...); len(template) > 0 {
+		newNode = template[0].Clone()
+		// Only the spaces that make up the layout of closing marker remain
+		leaves := make([]*lexer.DocumentNode, 0, 0)
+		for _, leaf := range newNode.Leaves {
+			if stmt, ok := leaf.Entity.(*lexer.Statement); ok && len(navigate.StatementTexts(stmt)) == 0 && len(navigate.StatementComments(stmt)) == 0 {
+				leaves = append(leaves, leaf)
+			}
+		}
+		newNode.Leaves = leaves
+		sect := newNode.Entity.(*lexer.Section)
+		oldHeader := sect.FirstStatement
+		sect.FirstStatement = navigate.NewStatement(append([]string{key}, args...), oldHeader)
+		oldWords, newWords := navigate.WordPieces(oldHeader), navigate.WordPieces(sect.FirstStatement)
+		for i := 1; i < len(newWords) && i < len(oldWords); i++ {
+			if newWords[i].QuoteStyle == "" {
+				newWords[i].QuoteStyle = oldWords[i].QuoteStyle
+			}
+		}
+		// The opening statement may end with the spaces that separate it from the opening marker.
+		if len(newWords) > 0 && len(oldWords) > 0 {
+			newWords[len(newWords)-1].TrailingSpaces = oldWords[len(oldWords)-1].TrailingSpaces
+		}
+	} else {
+		style := config.SectionStyle
+		header := strings.Join(append([]string{key}, args...), " ")
+		if style.OpeningPrefix == "" {
+			header += " "
+		}
+		text := style.OpeningPrefix + header + style.OpeningSuffix + "\n"
+		if style.ClosingPrefix != "" || style.ClosingSuffix != "" {
+			if style.CloseSectionWithAStatement {
+				text += style.ClosingPrefix + key + style.ClosingSuffix
+			} else {
+				text += style.ClosingPrefix + style.ClosingSuffix
+			}
+		}
+		configCopy := *config
+		for _, leaf := range lexer.NewLexer(text, &configCopy, &lexer.LexerDebugNoop{}).Run().Leaves {
+			if navigate.IsSection(leaf) {
+				newNode = leaf
+				break
+			}
+		}
+		if newNode == nil {
+			return nil, fmt.Errorf("the format has no sections")
+		}
+		newNode.Parent = nil
+	}
+	navigate.MoveInto(newNode, parent)
+	return newNode, nil
+}
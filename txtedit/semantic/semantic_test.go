@@ -0,0 +1,118 @@
+package semantic
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer/predef"
+)
+
+func lex(text string, config lexer.LexerConfig) *lexer.DocumentNode {
+	return lexer.NewLexer(text, &config, &lexer.LexerDebugNoop{}).Run()
+}
+
+var unit = `# Example unit
+[Unit]
+Description=Example service
+
+[Service]
+ExecStartPre=/bin/true
+ExecStart=/usr/bin/a --verbose
+ExecStart=/usr/bin/b
+Environment=A=B
+# run as nobody
+User=nobody
+
+[Install]
+WantedBy=multi-user.target
+`
+
+func TestExport(t *testing.T) {
+	config := predef.SystemdConf
+	serialised, err := json.Marshal(Export(lex(unit, config), &config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Install":{"WantedBy":"multi-user.target"},"Service":{"Environment":"A=B","ExecStart":["/usr/bin/a --verbose","/usr/bin/b"],"ExecStartPre":"/bin/true","User":"nobody"},"Unit":{"Description":"Example service"}}`
+	if string(serialised) != expected {
+		t.Fatal(string(serialised))
+	}
+	config = predef.NamedConf
+	serialised, _ = json.Marshal(Export(lex("options {\n\tdirectory \"/var\";\n};\nzone \"a\" in {\n\ttype master;\n};\nzone \"b\" {\n};\n", config), &config))
+	if string(serialised) != `{"options":{"directory":"/var"},"zone":{"a in":{"type":"master"},"b":{}}}` {
+		t.Fatal(string(serialised))
+	}
+}
+
+func TestImport(t *testing.T) {
+	config := predef.SystemdConf
+	doc := lex(unit, config)
+	// Importing the export does not change anything
+	if err := Import(doc, &config, Export(doc, &config)); err != nil || doc.VerbatimText() != unit {
+		t.Fatal(err, doc.VerbatimText())
+	}
+	desired := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(`{
+		"Unit": {"Description": "Example service", "After": "network.target"},
+		"Service": {"ExecStart": ["/usr/bin/a --quiet", "/usr/bin/b", "/usr/bin/c"], "Environment": "A=B", "User": "daemon"},
+		"Timer": {"OnCalendar": "daily"}
+	}`), &desired); err != nil {
+		t.Fatal(err)
+	}
+	if err := Import(doc, &config, desired); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# Example unit
+[Unit]
+Description=Example service
+After=network.target
+
+[Service]
+ExecStart=/usr/bin/a --quiet
+ExecStart=/usr/bin/b
+ExecStart=/usr/bin/c
+Environment=A=B
+# run as nobody
+User=daemon
+
+[Timer]
+OnCalendar=daily
+`
+	if text := doc.VerbatimText(); text != expected {
+		t.Fatal(text)
+	}
+
+	config = predef.SysctlConf
+	doc = lex("# forwarding\nnet.ipv4.ip_forward = 0\nkernel.panic = 5\n", config)
+	if err := Import(doc, &config, map[string]interface{}{"net.ipv4.ip_forward": float64(1), "vm.swappiness": "10"}); err != nil {
+		t.Fatal(err)
+	}
+	if text := doc.VerbatimText(); text != "# forwarding\nnet.ipv4.ip_forward = 1\nvm.swappiness = 10\n" {
+		t.Fatal(text)
+	}
+	// sysctl has no sections to put a map into
+	desired = map[string]interface{}{}
+	if err := json.Unmarshal([]byte(`{"a": "1", "foo": {"bar": "1"}}`), &desired); err != nil {
+		t.Fatal(err)
+	}
+	if err := Import(doc, &config, desired); err == nil || err.Error() != "foo: the format has no sections" {
+		t.Fatal(err)
+	}
+
+	config = predef.NamedConf
+	doc = lex("options {\n\tdirectory \"/var\";\n};\nzone \"a\" in {\n\ttype master;\n};\n", config)
+	desired = map[string]interface{}{
+		"options": map[string]interface{}{"directory": "/var/named"},
+		"zone": map[string]interface{}{
+			"a in": map[string]interface{}{"type": "master"},
+			"b in": map[string]interface{}{"type": "slave"},
+		},
+	}
+	if err := Import(doc, &config, desired); err != nil {
+		t.Fatal(err)
+	}
+	if text := doc.VerbatimText(); text != "options {\n\tdirectory \"/var/named\";\n};\nzone \"a\" in {\n\ttype master;\n};\nzone \"b\" in {\n\ttype slave;\n};\n" {
+		t.Fatal(text)
+	}
+}
@@ -0,0 +1,267 @@
+package semantic
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Encode the value (a map, list, or string such as the output of Export) into YAML. Maps are written in the order of
+their keys, and strings are quoted whenever YAML could read them as anything other than the same string.
+*/
+func ToYAML(value interface{}) []byte {
+	var out bytes.Buffer
+	writeYAML(&out, value, 0)
+	return out.Bytes()
+}
+
+func writeYAML(out *bytes.Buffer, value interface{}, indent int) {
+	prefix := strings.Repeat("  ", indent)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			out.WriteString(prefix + yamlScalar(key) + ":")
+			writeYAMLItem(out, v[key], indent)
+		}
+	case []interface{}:
+		for _, item := range v {
+			out.WriteString(prefix + "-")
+			writeYAMLItem(out, item, indent)
+		}
+	default:
+		text, _ := scalarText(value)
+		out.WriteString(prefix + yamlScalar(text) + "\n")
+	}
+}
+
+// Write the value that follows a map key or list marker.
+func writeYAMLItem(out *bytes.Buffer, value interface{}, indent int) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			out.WriteString(" {}\n")
+			return
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			out.WriteString(" []\n")
+			return
+		}
+	default:
+		text, _ := scalarText(value)
+		out.WriteString(" " + yamlScalar(text) + "\n")
+		return
+	}
+	out.WriteString("\n")
+	writeYAML(out, value, indent+1)
+}
+
+// Return the string as YAML scalar, quote it if necessary.
+func yamlScalar(str string) string {
+	if str == "" || strings.TrimSpace(str) != str || strings.ContainsAny(str, "\"'\\\n\t") ||
+		strings.ContainsAny(str[:1], "-?:,[]{}#&*!|>%@`") || strings.Contains(str, ": ") || strings.Contains(str, " #") ||
+		strings.HasSuffix(str, ":") {
+		return strconv.Quote(str)
+	}
+	switch strings.ToLower(str) {
+	case "~", "null", "true", "false", "yes", "no", "on", "off":
+		return strconv.Quote(str)
+	}
+	if _, err := strconv.ParseFloat(str, 64); err == nil {
+		return strconv.Quote(str)
+	}
+	return str
+}
+
+type yamlLine struct {
+	number int    // line number counted from 1
+	indent int    // number of spaces in front of the text
+	text   string // the text without indentation
+}
+
+/*
+Decode a map from YAML. Only a subset of YAML is understood, which is sufficient for the output of ToYAML and the
+hand-written documents alike: block maps, block lists, scalars that are plain or quoted, comments, and empty flow maps
+and lists ({} and []). Scalars are decoded as strings, except null (or ~) which is decoded as nil.
+*/
+func FromYAML(data []byte) (map[string]interface{}, error) {
+	lines := make([]yamlLine, 0, 32)
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimRight(text, " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		} else if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tab characters cannot indent YAML", i+1)
+		}
+		lines = append(lines, yamlLine{number: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(lines) == 0 {
+		return make(map[string]interface{}), nil
+	}
+	value, next, err := parseYAMLBlock(lines, 0, lines[0].indent)
+	if err != nil {
+		return nil, err
+	} else if next < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[next].number)
+	}
+	m, isMap := value.(map[string]interface{})
+	if !isMap {
+		return nil, fmt.Errorf("YAML document is not a map")
+	}
+	return m, nil
+}
+
+func isListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// Parse the map or list that begins at the line, return the value and index of the line that follows the value.
+func parseYAMLBlock(lines []yamlLine, i, indent int) (interface{}, int, error) {
+	if isListItem(lines[i].text) {
+		return parseYAMLList(lines, i, indent)
+	}
+	return parseYAMLMap(lines, i, indent)
+}
+
+func parseYAMLMap(lines []yamlLine, i, indent int) (interface{}, int, error) {
+	m := make(map[string]interface{})
+	for i < len(lines) && lines[i].indent == indent && !isListItem(lines[i].text) {
+		key, rest, err := splitYAMLKey(lines[i])
+		if err != nil {
+			return nil, i, err
+		}
+		i++
+		var value interface{}
+		if rest != "" {
+			if value, err = parseYAMLScalar(rest, lines[i-1].number); err != nil {
+				return nil, i, err
+			}
+		} else if i < len(lines) && (lines[i].indent > indent || lines[i].indent == indent && isListItem(lines[i].text)) {
+			if value, i, err = parseYAMLBlock(lines, i, lines[i].indent); err != nil {
+				return nil, i, err
+			}
+		}
+		m[key] = value
+	}
+	if i < len(lines) && lines[i].indent > indent {
+		return nil, i, fmt.Errorf("line %d: unexpected indentation", lines[i].number)
+	}
+	return m, i, nil
+}
+
+func parseYAMLList(lines []yamlLine, i, indent int) (interface{}, int, error) {
+	list := make([]interface{}, 0, 4)
+	for i < len(lines) && lines[i].indent == indent && isListItem(lines[i].text) {
+		item := strings.TrimLeft(strings.TrimPrefix(lines[i].text, "-"), " ")
+		var value interface{}
+		var err error
+		if item == "" {
+			i++
+			if i < len(lines) && lines[i].indent > indent {
+				if value, i, err = parseYAMLBlock(lines, i, lines[i].indent); err != nil {
+					return nil, i, err
+				}
+			}
+		} else if _, _, keyErr := splitYAMLKey(yamlLine{text: item}); keyErr == nil {
+			// The item is a map whose first key is on the same line as the list marker
+			lines[i] = yamlLine{number: lines[i].number, indent: indent + len(lines[i].text) - len(item), text: item}
+			if value, i, err = parseYAMLMap(lines, i, lines[i].indent); err != nil {
+				return nil, i, err
+			}
+		} else {
+			if value, err = parseYAMLScalar(item, lines[i].number); err != nil {
+				return nil, i, err
+			}
+			i++
+		}
+		list = append(list, value)
+	}
+	return list, i, nil
+}
+
+// Split the line into a map key and the text that follows the colon.
+func splitYAMLKey(line yamlLine) (key, rest string, err error) {
+	text := line.text
+	if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+		end := closingQuote(text)
+		if end == -1 || !strings.HasPrefix(text[end+1:], ":") {
+			return "", "", fmt.Errorf("line %d: expecting a map key", line.number)
+		}
+		if key, err = unquoteYAML(text[:end+1], line.number); err != nil {
+			return
+		}
+		rest = strings.TrimSpace(text[end+2:])
+	} else if colon := strings.Index(text, ": "); colon != -1 {
+		key, rest = text[:colon], strings.TrimSpace(text[colon+2:])
+	} else if strings.HasSuffix(text, ":") {
+		key = text[:len(text)-1]
+	} else {
+		return "", "", fmt.Errorf("line %d: expecting a map key", line.number)
+	}
+	if strings.HasPrefix(rest, "#") {
+		rest = ""
+	}
+	return
+}
+
+// Return index of the quotation mark that closes the quoted text at the beginning of the string, or -1 if not closed.
+func closingQuote(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		if quote == '"' && text[i] == '\\' {
+			i++
+		} else if text[i] == quote {
+			if quote == '\'' && i+1 < len(text) && text[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+func unquoteYAML(text string, number int) (string, error) {
+	if text[0] == '\'' {
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	}
+	str, err := strconv.Unquote(text)
+	if err != nil {
+		return "", fmt.Errorf("line %d: malformed quoted text %s", number, text)
+	}
+	return str, nil
+}
+
+func parseYAMLScalar(text string, number int) (interface{}, error) {
+	switch {
+	case text == "{}":
+		return make(map[string]interface{}), nil
+	case text == "[]":
+		return make([]interface{}, 0, 0), nil
+	case text == "~" || text == "null":
+		return nil, nil
+	case text[0] == '"' || text[0] == '\'':
+		end := closingQuote(text)
+		if end == -1 {
+			return nil, fmt.Errorf("line %d: quoted text is not closed", number)
+		} else if rest := strings.TrimSpace(text[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("line %d: unexpected text after quoted text", number)
+		}
+		return unquoteYAML(text[:end+1], number)
+	case strings.HasPrefix(text, "{") || strings.HasPrefix(text, "[") || strings.HasPrefix(text, "|") || strings.HasPrefix(text, ">"):
+		return nil, fmt.Errorf("line %d: only empty flow maps and lists are supported", number)
+	}
+	if comment := strings.Index(text, " #"); comment != -1 {
+		text = strings.TrimSpace(text[:comment])
+	}
+	return text, nil
+}
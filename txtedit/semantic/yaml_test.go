@@ -0,0 +1,74 @@
+package semantic
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer/predef"
+)
+
+func TestYAML(t *testing.T) {
+	config := predef.SystemdConf
+	exported := Export(lex(unit, config), &config)
+	exported["Odd"] = map[string]interface{}{"a: b": "", "yes": "1.0", "list": []interface{}{"x", map[string]interface{}{"k": "v", "l": "w"}}, "empty": []interface{}{}}
+	serialised := ToYAML(exported)
+	expected := `Install:
+  WantedBy: multi-user.target
+Odd:
+  "a: b": ""
+  empty: []
+  list:
+    - x
+    -
+      k: v
+      l: w
+  "yes": "1.0"
+Service:
+  Environment: A=B
+  ExecStart:
+    - /usr/bin/a --verbose
+    - /usr/bin/b
+  ExecStartPre: /bin/true
+  User: nobody
+Unit:
+  Description: Example service
+`
+	if string(serialised) != expected {
+		t.Fatal(string(serialised))
+	}
+	decoded, err := FromYAML(serialised)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, exported) {
+		t.Fatal(decoded)
+	}
+	// Hand-written YAML
+	decoded, err = FromYAML([]byte(`---
+# comment
+Service:
+  ExecStart:
+  - /bin/a   # trailing comment
+  - '/bin/b ''x'''
+  Hosts:
+    - name: a
+      ip: 10.0.0.1
+  User: ~
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMap := map[string]interface{}{"Service": map[string]interface{}{
+		"ExecStart": []interface{}{"/bin/a", "/bin/b 'x'"},
+		"Hosts":     []interface{}{map[string]interface{}{"name": "a", "ip": "10.0.0.1"}},
+		"User":      nil,
+	}}
+	if !reflect.DeepEqual(decoded, expectedMap) {
+		t.Fatal(decoded)
+	}
+	for _, malformed := range []string{"a: b\n  c: d\n", "a: \"b\n", "- a\n", "a: {b: c}\n", "a\n"} {
+		if _, err := FromYAML([]byte(malformed)); err == nil {
+			t.Fatal("did not fail", malformed)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package writeback
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+)
+
+// Format of the time stamp in the names of backup files, it sorts in chronological order.
+const BACKUP_TIME_FORMAT = "20060102-150405.000000000"
+
+// Permission of a file that is written for the first time.
+const NEW_FILE_MODE = 0644
+
+/*
+FileWriter replaces the content of files atomically: the new content is written to a temporary file in the same
+directory, which takes over the original file's mode, owner, group, ACLs and extended attributes, and is flushed to
+the disk before it is renamed over the original. Readers of the file see either the old or the new content, never a
+mixture of both, even if the computer crashes half way. A symbolic link is followed, so that the file it points to is
+replaced and the link stays.
+*/
+type FileWriter struct {
+	/*
+		The previous content of a file is kept in this directory under the file's absolute path followed by a time
+		stamp. If it is empty, the backup is placed next to the file, named after the file with a leading dot and a
+		trailing time stamp and tilde, so that it does not match the patterns that usually include configuration files.
+	*/
+	BackupDir string
+	now       func() time.Time
+}
+
+// Return the path that the backup of the file's content is kept at.
+func (writer FileWriter) backupPath(filePath string) string {
+	now := time.Now
+	if writer.now != nil {
+		now = writer.now
+	}
+	stamp := now().Format(BACKUP_TIME_FORMAT)
+	if writer.BackupDir == "" {
+		return filepath.Join(filepath.Dir(filePath), "."+filepath.Base(filePath)+"."+stamp+"~")
+	}
+	return filepath.Join(writer.BackupDir, filePath+"."+stamp)
+}
+
+/*
+Replace the content of the file, or create the file if it does not yet exist. Return the path of the backup that
+keeps the previous content, which is empty if the file did not exist.
+*/
+func (writer FileWriter) WriteFile(filePath string, content []byte) (backupPath string, err error) {
+	if filePath, err = filepath.Abs(filePath); err != nil {
+		return
+	}
+	info, err := os.Lstat(filePath)
+	if err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if filePath, err = filepath.EvalSymlinks(filePath); err != nil {
+			return
+		}
+		info, err = os.Stat(filePath)
+	}
+	if os.IsNotExist(err) {
+		info, err = nil, nil
+	} else if err != nil {
+		return
+	}
+	if info != nil {
+		previous, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return "", err
+		}
+		backupPath = writer.backupPath(filePath)
+		if err := os.MkdirAll(filepath.Dir(backupPath), 0700); err != nil {
+			return "", err
+		}
+		if err := writeSynced(backupPath, previous, filePath, info); err != nil {
+			return "", err
+		}
+	}
+	if err = writeSynced(filePath, content, filePath, info); err != nil {
+		return
+	}
+	return backupPath, nil
+}
+
+// Write the content to a file via a temporary file in the same directory, serialise the document.
+func (writer FileWriter) WriteDocument(filePath string, root *lexer.DocumentNode) (backupPath string, err error) {
+	return writer.WriteFile(filePath, []byte(root.VerbatimText()))
+}
+
+/*
+Atomically replace the destination by a file of the content, whose metadata is copied from the source file (which
+may be nil for a new file). The destination's directory is flushed to the disk too, so that the rename is durable.
+*/
+func writeSynced(destPath string, content []byte, srcPath string, srcInfo os.FileInfo) (err error) {
+	dir, name := filepath.Split(destPath)
+	tmp, err := ioutil.TempFile(dir, "."+strings.TrimPrefix(name, ".")+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(content); err != nil {
+		return
+	}
+	if srcInfo == nil {
+		err = tmp.Chmod(NEW_FILE_MODE)
+	} else {
+		err = copyMetadata(srcPath, srcInfo, tmp)
+	}
+	if err != nil {
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	if err = os.Rename(tmp.Name(), destPath); err != nil {
+		return
+	}
+	dirFile, err := os.Open(filepath.Clean(dir))
+	if err != nil {
+		return nil
+	}
+	defer dirFile.Close()
+	// Some file systems do not support flushing a directory, the file content is durable nevertheless.
+	dirFile.Sync()
+	return nil
+}
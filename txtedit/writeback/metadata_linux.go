@@ -0,0 +1,59 @@
+package writeback
+
+import (
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Copy the mode, owner, group, and extended attributes (which carry ACLs and security labels) of the source file.
+func copyMetadata(srcPath string, srcInfo os.FileInfo, dest *os.File) error {
+	if stat, ok := srcInfo.Sys().(*syscall.Stat_t); ok {
+		// Changing owner requires privilege, which is unnecessary if the owner is already the same.
+		destInfo, err := dest.Stat()
+		if err != nil {
+			return err
+		}
+		if destStat, ok := destInfo.Sys().(*syscall.Stat_t); !ok || destStat.Uid != stat.Uid || destStat.Gid != stat.Gid {
+			if err := dest.Chown(int(stat.Uid), int(stat.Gid)); err != nil {
+				return err
+			}
+		}
+	}
+	// Change mode after owner, as changing owner clears the set-user-ID and set-group-ID bits.
+	if err := dest.Chmod(srcInfo.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)); err != nil {
+		return err
+	}
+	return copyXattrs(srcPath, dest.Name())
+}
+
+// Copy all extended attributes of the source file to the destination file.
+func copyXattrs(srcPath, destPath string) error {
+	size, err := syscall.Listxattr(srcPath, nil)
+	if err == syscall.ENOTSUP || err == nil && size == 0 {
+		return nil
+	} else if err != nil {
+		return &os.PathError{Op: "listxattr", Path: srcPath, Err: err}
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(srcPath, buf); err != nil {
+		return &os.PathError{Op: "listxattr", Path: srcPath, Err: err}
+	}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if name == "" {
+			continue
+		}
+		valueSize, err := syscall.Getxattr(srcPath, name, nil)
+		if err != nil {
+			return &os.PathError{Op: "getxattr " + name, Path: srcPath, Err: err}
+		}
+		value := make([]byte, valueSize)
+		if valueSize, err = syscall.Getxattr(srcPath, name, value); err != nil {
+			return &os.PathError{Op: "getxattr " + name, Path: srcPath, Err: err}
+		}
+		if err := syscall.Setxattr(destPath, name, value[:valueSize], 0); err != nil {
+			return &os.PathError{Op: "setxattr " + name, Path: destPath, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package writeback
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCopyMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lmc-writeback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(name, []byte("127.0.0.1 localhost\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(name, 0604|os.ModeSetgid)
+	xattrs := syscall.Setxattr(name, "user.lmc", []byte("kept"), 0) == nil
+	owned := os.Getuid() == 0 && os.Chown(name, 1234, 2345) == nil
+	if _, err := (FileWriter{BackupDir: filepath.Join(dir, "backups")}).WriteFile(name, []byte("::1 localhost\n")); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(name)
+	if info.Mode() != 0604|os.ModeSetgid {
+		t.Fatal(info.Mode())
+	}
+	if stat := info.Sys().(*syscall.Stat_t); owned && (stat.Uid != 1234 || stat.Gid != 2345) {
+		t.Fatal(stat.Uid, stat.Gid)
+	}
+	value := make([]byte, 16)
+	if size, err := syscall.Getxattr(name, "user.lmc", value); xattrs && (err != nil || string(value[:size]) != "kept") {
+		t.Fatal(err, string(value[:size]))
+	}
+	if !owned || !xattrs {
+		t.Log("owner or extended attributes are not tested, as they cannot be set here")
+	}
+}
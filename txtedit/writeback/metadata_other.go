@@ -0,0 +1,10 @@
+//go:build !linux
+
+package writeback
+
+import "os"
+
+// Copy the mode of the source file, other metadata is not supported on this operating system.
+func copyMetadata(srcPath string, srcInfo os.FileInfo, dest *os.File) error {
+	return dest.Chmod(srcInfo.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky))
+}
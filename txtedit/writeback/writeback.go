@@ -0,0 +1,305 @@
+package writeback
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+)
+
+/*
+Splice replaces a range of bytes in the original text. The ranges of splices never overlap, and splices are ordered
+by their position in the original text.
+*/
+type Splice struct {
+	Start int    `json:"start"` // offset of the first replaced byte
+	End   int    `json:"end"`   // offset of the byte that follows the replaced range, equal to Start for an insertion.
+	Line  int    `json:"line"`  // line number of Start counting from 1
+	Old   string `json:"old"`   // the bytes that are replaced
+	New   string `json:"new"`   // the replacement
+}
+
+func (splice Splice) String() string {
+	return fmt.Sprintf("line %d bytes %d-%d: %q => %q", splice.Line, splice.Start, splice.End, splice.Old, splice.New)
+}
+
+// The original text of a node, and its byte range in the original input. The range is -1 if it is not known.
+type record struct {
+	verbatim, opening, closing string // verbatim text of the node, and of a section's opening and closing markers
+	start, openingEnd          int
+	closingStart, end          int
+}
+
+/*
+Original remembers the text that a document was lexed from, along with the original text and byte range of each node.
+Nodes that are left alone are written back by copying their bytes from the original text, rather than by reproducing
+them via VerbatimText, so that a round-trip defect of the lexer cannot alter the parts of the file nobody touched.
+*/
+type Original struct {
+	Input   string
+	records map[*lexer.DocumentNode]*record
+}
+
+/*
+Remember the input text and the byte ranges of the nodes lexed from it. This must be called before the document is
+modified. Should the lexer fail to reproduce the input exactly, the byte ranges are found by aligning the reproduced
+text against the input, and the nodes that cannot be aligned will always be written via VerbatimText.
+*/
+func Track(input string, root *lexer.DocumentNode) *Original {
+	orig := &Original{Input: input, records: make(map[*lexer.DocumentNode]*record)}
+	verbatim := root.VerbatimText()
+	offsets := align(verbatim, input)
+	orig.measure(root, 0, offsets)
+	return orig
+}
+
+// Remember the original text and byte range of the node and its leaves. Return the end of the node in verbatim text.
+func (orig *Original) measure(node *lexer.DocumentNode, offset int, offsets []int) int {
+	rec := &record{verbatim: node.VerbatimText(), start: offsets[offset]}
+	orig.records[node] = rec
+	sect, isSection := node.Entity.(*lexer.Section)
+	if isSection {
+		rec.opening = sectionOpening(sect)
+		offset += len(rec.opening)
+	} else if stmt, isStatement := node.Entity.(*lexer.Statement); isStatement {
+		offset += len(stmt.VerbatimText())
+	}
+	rec.openingEnd = offsets[offset]
+	for _, leaf := range node.Leaves {
+		offset = orig.measure(leaf, offset, offsets)
+	}
+	rec.closingStart = offsets[offset]
+	if isSection {
+		rec.closing = sectionClosing(sect)
+		offset += len(rec.closing)
+	}
+	rec.end = offsets[offset]
+	return offset
+}
+
+func sectionOpening(sect *lexer.Section) string {
+	opening := sect.OpeningPrefix
+	if sect.FirstStatement != nil {
+		opening += sect.FirstStatement.VerbatimText()
+	}
+	return opening + sect.OpeningSuffix
+}
+
+func sectionClosing(sect *lexer.Section) string {
+	closing := sect.ClosingPrefix
+	if sect.FinalStatement != nil {
+		closing += sect.FinalStatement.VerbatimText()
+	}
+	return closing + sect.ClosingSuffix
+}
+
+// Number of bytes that must agree after a mismatch before the alignment of two texts is trusted again.
+const RESYNC_LENGTH = 8
+
+// Maximum number of bytes skipped in either text to align them again after a mismatch.
+const RESYNC_DISTANCE = 256
+
+/*
+Return the input offset of each offset in the verbatim text (including the offset at its end), or -1 where the offset
+cannot be aligned. Bytes that only exist in the input belong to the verbatim byte in front of them.
+*/
+func align(verbatim, input string) []int {
+	offsets := make([]int, len(verbatim)+1)
+	v, i := 0, 0
+	for v < len(verbatim) {
+		if i < len(input) && verbatim[v] == input[i] {
+			offsets[v] = i
+			v++
+			i++
+			continue
+		}
+		// Find the fewest bytes to skip in either text that lead to agreement
+		skipV, skipI, found := resync(verbatim[v:], input[i:])
+		if !found {
+			for ; v < len(verbatim); v++ {
+				offsets[v] = -1
+			}
+			offsets[len(verbatim)] = len(input)
+			return offsets
+		}
+		for k := 0; k < skipV; k++ {
+			offsets[v+k] = -1
+		}
+		v += skipV
+		i += skipI
+	}
+	offsets[len(verbatim)] = len(input)
+	return offsets
+}
+
+func resync(verbatim, input string) (skipV, skipI int, found bool) {
+	for distance := 1; distance <= RESYNC_DISTANCE; distance++ {
+		for skipV = 0; skipV <= distance; skipV++ {
+			skipI = distance - skipV
+			if agree(verbatim, input, skipV, skipI) {
+				return skipV, skipI, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// Return true if both texts agree after skipping the bytes, either for RESYNC_LENGTH bytes or up to the end of both.
+func agree(verbatim, input string, skipV, skipI int) bool {
+	if skipV > len(verbatim) || skipI > len(input) {
+		return false
+	}
+	verbatim, input = verbatim[skipV:], input[skipI:]
+	if len(verbatim) < RESYNC_LENGTH || len(input) < RESYNC_LENGTH {
+		return verbatim == input
+	}
+	return verbatim[:RESYNC_LENGTH] == input[:RESYNC_LENGTH]
+}
+
+// A piece of the new text, which is either copied from a range of the original input or written anew.
+type segment struct {
+	start, end int // the original range, or -1 for new text
+	text       string
+}
+
+// Return the segments that make up the document's text.
+func (orig *Original) segments(node *lexer.DocumentNode, segments []segment) []segment {
+	rec, tracked := orig.records[node]
+	if tracked && rec.start != -1 && rec.end != -1 && node.VerbatimText() == rec.verbatim {
+		return append(segments, segment{start: rec.start, end: rec.end})
+	}
+	sect, isSection := node.Entity.(*lexer.Section)
+	if isSection {
+		if opening := sectionOpening(sect); tracked && opening == rec.opening && rec.start != -1 && rec.openingEnd != -1 {
+			segments = append(segments, segment{start: rec.start, end: rec.openingEnd})
+		} else {
+			segments = append(segments, segment{start: -1, text: opening})
+		}
+	} else if node.Entity != nil {
+		segments = append(segments, segment{start: -1, text: node.Entity.(lexer.ContainVerbatimText).VerbatimText()})
+	}
+	for _, leaf := range node.Leaves {
+		segments = orig.segments(leaf, segments)
+	}
+	if isSection {
+		if closing := sectionClosing(sect); tracked && closing == rec.closing && rec.closingStart != -1 && rec.end != -1 {
+			segments = append(segments, segment{start: rec.closingStart, end: rec.end})
+		} else {
+			segments = append(segments, segment{start: -1, text: closing})
+		}
+	}
+	return segments
+}
+
+/*
+Return the line number, counting from 1, on which the node begins in the original input, not counting the spaces and
+new-line characters that lead the node. Return 0 if the node is not among those lexed from the input, or if its range
+in the input is not known.
+*/
+func (orig *Original) Line(node *lexer.DocumentNode) int {
+	rec, found := orig.records[node]
+	if !found || rec.start == -1 {
+		return 0
+	}
+	start, end := rec.start, rec.end
+	if end == -1 {
+		end = len(orig.Input)
+	}
+	for start < end && strings.ContainsRune(" \t\r\n", rune(orig.Input[start])) {
+		start++
+	}
+	return strings.Count(orig.Input[:start], "\n") + 1
+}
+
+/*
+Return the splices that turn the original input into the text of the document in its current state. Nodes that are
+left alone do not produce splices, and each splice is trimmed down to the bytes that are actually different.
+*/
+func (orig *Original) Splices(root *lexer.DocumentNode) []Splice {
+	splices := make([]Splice, 0, 8)
+	var pending strings.Builder // new text to be written in front of the next original range
+	position := 0               // the original input up to here is accounted for
+	addSplice := func(end int) {
+		if end > position || pending.Len() > 0 {
+			splices = orig.appendSplice(splices, position, end, pending.String())
+		}
+		pending.Reset()
+		position = end
+	}
+	for _, seg := range orig.segments(root, nil) {
+		switch {
+		case seg.start == -1:
+			pending.WriteString(seg.text)
+		case seg.start < position:
+			// The range has been moved backward, hence it is written anew.
+			pending.WriteString(orig.Input[seg.start:seg.end])
+		case seg.start == seg.end:
+			// Empty ranges have no effect
+		default:
+			addSplice(seg.start)
+			position = seg.end
+		}
+	}
+	addSplice(len(orig.Input))
+	return splices
+}
+
+// Trim the bytes in common off both ends of a splice, and append it unless nothing remains to be changed.
+func (orig *Original) appendSplice(splices []Splice, start, end int, newText string) []Splice {
+	oldText := orig.Input[start:end]
+	prefix := 0
+	for prefix < len(oldText) && prefix < len(newText) && oldText[prefix] == newText[prefix] {
+		prefix++
+	}
+	for prefix > 0 && (!runeStart(oldText, prefix) || !runeStart(newText, prefix)) {
+		prefix--
+	}
+	suffix := 0
+	for suffix < len(oldText)-prefix && suffix < len(newText)-prefix &&
+		oldText[len(oldText)-1-suffix] == newText[len(newText)-1-suffix] {
+		suffix++
+	}
+	for suffix > 0 && (!runeStart(oldText, len(oldText)-suffix) || !runeStart(newText, len(newText)-suffix)) {
+		suffix--
+	}
+	oldText, newText = oldText[prefix:len(oldText)-suffix], newText[prefix:len(newText)-suffix]
+	if oldText == "" && newText == "" {
+		return splices
+	}
+	start += prefix
+	return append(splices, Splice{Start: start, End: start + len(oldText), Line: strings.Count(orig.Input[:start], "\n") + 1,
+		Old: oldText, New: newText})
+}
+
+func runeStart(text string, offset int) bool {
+	return offset >= len(text) || utf8.RuneStart(text[offset])
+}
+
+// Return the text of the document in its current state, made by splicing the changes into the original input.
+func (orig *Original) Write(root *lexer.DocumentNode) string {
+	text, _ := Apply(orig.Input, orig.Splices(root))
+	return text
+}
+
+var ErrSpliceMismatch = errors.New("the text does not match the splice")
+
+/*
+Apply the splices to the text, the bytes that each splice replaces must match what it expects, or ErrSpliceMismatch
+is returned along with the unchanged text.
+*/
+func Apply(text string, splices []Splice) (string, error) {
+	var out strings.Builder
+	position := 0
+	for _, splice := range splices {
+		if splice.Start < position || splice.End < splice.Start || splice.End > len(text) || text[splice.Start:splice.End] != splice.Old {
+			return text, ErrSpliceMismatch
+		}
+		out.WriteString(text[position:splice.Start])
+		out.WriteString(splice.New)
+		position = splice.End
+	}
+	out.WriteString(text[position:])
+	return out.String(), nil
+}
@@ -0,0 +1,165 @@
+package writeback
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/lexer/predef"
+	"github.com/HouzuoGuo/LinuxManagementConsole/txtedit/navigate"
+)
+
+func lex(text string, config lexer.LexerConfig) *lexer.DocumentNode {
+	return lexer.NewLexer(text, &config, &lexer.LexerDebugNoop{}).Run()
+}
+
+func mustFind(t *testing.T, root *lexer.DocumentNode, str string) *lexer.DocumentNode {
+	path, err := navigate.ParsePath(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := navigate.Find(root, path)
+	if node == nil {
+		t.Fatal("cannot find", str)
+	}
+	return node
+}
+
+var namedConf = "options {\n\tdirectory \"/var\";\n\tnotify yes;\n};\nzone \"a\" {\n\ttype master;\n};\n"
+
+func TestSplices(t *testing.T) {
+	root := lex(namedConf, predef.NamedConf)
+	orig := Track(namedConf, root)
+	if splices := orig.Splices(root); len(splices) != 0 || orig.Write(root) != namedConf {
+		t.Fatal(splices)
+	}
+	navigate.SetStatementValues(mustFind(t, root, "options/directory").Entity.(*lexer.Statement), []string{"/var/named"})
+	splices := orig.Splices(root)
+	if !reflect.DeepEqual(splices, []Splice{{Start: 26, End: 26, Line: 2, Old: "", New: "/named"}}) {
+		t.Fatal(splices)
+	}
+	if text := orig.Write(root); text != root.VerbatimText() {
+		t.Fatal(text)
+	}
+	// Removed, moved, and new nodes
+	navigate.Remove(mustFind(t, root, "options/notify"))
+	navigate.MoveBefore(mustFind(t, root, `zone["a"]`), mustFind(t, root, "options"))
+	zone := mustFind(t, root, `zone["a"]`)
+	navigate.AppendStatement(zone, navigate.NewStatement([]string{"file", "a.zone"}, navigate.StatementTemplate(zone)))
+	if text := orig.Write(root); text != root.VerbatimText() || text != "zone \"a\" {\n\ttype master;\n\tfile a.zone;\n};\noptions {\n\tdirectory \"/var/named\";\n};\n" {
+		t.Fatal(text)
+	}
+	for _, splice := range orig.Splices(root) {
+		if splice.Old != namedConf[splice.Start:splice.End] {
+			t.Fatal(splice)
+		}
+	}
+}
+
+func TestLine(t *testing.T) {
+	root := lex(namedConf, predef.NamedConf)
+	orig := Track(namedConf, root)
+	for str, line := range map[string]int{"options": 1, "options/notify": 3, `zone["a"]`: 5, `zone["a"]/type`: 6} {
+		if got := orig.Line(mustFind(t, root, str)); got != line {
+			t.Fatal(str, got, line)
+		}
+	}
+	if line := orig.Line(&lexer.DocumentNode{}); line != 0 {
+		t.Fatal(line)
+	}
+}
+
+func TestRoundTripDefect(t *testing.T) {
+	// The lexer drops the new-line character that follows a continuation marker
+	input := "<VirtualHost *:80>\n    ServerName a \\\n  b\n    ServerAdmin root\n</VirtualHost>\n"
+	root := lex(input, predef.HttpdConf)
+	if root.VerbatimText() == input {
+		t.Skip("the lexer no longer has the defect")
+	}
+	orig := Track(input, root)
+	if splices := orig.Splices(root); len(splices) != 0 || orig.Write(root) != input {
+		t.Fatal(splices)
+	}
+	navigate.SetStatementValues(mustFind(t, root, `VirtualHost["*" ":" "80"]/ServerAdmin`).Entity.(*lexer.Statement), []string{"admin"})
+	splices := orig.Splices(root)
+	if len(splices) != 1 || splices[0].Old != "root" || splices[0].New != "admin" || splices[0].Line != 4 {
+		t.Fatal(splices)
+	}
+	if text := orig.Write(root); text != "<VirtualHost *:80>\n    ServerName a \\\n  b\n    ServerAdmin admin\n</VirtualHost>\n" {
+		t.Fatal(text)
+	}
+}
+
+func TestApply(t *testing.T) {
+	if text, err := Apply("héllo world", []Splice{{Start: 0, End: 6, Old: "héllo", New: "hi"}, {Start: 12, End: 12, New: "!"}}); err != nil || text != "hi world!" {
+		t.Fatal(text, err)
+	}
+	if _, err := Apply("hello", []Splice{{Start: 0, End: 1, Old: "j"}}); err != ErrSpliceMismatch {
+		t.Fatal(err)
+	}
+	// Splices do not cut a multi-byte character in half
+	root := lex("a é;\n", predef.NamedConf)
+	orig := Track("a é;\n", root)
+	navigate.SetStatementValues(root.Leaves[0].Entity.(*lexer.Statement), []string{"è"})
+	if splices := orig.Splices(root); len(splices) != 1 || splices[0].Old != "é" || splices[0].New != "è" {
+		t.Fatal(splices)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lmc-writeback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "named.conf.real")
+	link := filepath.Join(dir, "named.conf")
+	if err := ioutil.WriteFile(target, []byte(namedConf), 0640); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(target, 0640)
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+	stamp := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	writer := FileWriter{now: func() time.Time { return stamp }}
+	backup, err := writer.WriteDocument(link, lex("options {\n};\n", predef.NamedConf))
+	if err != nil || backup != filepath.Join(dir, ".named.conf.real.20200102-030405.000000006~") {
+		t.Fatal(backup, err)
+	}
+	// The link is followed rather than replaced, and the mode is kept
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatal(info, err)
+	}
+	if content, _ := ioutil.ReadFile(target); string(content) != "options {\n};\n" {
+		t.Fatal(string(content))
+	}
+	if content, _ := ioutil.ReadFile(backup); string(content) != namedConf {
+		t.Fatal(string(content))
+	}
+	for _, name := range []string{target, backup} {
+		if info, _ := os.Stat(name); info.Mode() != 0640 {
+			t.Fatal(name, info.Mode())
+		}
+	}
+	// Backups may be kept in their own directory, new files are created
+	writer.BackupDir = filepath.Join(dir, "backups")
+	if backup, err = writer.WriteFile(target, []byte("a;\n")); err != nil || backup != filepath.Join(dir, "backups", target+".20200102-030405.000000006") {
+		t.Fatal(backup, err)
+	}
+	newFile := filepath.Join(dir, "new.conf")
+	if backup, err = writer.WriteFile(newFile, []byte("b;\n")); err != nil || backup != "" {
+		t.Fatal(backup, err)
+	}
+	if info, _ := os.Stat(newFile); info.Mode() != NEW_FILE_MODE {
+		t.Fatal(info.Mode())
+	}
+	// Temporary files do not linger
+	if names, _ := filepath.Glob(filepath.Join(dir, ".*tmp*")); len(names) != 0 {
+		t.Fatal(names)
+	}
+}